@@ -0,0 +1,588 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AdminPasteInfo is PasteInfo for operators: no URLs since admin API is
+// served on its own listener, but with counter and creator address.
+type AdminPasteInfo struct {
+	ID       string     `json:"id"`
+	Counter  int64      `json:"counter"`
+	Bytes    int64      `json:"bytes"`
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	Burn     bool       `json:"burn,omitempty"`
+	Private  bool       `json:"private,omitempty"`
+	Public   bool       `json:"public,omitempty"`
+	Tags     []string   `json:"tags,omitempty"`
+	IP       string     `json:"ip,omitempty"`
+	APIKey   string     `json:"api_key,omitempty"`
+	Owner    string     `json:"owner,omitempty"`
+	Content  *string    `json:"content,omitempty"`
+	Encoding string     `json:"encoding,omitempty"`
+
+	Reports    []Report  `json:"reports,omitempty"`
+	Takedown   *Takedown `json:"takedown,omitempty"`
+	Quarantine string    `json:"quarantine,omitempty"`
+}
+
+func NewAdminPasteInfo(counter int64, hash string, meta *PasteMeta) *AdminPasteInfo {
+	return &AdminPasteInfo{
+		ID:      hash,
+		Counter: counter,
+		Bytes:   meta.Size,
+		Created: meta.Created,
+		Expires: meta.Expires,
+		Burn:    meta.Burn,
+		Private: meta.Private,
+		Public:  meta.Visibility == VisibilityPublic,
+		Tags:    meta.Tags,
+		IP:      meta.IP,
+		APIKey:  meta.APIKey,
+		Owner:   meta.Owner,
+
+		Reports:    meta.Reports,
+		Takedown:   meta.Takedown,
+		Quarantine: meta.Quarantine,
+	}
+}
+
+// PasteFilter selects pastes by creator address or API key, creation time,
+// tags and checksum of content.
+type PasteFilter struct {
+	IP     *net.IPNet
+	APIKey string
+	Since  time.Time
+	Until  time.Time
+	Tags   []string
+	SHA256 string
+	// Only reported or quarantined pastes which weren't taken down yet
+	Reported bool
+}
+
+// ParseFilterTime accepts RFC 3339 timestamp or age like 12h or 7d.
+func ParseFilterTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	age, err := ParseExpiry(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %s", value)
+	}
+	return time.Now().Add(-age), nil
+}
+
+// ParsePasteFilter reads filter from ip (address or CIDR), api_key (name of
+// key), since, until, tag and sha256 query parameters. Tag may be repeated.
+func ParsePasteFilter(r *http.Request) (*PasteFilter, error) {
+	query := r.URL.Query()
+	filter := &PasteFilter{}
+	var err error
+	if ip := query.Get("ip"); ip != "" {
+		if filter.IP, err = ParseNetwork(ip); err != nil {
+			return nil, fmt.Errorf("invalid ip: %s", ip)
+		}
+	}
+	filter.APIKey = query.Get("api_key")
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = ParseFilterTime(since); err != nil {
+			return nil, err
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = ParseFilterTime(until); err != nil {
+			return nil, err
+		}
+	}
+	filter.Tags = query["tag"]
+	if sum := query.Get("sha256"); sum != "" {
+		if filter.SHA256, err = ParseSHA256(sum); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+func (f *PasteFilter) Empty() bool {
+	return f.IP == nil && f.APIKey == "" && f.Since.IsZero() && f.Until.IsZero() && len(f.Tags) == 0 && f.SHA256 == ""
+}
+
+func (f *PasteFilter) Match(meta *PasteMeta) bool {
+	if f.IP != nil && !f.IP.Contains(net.ParseIP(meta.IP)) {
+		return false
+	}
+	if f.APIKey != "" && meta.APIKey != f.APIKey {
+		return false
+	}
+	if !f.Since.IsZero() && meta.Created.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && meta.Created.After(f.Until) {
+		return false
+	}
+	if f.SHA256 != "" && meta.SHA256 != f.SHA256 {
+		return false
+	}
+	if f.Reported && ((len(meta.Reports) == 0 && meta.Quarantine == "") || meta.Takedown != nil) {
+		return false
+	}
+	return meta.HasTags(f.Tags)
+}
+
+// AdminAuth requires "Authorization: Bearer <token>" on every request.
+func AdminAuth(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				rw.Header().Set("WWW-Authenticate", "Bearer")
+				WriteJSON(rw, 401, map[string]string{"error": "invalid admin token"})
+				return
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// findPastes returns pastes matching filter, newest first.
+func (hr *HttpRoutes) findPastes(filter *PasteFilter) ([]*AdminPasteInfo, error) {
+	names, err := hr.storage.List()
+	if err != nil {
+		return nil, err
+	}
+	pastes := []*AdminPasteInfo{}
+	for _, name := range names {
+		counter, hash, err := ParsePasteName(name)
+		if err != nil {
+			continue
+		}
+		meta, err := hr.storage.LoadMeta(name)
+		if err != nil {
+			// Deleted while listing
+			if errors.Is(err, ErrPasteNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		if filter.Match(meta) {
+			pastes = append(pastes, NewAdminPasteInfo(counter, hash, meta))
+		}
+	}
+	sort.Slice(pastes, func(i, j int) bool {
+		return pastes[i].Counter > pastes[j].Counter
+	})
+	return pastes, nil
+}
+
+// counterName maps counter from URL to paste name, it's the admin
+// counterpart of HashName.
+func (hr *HttpRoutes) counterName(r *http.Request) (int64, string, string, error) {
+	counter, err := strconv.ParseInt(mux.Vars(r)["counter"], 10, 64)
+	if err != nil {
+		return 0, "", "", err
+	}
+	hash, err := hr.hashidMaker.EncodeInt64([]int64{counter})
+	if err != nil {
+		return 0, "", "", err
+	}
+	return counter, hash, PasteName(counter, hash), nil
+}
+
+func (hr *HttpRoutes) AdminListPastes(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	filter, err := ParsePasteFilter(r)
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			WriteJSON(rw, 400, map[string]string{"error": fmt.Sprintf("invalid limit: %s", value)})
+			return
+		}
+	}
+	pastes, err := hr.findPastes(filter)
+	if err != nil {
+		panic(err)
+	}
+	if len(pastes) > limit {
+		pastes = pastes[:limit]
+	}
+	WriteJSON(rw, 200, pastes)
+}
+
+func (hr *HttpRoutes) AdminGetPaste(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	counter, hash, name, err := hr.counterName(r)
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": "invalid counter"})
+		return
+	}
+	meta, content, err := hr.storage.Load(name)
+	if err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			WriteJSON(rw, 404, map[string]string{"error": "paste not found"})
+			return
+		}
+		panic(err)
+	}
+	info := NewAdminPasteInfo(counter, hash, meta)
+	info.Bytes = int64(len(content))
+	pasteInfo := &PasteInfo{}
+	pasteInfo.SetContent(content)
+	info.Content, info.Encoding = pasteInfo.Content, pasteInfo.Encoding
+	WriteJSON(rw, 200, info)
+}
+
+func (hr *HttpRoutes) AdminDeletePaste(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	_, hash, name, err := hr.counterName(r)
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": "invalid counter"})
+		return
+	}
+	if err = hr.removePaste(r, name); err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			WriteJSON(rw, 404, map[string]string{"error": "paste not found"})
+			return
+		}
+		panic(err)
+	}
+	SetPasteID(r, hash)
+	RequestLogger(r).Info("paste deleted by admin")
+	hr.audit(r, &AuditEvent{Action: AuditDelete, Target: hash, Reason: r.FormValue("reason")})
+	rw.WriteHeader(204)
+}
+
+// AdminPurge deletes all pastes matching filter. Empty filter is refused so
+// a typo doesn't wipe everything.
+func (hr *HttpRoutes) AdminPurge(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	filter, err := ParsePasteFilter(r)
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	if filter.Empty() {
+		WriteJSON(rw, 400, map[string]string{"error": "purge requires ip, api_key, since, until, tag or sha256"})
+		return
+	}
+	deleted, err := hr.purgePastes(r, filter)
+	if err != nil {
+		panic(err)
+	}
+	RequestLogger(r).Info("pastes purged by admin", "count", len(deleted), "query", r.URL.RawQuery)
+	hr.audit(r, &AuditEvent{Action: AuditPurge, Target: r.URL.RawQuery, Reason: r.FormValue("reason"), Deleted: deleted})
+	WriteJSON(rw, 200, map[string]interface{}{"deleted": deleted})
+}
+
+// AdminExport streams pastes matching filter in backup layout, e.g. to hand
+// client their data on request. Counter of manifest is left at zero, so
+// restoring export only advances counter past exported pastes.
+func (hr *HttpRoutes) AdminExport(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	filter, err := ParsePasteFilter(r)
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	if filter.Empty() {
+		WriteJSON(rw, 400, map[string]string{"error": "export requires ip, api_key, since, until, tag or sha256, backup has everything"})
+		return
+	}
+	pastes, err := hr.findPastes(filter)
+	if err != nil {
+		panic(err)
+	}
+	names := make([]string, 0, len(pastes))
+	for _, paste := range pastes {
+		names = append(names, PasteName(paste.Counter, paste.ID))
+	}
+	rw.Header().Set("Content-Type", "application/gzip")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"paast-export-%s.tar.gz\"", time.Now().UTC().Format("20060102-150405")))
+	exported, err := writeArchive(hr.storage, rw, names, 0)
+	if err != nil {
+		RequestLogger(r).Error("export failed", "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	RequestLogger(r).Info("pastes exported by admin", "count", exported, "query", r.URL.RawQuery)
+	hr.audit(r, &AuditEvent{Action: AuditExport, Target: r.URL.RawQuery, Reason: r.FormValue("reason")})
+}
+
+// purgePastes deletes pastes matching filter and returns their IDs.
+func (hr *HttpRoutes) purgePastes(r *http.Request, filter *PasteFilter) ([]string, error) {
+	pastes, err := hr.findPastes(filter)
+	if err != nil {
+		return nil, err
+	}
+	deleted := []string{}
+	for _, paste := range pastes {
+		if err = hr.removePaste(r, PasteName(paste.Counter, paste.ID)); err != nil {
+			if errors.Is(err, ErrPasteNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		deleted = append(deleted, paste.ID)
+	}
+	return deleted, nil
+}
+
+// removePaste deletes paste along with its search index entry.
+func (hr *HttpRoutes) removePaste(r *http.Request, name string) error {
+	// Meta has size of revisions too
+	meta, err := hr.storage.LoadMeta(name)
+	if err == nil {
+		err = hr.storage.Delete(name)
+	}
+	if err != nil {
+		return err
+	}
+	hr.unindexPaste(r, name)
+	hr.usage.Release(meta)
+	return nil
+}
+
+// AdminListReports lists queue of reported and quarantined pastes awaiting
+// review, newest first.
+func (hr *HttpRoutes) AdminListReports(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	pastes, err := hr.findPastes(&PasteFilter{Reported: true})
+	if err != nil {
+		panic(err)
+	}
+	WriteJSON(rw, 200, pastes)
+}
+
+// AdminTakedown erases content of paste, including its revisions, and
+// leaves a tombstone with reason which is served instead.
+func (hr *HttpRoutes) AdminTakedown(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	counter, hash, name, err := hr.counterName(r)
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": "invalid counter"})
+		return
+	}
+	reason, err := ReportReason(rw, r)
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	meta, err := hr.takedownPaste(r, name, reason, r.FormValue("block") == "true")
+	if err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			WriteJSON(rw, 404, map[string]string{"error": "paste not found"})
+			return
+		}
+		panic(err)
+	}
+	SetPasteID(r, hash)
+	RequestLogger(r).Info("paste taken down by admin", "reason", reason)
+	hr.audit(r, &AuditEvent{Action: AuditTakedown, Target: hash, Reason: reason})
+	WriteJSON(rw, 200, NewAdminPasteInfo(counter, hash, meta))
+}
+
+// takedownPaste erases content and revisions of paste, and adds content to
+// blocklist first if block is set.
+func (hr *HttpRoutes) takedownPaste(r *http.Request, name string, reason string, block bool) (*PasteMeta, error) {
+	unlock, err := hr.lockPaste(name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	meta, err := hr.storage.LoadMeta(name)
+	if err != nil {
+		return nil, err
+	}
+	released := meta.StoredSize()
+	// Checksum is gone once content is erased
+	if block && meta.SHA256 != "" {
+		if _, err = hr.blocklist.Add(meta.SHA256, reason); err != nil {
+			return nil, err
+		}
+		hr.audit(r, &AuditEvent{Action: AuditBlock, Target: meta.SHA256, Reason: reason})
+	}
+	for revision := range meta.Revisions {
+		if err = hr.storage.SaveRevision(name, revision+1, nil); err != nil {
+			return nil, err
+		}
+	}
+	meta.Revisions = nil
+	meta.Redirect = ""
+	meta.Password = nil
+	meta.SetContent(nil)
+	meta.Takedown = &Takedown{Created: time.Now(), Reason: reason}
+	if err = hr.storage.Save(name, meta, strings.NewReader("")); err != nil {
+		return nil, err
+	}
+	hr.usage.Add(-released)
+	hr.unindexPaste(r, name)
+	return meta, nil
+}
+
+// AdminDismissReports clears reports of paste which was reviewed and kept,
+// releasing it from quarantine.
+func (hr *HttpRoutes) AdminDismissReports(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	_, hash, name, err := hr.counterName(r)
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": "invalid counter"})
+		return
+	}
+	if err = hr.dismissReports(name); err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			WriteJSON(rw, 404, map[string]string{"error": "paste not found"})
+			return
+		}
+		panic(err)
+	}
+	SetPasteID(r, hash)
+	RequestLogger(r).Info("paste reports dismissed by admin")
+	hr.audit(r, &AuditEvent{Action: AuditDismiss, Target: hash, Reason: r.FormValue("reason")})
+	rw.WriteHeader(204)
+}
+
+func (hr *HttpRoutes) dismissReports(name string) error {
+	unlock, err := hr.lockPaste(name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	meta, content, err := hr.storage.Open(name)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	meta.Reports = nil
+	meta.Quarantine = ""
+	return hr.storage.Save(name, meta, content)
+}
+
+func (hr *HttpRoutes) AdminListBans(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	WriteJSON(rw, 200, hr.bans.List())
+}
+
+// AdminBan bans network given as address or CIDR, for expire if it's set,
+// e.g. 7d.
+func (hr *HttpRoutes) AdminBan(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	network, err := ParseNetwork(r.FormValue("network"))
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	var expires *time.Time
+	if expire := r.FormValue("expire"); expire != "" {
+		ttl, err := ParseExpiry(expire)
+		if err != nil {
+			WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+			return
+		}
+		at := time.Now().Add(ttl)
+		expires = &at
+	}
+	ban, err := hr.bans.Add(network, r.FormValue("reason"), expires)
+	if err != nil {
+		panic(err)
+	}
+	RequestLogger(r).Info("network banned by admin", "network", ban.Network, "reason", ban.Reason)
+	hr.audit(r, &AuditEvent{Action: AuditBan, Target: ban.Network, Reason: ban.Reason})
+	WriteJSON(rw, 200, ban)
+}
+
+func (hr *HttpRoutes) AdminUnban(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	network, err := ParseNetwork(r.FormValue("network"))
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	removed, err := hr.bans.Remove(network)
+	if err != nil {
+		panic(err)
+	}
+	if !removed {
+		WriteJSON(rw, 404, map[string]string{"error": "network is not banned"})
+		return
+	}
+	RequestLogger(r).Info("network unbanned by admin", "network", network.String())
+	hr.audit(r, &AuditEvent{Action: AuditUnban, Target: network.String(), Reason: r.FormValue("reason")})
+	rw.WriteHeader(204)
+}
+
+func (hr *HttpRoutes) AdminListBlocklist(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	WriteJSON(rw, 200, hr.blocklist.List())
+}
+
+// AdminBlock blocks content by its SHA-256, existing pastes with the same
+// content are deleted if purge is set.
+func (hr *HttpRoutes) AdminBlock(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	sum, err := ParseSHA256(r.FormValue("sha256"))
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	hash, err := hr.blocklist.Add(sum, r.FormValue("reason"))
+	if err != nil {
+		panic(err)
+	}
+	RequestLogger(r).Info("content blocked by admin", "sha256", sum, "reason", hash.Reason)
+	deleted := []string{}
+	if r.FormValue("purge") == "true" {
+		if deleted, err = hr.purgePastes(r, &PasteFilter{SHA256: sum}); err != nil {
+			panic(err)
+		}
+		RequestLogger(r).Info("pastes purged by admin", "count", len(deleted), "sha256", sum)
+	}
+	hr.audit(r, &AuditEvent{Action: AuditBlock, Target: sum, Reason: hash.Reason, Deleted: deleted})
+	WriteJSON(rw, 200, map[string]interface{}{"blocked": hash, "deleted": deleted})
+}
+
+func (hr *HttpRoutes) AdminUnblock(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	sum, err := ParseSHA256(r.FormValue("sha256"))
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	removed, err := hr.blocklist.Remove(sum)
+	if err != nil {
+		panic(err)
+	}
+	if !removed {
+		WriteJSON(rw, 404, map[string]string{"error": "content is not blocked"})
+		return
+	}
+	RequestLogger(r).Info("content unblocked by admin", "sha256", sum)
+	hr.audit(r, &AuditEvent{Action: AuditUnblock, Target: sum, Reason: r.FormValue("reason")})
+	rw.WriteHeader(204)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ansiSGR matches Select Graphic Rendition sequence, the one setting colors.
+var ansiSGR = regexp.MustCompile(`\x1b\[[0-9;:]*m`)
+
+// HasANSI tells whether content is colored terminal output.
+func HasANSI(content []byte) bool {
+	return ansiSGR.Match(content)
+}
+
+// Colors of dark terminal, standard and bright ones.
+var ansiPalette = [16]string{
+	"#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+	"#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+}
+
+const ansiForeground = "#d4d4d4"
+const ansiBackground = "#1e1e1e"
+
+// ansiColor returns color from 256-color palette: 16 standard ones, 6x6x6
+// cube and 24 shades of gray.
+func ansiColor(index int) string {
+	switch {
+	case index < 16:
+		return ansiPalette[index]
+	case index < 232:
+		levels := []int{0, 95, 135, 175, 215, 255}
+		index -= 16
+		return fmt.Sprintf("#%02x%02x%02x", levels[index/36], levels[index/6%6], levels[index%6])
+	}
+	gray := 8 + (index-232)*10
+	return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+}
+
+type ansiStyle struct {
+	fg, bg                                        string
+	bold, dim, italic, underline, strike, inverse bool
+}
+
+// apply updates style with SGR parameters.
+func (s *ansiStyle) apply(params []int) {
+	if len(params) == 0 {
+		params = []int{0}
+	}
+	for i := 0; i < len(params); i++ {
+		switch code := params[i]; {
+		case code == 0:
+			*s = ansiStyle{}
+		case code == 1:
+			s.bold = true
+		case code == 2:
+			s.dim = true
+		case code == 3:
+			s.italic = true
+		case code == 4:
+			s.underline = true
+		case code == 7:
+			s.inverse = true
+		case code == 9:
+			s.strike = true
+		case code == 22:
+			s.bold, s.dim = false, false
+		case code == 23:
+			s.italic = false
+		case code == 24:
+			s.underline = false
+		case code == 27:
+			s.inverse = false
+		case code == 29:
+			s.strike = false
+		case code >= 30 && code <= 37:
+			s.fg = ansiPalette[code-30]
+		case code >= 90 && code <= 97:
+			s.fg = ansiPalette[code-90+8]
+		case code == 39:
+			s.fg = ""
+		case code >= 40 && code <= 47:
+			s.bg = ansiPalette[code-40]
+		case code >= 100 && code <= 107:
+			s.bg = ansiPalette[code-100+8]
+		case code == 49:
+			s.bg = ""
+		case code == 38 || code == 48:
+			// 5;n picks from 256 colors, 2;r;g;b is true color
+			var color string
+			switch {
+			case i+2 < len(params) && params[i+1] == 5:
+				color = ansiColor(min(params[i+2], 255))
+				i += 2
+			case i+4 < len(params) && params[i+1] == 2:
+				color = fmt.Sprintf("#%02x%02x%02x", min(params[i+2], 255), min(params[i+3], 255), min(params[i+4], 255))
+				i += 4
+			default:
+				return
+			}
+			if code == 38 {
+				s.fg = color
+			} else {
+				s.bg = color
+			}
+		}
+	}
+}
+
+// CSS returns inline style of text, empty for default one.
+func (s *ansiStyle) CSS() string {
+	fg, bg := s.fg, s.bg
+	if s.inverse {
+		fg, bg = bg, fg
+		if fg == "" {
+			fg = ansiBackground
+		}
+		if bg == "" {
+			bg = ansiForeground
+		}
+	}
+	var css []string
+	if fg != "" {
+		css = append(css, "color:"+fg)
+	}
+	if bg != "" {
+		css = append(css, "background:"+bg)
+	}
+	if s.bold {
+		css = append(css, "font-weight:bold")
+	}
+	if s.dim {
+		css = append(css, "opacity:0.7")
+	}
+	if s.italic {
+		css = append(css, "font-style:italic")
+	}
+	switch {
+	case s.underline && s.strike:
+		css = append(css, "text-decoration:underline line-through")
+	case s.underline:
+		css = append(css, "text-decoration:underline")
+	case s.strike:
+		css = append(css, "text-decoration:line-through")
+	}
+	return strings.Join(css, ";")
+}
+
+// scanANSI splits content into text and escape sequences. SGR parameters
+// are passed to sgr, other sequences (cursor movement, window titles) are
+// dropped.
+func scanANSI(content string, text func(string), sgr func([]int)) {
+	for len(content) > 0 {
+		esc := strings.IndexByte(content, '\x1b')
+		if esc < 0 {
+			text(content)
+			return
+		}
+		if esc > 0 {
+			text(content[:esc])
+		}
+		content = content[esc:]
+		if len(content) < 2 {
+			return
+		}
+		switch content[1] {
+		case '[':
+			// CSI: parameter and intermediate bytes, then final byte
+			end := 2
+			for end < len(content) && (content[end] < 0x40 || content[end] > 0x7e) {
+				end++
+			}
+			if end == len(content) {
+				return
+			}
+			if content[end] == 'm' {
+				sgr(ansiParams(content[2:end]))
+			}
+			content = content[end+1:]
+		case ']':
+			// OSC: terminated by BEL or ESC \
+			bel, st := strings.IndexByte(content, '\a'), strings.Index(content, "\x1b\\")
+			switch {
+			case bel >= 0 && (st < 0 || bel < st):
+				content = content[bel+1:]
+			case st >= 0:
+				content = content[st+2:]
+			default:
+				return
+			}
+		default:
+			content = content[2:]
+		}
+	}
+}
+
+func ansiParams(value string) []int {
+	var params []int
+	for _, param := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ':' }) {
+		n, err := strconv.Atoi(param)
+		if err != nil || n < 0 {
+			return nil
+		}
+		params = append(params, n)
+	}
+	return params
+}
+
+// StripANSI removes escape sequences from content.
+func StripANSI(content []byte) []byte {
+	var stripped bytes.Buffer
+	scanANSI(string(content), func(text string) {
+		stripped.WriteString(text)
+	}, func([]int) {})
+	return stripped.Bytes()
+}
+
+// ANSIToHTML converts colored terminal output to escaped HTML.
+func ANSIToHTML(content []byte) string {
+	var out strings.Builder
+	var style ansiStyle
+	scanANSI(string(content), func(text string) {
+		if css := style.CSS(); css != "" {
+			fmt.Fprintf(&out, `<span style="%s">%s</span>`, css, html.EscapeString(text))
+		} else {
+			out.WriteString(html.EscapeString(text))
+		}
+	}, style.apply)
+	return out.String()
+}
+
+const ANSIHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Hash }} - paast</title>
+<style>
+body { margin: 0; font-family: monospace; font-size: 14px; background: ` + ansiBackground + `; color: ` + ansiForeground + `; }
+header { padding: 8px 12px; border-bottom: 1px solid #444; }
+header a { color: #3b8eea; margin-left: 12px; }
+main pre { margin: 0; padding: 8px 12px; white-space: pre-wrap; word-break: break-all; }
+</style>
+</head>
+<body>
+<header>{{ .Hash }}{{ .Ext }} (terminal)<a href="/{{ .Hash }}{{ .Ext }}/raw">raw</a><a href="/{{ .Hash }}{{ .Ext }}/raw?strip-ansi=1">plain</a></header>
+<main><pre>{{ .Content }}</pre></main>
+</body>
+</html>
+`
+
+var ansiTemplate = template.Must(template.New("ansi").Parse(ANSIHTML))
+
+// RenderANSI shows terminal output with its colors.
+func RenderANSI(rw http.ResponseWriter, hash string, ext string, content []byte) error {
+	var page bytes.Buffer
+	if err := ansiTemplate.Execute(&page, map[string]interface{}{
+		"Hash":    hash,
+		"Ext":     ext,
+		"Content": template.HTML(ANSIToHTML(content)),
+	}); err != nil {
+		return fmt.Errorf("render ansi: %s", err)
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(200)
+	rw.Write(page.Bytes())
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIKey grants its holder own rate limit budget and paste size limit.
+type APIKey struct {
+	Name          string
+	PasteCooldown time.Duration
+	PasteBurst    int
+	MaxBodyLen    int64
+}
+
+// APIKeys maps hashed keys to their holders, so keys are never compared
+// directly and don't linger in memory in plain text.
+type APIKeys map[string]*APIKey
+
+// LoadAPIKeys reads keys file. Every line is "name key [option=value ...]",
+// where options are paste-cooldown, paste-burst and max-body-len. Options
+// which are not set default to global ones. Blank lines and lines starting
+// with # are ignored.
+func LoadAPIKeys(filename string, config *Config) (APIKeys, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("api keys: %s", err)
+	}
+	defer file.Close()
+	keys := APIKeys{}
+	names := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("api keys: %s:%d: expected name and key", filename, lineNo)
+		}
+		if len(fields[1]) < 16 {
+			return nil, fmt.Errorf("api keys: %s:%d: key must be at least 16 characters long", filename, lineNo)
+		}
+		key := &APIKey{
+			Name:          fields[0],
+			PasteCooldown: config.PasteCooldown,
+			PasteBurst:    config.PasteBurst,
+			MaxBodyLen:    config.MaxBodyLen,
+		}
+		for _, option := range fields[2:] {
+			parts := strings.SplitN(option, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("api keys: %s:%d: expected option=value, got %s", filename, lineNo, option)
+			}
+			switch parts[0] {
+			case "paste-cooldown":
+				key.PasteCooldown, err = time.ParseDuration(parts[1])
+			case "paste-burst":
+				key.PasteBurst, err = strconv.Atoi(parts[1])
+				if err == nil && key.PasteBurst < 1 {
+					err = fmt.Errorf("must be at least 1")
+				}
+			case "max-body-len":
+				key.MaxBodyLen, err = strconv.ParseInt(parts[1], 10, 64)
+				if err == nil && key.MaxBodyLen <= 0 {
+					err = fmt.Errorf("must be positive")
+				}
+			default:
+				err = fmt.Errorf("unknown option")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("api keys: %s:%d: invalid %s: %s", filename, lineNo, parts[0], err)
+			}
+		}
+		if names[key.Name] {
+			return nil, fmt.Errorf("api keys: %s:%d: duplicate name %s", filename, lineNo, key.Name)
+		}
+		names[key.Name] = true
+		keys[HashToken(fields[1])] = key
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("api keys: %s", err)
+	}
+	return keys, nil
+}
+
+// Middleware attaches API key from "Authorization: Bearer" header to request
+// info. Unknown keys are rejected rather than silently downgraded to
+// anonymous limits.
+func (keys APIKeys) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		key, ok := keys.Lookup(auth)
+		if !ok {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			WriteError(rw, r, 401, "invalid API key")
+			return
+		}
+		info := GetRequestInfo(r)
+		info.APIKey = key
+		info.Logger = info.Logger.With("api_key", key.Name)
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Lookup finds holder of key given in "Authorization: Bearer" header.
+func (keys APIKeys) Lookup(auth string) (*APIKey, bool) {
+	key, ok := keys[HashToken(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))]
+	return key, ok
+}
+
+// MaxBodyLen returns paste size limit for the request.
+func MaxBodyLen(r *http.Request, config *Config) int64 {
+	if key := GetRequestInfo(r).APIKey; key != nil {
+		return key.MaxBodyLen
+	}
+	return config.MaxBodyLen
+}
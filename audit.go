@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditEvent records who changed what, when and why.
+type AuditEvent struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	// Operator as identified by client certificate or X-Operator header,
+	// owner for pastes deleted with their token, system for the instance
+	// itself
+	Actor string `json:"actor"`
+	IP    string `json:"ip,omitempty"`
+	// ID of paste, network, checksum or filter action applies to
+	Target string `json:"target,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Pastes deleted along, e.g. by purge
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+// Audited actions
+const (
+	AuditStart    = "start"
+	AuditDelete   = "delete"
+	AuditPurge    = "purge"
+	AuditExport   = "export"
+	AuditTakedown = "takedown"
+	AuditDismiss  = "dismiss"
+	AuditBan      = "ban"
+	AuditUnban    = "unban"
+	AuditBlock    = "block"
+	AuditUnblock  = "unblock"
+	AuditGC       = "gc"
+	AuditBackup   = "backup"
+	AuditRestore  = "restore"
+)
+
+// AuditLog is append-only file with one JSON event per line. Every event is
+// appended with a single write, so instances sharing the file don't mix
+// their lines.
+type AuditLog struct {
+	filename string
+	mu       sync.Mutex
+}
+
+// NewAuditLog opens audit log at audit-file, data-dir/audit.log by default.
+func NewAuditLog(config *Config) (*AuditLog, error) {
+	al := &AuditLog{filename: config.AuditFile}
+	if al.filename == "" {
+		al.filename = path.Join(config.DataDir, "audit.log")
+	}
+	if err := os.MkdirAll(path.Dir(al.filename), 0755); err != nil {
+		return nil, fmt.Errorf("audit log: %s", err)
+	}
+	return al, nil
+}
+
+// Record appends event, failures are logged as action already took place.
+func (al *AuditLog) Record(event *AuditEvent) {
+	event.Time = time.Now().UTC()
+	if err := al.append(event); err != nil {
+		slog.Error("failed to write audit log", "action", event.Action, "target", event.Target, "error", err)
+	}
+}
+
+func (al *AuditLog) append(event *AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	// Addresses of clients are kept
+	file, err := os.OpenFile(al.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return diskError("open audit log", err)
+	}
+	defer file.Close()
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		return diskError("write audit log", err)
+	}
+	return file.Sync()
+}
+
+// AuditFilter narrows down events, empty fields match everything.
+type AuditFilter struct {
+	Action string
+	Actor  string
+	Target string
+	Since  time.Time
+	Until  time.Time
+}
+
+func (f *AuditFilter) Match(event *AuditEvent) bool {
+	return (f.Action == "" || event.Action == f.Action) &&
+		(f.Actor == "" || event.Actor == f.Actor) &&
+		(f.Target == "" || event.Target == f.Target || slices.Contains(event.Deleted, f.Target)) &&
+		(f.Since.IsZero() || !event.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || event.Time.Before(f.Until))
+}
+
+// Events returns up to limit events matching filter, newest first.
+func (al *AuditLog) Events(filter *AuditFilter, limit int) ([]*AuditEvent, error) {
+	file, err := os.Open(al.filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []*AuditEvent{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	events := []*AuditEvent{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		event := &AuditEvent{}
+		if err = json.Unmarshal(scanner.Bytes(), event); err != nil {
+			// Line cut short by crash
+			continue
+		}
+		if filter.Match(event) {
+			events = append(events, event)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	slices.Reverse(events)
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// AuditActor names operator making admin request: common name of verified
+// client certificate if admin listener requires one, user name signed in to
+// dashboard or X-Operator header otherwise, as operators share admin token.
+func AuditActor(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if name := r.TLS.VerifiedChains[0][0].Subject.CommonName; name != "" {
+			return name
+		}
+	}
+	if operator, _, ok := r.BasicAuth(); ok && operator != "" {
+		return operator
+	}
+	if operator := r.Header.Get("X-Operator"); operator != "" {
+		return operator
+	}
+	return "admin"
+}
+
+// audit records admin request.
+func (hr *HttpRoutes) audit(r *http.Request, event *AuditEvent) {
+	event.Actor = AuditActor(r)
+	event.IP = RemoteIP(r)
+	hr.auditLog.Record(event)
+}
+
+// AdminAuditLog lists events filtered by action, actor, target (which
+// matches pastes deleted along too), since and until, newest first.
+func (hr *HttpRoutes) AdminAuditLog(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	query := r.URL.Query()
+	filter := &AuditFilter{Action: query.Get("action"), Actor: query.Get("actor"), Target: query.Get("target")}
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = ParseFilterTime(since); err != nil {
+			WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = ParseFilterTime(until); err != nil {
+			WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			WriteJSON(rw, 400, map[string]string{"error": fmt.Sprintf("invalid limit: %s", value)})
+			return
+		}
+	}
+	events, err := hr.auditLog.Events(filter, limit)
+	if err != nil {
+		panic(err)
+	}
+	WriteJSON(rw, 200, events)
+}
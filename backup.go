@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// BackupVersion is the version of backup archive layout.
+const BackupVersion = 1
+
+// BackupManifestName is the name of the first entry of backup archive.
+const BackupManifestName = "manifest.json"
+
+// BackupManifest describes backup archive. Every paste is stored under
+// pastes/ as meta (<name>.json), content (<name>), revisions
+// (<name>.v<revision>) and stats (<name>.stats) if it was viewed, where name
+// is the storage name of paste like 000000042_abc.
+type BackupManifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Counter taken after listing pastes, it's past all of them
+	Counter int64 `json:"counter"`
+}
+
+// WriteBackup streams tar.gz archive of all pastes to w. Every paste is
+// consistent with its meta, though pastes created meanwhile may be left
+// out. Counter is advanced by one, so backups leave gaps in paste numbers.
+func WriteBackup(storage Storage, w io.Writer) (int, error) {
+	names, err := storage.List()
+	if err != nil {
+		return 0, fmt.Errorf("backup: %s", err)
+	}
+	counter, err := storage.NextCounter()
+	if err != nil {
+		return 0, fmt.Errorf("backup: %s", err)
+	}
+	return writeArchive(storage, w, names, counter)
+}
+
+// writeArchive writes pastes in backup layout, manifest carries counter.
+func writeArchive(storage Storage, w io.Writer, names []string, counter int64) (int, error) {
+	gz := gzip.NewWriter(w)
+	bw := &backupWriter{tar.NewWriter(gz)}
+	now := time.Now()
+	manifest := &BackupManifest{Version: BackupVersion, Created: now, Counter: counter}
+	err := bw.writeJSON(BackupManifestName, now, manifest)
+	if err != nil {
+		return 0, fmt.Errorf("backup: %s", err)
+	}
+	pastes := 0
+	for _, name := range names {
+		if err = bw.writePaste(storage, name); err != nil {
+			if errors.Is(err, ErrPasteNotFound) {
+				// Deleted meanwhile
+				continue
+			}
+			return pastes, fmt.Errorf("backup: %s: %s", name, err)
+		}
+		pastes++
+	}
+	if err = bw.Close(); err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return pastes, fmt.Errorf("backup: %s", err)
+	}
+	return pastes, nil
+}
+
+type backupWriter struct {
+	*tar.Writer
+}
+
+func (bw *backupWriter) writeEntry(name string, modified time.Time, size int64, content io.Reader) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modified, Typeflag: tar.TypeReg}
+	if err := bw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(bw, content)
+	return err
+}
+
+func (bw *backupWriter) writeJSON(name string, modified time.Time, value any) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return bw.writeEntry(name, modified, int64(len(content)), bytes.NewReader(content))
+}
+
+// writePaste adds meta, content, revisions and stats of paste to archive.
+func (bw *backupWriter) writePaste(storage Storage, name string) error {
+	meta, content, err := storage.Open(name)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	// Tar header needs exact size, content is trusted with it over meta
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return err
+	}
+	modified := meta.Modified()
+	if err = bw.writeJSON("pastes/"+name+".json", modified, meta); err != nil {
+		return err
+	}
+	if err = bw.writeEntry("pastes/"+name, modified, size, content); err != nil {
+		return err
+	}
+	for i, revision := range meta.Revisions {
+		content, err := storage.LoadRevision(name, i+1)
+		if err != nil {
+			return err
+		}
+		if err = bw.writeEntry(fmt.Sprintf("pastes/%s.v%d", name, i+1), revision.Created, int64(len(content)), bytes.NewReader(content)); err != nil {
+			return err
+		}
+	}
+	stats, err := storage.LoadStats(name)
+	if err != nil {
+		return err
+	}
+	if stats.Views > 0 {
+		return bw.writeJSON("pastes/"+name+".stats", modified, stats)
+	}
+	return nil
+}
+
+// WriteBackupFile writes backup to filename, replacing it only once backup
+// is complete, or to stdout if filename is "-".
+func WriteBackupFile(storage Storage, filename string) (int, error) {
+	if filename == "-" {
+		return WriteBackup(storage, os.Stdout)
+	}
+	pastes := 0
+	err := WriteFileAtomicFunc(filename, func(w io.Writer) error {
+		var err error
+		pastes, err = WriteBackup(storage, w)
+		return err
+	})
+	return pastes, err
+}
+
+// AdminBackup streams backup archive. Errors past the start of response
+// abort the connection, so that clients don't take truncated archive for
+// complete one.
+func (hr *HttpRoutes) AdminBackup(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	rw.Header().Set("Content-Type", "application/gzip")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"paast-%s.tar.gz\"", time.Now().UTC().Format("20060102-150405")))
+	pastes, err := WriteBackup(hr.storage, rw)
+	if err != nil {
+		RequestLogger(r).Error("backup failed", "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	RequestLogger(r).Info("backup created by admin", "pastes", pastes)
+	hr.audit(r, &AuditEvent{Action: AuditBackup})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ban keeps clients of network from creating pastes, until it expires if
+// expiry is set.
+type Ban struct {
+	Network string     `json:"network"`
+	Reason  string     `json:"reason,omitempty"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+
+	network *net.IPNet
+}
+
+func (b *Ban) Expired() bool {
+	return b.Expires != nil && time.Now().After(*b.Expires)
+}
+
+// ParseNetwork accepts address or CIDR, address is a network of its own.
+func ParseNetwork(value string) (*net.IPNet, error) {
+	cidr := value
+	if !strings.Contains(cidr, "/") {
+		if strings.Contains(cidr, ":") {
+			cidr += "/128"
+		} else {
+			cidr += "/32"
+		}
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network: %s", value)
+	}
+	return network, nil
+}
+
+// BanList is the list of banned networks, persisted as JSON file so bans
+// survive restarts. Expired bans are dropped on next change. The file may be
+// shared by instances, bans made by others are picked up once it's replaced.
+type BanList struct {
+	filename string
+	file     *SharedFile
+	mu       sync.RWMutex
+	bans     []*Ban
+}
+
+// NewBanList loads ban list from ban-file, data-dir/bans.json by default.
+func NewBanList(config *Config) (*BanList, error) {
+	bl := &BanList{filename: config.BanFile}
+	if bl.filename == "" {
+		bl.filename = path.Join(config.DataDir, "bans.json")
+	}
+	bl.file = NewSharedFile(bl.filename)
+	if err := bl.load(); err != nil {
+		return nil, err
+	}
+	return bl, nil
+}
+
+// load reads bans from file, caller must hold write lock.
+func (bl *BanList) load() error {
+	content, err := bl.file.Read()
+	if err != nil {
+		return fmt.Errorf("ban list: %s", err)
+	}
+	var bans []*Ban
+	if content != nil {
+		if err = json.Unmarshal(content, &bans); err != nil {
+			return fmt.Errorf("ban list: %s: %s", bl.filename, err)
+		}
+	}
+	for _, ban := range bans {
+		if ban.network, err = ParseNetwork(ban.Network); err != nil {
+			return fmt.Errorf("ban list: %s: %s", bl.filename, err)
+		}
+	}
+	bl.bans = bans
+	return nil
+}
+
+// refresh loads bans again if file was replaced by another instance.
+func (bl *BanList) refresh() {
+	bl.mu.RLock()
+	changed := bl.file.Changed()
+	bl.mu.RUnlock()
+	if !changed {
+		return
+	}
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if err := bl.load(); err != nil {
+		slog.Warn("failed to reload ban list", "error", err)
+	}
+}
+
+// Banned returns ban of network addr belongs to, nil if there's none.
+func (bl *BanList) Banned(addr string) *Ban {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	bl.refresh()
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	for _, ban := range bl.bans {
+		if !ban.Expired() && ban.network.Contains(ip) {
+			return ban
+		}
+	}
+	return nil
+}
+
+// List returns bans in effect, oldest first.
+func (bl *BanList) List() []*Ban {
+	bl.refresh()
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	bans := []*Ban{}
+	for _, ban := range bl.bans {
+		if !ban.Expired() {
+			bans = append(bans, ban)
+		}
+	}
+	return bans
+}
+
+// Add bans network, replacing existing ban of the same network.
+func (bl *BanList) Add(network *net.IPNet, reason string, expires *time.Time) (*Ban, error) {
+	ban := &Ban{Network: network.String(), Reason: reason, Created: time.Now(), Expires: expires, network: network}
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	unlock, err := bl.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	bans := slices.DeleteFunc(slices.Clone(bl.bans), func(existing *Ban) bool {
+		return existing.Network == ban.Network
+	})
+	if err = bl.save(append(bans, ban)); err != nil {
+		return nil, err
+	}
+	return ban, nil
+}
+
+// Remove lifts ban of network, false is returned if it wasn't banned.
+func (bl *BanList) Remove(network *net.IPNet) (bool, error) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	unlock, err := bl.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	bans := slices.DeleteFunc(slices.Clone(bl.bans), func(existing *Ban) bool {
+		return existing.Network == network.String()
+	})
+	if len(bans) == len(bl.bans) {
+		return false, nil
+	}
+	return true, bl.save(bans)
+}
+
+// lock keeps other instances from changing bans and loads their changes,
+// caller must hold write lock.
+func (bl *BanList) lock() (func(), error) {
+	lockFile, err := bl.file.Lock()
+	if err != nil {
+		return nil, fmt.Errorf("ban list: %s", err)
+	}
+	if bl.file.Changed() {
+		if err = bl.load(); err != nil {
+			lockFile.Close()
+			return nil, err
+		}
+	}
+	return func() { lockFile.Close() }, nil
+}
+
+// save writes bans in effect to file and makes them current, caller must
+// hold write lock and file lock.
+func (bl *BanList) save(bans []*Ban) error {
+	bans = slices.DeleteFunc(bans, (*Ban).Expired)
+	content, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return fmt.Errorf("ban list: %s", err)
+	}
+	if err = bl.file.Write(append(content, '\n')); err != nil {
+		return fmt.Errorf("ban list: %s", err)
+	}
+	bl.bans = bans
+	return nil
+}
+
+// BannedError describes ban to banned client.
+func BannedError(ban *Ban) string {
+	msg := "your address is banned"
+	if ban.Expires != nil {
+		msg += " until " + ban.Expires.UTC().Format(time.RFC3339)
+	}
+	if ban.Reason != "" {
+		msg += ": " + ban.Reason
+	}
+	return msg
+}
+
+// Middleware refuses banned clients, it goes before rate limiter so they
+// don't use up budget.
+func (bl *BanList) Middleware(fn http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if ban := bl.Banned(RemoteIP(r)); ban != nil {
+			WriteError(rw, r, 403, BannedError(ban))
+			return
+		}
+		fn(rw, r)
+	}
+}
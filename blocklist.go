@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrContentBlocked is returned for upload whose content is on blocklist.
+var ErrContentBlocked = errors.New("content is blocked")
+
+// BlockedHash is SHA-256 of content which can't be pasted again.
+type BlockedHash struct {
+	SHA256  string    `json:"sha256"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Blocklist keeps removed content from being pasted again, it's persisted
+// as JSON file like BanList. Only exact copies are caught, checksums of
+// client-encrypted pastes are different every time.
+type Blocklist struct {
+	filename string
+	file     *SharedFile
+	mu       sync.RWMutex
+	hashes   map[string]*BlockedHash
+}
+
+// NewBlocklist loads blocklist from blocklist-file, data-dir/blocklist.json
+// by default.
+func NewBlocklist(config *Config) (*Blocklist, error) {
+	bl := &Blocklist{filename: config.BlocklistFile}
+	if bl.filename == "" {
+		bl.filename = path.Join(config.DataDir, "blocklist.json")
+	}
+	bl.file = NewSharedFile(bl.filename)
+	if err := bl.load(); err != nil {
+		return nil, err
+	}
+	return bl, nil
+}
+
+// load reads hashes from file, caller must hold write lock.
+func (bl *Blocklist) load() error {
+	content, err := bl.file.Read()
+	if err != nil {
+		return fmt.Errorf("blocklist: %s", err)
+	}
+	var hashes []*BlockedHash
+	if content != nil {
+		if err = json.Unmarshal(content, &hashes); err != nil {
+			return fmt.Errorf("blocklist: %s: %s", bl.filename, err)
+		}
+	}
+	bl.hashes = map[string]*BlockedHash{}
+	for _, hash := range hashes {
+		bl.hashes[hash.SHA256] = hash
+	}
+	return nil
+}
+
+// refresh loads hashes again if file was replaced by another instance.
+func (bl *Blocklist) refresh() {
+	bl.mu.RLock()
+	changed := bl.file.Changed()
+	bl.mu.RUnlock()
+	if !changed {
+		return
+	}
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if err := bl.load(); err != nil {
+		slog.Warn("failed to reload blocklist", "error", err)
+	}
+}
+
+// lock keeps other instances from changing blocklist and loads their
+// changes, caller must hold write lock.
+func (bl *Blocklist) lock() (func(), error) {
+	lockFile, err := bl.file.Lock()
+	if err != nil {
+		return nil, fmt.Errorf("blocklist: %s", err)
+	}
+	if bl.file.Changed() {
+		if err = bl.load(); err != nil {
+			lockFile.Close()
+			return nil, err
+		}
+	}
+	return func() { lockFile.Close() }, nil
+}
+
+// ParseSHA256 validates hex SHA-256 checksum and normalizes its case.
+func ParseSHA256(value string) (string, error) {
+	sum := strings.ToLower(strings.TrimSpace(value))
+	if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != 32 {
+		return "", fmt.Errorf("invalid sha256: %s", value)
+	}
+	return sum, nil
+}
+
+// Check returns error wrapping ErrContentBlocked with reason if content with
+// checksum sum is blocked.
+func (bl *Blocklist) Check(sum string) error {
+	bl.refresh()
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	hash, ok := bl.hashes[sum]
+	if !ok {
+		return nil
+	}
+	if hash.Reason != "" {
+		return fmt.Errorf("%w: %s", ErrContentBlocked, hash.Reason)
+	}
+	return ErrContentBlocked
+}
+
+// CheckUploads is Check of every upload.
+func (bl *Blocklist) CheckUploads(uploads []*Upload) error {
+	for _, upload := range uploads {
+		if err := bl.Check(upload.SHA256()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns blocked hashes, oldest first.
+func (bl *Blocklist) List() []*BlockedHash {
+	bl.refresh()
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	return bl.sorted()
+}
+
+// sorted returns blocked hashes oldest first, caller must hold lock.
+func (bl *Blocklist) sorted() []*BlockedHash {
+	hashes := make([]*BlockedHash, 0, len(bl.hashes))
+	for _, hash := range bl.hashes {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return hashes[i].Created.Before(hashes[j].Created)
+	})
+	return hashes
+}
+
+// Add blocks content with checksum sum, replacing reason if it's blocked
+// already.
+func (bl *Blocklist) Add(sum string, reason string) (*BlockedHash, error) {
+	hash := &BlockedHash{SHA256: sum, Reason: reason, Created: time.Now()}
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	unlock, err := bl.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	previous := bl.hashes[sum]
+	bl.hashes[sum] = hash
+	if err = bl.save(); err != nil {
+		if previous != nil {
+			bl.hashes[sum] = previous
+		} else {
+			delete(bl.hashes, sum)
+		}
+		return nil, err
+	}
+	return hash, nil
+}
+
+// Remove unblocks content, false is returned if it wasn't blocked.
+func (bl *Blocklist) Remove(sum string) (bool, error) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	unlock, err := bl.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	hash, ok := bl.hashes[sum]
+	if !ok {
+		return false, nil
+	}
+	delete(bl.hashes, sum)
+	if err = bl.save(); err != nil {
+		bl.hashes[sum] = hash
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes hashes to file, caller must hold write lock and file lock.
+func (bl *Blocklist) save() error {
+	content, err := json.MarshalIndent(bl.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("blocklist: %s", err)
+	}
+	if err = bl.file.Write(append(content, '\n')); err != nil {
+		return fmt.Errorf("blocklist: %s", err)
+	}
+	return nil
+}
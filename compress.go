@@ -0,0 +1,166 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Compressor applies gzip or brotli to responses picked by Accept-Encoding.
+type Compressor struct {
+	minSize int
+}
+
+func NewCompressor(config *Config) *Compressor {
+	if !config.Compress {
+		return nil
+	}
+	return &Compressor{minSize: int(config.CompressMinSize)}
+}
+
+// AcceptedEncoding picks brotli or gzip from Accept-Encoding, brotli wins
+// unless client prefers gzip explicitly.
+func AcceptedEncoding(r *http.Request) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if (name == "br" || name == "gzip") && q > 0 && (q > bestQ || q == bestQ && name == "br") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// Compressible tells whether content type is worth compressing: text and
+// structured text, but not images, archives and other binary formats.
+func Compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-javascript",
+		"application/x-sh", "application/yaml", "application/x-yaml", "application/toml":
+		return true
+	}
+	return false
+}
+
+// Middleware compresses response of fn once it is known to be at least
+// minSize bytes. Range requests are left alone, since byte ranges refer to
+// uncompressed content.
+func (c *Compressor) Middleware(fn http.HandlerFunc) http.HandlerFunc {
+	if c == nil {
+		return fn
+	}
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Add("Vary", "Accept-Encoding")
+		encoding := AcceptedEncoding(r)
+		if encoding == "" || r.Header.Get("Range") != "" {
+			fn(rw, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: rw, encoding: encoding, minSize: c.minSize, code: http.StatusOK}
+		defer cw.Close()
+		fn(cw, r)
+	}
+}
+
+// compressWriter buffers up to minSize bytes before deciding whether to
+// compress, so small responses are sent as is.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	code     int
+	buf      []byte
+	decided  bool
+	encoder  io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided {
+		return
+	}
+	cw.code = code
+	// Informational and bodyless responses go straight through
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends headers and buffered content, compressing it if the response
+// is large enough and eligible.
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	header := cw.Header()
+	if large && cw.code == http.StatusOK && header.Get("Content-Encoding") == "" && Compressible(header.Get("Content-Type")) {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		// Compressed representation is not byte-for-byte identical
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		switch cw.encoding {
+		case "br":
+			cw.encoder = brotli.NewWriterLevel(cw.ResponseWriter, 5)
+		default:
+			cw.encoder, _ = gzip.NewWriterLevel(cw.ResponseWriter, gzip.DefaultCompression)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.code)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// CORSExposedHeaders are response headers of API which scripts of other
+// origins can read.
+var CORSExposedHeaders = []string{
+	"Location", "ETag", "Retry-After",
+	"X-Paste-Url", "X-Delete-Url", "X-Delete-Token", "X-Edit-Token", "X-Duplicate", "X-Encrypted", "X-Request-Id", "X-Warning",
+	"Upload-Offset", "Upload-Length", "Upload-Expires", "Tus-Resumable", "Tus-Version", "Tus-Max-Size", "Tus-Extension",
+}
+
+// CORSMaxAge is how long browsers may cache preflight responses, in seconds.
+const CORSMaxAge = 3600
+
+// CORS lets browser-based tools of allowed origins call API with fetch().
+// Credentials are never allowed, API keys are sent in Authorization header
+// which needs no cookies.
+type CORS struct {
+	origins []string
+	methods string
+	headers string
+}
+
+func NewCORS(config *Config) *CORS {
+	if config.CORSOrigins == "" {
+		return nil
+	}
+	return &CORS{
+		origins: splitList(config.CORSOrigins),
+		methods: strings.Join(splitList(config.CORSMethods), ", "),
+		headers: strings.Join(splitList(config.CORSHeaders), ", "),
+	}
+}
+
+// splitList splits comma-separated option, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Allowed reports whether scripts of origin may call API.
+func (c *CORS) Allowed(origin string) bool {
+	return origin != "" && (slices.Contains(c.origins, "*") || slices.Contains(c.origins, origin))
+}
+
+// Middleware answers preflight requests of allowed origins and marks their
+// responses as readable. It wraps router, so that preflight of any route is
+// answered without matching its methods.
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if !slices.Contains(c.origins, "*") {
+			rw.Header().Add("Vary", "Origin")
+		}
+		if !c.Allowed(origin) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if slices.Contains(c.origins, "*") {
+			rw.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			rw.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		// Other OPTIONS requests, e.g. of WebDAV, are left to routes
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			rw.Header().Add("Vary", "Access-Control-Request-Method")
+			rw.Header().Add("Vary", "Access-Control-Request-Headers")
+			rw.Header().Set("Access-Control-Allow-Methods", c.methods)
+			rw.Header().Set("Access-Control-Allow-Headers", c.headers)
+			rw.Header().Set("Access-Control-Max-Age", fmt.Sprint(CORSMaxAge))
+			rw.WriteHeader(204)
+			return
+		}
+		rw.Header().Set("Access-Control-Expose-Headers", strings.Join(CORSExposedHeaders, ", "))
+		next.ServeHTTP(rw, r)
+	})
+}
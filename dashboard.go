@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// DashboardLimit is how many recent pastes dashboard shows.
+const DashboardLimit = 50
+
+// DashboardAuth lets operators in with admin token as password of basic
+// auth, which browsers ask for on their own. User name is who they are in
+// audit log.
+func DashboardAuth(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			_, password, _ := r.BasicAuth()
+			if subtle.ConstantTimeCompare([]byte(password), []byte(token)) != 1 {
+				rw.Header().Set("WWW-Authenticate", `Basic realm="paast", charset="UTF-8"`)
+				WriteError(rw, r, 401, "admin token is required as password")
+				return
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// dashboardCSRF is the token forms of dashboard carry. Browsers send basic
+// auth credentials along with forms posted by other sites, so they're not
+// enough.
+func (hr *HttpRoutes) dashboardCSRF() string {
+	mac := hmac.New(sha256.New, []byte(hr.config.AdminToken))
+	mac.Write([]byte("dashboard"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DashboardPaste is paste as shown to operators, with first line of content
+// and URL if public-url is set.
+type DashboardPaste struct {
+	*AdminPasteInfo
+	URL     string
+	Preview string
+}
+
+// DashboardStats summarizes storage.
+type DashboardStats struct {
+	Pastes     int
+	Bytes      int64
+	MaxStorage int64
+	Reported   int
+	Bans       int
+	Blocked    int
+}
+
+func (hr *HttpRoutes) dashboardPaste(r *http.Request, paste *AdminPasteInfo) *DashboardPaste {
+	name := PasteName(paste.Counter, paste.ID)
+	shown := &DashboardPaste{AdminPasteInfo: paste}
+	meta, err := hr.storage.LoadMeta(name)
+	if err != nil {
+		return shown
+	}
+	if hr.config.PublicURL != "" {
+		shown.URL = strings.TrimSuffix(hr.config.PublicURL, "/") + "/" + hr.PasteID(paste.ID, meta)
+	}
+	if meta.Password == nil && !meta.Encrypted && meta.Takedown == nil {
+		if shown.Preview, err = hr.pastePreview(name); err != nil {
+			RequestLogger(r).Warn("failed to load preview", "paste", paste.ID, "error", err)
+		}
+	}
+	return shown
+}
+
+// Dashboard shows operators queue of reported pastes, recent pastes and
+// storage stats, with forms to act on pastes.
+func (hr *HttpRoutes) Dashboard(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	pastes, err := hr.findPastes(&PasteFilter{})
+	if err != nil {
+		panic(err)
+	}
+	stats := &DashboardStats{
+		Pastes:     len(pastes),
+		MaxStorage: hr.config.MaxStorage,
+		Bans:       len(hr.bans.List()),
+		Blocked:    len(hr.blocklist.List()),
+	}
+	reported, recent := []*DashboardPaste{}, []*DashboardPaste{}
+	for _, paste := range pastes {
+		stats.Bytes += paste.Bytes
+		if (len(paste.Reports) > 0 || paste.Quarantine != "") && paste.Takedown == nil {
+			stats.Reported++
+			reported = append(reported, hr.dashboardPaste(r, paste))
+		}
+		if len(recent) < DashboardLimit {
+			recent = append(recent, hr.dashboardPaste(r, paste))
+		}
+	}
+	operator, _, _ := r.BasicAuth()
+	var page bytes.Buffer
+	if err = dashboardTemplate.Execute(&page, map[string]interface{}{
+		"Operator": operator,
+		"Message":  r.URL.Query().Get("message"),
+		"Stats":    stats,
+		"Reported": reported,
+		"Recent":   recent,
+		"CSRF":     hr.dashboardCSRF(),
+	}); err != nil {
+		panic(fmt.Errorf("render dashboard: %s", err))
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Page lists addresses of clients
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(200)
+	rw.Write(page.Bytes())
+}
+
+// DashboardAction deletes, takes down or bans creator of paste, or dismisses
+// its reports, then goes back to dashboard with outcome.
+func (hr *HttpRoutes) DashboardAction(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	if subtle.ConstantTimeCompare([]byte(r.PostFormValue("csrf")), []byte(hr.dashboardCSRF())) != 1 {
+		WriteError(rw, r, 403, "invalid form token, reload dashboard")
+		return
+	}
+	_, hash, name, err := hr.counterName(r)
+	if err != nil {
+		WriteError(rw, r, 400, "invalid counter")
+		return
+	}
+	SetPasteID(r, hash)
+	reason := strings.TrimSpace(r.PostFormValue("reason"))
+	var message string
+	switch action := mux.Vars(r)["action"]; action {
+	case "delete":
+		if err = hr.removePaste(r, name); err == nil {
+			RequestLogger(r).Info("paste deleted by admin")
+			hr.audit(r, &AuditEvent{Action: AuditDelete, Target: hash, Reason: reason})
+			message = fmt.Sprintf("Paste %s deleted.", hash)
+		}
+	case "takedown":
+		if reason == "" {
+			message = "Takedown requires reason."
+			break
+		}
+		if _, err = hr.takedownPaste(r, name, reason, r.PostFormValue("block") == "true"); err == nil {
+			RequestLogger(r).Info("paste taken down by admin", "reason", reason)
+			hr.audit(r, &AuditEvent{Action: AuditTakedown, Target: hash, Reason: reason})
+			message = fmt.Sprintf("Paste %s taken down.", hash)
+		}
+	case "dismiss":
+		if err = hr.dismissReports(name); err == nil {
+			RequestLogger(r).Info("paste reports dismissed by admin")
+			hr.audit(r, &AuditEvent{Action: AuditDismiss, Target: hash, Reason: reason})
+			message = fmt.Sprintf("Reports of paste %s dismissed.", hash)
+		}
+	case "ban":
+		var meta *PasteMeta
+		if meta, err = hr.storage.LoadMeta(name); err != nil {
+			break
+		}
+		network, err := ParseNetwork(meta.IP)
+		if err != nil {
+			message = fmt.Sprintf("Paste %s has no address of creator.", hash)
+			break
+		}
+		ban, err := hr.bans.Add(network, reason, nil)
+		if err != nil {
+			panic(err)
+		}
+		RequestLogger(r).Info("network banned by admin", "network", ban.Network, "reason", ban.Reason)
+		hr.audit(r, &AuditEvent{Action: AuditBan, Target: ban.Network, Reason: ban.Reason})
+		message = fmt.Sprintf("%s banned.", ban.Network)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrPasteNotFound) {
+			panic(err)
+		}
+		message = fmt.Sprintf("Paste %s is gone.", hash)
+	}
+	http.Redirect(rw, r, "/dashboard?message="+url.QueryEscape(message), 303)
+}
+
+const DashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Moderation - paast</title>
+<style>
+body { margin: 0 auto; padding: 12px; max-width: 1200px; font-family: monospace; font-size: 14px; }
+a { color: #0366d6; }
+table { border-collapse: collapse; width: 100%; }
+td { padding: 4px 8px; border-bottom: 1px solid #ddd; vertical-align: top; }
+td.preview { word-break: break-all; color: #586069; }
+.message { padding: 8px; background: #fff8c5; }
+.flag { color: #cb2431; }
+input, button { font-family: monospace; }
+</style>
+</head>
+<body>
+<h3>Moderation <small>signed in as {{ if .Operator }}{{ .Operator }}{{ else }}admin{{ end }}</small></h3>
+{{ if .Message }}<p class="message">{{ .Message }}</p>
+{{ end -}}
+<p>{{ .Stats.Pastes }} pastes, {{ .Stats.Bytes }} bytes{{ if .Stats.MaxStorage }} of {{ .Stats.MaxStorage }}{{ end }}, {{ .Stats.Reported }} awaiting review, {{ .Stats.Bans }} bans, {{ .Stats.Blocked }} blocked checksums</p>
+{{ define "paste" }}<tr><td>{{ if .URL }}<a href="{{ .URL }}">{{ .ID }}</a>{{ else }}{{ .ID }}{{ end }}<br>#{{ .Counter }}</td><td>{{ .Created.Format "2006-01-02 15:04" }}<br>{{ .Bytes }} bytes</td><td>{{ .IP }}{{ if .APIKey }}<br>key {{ .APIKey }}{{ end }}</td>
+<td class="preview">{{ if .Takedown }}taken down: {{ .Takedown.Reason }}{{ else }}{{ if .Public }}public {{ end }}{{ if .Private }}private {{ end }}{{ if .Burn }}burn {{ end }}{{ .Preview }}{{ end }}
+{{ if .Quarantine }}<br><span class="flag">quarantined: {{ .Quarantine }}</span>{{ end }}{{ range .Reports }}<br><span class="flag">reported {{ .Created.Format "2006-01-02 15:04" }} from {{ .IP }}: {{ .Reason }}</span>{{ end }}</td>
+<td><form method="post" action="/dashboard/pastes/{{ .Counter }}/delete"><input type="hidden" name="csrf" value="{{ .CSRF }}"><input name="reason" placeholder="reason" size="16">
+<button>delete</button>{{ if not .Takedown }} <button formaction="/dashboard/pastes/{{ .Counter }}/takedown">take down</button> <label><input type="checkbox" name="block" value="true">block</label>{{ end }}
+{{ if or .Reports .Quarantine }}<button formaction="/dashboard/pastes/{{ .Counter }}/dismiss">dismiss</button> {{ end }}{{ if .IP }}<button formaction="/dashboard/pastes/{{ .Counter }}/ban">ban {{ .IP }}</button>{{ end }}</form></td></tr>
+{{ end -}}
+<h4>Awaiting review</h4>
+{{ if .Reported }}<table>
+{{ range .Reported }}{{ template "paste" (row $.CSRF .) }}{{ end }}</table>
+{{ else }}<p>Nothing reported.</p>
+{{ end -}}
+<h4>Recent pastes</h4>
+{{ if .Recent }}<table>
+{{ range .Recent }}{{ template "paste" (row $.CSRF .) }}{{ end }}</table>
+{{ else }}<p>No pastes yet.</p>
+{{ end }}</body>
+</html>
+`
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	// Forms of rows need the token too
+	"row": func(csrf string, paste *DashboardPaste) any {
+		return struct {
+			*DashboardPaste
+			CSRF string
+		}{paste, csrf}
+	},
+}).Parse(DashboardHTML))
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// DiffMaxSize limits size of each of compared pastes, diffing is quadratic
+// at worst.
+const DiffMaxSize = 1 << 20
+
+// DiffContext is the number of unchanged lines shown around changes.
+const DiffContext = 3
+
+var ErrNotComparable = errors.New("only text pastes which are not burn, encrypted or protected can be compared")
+
+// diffPaste loads content of paste to compare. Pastes which can't be read
+// more than once, or which server can't read at all, are not comparable.
+func (hr *HttpRoutes) diffPaste(hash string) ([]byte, error) {
+	meta, content, err := hr.storage.Load(hr.HashName(hash))
+	if err != nil {
+		return nil, err
+	}
+	if meta.Expired() || !hr.CanAccess(hash, meta) {
+		return nil, ErrPasteNotFound
+	}
+	if meta.Burn || meta.Encrypted || meta.Password != nil || IsBinary(content) {
+		return nil, ErrNotComparable
+	}
+	if len(content) > DiffMaxSize {
+		return nil, fmt.Errorf("%w: pastes bigger than %d bytes can't be compared", ErrNotComparable, DiffMaxSize)
+	}
+	return content, nil
+}
+
+// DiffPastes compares two pastes line by line. Browsers get side-by-side
+// HTML, other clients get unified diff.
+func (hr *HttpRoutes) DiffPastes(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	vars := mux.Vars(r)
+	var contents [2][]byte
+	for i, hash := range []string{vars["a"], vars["b"]} {
+		var err error
+		if contents[i], err = hr.diffPaste(hash); err != nil {
+			switch {
+			case errors.Is(err, ErrPasteNotFound):
+				PasteNotFound(rw, r, hash)
+			case errors.Is(err, ErrNotComparable):
+				WriteError(rw, r, 400, err.Error())
+			default:
+				panic(err)
+			}
+			return
+		}
+	}
+	a, b := DiffLines(contents[0]), DiffLines(contents[1])
+	if PasteView(r, vars["view"]) == "html" {
+		if err := RenderDiff(rw, vars["a"], vars["b"], a, b); err != nil {
+			panic(err)
+		}
+		return
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        a,
+		B:        b,
+		FromFile: vars["a"],
+		ToFile:   vars["b"],
+		Context:  DiffContext,
+	})
+	if err != nil {
+		panic(err)
+	}
+	rw.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	rw.WriteHeader(200)
+	rw.Write([]byte(diff))
+}
+
+// DiffLines splits content into lines ending with line break, which is
+// added to the last line if it's missing.
+func DiffLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n"
+	return lines
+}
+
+// DiffLine is a line of either side of side-by-side diff, Number is zero
+// if there's no line on this side.
+type DiffLine struct {
+	Number int
+	Text   string
+	Class  string
+}
+
+// DiffRow is a pair of lines shown next to each other, rows with Gap set
+// separate groups of changes.
+type DiffRow struct {
+	Left  DiffLine
+	Right DiffLine
+	Gap   bool
+}
+
+// SideBySide pairs lines of both pastes around changes.
+func SideBySide(a []string, b []string) []DiffRow {
+	matcher := difflib.NewMatcher(a, b)
+	rows := []DiffRow{}
+	for i, group := range matcher.GetGroupedOpCodes(DiffContext) {
+		if i > 0 {
+			rows = append(rows, DiffRow{Gap: true})
+		}
+		for _, op := range group {
+			left, right := op.I2-op.I1, op.J2-op.J1
+			for n := 0; n < max(left, right); n++ {
+				var row DiffRow
+				if n < left {
+					row.Left = DiffLine{Number: op.I1 + n + 1, Text: strings.TrimSuffix(a[op.I1+n], "\n")}
+				}
+				if n < right {
+					row.Right = DiffLine{Number: op.J1 + n + 1, Text: strings.TrimSuffix(b[op.J1+n], "\n")}
+				}
+				if op.Tag != 'e' {
+					row.Left.Class, row.Right.Class = "del", "add"
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows
+}
+
+const DiffHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .A }}..{{ .B }} - paast</title>
+<style>
+body { margin: 0; font-family: monospace; font-size: 14px; }
+header { padding: 8px 12px; border-bottom: 1px solid #ddd; background: #f6f8fa; }
+header a { color: #0366d6; }
+header a.raw { margin-left: 12px; }
+table { border-collapse: collapse; width: 100%; table-layout: fixed; }
+td { padding: 0 8px; white-space: pre-wrap; word-break: break-all; vertical-align: top; }
+td.n { width: 48px; color: #959da5; text-align: right; }
+td.del { background: #ffeef0; }
+td.add { background: #e6ffed; }
+tr.gap td { padding: 4px 8px; background: #f1f8ff; color: #586069; }
+</style>
+</head>
+<body>
+<header><a href="/{{ .A }}">{{ .A }}</a> .. <a href="/{{ .B }}">{{ .B }}</a><a class="raw" href="/diff/{{ .A }}/{{ .B }}/raw">raw</a></header>
+{{ if .Rows }}<table>
+{{ range .Rows }}{{ if .Gap }}<tr class="gap"><td class="n"></td><td>…</td><td class="n"></td><td>…</td></tr>
+{{ else }}<tr><td class="n">{{ if .Left.Number }}{{ .Left.Number }}{{ end }}</td><td class="{{ .Left.Class }}">{{ .Left.Text }}</td><td class="n">{{ if .Right.Number }}{{ .Right.Number }}{{ end }}</td><td class="{{ .Right.Class }}">{{ .Right.Text }}</td></tr>
+{{ end }}{{ end }}</table>
+{{ else }}<p style="margin: 12px">Pastes are identical.</p>
+{{ end }}</body>
+</html>
+`
+
+var diffTemplate = template.Must(template.New("diff").Parse(DiffHTML))
+
+// RenderDiff shows changes between two pastes side by side.
+func RenderDiff(rw http.ResponseWriter, hashA string, hashB string, a []string, b []string) error {
+	var page bytes.Buffer
+	if err := diffTemplate.Execute(&page, map[string]interface{}{
+		"A":    hashA,
+		"B":    hashB,
+		"Rows": SideBySide(a, b),
+	}); err != nil {
+		return fmt.Errorf("render diff: %s", err)
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(200)
+	rw.Write(page.Bytes())
+	return nil
+}
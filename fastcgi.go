@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FastCGIStdin is listen address of socket passed on stdin by web server
+// which spawns paast itself, like lighttpd or mod_fcgid do.
+const FastCGIStdin = "-"
+
+// FastCGIServer serves handler over FastCGI instead of HTTP, behind web
+// server which already handles HTTP and TLS. Address is either host:port,
+// path of unix socket or FastCGIStdin.
+type FastCGIServer struct {
+	Addr    string
+	Handler http.Handler
+
+	mu       sync.Mutex
+	listener net.Listener
+	closed   bool
+	requests sync.WaitGroup
+}
+
+// FastCGIListen listens on address of FastCGIServer.
+func FastCGIListen(addr string) (net.Listener, error) {
+	if listener, ok := inheritedListener("listen"); ok {
+		return listener, nil
+	}
+	if addr == FastCGIStdin {
+		return net.FileListener(os.Stdin)
+	}
+	if !strings.Contains(addr, "/") {
+		return net.Listen("tcp", addr)
+	}
+	// Socket left over by previous run would fail listening
+	if err := os.Remove(addr); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	// Web server is expected to run as different user of the same group
+	if err = os.Chmod(addr, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func (fs *FastCGIServer) ListenAndServe() error {
+	listener, err := FastCGIListen(fs.Addr)
+	if err != nil {
+		return fmt.Errorf("fastcgi: %s", err)
+	}
+	fs.mu.Lock()
+	if fs.closed {
+		fs.mu.Unlock()
+		listener.Close()
+		return http.ErrServerClosed
+	}
+	fs.listener = listener
+	fs.mu.Unlock()
+
+	err = fcgi.Serve(listener, http.HandlerFunc(fs.serve))
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closed {
+		return http.ErrServerClosed
+	}
+	return fmt.Errorf("fastcgi: %s", err)
+}
+
+func (fs *FastCGIServer) serve(rw http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	if fs.closed {
+		fs.mu.Unlock()
+		rw.WriteHeader(503)
+		return
+	}
+	fs.requests.Add(1)
+	fs.mu.Unlock()
+	defer fs.requests.Done()
+	fs.Handler.ServeHTTP(rw, r)
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests
+// until ctx is done. Unlike http.Server, connections kept alive by web
+// server are not closed, it's up to web server to reconnect.
+func (fs *FastCGIServer) Shutdown(ctx context.Context) error {
+	fs.mu.Lock()
+	fs.closed = true
+	if fs.listener != nil {
+		fs.listener.Close()
+	}
+	fs.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		fs.requests.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PeerHeader marks lookups made by peers, which are never passed on, so
+// that peers don't ask each other in circles.
+const PeerHeader = "X-Paast-Peer"
+
+// PeerMissTTL is how long hashes no peer has are remembered, pastes may be
+// created there meanwhile.
+const PeerMissTTL = time.Minute
+
+// PeerCacheSize limits number of remembered hashes, unknown ones are cheap
+// to make up.
+const PeerCacheSize = 10000
+
+type peerEntry struct {
+	// Empty if no peer has the paste
+	peer    string
+	expires time.Time
+}
+
+// Peers resolves pastes unknown to this instance on peer instances, so that
+// a group of instances can share links. Clients are redirected to the peer,
+// content is never copied. Hashes are only unique within instance, so peers
+// are asked in order they're listed and the first one wins. Every lookup
+// asks all peers, so clients get peer-burst of them in a row and regain one
+// every peer-cooldown, cached answers are free.
+type Peers struct {
+	urls     []string
+	client   *http.Client
+	timeout  time.Duration
+	ttl      time.Duration
+	limiter  *MemoryRateLimitStore
+	cooldown time.Duration
+	burst    int
+	mu       sync.Mutex
+	cache    map[string]peerEntry
+}
+
+// NewPeers returns nil if no peers are configured.
+func NewPeers(config *Config) *Peers {
+	urls := splitList(config.Peers)
+	if len(urls) == 0 {
+		return nil
+	}
+	peers := &Peers{
+		client: &http.Client{
+			// Redirect paste on peer is found as well
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		timeout:  config.PeerTimeout,
+		ttl:      config.PeerCache,
+		limiter:  NewMemoryRateLimitStore(config.RateLimitMax),
+		cooldown: config.PeerCooldown,
+		burst:    config.PeerBurst,
+		cache:    map[string]peerEntry{},
+	}
+	for _, peer := range urls {
+		peers.urls = append(peers.urls, strings.TrimSuffix(peer, "/"))
+	}
+	return peers
+}
+
+// Resolve returns URL of peer having paste with hash, empty if none does or
+// client identified by key has run out of lookups.
+func (p *Peers) Resolve(client string, hash string) string {
+	now := time.Now()
+	p.mu.Lock()
+	entry, ok := p.cache[hash]
+	p.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.peer
+	}
+	if p.cooldown > 0 {
+		if ok, _ := p.limiter.Take(client, p.cooldown, p.burst); !ok {
+			return ""
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	found := make([]bool, len(p.urls))
+	var wg sync.WaitGroup
+	for i, peer := range p.urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found[i] = p.lookup(ctx, peer, hash)
+		}()
+	}
+	wg.Wait()
+	entry = peerEntry{expires: now.Add(min(p.ttl, PeerMissTTL))}
+	for i, peer := range p.urls {
+		if found[i] {
+			entry = peerEntry{peer: peer, expires: now.Add(p.ttl)}
+			break
+		}
+	}
+	if p.ttl > 0 {
+		p.remember(hash, entry)
+	}
+	return entry.peer
+}
+
+// lookup tells whether peer has paste, unreachable peer doesn't.
+func (p *Peers) lookup(ctx context.Context, peer string, hash string) bool {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", peer+"/"+hash, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set(PeerHeader, "1")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode < 400
+}
+
+func (p *Peers) remember(hash string, entry peerEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.cache) >= PeerCacheSize {
+		now := time.Now()
+		for cached, entry := range p.cache {
+			if !now.Before(entry.expires) {
+				delete(p.cache, cached)
+			}
+		}
+		// Arbitrary entries make room if none expired
+		for cached := range p.cache {
+			if len(p.cache) < PeerCacheSize {
+				break
+			}
+			delete(p.cache, cached)
+		}
+	}
+	p.cache[hash] = entry
+}
+
+// Run periodically forgets clients which have regained all lookups until
+// ctx is done.
+func (p *Peers) Run(ctx context.Context) {
+	p.limiter.Run(ctx)
+}
+
+// peerRedirect redirects client to peer having paste unknown to this
+// instance, with the same path and query. False is returned if there's no
+// such peer.
+func (hr *HttpRoutes) peerRedirect(rw http.ResponseWriter, r *http.Request, hash string) bool {
+	if hr.peers == nil || r.Header.Get(PeerHeader) != "" {
+		return false
+	}
+	peer := hr.peers.Resolve(ClientKey(RemoteIP(r), hr.config.IPv6Prefix), hash)
+	if peer == "" {
+		return false
+	}
+	target := peer + r.URL.Path
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	RequestLogger(r).Info("paste found on peer", "peer", peer)
+	// Form with password of protected paste is posted again
+	http.Redirect(rw, r, target, 307)
+	return true
+}
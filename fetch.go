@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"syscall"
+	"time"
+)
+
+// FetchMaxRedirects is how many redirects are followed when fetching URL.
+const FetchMaxRedirects = 5
+
+var ErrForbiddenAddress = errors.New("address is not allowed")
+
+// ReservedPrefixes are special-purpose ranges not covered by netip methods.
+// 0.0.0.0/8 reaches local host on Linux, IPv6 translation ranges can embed
+// any IPv4 address.
+var ReservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// PublicAddress tells whether ip is routable on the internet, so fetching it
+// cannot reach services behind the server.
+func PublicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range ReservedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewFetchClient returns client which only connects to public addresses
+// unless allowPrivate is set. Addresses are checked after name resolution,
+// so DNS can't be used to point it inside.
+func NewFetchClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, conn syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !PublicAddress(addrPort.Addr()) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: timeout,
+		// Proxy from environment would bypass address checks
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= FetchMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", FetchMaxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("unsupported redirect to %s", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// FetchPaste downloads URL given in url parameter and stores it as a paste
+// with the same options as regular upload.
+func (hr *HttpRoutes) FetchPaste(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	options, err := hr.PasteOptions(RequestOptions(r))
+	if err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
+	}
+	target, err := url.Parse(r.FormValue("url"))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		WriteError(rw, r, 400, "url parameter must be http or https URL")
+		return
+	}
+
+	// Download
+	req, err := http.NewRequestWithContext(r.Context(), "GET", target.String(), nil)
+	if err != nil {
+		WriteError(rw, r, 400, fmt.Sprintf("invalid url: %s", err))
+		return
+	}
+	req.Header.Set("User-Agent", "paast")
+	resp, err := hr.fetchClient.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrForbiddenAddress) {
+			WriteError(rw, r, 403, "fetching private addresses is not allowed")
+			return
+		}
+		// Details may reveal network of the server, they are only logged
+		RequestLogger(r).Info("fetch failed", "url", target.Redacted(), "error", err)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && urlErr.Timeout() {
+			WriteError(rw, r, 504, "fetch timed out")
+			return
+		}
+		WriteError(rw, r, 502, "fetch failed: could not connect to remote server")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		WriteError(rw, r, 502, fmt.Sprintf("fetch failed: remote server responded with %s", resp.Status))
+		return
+	}
+
+	// Same limit as for uploads, declared size is checked before anything
+	// is read
+	limit := MaxBodyLen(r, hr.config)
+	tooLarge := fmt.Sprintf("remote content too large, limit is %s", FormatSize(limit))
+	if resp.ContentLength > limit {
+		WriteError(rw, r, 413, tooLarge)
+		return
+	}
+	spool, err := SpoolPaste(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		RequestLogger(r).Info("fetch failed", "url", target.Redacted(), "error", err)
+		WriteError(rw, r, 502, "fetch failed: download interrupted")
+		return
+	}
+	upload := &Upload{Spool: spool, ContentType: resp.Header.Get("Content-Type")}
+	defer upload.Close()
+	if spool.Size > limit {
+		WriteError(rw, r, 413, tooLarge)
+		return
+	}
+	if spool.Size == 0 {
+		WriteError(rw, r, 400, "remote content is empty")
+		return
+	}
+	if name := path.Base(resp.Request.URL.Path); name != "/" && name != "." {
+		upload.Filename = name
+	}
+	upload.DetectContentType()
+	if err = hr.CheckContent(r, upload); err != nil {
+		WriteError(rw, r, ContentStatus(err), err.Error())
+		return
+	}
+	if err = CheckRedirect(options, false, []*Upload{upload}); err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
+	}
+
+	WriteCreated(rw, r, []*PasteInfo{hr.createPaste(r, options, upload)})
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrContentRejected is returned for upload rejected by content filter.
+var ErrContentRejected = errors.New("paste rejected")
+
+// Content filter actions.
+const (
+	FilterReject     = "reject"
+	FilterQuarantine = "quarantine"
+	FilterExpire     = "expire"
+)
+
+// Base64MinRun is the shortest run of base64 characters counted as part of
+// encoded blob, shorter ones are likely words.
+const Base64MinRun = 64
+
+// FilterRule acts on uploads matching all of its conditions.
+type FilterRule struct {
+	Action string
+	// Time to live of paste for FilterExpire
+	Expire time.Duration
+	Reason string
+
+	Match   *regexp.Regexp
+	Type    string
+	MinSize int64
+	MaxSize int64
+	Base64  float64
+}
+
+// ContentFilter is the list of rules uploads are checked against in order.
+// Rejecting rule stops the check, quarantine and expiry add up.
+type ContentFilter []*FilterRule
+
+// splitRuleFields splits line by spaces, except ones in double-quoted
+// values like match="a b". Quoted values are taken as is, there are no
+// escapes, \x22 stands for quote in regular expressions.
+func splitRuleFields(line string) ([]string, error) {
+	var fields []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		end := strings.IndexAny(line, " \t")
+		if end == -1 {
+			end = len(line)
+		}
+		if eq := strings.Index(line, "="); eq != -1 && eq < end && strings.HasPrefix(line[eq+1:], `"`) {
+			value, rest, ok := strings.Cut(line[eq+2:], `"`)
+			if !ok {
+				return nil, fmt.Errorf("unterminated quote in %s", line[:eq])
+			}
+			fields = append(fields, line[:eq+1]+value)
+			line = rest
+			continue
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+	return fields, nil
+}
+
+// LoadContentFilter reads rules file. Every line is "action [condition=value
+// ...] [reason=text]", where action is reject, quarantine or expire=<ttl>,
+// and conditions are match (regular expression), type (MIME type, may end
+// with /*), min-size, max-size (in bytes) and base64 (share of content in
+// base64 blobs, 0 to 1). Values with spaces are double-quoted. Blank lines
+// and lines starting with # are ignored.
+func LoadContentFilter(filename string) (ContentFilter, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("filter rules: %s", err)
+	}
+	defer file.Close()
+	var filter ContentFilter
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields, err := splitRuleFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("filter rules: %s:%d: %s", filename, lineNo, err)
+		}
+		rule := &FilterRule{}
+		for i, field := range fields {
+			name, value, _ := strings.Cut(field, "=")
+			if i == 0 {
+				switch name {
+				case FilterReject, FilterQuarantine:
+					if value != "" {
+						return nil, fmt.Errorf("filter rules: %s:%d: %s takes no value", filename, lineNo, name)
+					}
+				case FilterExpire:
+					rule.Expire, err = ParseExpiry(value)
+					if err == nil && rule.Expire <= 0 {
+						err = errors.New("must be positive")
+					}
+					if err != nil {
+						return nil, fmt.Errorf("filter rules: %s:%d: invalid expire: %s", filename, lineNo, err)
+					}
+				default:
+					return nil, fmt.Errorf("filter rules: %s:%d: unknown action %s", filename, lineNo, name)
+				}
+				rule.Action = name
+				continue
+			}
+			switch name {
+			case "reason":
+				rule.Reason = value
+			case "match":
+				rule.Match, err = regexp.Compile(value)
+			case "type":
+				rule.Type = value
+			case "min-size":
+				rule.MinSize, err = strconv.ParseInt(value, 10, 64)
+			case "max-size":
+				rule.MaxSize, err = strconv.ParseInt(value, 10, 64)
+			case "base64":
+				rule.Base64, err = strconv.ParseFloat(value, 64)
+				if err == nil && (rule.Base64 <= 0 || rule.Base64 > 1) {
+					err = errors.New("must be between 0 and 1")
+				}
+			default:
+				err = errors.New("unknown condition")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("filter rules: %s:%d: invalid %s: %s", filename, lineNo, name, err)
+			}
+		}
+		filter = append(filter, rule)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("filter rules: %s", err)
+	}
+	return filter, nil
+}
+
+// Base64Share returns share of content taken by runs of base64 characters
+// at least Base64MinRun long, line breaks of wrapped blobs included.
+func Base64Share(content io.Reader, size int64) (float64, error) {
+	if size == 0 {
+		return 0, nil
+	}
+	reader := bufio.NewReader(content)
+	var encoded, run int64
+	for {
+		c, err := reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '+', c == '/', c == '=', c == '-', c == '_':
+			run++
+			continue
+		case (c == '\n' || c == '\r') && run >= Base64MinRun:
+			// Wrapped blob goes on on the next line
+			encoded += run + 1
+			run = 0
+			continue
+		}
+		if run >= Base64MinRun {
+			encoded += run
+		}
+		run = 0
+	}
+	if run >= Base64MinRun {
+		encoded += run
+	}
+	return float64(encoded) / float64(size), nil
+}
+
+// Matches tells whether upload meets all conditions of rule.
+func (rule *FilterRule) Matches(upload *Upload) bool {
+	if rule.MinSize > 0 && upload.Size < rule.MinSize {
+		return false
+	}
+	if rule.MaxSize > 0 && upload.Size > rule.MaxSize {
+		return false
+	}
+	if rule.Type != "" {
+		mediaType, _, _ := strings.Cut(upload.ContentType, ";")
+		if ok, _ := path.Match(rule.Type, strings.TrimSpace(mediaType)); !ok {
+			return false
+		}
+	}
+	// Content is read from spooled file without moving its offset
+	if rule.Base64 > 0 {
+		share, err := Base64Share(io.NewSectionReader(upload.File, 0, upload.Size), upload.Size)
+		if err != nil {
+			panic(err)
+		}
+		if share < rule.Base64 {
+			return false
+		}
+	}
+	return rule.Match == nil || rule.Match.MatchReader(bufio.NewReader(io.NewSectionReader(upload.File, 0, upload.Size)))
+}
+
+// Apply checks upload against rules. Error wrapping ErrContentRejected is
+// returned if upload is rejected, otherwise quarantine reason and time to
+// live are recorded on upload for paste to be created with.
+func (cf ContentFilter) Apply(upload *Upload) error {
+	for _, rule := range cf {
+		if !rule.Matches(upload) {
+			continue
+		}
+		reason := rule.Reason
+		if reason == "" {
+			reason = "matched content filter"
+		}
+		switch rule.Action {
+		case FilterReject:
+			return fmt.Errorf("%w: %s", ErrContentRejected, reason)
+		case FilterQuarantine:
+			if upload.Quarantine == "" {
+				upload.Quarantine = reason
+			}
+		case FilterExpire:
+			if upload.MaxTTL == 0 || rule.Expire < upload.MaxTTL {
+				upload.MaxTTL = rule.Expire
+			}
+		}
+	}
+	return nil
+}
+
+// CheckContent refuses uploads over quota of request's client, whose
+// content is blocked or rejected by content filter or secrets policy, which
+// couldn't be scanned for viruses, or which don't fit in storage, see
+// ContentStatus.
+func (hr *HttpRoutes) CheckContent(r *http.Request, uploads ...*Upload) error {
+	if err := hr.ipQuota.Check(r, uploads); err != nil {
+		return err
+	}
+	if err := hr.usage.CheckUploads(uploads); err != nil {
+		return err
+	}
+	if err := hr.blocklist.CheckUploads(uploads); err != nil {
+		return err
+	}
+	for _, upload := range uploads {
+		if err := hr.filter.Apply(upload); err != nil {
+			return err
+		}
+		if err := hr.checkSecrets(upload); err != nil {
+			return err
+		}
+		if err := hr.scanUpload(upload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ContentStatus is HTTP status of error returned by CheckContent.
+func ContentStatus(err error) int {
+	if errors.Is(err, ErrContentBlocked) {
+		return 451
+	}
+	if errors.Is(err, ErrScanFailed) {
+		return 503
+	}
+	if errors.Is(err, ErrStorageFull) {
+		return 507
+	}
+	if errors.Is(err, ErrQuotaExceeded) {
+		return 429
+	}
+	return 403
+}
+
+// ApplyFilter holds paste for review and shortens its life as content
+// filter decided for upload.
+func (m *PasteMeta) ApplyFilter(upload *Upload, now time.Time) {
+	if upload.Quarantine != "" {
+		m.Quarantine = upload.Quarantine
+	}
+	if upload.MaxTTL > 0 {
+		expires := now.Add(upload.MaxTTL)
+		if m.Expires == nil || expires.Before(*m.Expires) {
+			m.Expires = &expires
+		}
+	}
+}
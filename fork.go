@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// ForkPaste creates a new paste from existing one, with content of request
+// body if any, so that shared snippet can be changed without touching the
+// original. New paste gets its own options and tokens, name, type and tags
+// are inherited unless given.
+func (hr *HttpRoutes) ForkPaste(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	hash, _ := mux.Vars(r)["hash"]
+	name := hr.HashName(hash)
+	parent, content, err := hr.storage.Load(name)
+	if err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			PasteNotFound(rw, r, hash)
+			return
+		}
+		panic(err)
+	}
+	if parent.Expired() || !hr.CanAccess(hash, parent) {
+		PasteNotFound(rw, r, hash)
+		return
+	}
+	// Fork would let burn paste be read more than once
+	if parent.Burn {
+		WriteError(rw, r, 400, "burn pastes can't be forked")
+		return
+	}
+
+	options, err := hr.PasteOptions(RequestOptions(r))
+	if err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
+	}
+	// Fork of client-encrypted paste is ciphertext too
+	options.Encrypted = parent.Encrypted
+	options.Parent, _, _ = strings.Cut(hash, "-")
+	if options.Tags == nil {
+		options.Tags = parent.Tags
+	}
+
+	var upload *Upload
+	if r.ContentLength != 0 {
+		uploads := hr.readPastes(rw, r)
+		if uploads == nil {
+			return
+		}
+		defer CloseUploads(uploads)
+		if len(uploads) > 1 {
+			WriteError(rw, r, 400, "paste can only be forked with a single file")
+			return
+		}
+		if options.Encrypted && !ValidCiphertext(uploads) {
+			WriteError(rw, r, 400, "encrypted paste must be 12-byte IV followed by AES-GCM ciphertext")
+			return
+		}
+		upload = uploads[0]
+	}
+
+	// Password opens protected parent and protects the fork, so protected
+	// paste stays protected with the same password
+	password := PasteOption(r, "password", "X-Password")
+	if parent.Password != nil {
+		if password == "" {
+			PasswordRequired(rw, r, hash, false)
+			return
+		}
+		if content, _, err = hr.passwords.Unlock(r, hash, parent.Password, password, content); err != nil {
+			if errors.Is(err, ErrInvalidPassword) {
+				PasswordRequired(rw, r, hash, true)
+				return
+			}
+			if errors.Is(err, ErrPasswordAttempts) {
+				WriteError(rw, r, 429, err.Error())
+				return
+			}
+			panic(err)
+		}
+	}
+
+	if upload == nil {
+		spool, err := SpoolPaste(bytes.NewReader(content))
+		if err != nil {
+			panic(err)
+		}
+		upload = &Upload{Spool: spool, ContentType: parent.ContentType, Filename: parent.Filename}
+		defer upload.Close()
+		if err = hr.CheckContent(r, upload); err != nil {
+			WriteError(rw, r, ContentStatus(err), err.Error())
+			return
+		}
+	}
+	if err = CheckRedirect(options, password != "", []*Upload{upload}); err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
+	}
+	if password != "" {
+		if options.Password, err = NewPasswordKDF(); err != nil {
+			panic(err)
+		}
+		if err = upload.Encrypt(hr.passwords.Key(options.Password, password)); err != nil {
+			panic(err)
+		}
+	}
+
+	WriteCreated(rw, r, []*PasteInfo{hr.createPaste(r, options, upload)})
+}
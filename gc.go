@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// OrphanMinAge is the age files of pastes must reach before they're
+// collected as orphans, uploads being saved never take that long.
+const OrphanMinAge = time.Hour
+
+// GCResult is the outcome of garbage collection.
+type GCResult struct {
+	Deleted int `json:"deleted"`
+	// Size of deleted content and revisions, and orphaned files
+	Bytes int64 `json:"bytes"`
+}
+
+// CollectGarbage deletes expired pastes, which are otherwise only hidden,
+// along with their revisions, stats and index entries. Files left over from
+// deleted pastes, burn-after-read ones mostly, are removed too if storage
+// keeps any.
+func (hr *HttpRoutes) CollectGarbage() (*GCResult, error) {
+	names, err := hr.storage.List()
+	if err != nil {
+		return nil, err
+	}
+	result := &GCResult{}
+	// Usage is recounted on the way
+	var kept int64
+	for _, name := range names {
+		meta, err := hr.storage.LoadMeta(name)
+		if err != nil {
+			if !errors.Is(err, ErrPasteNotFound) {
+				slog.Warn("failed to collect paste", "paste", name, "error", err)
+			}
+			continue
+		}
+		if !meta.Expired() {
+			kept += meta.StoredSize()
+			continue
+		}
+		if err = hr.storage.Delete(name); err != nil {
+			if errors.Is(err, ErrPasteNotFound) {
+				continue
+			}
+			return result, err
+		}
+		if hr.search != nil {
+			if err = hr.search.Remove(name); err != nil {
+				slog.Warn("failed to unindex paste", "paste", name, "error", err)
+			}
+		}
+		result.Deleted++
+		result.Bytes += meta.StoredSize()
+	}
+	hr.usage.Set(kept)
+	if collector, ok := hr.storage.(OrphanCollector); ok {
+		reclaimed, err := collector.CollectOrphans(OrphanMinAge)
+		result.Bytes += reclaimed
+		if err != nil {
+			return result, err
+		}
+	}
+	metricGCDeleted.Add(float64(result.Deleted))
+	metricGCReclaimed.Add(float64(result.Bytes))
+	return result, nil
+}
+
+// RunGC collects garbage every gc-interval until ctx is done.
+func (hr *HttpRoutes) RunGC(ctx context.Context) {
+	ticker := time.NewTicker(hr.config.GCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := hr.CollectGarbage()
+			if err != nil {
+				slog.Error("failed to collect garbage", "error", err)
+				continue
+			}
+			if result.Deleted > 0 || result.Bytes > 0 {
+				slog.Info("garbage collected", "pastes", result.Deleted, "bytes", result.Bytes)
+			}
+		}
+	}
+}
+
+// AdminCollectGarbage runs garbage collection right away.
+func (hr *HttpRoutes) AdminCollectGarbage(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	result, err := hr.CollectGarbage()
+	if err != nil {
+		panic(err)
+	}
+	RequestLogger(r).Info("garbage collected by admin", "pastes", result.Deleted, "bytes", result.Bytes)
+	hr.audit(r, &AuditEvent{Action: AuditGC})
+	WriteJSON(rw, 200, result)
+}
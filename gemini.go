@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GeminiDeadline limits the whole Gemini connection.
+const GeminiDeadline = 30 * time.Second
+
+// GeminiMaxRequest is maximum length of request URL, as per specification.
+const GeminiMaxRequest = 1024
+
+// GeminiServer serves pastes over Gemini protocol: root is gemtext index of
+// recent pastes, /<id> shows paste and /<id>/raw serves it as is. Pastes
+// can't be created over Gemini.
+type GeminiServer struct {
+	routes   *HttpRoutes
+	listener net.Listener
+	pattern  *regexp.Regexp
+	wg       sync.WaitGroup
+}
+
+// LoadGeminiCertificate reads certificate for Gemini server, generating
+// self-signed one for hostname if files don't exist yet. Gemini clients
+// trust certificate on first use, so it's fine not to have CA sign it.
+func LoadGeminiCertificate(certPath string, keyPath string, hostname string) (tls.Certificate, error) {
+	_, err := os.Stat(certPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("gemini certificate: %s", err)
+		}
+		serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("gemini certificate: %s", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: serial,
+			Subject:      pkix.Name{CommonName: hostname},
+			DNSNames:     []string{hostname},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().AddDate(10, 0, 0),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("gemini certificate: %s", err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("gemini certificate: %s", err)
+		}
+		if err = os.MkdirAll(path.Dir(certPath), 0755); err == nil {
+			err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+		}
+		if err == nil {
+			err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+		}
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("gemini certificate: %s", err)
+		}
+		slog.Info("gemini certificate generated", "path", certPath, "hostname", hostname)
+	} else if err != nil {
+		return tls.Certificate{}, fmt.Errorf("gemini certificate: %s", err)
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("gemini certificate: %s", err)
+	}
+	return cert, nil
+}
+
+func NewGeminiServer(config *Config, routes *HttpRoutes, idPattern string) (*GeminiServer, error) {
+	certPath, keyPath := config.GeminiCert, config.GeminiKey
+	if certPath == "" {
+		certPath, keyPath = path.Join(config.DataDir, "gemini.crt"), path.Join(config.DataDir, "gemini.key")
+	}
+	hostname := "localhost"
+	if publicURL, err := url.Parse(config.PublicURL); err == nil && publicURL.Hostname() != "" {
+		hostname = publicURL.Hostname()
+	}
+	cert, err := LoadGeminiCertificate(certPath, keyPath, hostname)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := Listen("gemini-listen", config.GeminiListen)
+	if err != nil {
+		return nil, fmt.Errorf("gemini: %s", err)
+	}
+	listener = tls.NewListener(listener, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	return &GeminiServer{
+		routes:   routes,
+		listener: listener,
+		pattern:  regexp.MustCompile(fmt.Sprintf("^/(%s)(/raw)?$", idPattern)),
+	}, nil
+}
+
+// Serve accepts connections until server is closed.
+func (gs *GeminiServer) Serve() error {
+	for {
+		conn, err := gs.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return fmt.Errorf("gemini: %s", err)
+		}
+		gs.wg.Add(1)
+		go func() {
+			defer gs.wg.Done()
+			gs.handle(conn)
+		}()
+	}
+}
+
+// Close stops accepting connections and waits for pending ones, which are
+// bounded by GeminiDeadline.
+func (gs *GeminiServer) Close() error {
+	err := gs.listener.Close()
+	gs.wg.Wait()
+	return err
+}
+
+// geminiHeader writes response header: status and meta, which is MIME type
+// for success, URL for redirects and message for anything else.
+func geminiHeader(w io.Writer, status int, meta string) {
+	fmt.Fprintf(w, "%d %s\r\n", status, meta)
+}
+
+func (gs *GeminiServer) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(GeminiDeadline))
+	r, err := ListenerRequest(gs.routes.config.PublicURL, conn.RemoteAddr().String(), "gemini")
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			RequestLogger(r).Error("internal error", "error", strings.TrimSpace(fmt.Sprint(rec)))
+			geminiHeader(conn, 40, fmt.Sprintf("internal error, ref=%s", GetRequestInfo(r).ID))
+		}
+	}()
+
+	// URL is followed by CRLF
+	line, err := bufio.NewReaderSize(io.LimitReader(conn, GeminiMaxRequest+2), GeminiMaxRequest+2).ReadString('\n')
+	if err != nil {
+		if len(line) > GeminiMaxRequest {
+			geminiHeader(conn, 59, "request too long")
+			return
+		}
+		RequestLogger(r).Info("gemini request failed", "error", err)
+		return
+	}
+	request, err := url.Parse(strings.TrimRight(line, "\r\n"))
+	if err != nil || !request.IsAbs() {
+		geminiHeader(conn, 59, "bad request")
+		return
+	}
+	if request.Scheme != "gemini" {
+		geminiHeader(conn, 53, "proxy request refused")
+		return
+	}
+	r.URL.Path = request.Path
+
+	if request.Path == "" || request.Path == "/" {
+		gs.index(conn, r)
+		return
+	}
+	match := gs.pattern.FindStringSubmatch(request.Path)
+	if match == nil {
+		geminiHeader(conn, 51, "not found")
+		return
+	}
+	password, _ := url.QueryUnescape(request.RawQuery)
+	gs.paste(conn, r, match[1], match[2] != "", password)
+}
+
+func (gs *GeminiServer) index(w io.Writer, r *http.Request) {
+	pastes, err := gs.routes.recentPastes(r, RecentLimit, nil)
+	if err != nil {
+		panic(err)
+	}
+	geminiHeader(w, 20, "text/gemini; charset=utf-8")
+	io.WriteString(w, "# paast\n\n")
+	if len(pastes) == 0 {
+		io.WriteString(w, "No public pastes yet.\n")
+	}
+	for _, paste := range pastes {
+		fmt.Fprintf(w, "=> /%s %s %s\n", paste.ID, paste.Created.Format(time.DateOnly), strings.TrimSpace(paste.Title()))
+	}
+	if publicURL := gs.routes.config.PublicURL; publicURL != "" {
+		fmt.Fprintf(w, "\n=> %s Create pastes on the web\n", publicURL)
+	}
+}
+
+func (gs *GeminiServer) paste(w io.Writer, r *http.Request, hash string, raw bool, password string) {
+	SetPasteID(r, hash)
+	meta, content, err := gs.routes.readPaste(r, hash, password)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrPasteNotFound):
+			geminiHeader(w, 51, fmt.Sprintf("paste with id \"%s\" was not found", hash))
+		case errors.Is(err, ErrPasswordRequired):
+			geminiHeader(w, 11, "Password")
+		case errors.Is(err, ErrInvalidPassword):
+			geminiHeader(w, 11, "Invalid password, try again")
+		case errors.Is(err, ErrPasswordAttempts):
+			// Meta of slow down is seconds to wait, lockout is the
+			// longest wait
+			geminiHeader(w, 44, fmt.Sprint(int64(gs.routes.config.PasswordLockout.Seconds())))
+		default:
+			panic(err)
+		}
+		return
+	}
+	if meta.Encrypted {
+		geminiHeader(w, 50, "paste is encrypted, it can only be read over HTTP")
+		return
+	}
+	if !raw && IsBinary(content) {
+		geminiHeader(w, 31, fmt.Sprintf("/%s/raw", hash))
+		return
+	}
+	if err = gs.routes.viewPaste(r, hash, meta); err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			geminiHeader(w, 51, fmt.Sprintf("paste with id \"%s\" was not found", hash))
+			return
+		}
+		panic(err)
+	}
+	// Content with lines toggling preformatting can't be wrapped into
+	// preformatted block
+	if raw || bytes.HasPrefix(content, []byte("```")) || bytes.Contains(content, []byte("\n```")) {
+		geminiHeader(w, 20, PasteContentType(meta, ""))
+		w.Write(content)
+		return
+	}
+	title := meta.Filename
+	if title == "" {
+		title = hash
+	}
+	geminiHeader(w, 20, "text/gemini; charset=utf-8")
+	fmt.Fprintf(w, "# %s\n\n```%s\n", title, meta.Language)
+	w.Write(content)
+	if !bytes.HasSuffix(content, []byte("\n")) {
+		io.WriteString(w, "\n")
+	}
+	fmt.Fprintf(w, "```\n\n=> /%s/raw Raw\n", hash)
+}
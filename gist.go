@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// GistResponseLimit caps GitHub API response, it truncates big files itself.
+const GistResponseLimit = 16 << 20
+
+// GistIDPattern matches gist ID, alone or at the end of gist URL.
+var GistIDPattern = regexp.MustCompile(`(?:^|/)([0-9a-f]{20,40})/?$`)
+
+type GistFile struct {
+	Filename  string `json:"filename,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	RawURL    string `json:"raw_url,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Content   string `json:"content"`
+}
+
+type Gist struct {
+	ID          string               `json:"id,omitempty"`
+	HTMLURL     string               `json:"html_url,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Public      bool                 `json:"public"`
+	Files       map[string]*GistFile `json:"files"`
+}
+
+// GistToken returns GitHub token sent along with request, it's only passed
+// to GitHub and never stored.
+func GistToken(r *http.Request) string {
+	return r.Header.Get("X-Github-Token")
+}
+
+// gistRequest calls GitHub API and decodes its response into result. Errors
+// of GitHub are returned as status and message to respond with.
+func (hr *HttpRoutes) gistRequest(r *http.Request, method string, path string, body interface{}, result interface{}) (int, string) {
+	var payload io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			panic(err)
+		}
+		payload = bytes.NewReader(content)
+	}
+	req, err := http.NewRequestWithContext(r.Context(), method, strings.TrimSuffix(hr.config.GistAPI, "/")+path, payload)
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "paast")
+	if token := GistToken(r); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := hr.gistClient.Do(req)
+	if err != nil {
+		RequestLogger(r).Info("gist request failed", "error", err)
+		return 502, "could not connect to GitHub"
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == 401:
+		return 403, "GitHub rejected token"
+	case resp.StatusCode == 404:
+		return 404, "gist was not found"
+	case resp.StatusCode >= 300:
+		return 502, fmt.Sprintf("GitHub responded with %s", resp.Status)
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, GistResponseLimit)).Decode(result); err != nil {
+		RequestLogger(r).Info("gist request failed", "error", err)
+		return 502, "invalid response from GitHub"
+	}
+	return 0, ""
+}
+
+// ExportGist creates gist from paste with token given in X-GitHub-Token
+// header. Gist is secret unless public is set.
+func (hr *HttpRoutes) ExportGist(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	hash, _ := mux.Vars(r)["hash"]
+	if GistToken(r) == "" {
+		WriteError(rw, r, 401, "GitHub token is required in X-GitHub-Token header")
+		return
+	}
+	public, _ := strconv.ParseBool(r.URL.Query().Get("public"))
+
+	meta, content, err := hr.storage.Load(hr.HashName(hash))
+	if err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			PasteNotFound(rw, r, hash)
+			return
+		}
+		panic(err)
+	}
+	if meta.Expired() || !hr.CanAccess(hash, meta) {
+		PasteNotFound(rw, r, hash)
+		return
+	}
+	if meta.Burn || meta.Encrypted {
+		WriteError(rw, r, 400, "burn and encrypted pastes can't be exported")
+		return
+	}
+	if meta.Password != nil {
+		password := PastePassword(r)
+		if password == "" {
+			PasswordRequired(rw, r, hash, false)
+			return
+		}
+		if content, _, err = hr.passwords.Unlock(r, hash, meta.Password, password, content); err != nil {
+			if errors.Is(err, ErrInvalidPassword) {
+				PasswordRequired(rw, r, hash, true)
+				return
+			}
+			if errors.Is(err, ErrPasswordAttempts) {
+				WriteError(rw, r, 429, err.Error())
+				return
+			}
+			panic(err)
+		}
+	}
+	if IsBinary(content) {
+		WriteError(rw, r, 400, "binary pastes can't be exported")
+		return
+	}
+
+	filename := meta.Filename
+	if filename == "" {
+		filename = hash + LanguageExtension(meta.Language)
+		if meta.Language == "" {
+			filename += ".txt"
+		}
+	}
+	description := r.URL.Query().Get("description")
+	if description == "" {
+		description = "Paste " + BaseURL(r) + "/" + hash
+	}
+	gist := &Gist{}
+	if code, msg := hr.gistRequest(r, "POST", "/gists", &Gist{
+		Description: description,
+		Public:      public,
+		Files:       map[string]*GistFile{filename: {Content: string(content)}},
+	}, gist); code != 0 {
+		WriteError(rw, r, code, msg)
+		return
+	}
+	RequestLogger(r).Info("paste exported to gist", "gist", gist.ID)
+	if WantsJSON(r) {
+		WriteJSON(rw, 200, map[string]string{"id": gist.ID, "url": gist.HTMLURL})
+		return
+	}
+	rw.WriteHeader(200)
+	rw.Write([]byte(gist.HTMLURL + "\n"))
+}
+
+// ImportGist creates pastes from files of gist given by ID or URL in id
+// parameter, one paste per file, with the same options as regular upload.
+func (hr *HttpRoutes) ImportGist(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	options, err := hr.PasteOptions(RequestOptions(r))
+	if err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
+	}
+	match := GistIDPattern.FindStringSubmatch(r.FormValue("id"))
+	if match == nil {
+		WriteError(rw, r, 400, "id parameter must be gist ID or URL")
+		return
+	}
+	gist := &Gist{}
+	if code, msg := hr.gistRequest(r, "GET", "/gists/"+match[1], nil, gist); code != 0 {
+		WriteError(rw, r, code, msg)
+		return
+	}
+	if len(gist.Files) == 0 {
+		WriteError(rw, r, 400, "gist is empty")
+		return
+	}
+	if len(gist.Files) > hr.config.MaxParts {
+		WriteError(rw, r, 400, fmt.Sprintf("too many files, limit is %d", hr.config.MaxParts))
+		return
+	}
+
+	limit := MaxBodyLen(r, hr.config)
+	names := []string{}
+	for name := range gist.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var uploads []*Upload
+	defer func() {
+		CloseUploads(uploads)
+	}()
+	for _, name := range names {
+		file := gist.Files[name]
+		if file.Size > limit {
+			WriteError(rw, r, 413, fmt.Sprintf("gist file %s too large, limit is %s", name, FormatSize(limit)))
+			return
+		}
+		// API returns only the beginning of big files
+		content := io.Reader(strings.NewReader(file.Content))
+		if file.Truncated {
+			resp, err := hr.gistClient.Get(file.RawURL)
+			if err != nil {
+				RequestLogger(r).Info("gist request failed", "error", err)
+				WriteError(rw, r, 502, "could not download gist file")
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				WriteError(rw, r, 502, fmt.Sprintf("could not download gist file: GitHub responded with %s", resp.Status))
+				return
+			}
+			content = io.LimitReader(resp.Body, limit+1)
+		}
+		spool, err := SpoolPaste(content)
+		if err != nil {
+			panic(err)
+		}
+		upload := &Upload{Spool: spool, Filename: file.Filename}
+		uploads = append(uploads, upload)
+		if spool.Size > limit {
+			WriteError(rw, r, 413, fmt.Sprintf("gist file %s too large, limit is %s", name, FormatSize(limit)))
+			return
+		}
+		if spool.Size == 0 {
+			WriteError(rw, r, 400, fmt.Sprintf("gist file %s is empty", name))
+			return
+		}
+		upload.DetectContentType()
+	}
+	if err = hr.CheckContent(r, uploads...); err != nil {
+		WriteError(rw, r, ContentStatus(err), err.Error())
+		return
+	}
+	if err = CheckRedirect(options, false, uploads); err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
+	}
+
+	var pastes []*PasteInfo
+	for _, upload := range uploads {
+		pastes = append(pastes, hr.createPaste(r, options, upload))
+	}
+	RequestLogger(r).Info("gist imported", "gist", gist.ID, "files", len(pastes))
+	WriteCreated(rw, r, pastes)
+}
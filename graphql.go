@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+// GraphQLSchema describes pastes the same way as JSON API. Reading content
+// of burn paste deletes it, its metadata can be read any number of times.
+const GraphQLSchema = `
+scalar Time
+
+type Query {
+	# Paste with given ID, null if it doesn't exist or has expired
+	paste(id: ID!): Paste
+	# Newest public pastes with all of given tags
+	recentPastes(tags: [String!], limit: Int = 50): [Paste!]!
+}
+
+type Mutation {
+	createPaste(content: String!, options: PasteOptions): Paste!
+	deletePaste(id: ID!, token: String!): Boolean!
+}
+
+input PasteOptions {
+	expire: String
+	burn: Boolean
+	private: Boolean
+	visibility: String
+	tags: [String!]
+	type: String
+	filename: String
+	contentType: String
+	password: String
+}
+
+type Paste {
+	id: ID!
+	url: String!
+	rawUrl: String!
+	# Tokens are only set for pastes which were just created
+	deleteUrl: String
+	deleteToken: String
+	editToken: String
+	duplicate: Boolean!
+	bytes: Int!
+	created: Time!
+	updated: Time
+	expires: Time
+	burn: Boolean!
+	private: Boolean!
+	visibility: String!
+	tags: [String!]!
+	language: String
+	extension: String
+	filename: String
+	encrypted: Boolean!
+	protected: Boolean!
+	parent: String
+	redirect: String
+	# Set for pastes which were just created and seem to contain secrets
+	warnings: [String!]!
+	# Password is required for protected pastes
+	content(password: String): Content
+}
+
+type Content {
+	data: String!
+	# Set to base64 when content is not valid UTF-8
+	encoding: String
+}
+`
+
+// GraphQLMaxDepth limits nesting of queries, schema is flat anyway.
+const GraphQLMaxDepth = 5
+
+// GraphQL serves GraphQLSchema at /graphql.
+type GraphQL struct {
+	routes      *HttpRoutes
+	rateLimiter *RateLimiter
+	schema      *graphql.Schema
+}
+
+type graphqlRequestKey struct{}
+
+func graphqlRequest(ctx context.Context) *http.Request {
+	return ctx.Value(graphqlRequestKey{}).(*http.Request)
+}
+
+// graphqlPanics logs panics of resolvers the same way RecoverError does and
+// turns them into errors with reference to log.
+type graphqlPanics struct{}
+
+func (graphqlPanics) LogPanic(ctx context.Context, value any) {
+	RequestLogger(graphqlRequest(ctx)).Error("internal error", "error", strings.TrimSpace(fmt.Sprint(value)))
+}
+
+func (graphqlPanics) MakePanicError(ctx context.Context, value any) *gqlerrors.QueryError {
+	return gqlerrors.Errorf("internal error, ref=%s", GetRequestInfo(graphqlRequest(ctx)).ID)
+}
+
+func NewGraphQL(routes *HttpRoutes, rateLimiter *RateLimiter) (*GraphQL, error) {
+	gql := &GraphQL{routes: routes, rateLimiter: rateLimiter}
+	schema, err := graphql.ParseSchema(
+		GraphQLSchema,
+		&graphqlResolver{gql},
+		graphql.MaxDepth(GraphQLMaxDepth),
+		graphql.Logger(graphqlPanics{}),
+		graphql.PanicHandler(graphqlPanics{}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("graphql: %s", err)
+	}
+	gql.schema = schema
+	return gql, nil
+}
+
+// GraphQLRequest is body of POST /graphql.
+type GraphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Serve executes query POSTed as JSON, GET returns schema. Requests of other
+// types are refused so that forms of other sites can't run mutations.
+func (gql *GraphQL) Serve(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	if r.Method == "GET" {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.WriteHeader(200)
+		rw.Write([]byte(strings.TrimPrefix(GraphQLSchema, "\n")))
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		WriteError(rw, r, 415, "query must be sent as application/json")
+		return
+	}
+	// JSON escaping takes up to six bytes per byte of content
+	r.Body = http.MaxBytesReader(rw, r.Body, MaxBodyLen(r, gql.routes.config)*6+64<<10)
+	var req GraphQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			WriteError(rw, r, 413, "request too large")
+			return
+		}
+		WriteError(rw, r, 400, fmt.Sprintf("invalid request: %s", err))
+		return
+	}
+	ctx := context.WithValue(r.Context(), graphqlRequestKey{}, r)
+	WriteJSON(rw, 200, gql.schema.Exec(ctx, req.Query, req.OperationName, req.Variables))
+}
+
+type graphqlResolver struct {
+	gql *GraphQL
+}
+
+func (gr *graphqlResolver) Paste(ctx context.Context, args struct{ ID graphql.ID }) *pasteResolver {
+	r := graphqlRequest(ctx)
+	hr := gr.gql.routes
+	hash := string(args.ID)
+	meta, err := hr.storage.LoadMeta(hr.HashName(hash))
+	if err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			return nil
+		}
+		panic(err)
+	}
+	if meta.Expired() || !hr.CanAccess(hash, meta) {
+		return nil
+	}
+	size := meta.Size
+	if meta.Password != nil {
+		size -= PasswordOverhead
+	}
+	return &pasteResolver{hr, NewPasteInfo(r, hash, meta, int(size))}
+}
+
+func (gr *graphqlResolver) RecentPastes(ctx context.Context, args struct {
+	Tags  *[]string
+	Limit int32
+}) ([]*pasteResolver, error) {
+	var tags []string
+	if args.Tags != nil {
+		var err error
+		if tags, err = ParseTags(strings.Join(*args.Tags, ",")); err != nil {
+			return nil, err
+		}
+	}
+	if args.Limit < 1 || args.Limit > RecentLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", RecentLimit)
+	}
+	pastes, err := gr.gql.routes.recentPastes(graphqlRequest(ctx), int(args.Limit), tags)
+	if err != nil {
+		panic(err)
+	}
+	resolvers := make([]*pasteResolver, len(pastes))
+	for i, paste := range pastes {
+		resolvers[i] = &pasteResolver{gr.gql.routes, paste.PasteInfo}
+	}
+	return resolvers, nil
+}
+
+// graphqlOptions are options of createPaste, same as of HTTP API.
+type graphqlOptions struct {
+	Expire      *string
+	Burn        *bool
+	Private     *bool
+	Visibility  *string
+	Tags        *[]string
+	Type        *string
+	Filename    *string
+	ContentType *string
+	Password    *string
+}
+
+func (opts *graphqlOptions) option(name string) string {
+	var value any
+	switch name {
+	case "expire":
+		value = opts.Expire
+	case "burn":
+		value = opts.Burn
+	case "private":
+		value = opts.Private
+	case "visibility":
+		value = opts.Visibility
+	case "tags":
+		if opts.Tags != nil {
+			return strings.Join(*opts.Tags, ",")
+		}
+	case "type":
+		value = opts.Type
+	case "filename":
+		value = opts.Filename
+	case "contentType":
+		value = opts.ContentType
+	case "password":
+		value = opts.Password
+	}
+	switch value := value.(type) {
+	case *string:
+		if value != nil {
+			return *value
+		}
+	case *bool:
+		if value != nil {
+			return fmt.Sprint(*value)
+		}
+	}
+	return ""
+}
+
+func (gr *graphqlResolver) CreatePaste(ctx context.Context, args struct {
+	Content string
+	Options *graphqlOptions
+}) (*pasteResolver, error) {
+	r := graphqlRequest(ctx)
+	hr := gr.gql.routes
+	opts := args.Options
+	if opts == nil {
+		opts = &graphqlOptions{}
+	}
+	options, err := hr.PasteOptions(opts.option)
+	if err != nil {
+		return nil, err
+	}
+	if ban := hr.bans.Banned(RemoteIP(r)); ban != nil {
+		return nil, errors.New(BannedError(ban))
+	}
+	if ok, wait := gr.gql.rateLimiter.TakeRequest(r); !ok {
+		metricRateLimited.Inc()
+		return nil, fmt.Errorf("please wait %d seconds before creating new paste", int64(math.Ceil(wait.Seconds())))
+	}
+	if limit := MaxBodyLen(r, hr.config); int64(len(args.Content)) > limit {
+		return nil, fmt.Errorf("paste too large, limit is %s", FormatSize(limit))
+	}
+	if args.Content == "" {
+		return nil, errors.New("your paste is empty!")
+	}
+	spool, err := SpoolPaste(strings.NewReader(args.Content))
+	if err != nil {
+		panic(err)
+	}
+	upload := &Upload{Spool: spool, Filename: opts.option("filename"), ContentType: opts.option("contentType")}
+	defer upload.Close()
+	upload.DetectContentType()
+	if err = hr.CheckContent(r, upload); err != nil {
+		return nil, err
+	}
+	password := opts.option("password")
+	if err = CheckRedirect(options, password != "", []*Upload{upload}); err != nil {
+		return nil, err
+	}
+	if password != "" {
+		if options.Password, err = NewPasswordKDF(); err != nil {
+			panic(err)
+		}
+		if err = upload.Encrypt(hr.passwords.Key(options.Password, password)); err != nil {
+			panic(err)
+		}
+	}
+	return &pasteResolver{hr, hr.createPaste(r, options, upload)}, nil
+}
+
+func (gr *graphqlResolver) DeletePaste(ctx context.Context, args struct {
+	ID    graphql.ID
+	Token string
+}) (bool, error) {
+	r := graphqlRequest(ctx)
+	hash := string(args.ID)
+	if err := gr.gql.routes.deletePaste(r, hash, args.Token); err != nil {
+		switch {
+		case errors.Is(err, ErrPasteNotFound):
+			return false, fmt.Errorf("paste with id \"%s\" was not found", hash)
+		case errors.Is(err, ErrInvalidToken):
+			return false, err
+		}
+		panic(err)
+	}
+	return true, nil
+}
+
+type pasteResolver struct {
+	routes *HttpRoutes
+	info   *PasteInfo
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func optionalTime(value *time.Time) *graphql.Time {
+	if value == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *value}
+}
+
+func (pr *pasteResolver) ID() graphql.ID       { return graphql.ID(pr.info.ID) }
+func (pr *pasteResolver) URL() string          { return pr.info.URL }
+func (pr *pasteResolver) RawURL() string       { return pr.info.RawURL }
+func (pr *pasteResolver) DeleteURL() *string   { return optionalString(pr.info.DeleteURL) }
+func (pr *pasteResolver) DeleteToken() *string { return optionalString(pr.info.DeleteToken) }
+func (pr *pasteResolver) EditToken() *string   { return optionalString(pr.info.EditToken) }
+func (pr *pasteResolver) Duplicate() bool      { return pr.info.Duplicate }
+func (pr *pasteResolver) Bytes() int32         { return int32(min(pr.info.Bytes, math.MaxInt32)) }
+func (pr *pasteResolver) Created() graphql.Time {
+	return graphql.Time{Time: pr.info.Created}
+}
+func (pr *pasteResolver) Updated() *graphql.Time { return optionalTime(pr.info.Updated) }
+func (pr *pasteResolver) Expires() *graphql.Time { return optionalTime(pr.info.Expires) }
+func (pr *pasteResolver) Burn() bool             { return pr.info.Burn }
+func (pr *pasteResolver) Private() bool          { return pr.info.Private }
+func (pr *pasteResolver) Visibility() string     { return pr.info.Visibility }
+func (pr *pasteResolver) Tags() []string {
+	if pr.info.Tags == nil {
+		return []string{}
+	}
+	return pr.info.Tags
+}
+func (pr *pasteResolver) Language() *string  { return optionalString(pr.info.Language) }
+func (pr *pasteResolver) Extension() *string { return optionalString(pr.info.Extension) }
+func (pr *pasteResolver) Filename() *string  { return optionalString(pr.info.Filename) }
+func (pr *pasteResolver) Encrypted() bool    { return pr.info.Encrypted }
+func (pr *pasteResolver) Protected() bool    { return pr.info.Protected }
+func (pr *pasteResolver) Parent() *string    { return optionalString(pr.info.Parent) }
+func (pr *pasteResolver) Redirect() *string  { return optionalString(pr.info.Redirect) }
+func (pr *pasteResolver) Warnings() []string {
+	if pr.info.Warnings == nil {
+		return []string{}
+	}
+	return pr.info.Warnings
+}
+
+type contentResolver struct {
+	info *PasteInfo
+}
+
+func (cr *contentResolver) Data() string      { return *cr.info.Content }
+func (cr *contentResolver) Encoding() *string { return optionalString(cr.info.Encoding) }
+
+// Content is loaded only when asked for, so that burn paste survives
+// reading of its metadata.
+func (pr *pasteResolver) Content(ctx context.Context, args struct{ Password *string }) (*contentResolver, error) {
+	r := graphqlRequest(ctx)
+	password := ""
+	if args.Password != nil {
+		password = *args.Password
+	}
+	meta, content, err := pr.routes.readPaste(r, pr.info.ID, password)
+	if err == nil {
+		err = pr.routes.viewPaste(r, pr.info.ID, meta)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrPasteNotFound):
+			return nil, nil
+		case errors.Is(err, ErrPasswordRequired):
+			return nil, errors.New("password required")
+		case errors.Is(err, ErrInvalidPassword), errors.Is(err, ErrPasswordAttempts):
+			return nil, err
+		}
+		panic(err)
+	}
+	info := &PasteInfo{}
+	info.SetContent(content)
+	return &contentResolver{info}, nil
+}
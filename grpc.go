@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/and3rson/paast/pastepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer serves Pastes service of pastepb, gRPC counterpart of HTTP API
+// for services which would rather not parse text responses.
+type GRPCServer struct {
+	pastepb.UnimplementedPastesServer
+	routes      *HttpRoutes
+	rateLimiter *RateLimiter
+	apiKeys     APIKeys
+	listener    net.Listener
+	server      *grpc.Server
+}
+
+type grpcRequestKey struct{}
+
+// grpcStream passes context with request to streaming methods.
+type grpcStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (gs grpcStream) Context() context.Context {
+	return gs.ctx
+}
+
+func NewGRPCServer(config *Config, routes *HttpRoutes, rateLimiter *RateLimiter, apiKeys APIKeys) (*GRPCServer, error) {
+	listener, err := Listen("grpc-listen", config.GRPCListen)
+	if err != nil {
+		return nil, fmt.Errorf("grpc: %s", err)
+	}
+	// Content of CreatePaste comes in a single message, larger pastes can
+	// be streamed with UploadPaste
+	limit := config.MaxBodyLen
+	for _, key := range apiKeys {
+		limit = max(limit, key.MaxBodyLen)
+	}
+	gs := &GRPCServer{routes: routes, rateLimiter: rateLimiter, apiKeys: apiKeys, listener: listener}
+	gs.server = grpc.NewServer(
+		grpc.MaxRecvMsgSize(int(min(limit+64<<10, math.MaxInt32))),
+		grpc.UnaryInterceptor(gs.unary),
+		grpc.StreamInterceptor(gs.stream),
+	)
+	pastepb.RegisterPastesServer(gs.server, gs)
+	reflection.Register(gs.server)
+	return gs, nil
+}
+
+// Serve accepts connections until server is stopped.
+func (gs *GRPCServer) Serve() error {
+	if err := gs.server.Serve(gs.listener); err != nil {
+		return fmt.Errorf("grpc: %s", err)
+	}
+	return nil
+}
+
+// Close stops accepting connections and waits up to timeout for pending
+// calls.
+func (gs *GRPCServer) Close(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		gs.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		gs.server.Stop()
+	}
+}
+
+// request stands in for HTTP request of the call, with API key from
+// "authorization" metadata.
+func (gs *GRPCServer) request(ctx context.Context, method string) (*http.Request, error) {
+	addr := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	r, err := ListenerRequest(gs.routes.config.PublicURL, addr, "grpc")
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	info := GetRequestInfo(r)
+	info.Logger = info.Logger.With("method", method)
+	md, _ := metadata.FromIncomingContext(ctx)
+	if auth := md.Get("authorization"); len(auth) > 0 && strings.HasPrefix(auth[0], "Bearer ") {
+		key, ok := gs.apiKeys.Lookup(auth[0])
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "invalid API key")
+		}
+		info.APIKey = key
+		info.Logger = info.Logger.With("api_key", key.Name)
+	}
+	return r, nil
+}
+
+// grpcRecover turns panic into internal error with reference to log.
+func grpcRecover(r *http.Request, err *error) {
+	if rec := recover(); rec != nil {
+		RequestLogger(r).Error("internal error", "error", strings.TrimSpace(fmt.Sprint(rec)))
+		*err = status.Errorf(codes.Internal, "internal error, ref=%s", GetRequestInfo(r).ID)
+	}
+}
+
+func (gs *GRPCServer) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	r, err := gs.request(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	defer grpcRecover(r, &err)
+	return handler(context.WithValue(ctx, grpcRequestKey{}, r), req)
+}
+
+func (gs *GRPCServer) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	r, err := gs.request(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	defer grpcRecover(r, &err)
+	return handler(srv, grpcStream{ss, context.WithValue(ss.Context(), grpcRequestKey{}, r)})
+}
+
+// PasteProto converts paste info to its protobuf message.
+func PasteProto(info *PasteInfo) *pastepb.Paste {
+	paste := &pastepb.Paste{
+		Id:          info.ID,
+		Url:         info.URL,
+		RawUrl:      info.RawURL,
+		DeleteUrl:   info.DeleteURL,
+		DeleteToken: info.DeleteToken,
+		EditToken:   info.EditToken,
+		Bytes:       int64(info.Bytes),
+		Created:     timestamppb.New(info.Created),
+		Burn:        info.Burn,
+		Private:     info.Private,
+		Visibility:  info.Visibility,
+		Tags:        info.Tags,
+		Language:    info.Language,
+		Extension:   info.Extension,
+		Duplicate:   info.Duplicate,
+		Filename:    info.Filename,
+		Encrypted:   info.Encrypted,
+		Protected:   info.Protected,
+		Parent:      info.Parent,
+		Redirect:    info.Redirect,
+	}
+	if info.Updated != nil {
+		paste.Updated = timestamppb.New(*info.Updated)
+	}
+	if info.Expires != nil {
+		paste.Expires = timestamppb.New(*info.Expires)
+	}
+	return paste
+}
+
+func grpcFlag(value bool) string {
+	if value {
+		return "true"
+	}
+	return ""
+}
+
+// create creates paste from content read till the end, the same way
+// CreatePaste of HTTP API does.
+func (gs *GRPCServer) create(r *http.Request, opts *pastepb.PasteOptions, content io.Reader) (*pastepb.Paste, error) {
+	config := gs.routes.config
+	options, err := gs.routes.PasteOptions(func(name string) string {
+		switch name {
+		case "expire":
+			return opts.GetExpire()
+		case "burn":
+			return grpcFlag(opts.GetBurn())
+		case "private":
+			return grpcFlag(opts.GetPrivate())
+		case "visibility":
+			return opts.GetVisibility()
+		case "tags":
+			return strings.Join(opts.GetTags(), ",")
+		case "type":
+			return opts.GetType()
+		}
+		return ""
+	})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	options.Encrypted = opts.GetEncrypted()
+
+	if ban := gs.routes.bans.Banned(RemoteIP(r)); ban != nil {
+		return nil, status.Error(codes.PermissionDenied, BannedError(ban))
+	}
+	if ok, wait := gs.rateLimiter.TakeRequest(r); !ok {
+		metricRateLimited.Inc()
+		return nil, status.Errorf(codes.ResourceExhausted, "please wait %d seconds before creating new paste", int64(math.Ceil(wait.Seconds())))
+	}
+	limit := MaxBodyLen(r, config)
+	spool, err := SpoolPaste(io.LimitReader(content, limit+1))
+	if err != nil {
+		// Client went away or sent something else than chunk
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		panic(err)
+	}
+	upload := &Upload{Spool: spool, Filename: opts.GetFilename(), ContentType: opts.GetContentType()}
+	defer upload.Close()
+	if spool.Size > limit {
+		return nil, status.Errorf(codes.ResourceExhausted, "paste too large, limit is %s", FormatSize(limit))
+	}
+	if spool.Size == 0 {
+		return nil, status.Error(codes.InvalidArgument, "your paste is empty!")
+	}
+	upload.DetectContentType()
+	if err = gs.routes.CheckContent(r, upload); err != nil {
+		if errors.Is(err, ErrScanFailed) {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		if errors.Is(err, ErrStorageFull) || errors.Is(err, ErrQuotaExceeded) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	uploads := []*Upload{upload}
+	if options.Encrypted && !ValidCiphertext(uploads) {
+		return nil, status.Error(codes.InvalidArgument, "encrypted paste must be 12-byte IV followed by AES-GCM ciphertext")
+	}
+	password := opts.GetPassword()
+	if err = CheckRedirect(options, password != "", uploads); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if password != "" {
+		if options.Password, err = NewPasswordKDF(); err != nil {
+			panic(err)
+		}
+		if err = upload.Encrypt(gs.routes.passwords.Key(options.Password, password)); err != nil {
+			panic(err)
+		}
+	}
+	return PasteProto(gs.routes.createPaste(r, options, upload)), nil
+}
+
+func (gs *GRPCServer) CreatePaste(ctx context.Context, req *pastepb.CreatePasteRequest) (*pastepb.Paste, error) {
+	r := ctx.Value(grpcRequestKey{}).(*http.Request)
+	return gs.create(r, req.GetOptions(), bytes.NewReader(req.GetContent()))
+}
+
+// uploadReader reads content from chunks of UploadPaste stream.
+type uploadReader struct {
+	stream grpc.ClientStreamingServer[pastepb.UploadPasteRequest, pastepb.Paste]
+	chunk  []byte
+}
+
+func (ur *uploadReader) Read(p []byte) (int, error) {
+	for len(ur.chunk) == 0 {
+		req, err := ur.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		if req.Options != nil {
+			return 0, status.Error(codes.InvalidArgument, "options must be sent in the first message only")
+		}
+		ur.chunk = req.GetChunk()
+	}
+	n := copy(p, ur.chunk)
+	ur.chunk = ur.chunk[n:]
+	return n, nil
+}
+
+func (gs *GRPCServer) UploadPaste(stream grpc.ClientStreamingServer[pastepb.UploadPasteRequest, pastepb.Paste]) error {
+	r := stream.Context().Value(grpcRequestKey{}).(*http.Request)
+	first, err := stream.Recv()
+	if errors.Is(err, io.EOF) {
+		return status.Error(codes.InvalidArgument, "your paste is empty!")
+	}
+	if err != nil {
+		return err
+	}
+	paste, err := gs.create(r, first.GetOptions(), &uploadReader{stream: stream, chunk: first.GetChunk()})
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(paste)
+}
+
+func (gs *GRPCServer) GetPaste(ctx context.Context, req *pastepb.GetPasteRequest) (*pastepb.GetPasteResponse, error) {
+	r := ctx.Value(grpcRequestKey{}).(*http.Request)
+	hash := req.GetId()
+	SetPasteID(r, hash)
+	meta, content, err := gs.routes.readPaste(r, hash, req.GetPassword())
+	if err == nil {
+		err = gs.routes.viewPaste(r, hash, meta)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrPasteNotFound):
+			return nil, status.Errorf(codes.NotFound, "paste with id \"%s\" was not found", hash)
+		case errors.Is(err, ErrPasswordRequired):
+			return nil, status.Error(codes.Unauthenticated, "password required")
+		case errors.Is(err, ErrInvalidPassword):
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		case errors.Is(err, ErrPasswordAttempts):
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		panic(err)
+	}
+	return &pastepb.GetPasteResponse{
+		Paste:   PasteProto(NewPasteInfo(r, hash, meta, len(content))),
+		Content: content,
+	}, nil
+}
+
+func (gs *GRPCServer) DeletePaste(ctx context.Context, req *pastepb.DeletePasteRequest) (*pastepb.DeletePasteResponse, error) {
+	r := ctx.Value(grpcRequestKey{}).(*http.Request)
+	SetPasteID(r, req.GetId())
+	if err := gs.routes.deletePaste(r, req.GetId(), req.GetToken()); err != nil {
+		switch {
+		case errors.Is(err, ErrPasteNotFound):
+			return nil, status.Errorf(codes.NotFound, "paste with id \"%s\" was not found", req.GetId())
+		case errors.Is(err, ErrInvalidToken):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		panic(err)
+	}
+	return &pastepb.DeletePasteResponse{}, nil
+}
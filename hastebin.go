@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// HastebinError responds the way haste-server does, with message in JSON.
+func HastebinError(rw http.ResponseWriter, code int, msg string) {
+	WriteJSON(rw, code, map[string]string{"message": msg})
+}
+
+// HastebinCreate creates paste from request body like POST /documents of
+// haste-server, so that its clients can be pointed at paast. Query options
+// of regular upload are accepted too.
+func (hr *HttpRoutes) HastebinCreate(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	options, err := hr.PasteOptions(RequestOptions(r))
+	if err != nil {
+		HastebinError(rw, 400, err.Error())
+		return
+	}
+	uploads := hr.readPastes(rw, r)
+	if uploads == nil {
+		return
+	}
+	defer CloseUploads(uploads)
+	if len(uploads) > 1 {
+		HastebinError(rw, 400, "only one document can be uploaded at once")
+		return
+	}
+	if err = CheckRedirect(options, false, uploads); err != nil {
+		HastebinError(rw, 400, err.Error())
+		return
+	}
+	info := hr.createPaste(r, options, uploads[0])
+	WriteJSON(rw, 200, map[string]string{"key": info.ID})
+}
+
+// HastebinDocument returns paste as GET /documents/{id} of haste-server does,
+// with content in data field.
+func (hr *HttpRoutes) HastebinDocument(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	hash, _ := mux.Vars(r)["hash"]
+	meta, content, err := hr.readPaste(r, hash, PastePassword(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrPasteNotFound):
+			HastebinError(rw, 404, "Document not found.")
+		case errors.Is(err, ErrPasswordRequired):
+			PasswordRequired(rw, r, hash, false)
+		case errors.Is(err, ErrInvalidPassword):
+			PasswordRequired(rw, r, hash, true)
+		case errors.Is(err, ErrPasswordAttempts):
+			HastebinError(rw, 429, err.Error())
+		default:
+			panic(err)
+		}
+		return
+	}
+	if meta.Encrypted || IsBinary(content) {
+		HastebinError(rw, 400, "encrypted and binary pastes can only be read raw")
+		return
+	}
+	if meta.Password != nil {
+		rw.Header().Set("Cache-Control", "no-store")
+	}
+	if err = hr.viewPaste(r, hash, meta); err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			HastebinError(rw, 404, "Document not found.")
+			return
+		}
+		panic(err)
+	}
+	WriteJSON(rw, 200, map[string]string{"key": hash, "data": string(content)})
+}
+
+// HastebinRaw serves GET /raw/{id} of haste-server, which is raw view of
+// paste.
+func (hr *HttpRoutes) HastebinRaw(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	vars["view"] = "raw"
+	hr.RetrievePaste(rw, mux.SetURLVars(r, vars))
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+const HighlightStyle = "github"
+const PasteHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Hash }} - paast</title>
+<style>
+body { margin: 0; font-family: monospace; font-size: 14px; }
+header { padding: 8px 12px; border-bottom: 1px solid #ddd; background: #f6f8fa; }
+header a { color: #0366d6; margin-left: 12px; }
+main pre { margin: 0; padding: 8px 0; }
+main p, main img { margin: 12px; max-width: 100%; }
+{{ .CSS }}
+</style>
+</head>
+<body>
+<header>{{ .Hash }}{{ .Ext }} ({{ .Language }}){{ if .Lines }} lines {{ .Lines }}<a href="/{{ .Hash }}{{ .Ext }}#L{{ .Lines.Start }}">full</a>{{ end }}<a href="/{{ .Hash }}{{ .Ext }}/raw{{ if .Lines }}?lines={{ .Lines }}{{ end }}">raw</a></header>
+<main>
+{{- if .Encrypted }}
+<p>Encrypted in browser, server cannot read this paste.</p>
+<pre id="content" data-content="{{ .Ciphertext }}">Decrypting...</pre>
+<script>
+(async () => {
+	const out = document.getElementById("content");
+	const decode = (value) => Uint8Array.from(atob(value.replace(/-/g, "+").replace(/_/g, "/")), (c) => c.charCodeAt(0));
+	try {
+		const data = decode(out.dataset.content);
+		const key = await crypto.subtle.importKey("raw", decode(location.hash.slice(1)), "AES-GCM", false, ["decrypt"]);
+		const plain = await crypto.subtle.decrypt({name: "AES-GCM", iv: data.slice(0, 12)}, key, data.slice(12));
+		out.textContent = new TextDecoder().decode(plain);
+	} catch (e) {
+		out.textContent = "Cannot decrypt paste: key after # in URL is missing or wrong.";
+	}
+})();
+</script>
+{{- else if .Binary }}
+<p>Binary paste, {{ .Size }} bytes of {{ .ContentType }}. <a href="/{{ .Hash }}{{ .Ext }}/raw" download>Download</a></p>
+{{ if .Image }}<img src="/{{ .Hash }}{{ .Ext }}/raw" alt="{{ .Hash }}">{{ end }}
+<pre>{{ .Hexdump }}</pre>
+{{ if .Truncated }}<p>Only first {{ .HexdumpLen }} bytes are shown.</p>{{ end }}
+{{- else }}{{ .Code }}
+<script>
+// #L120-L180 highlights range of lines, shift-click on line number selects it
+const highlight = () => {
+	document.querySelectorAll(".line.hl").forEach((line) => line.classList.remove("hl"));
+	const match = location.hash.match(/^#L(\d+)(?:-L?(\d+))?$/);
+	if (!match) {
+		return;
+	}
+	const start = +match[1], end = +(match[2] || match[1]);
+	for (let n = start; n <= end; n++) {
+		const number = document.getElementById("L" + n);
+		if (number) {
+			number.parentElement.classList.add("hl");
+		}
+	}
+	const first = document.getElementById("L" + start);
+	if (first) {
+		first.scrollIntoView({block: "center"});
+	}
+};
+document.querySelectorAll("a.lnlinks").forEach((link) => link.addEventListener("click", (event) => {
+	const match = location.hash.match(/^#L(\d+)/);
+	if (event.shiftKey && match) {
+		event.preventDefault();
+		const a = +match[1], b = +link.textContent;
+		location.hash = "#L" + Math.min(a, b) + "-L" + Math.max(a, b);
+	}
+}));
+window.addEventListener("hashchange", highlight);
+highlight();
+</script>
+{{- end -}}
+</main>
+</body>
+</html>
+`
+
+const IndexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>paast</title>
+<style>
+body { margin: 0 auto; padding: 12px; max-width: 960px; font-family: monospace; font-size: 14px; }
+textarea { box-sizing: border-box; width: 100%; height: 70vh; font-family: monospace; font-size: 14px; }
+input, label { margin-top: 8px; font-family: monospace; }
+a { color: #0366d6; }
+</style>
+</head>
+<body>
+<h3>paast</h3>
+{{ if .Pastes }}
+{{ range .Pastes }}<p>Paste created: <a href="{{ .URL }}">{{ .URL }}</a>{{ if .Filename }} ({{ .Filename }}){{ end }}</p>
+{{ if .DeleteURL }}<p>Keep this link to delete it later: <a href="{{ .DeleteURL }}">{{ .DeleteURL }}</a></p>
+{{ else }}<p>Identical paste already existed, so it cannot be deleted with a link.</p>
+{{ end }}{{ end }}<p><a href="/">Create another paste</a></p>
+{{ else }}
+<form method="post" action="/" enctype="multipart/form-data">
+<textarea name="paste" autofocus></textarea>
+<input type="file" name="file" multiple>
+<label><input type="checkbox" id="encrypt"> Encrypt in browser</label>
+<input type="submit" value="Paste">
+</form>
+<script>
+// Only ciphertext is sent, key stays in URL fragment
+document.querySelector("form").addEventListener("submit", async (event) => {
+	const form = event.target;
+	if (!document.getElementById("encrypt").checked) {
+		return;
+	}
+	event.preventDefault();
+	if (form.file.files.length > 0 || form.paste.value === "") {
+		alert("Only text typed in can be encrypted.");
+		return;
+	}
+	const encode = (bytes) => btoa(String.fromCharCode(...bytes)).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+	const raw = crypto.getRandomValues(new Uint8Array(32));
+	const iv = crypto.getRandomValues(new Uint8Array(12));
+	const key = await crypto.subtle.importKey("raw", raw, "AES-GCM", false, ["encrypt"]);
+	const ciphertext = new Uint8Array(await crypto.subtle.encrypt({name: "AES-GCM", iv: iv}, key, new TextEncoder().encode(form.paste.value)));
+	const body = new Uint8Array(iv.length + ciphertext.length);
+	body.set(iv);
+	body.set(ciphertext, iv.length);
+	const resp = await fetch("/?encrypted=1", {method: "POST", body: body, headers: {"Accept": "application/json"}});
+	const info = await resp.json();
+	if (!resp.ok) {
+		alert(info.error);
+		return;
+	}
+	const result = document.createElement("div");
+	const links = [["Paste created: ", info.url + "#" + encode(raw)]];
+	if (info.delete_url) {
+		links.push(["Keep this link to delete it later: ", info.delete_url]);
+	}
+	for (const [label, url] of links) {
+		const p = document.createElement("p");
+		const a = document.createElement("a");
+		a.href = a.textContent = url;
+		p.append(label, a);
+		result.append(p);
+	}
+	form.replaceWith(result);
+});
+</script>
+{{ end }}
+</body>
+</html>
+`
+
+const PasswordHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Hash }} - paast</title>
+<style>
+body { margin: 0 auto; padding: 12px; max-width: 960px; font-family: monospace; font-size: 14px; }
+input { font-family: monospace; }
+</style>
+</head>
+<body>
+<h3>{{ .Hash }}</h3>
+<p>{{ if .Invalid }}Wrong password, try again.{{ else }}This paste is protected with a password.{{ end }}</p>
+<form method="post">
+<input type="password" name="password" autofocus required>
+<input type="submit" value="Open">
+</form>
+</body>
+</html>
+`
+
+var pasteTemplate = template.Must(template.New("paste").Parse(PasteHTML))
+var indexTemplate = template.Must(template.New("index").Parse(IndexHTML))
+var passwordTemplate = template.Must(template.New("password").Parse(PasswordHTML))
+
+// RenderIndex shows paste form, or links to newly created pastes if any.
+func RenderIndex(rw http.ResponseWriter, pastes []*PasteInfo) error {
+	var page bytes.Buffer
+	if err := indexTemplate.Execute(&page, map[string]interface{}{
+		"Pastes": pastes,
+	}); err != nil {
+		return fmt.Errorf("render index: %s", err)
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(200)
+	rw.Write(page.Bytes())
+	return nil
+}
+
+// PasswordRequired responds with 401 if password is missing or 403 if it's
+// wrong. Browsers get a form asking for it.
+func PasswordRequired(rw http.ResponseWriter, r *http.Request, hash string, invalid bool) {
+	code, msg := 401, "paste is protected, send password in X-Password header"
+	if invalid {
+		code, msg = 403, "invalid password"
+	}
+	if !WantsHTML(r) {
+		WriteError(rw, r, code, msg)
+		return
+	}
+	var page bytes.Buffer
+	if err := passwordTemplate.Execute(&page, map[string]interface{}{
+		"Hash":    hash,
+		"Invalid": invalid,
+	}); err != nil {
+		panic(fmt.Errorf("render password prompt: %s", err))
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(code)
+	rw.Write(page.Bytes())
+}
+
+// WantsHTML tells browsers from terminal clients: curl and httpie
+// never ask for text/html unless told to.
+func WantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// PasteLexer picks lexer by file extension if any, then by language detected
+// on creation, otherwise guesses it from content.
+func PasteLexer(ext string, language string, content []byte) chroma.Lexer {
+	var lexer chroma.Lexer
+	if ext != "" {
+		lexer = lexers.Match("paste" + ext)
+	}
+	if lexer == nil && language != "" {
+		lexer = lexers.Get(language)
+	}
+	if lexer == nil {
+		lexer = lexers.Analyse(string(content))
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	return chroma.Coalesce(lexer)
+}
+
+// HexdumpLen limits hexdump of binary pastes in HTML view.
+const HexdumpLen = 4096
+
+// RenderBinaryPaste shows hexdump of binary paste, and the image itself
+// if it is one.
+func RenderBinaryPaste(rw http.ResponseWriter, hash string, ext string, contentType string, content []byte) error {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	dump := content
+	if len(dump) > HexdumpLen {
+		dump = dump[:HexdumpLen]
+	}
+	var page bytes.Buffer
+	if err := pasteTemplate.Execute(&page, map[string]interface{}{
+		"Hash":        hash,
+		"Ext":         ext,
+		"Language":    "binary",
+		"Binary":      true,
+		"Size":        len(content),
+		"ContentType": contentType,
+		"Image":       strings.HasPrefix(mediaType, "image/"),
+		"Hexdump":     hex.Dump(dump),
+		"Truncated":   len(content) > HexdumpLen,
+		"HexdumpLen":  HexdumpLen,
+	}); err != nil {
+		return fmt.Errorf("render paste: %s", err)
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(200)
+	rw.Write(page.Bytes())
+	return nil
+}
+
+// RenderEncryptedPaste embeds ciphertext in a page which decrypts it with key
+// from URL fragment. Burn pastes are gone after this request, so content
+// can't be fetched separately.
+func RenderEncryptedPaste(rw http.ResponseWriter, hash string, ext string, content []byte) error {
+	var page bytes.Buffer
+	if err := pasteTemplate.Execute(&page, map[string]interface{}{
+		"Hash":       hash,
+		"Ext":        ext,
+		"Language":   "encrypted",
+		"Encrypted":  true,
+		"Ciphertext": base64.StdEncoding.EncodeToString(content),
+	}); err != nil {
+		return fmt.Errorf("render paste: %s", err)
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(200)
+	rw.Write(page.Bytes())
+	return nil
+}
+
+// RenderPaste highlights paste, numbering its lines from the first selected
+// one if only some were.
+func RenderPaste(rw http.ResponseWriter, hash string, ext string, language string, contentType string, content []byte, lines *LineRange) error {
+	if IsBinary(content) {
+		return RenderBinaryPaste(rw, hash, ext, contentType, content)
+	}
+	lexer := PasteLexer(ext, language, content)
+	style := styles.Get(HighlightStyle)
+	start := 1
+	if lines != nil {
+		start = lines.Start
+	}
+	formatter := html.New(
+		html.WithClasses(true),
+		html.WithCSSComments(false),
+		html.WithLineNumbers(true),
+		html.WithLinkableLineNumbers(true, "L"),
+		html.TabWidth(4),
+		html.BaseLineNumber(start),
+	)
+	iterator, err := lexer.Tokenise(nil, string(content))
+	if err != nil {
+		return fmt.Errorf("render paste: %s", err)
+	}
+	var code, css bytes.Buffer
+	if err = formatter.Format(&code, style, iterator); err != nil {
+		return fmt.Errorf("render paste: %s", err)
+	}
+	if err = formatter.WriteCSS(&css, style); err != nil {
+		return fmt.Errorf("render paste: %s", err)
+	}
+
+	var page bytes.Buffer
+	if err = pasteTemplate.Execute(&page, map[string]interface{}{
+		"Hash":     hash,
+		"Ext":      ext,
+		"Language": lexer.Config().Name,
+		"Code":     template.HTML(code.String()),
+		"Lines":    lines,
+		"CSS":      template.CSS(css.String()),
+	}); err != nil {
+		return fmt.Errorf("render paste: %s", err)
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(200)
+	rw.Write(page.Bytes())
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ImportPastes creates a paste from every regular file of directory or tar
+// archive, gzipped or not, keeping file name. Path of every file and URL of
+// its paste, relative to baseURL, are written to mapping separated by tab,
+// so links to the old pastebin can be rewritten. Empty files and ones over
+// max-body-len are skipped.
+func (hr *HttpRoutes) ImportPastes(source string, baseURL string, mapping io.Writer) (int, error) {
+	r, err := ListenerRequest(baseURL, "", "import")
+	if err != nil {
+		return 0, fmt.Errorf("import: %s", err)
+	}
+	imported := 0
+	importFile := func(name string, size int64, content io.Reader) error {
+		if size == 0 || size > hr.config.MaxBodyLen {
+			slog.Warn("file skipped", "file", name, "bytes", size)
+			return nil
+		}
+		spool, err := SpoolPaste(content)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		upload := &Upload{Spool: spool, Filename: path.Base(name)}
+		defer upload.Close()
+		upload.DetectContentType()
+		info := hr.createPaste(r, &PasteMeta{Created: time.Now()}, upload)
+		if _, err = fmt.Fprintf(mapping, "%s\t%s\n", name, info.URL); err != nil {
+			return err
+		}
+		imported++
+		return nil
+	}
+
+	stat, err := os.Stat(source)
+	if err != nil {
+		return 0, fmt.Errorf("import: %s", err)
+	}
+	if stat.IsDir() {
+		err = filepath.WalkDir(source, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil || !entry.Type().IsRegular() {
+				return err
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			file, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			relative, _ := filepath.Rel(source, name)
+			return importFile(filepath.ToSlash(relative), info.Size(), file)
+		})
+		if err != nil {
+			return imported, fmt.Errorf("import: %s", err)
+		}
+		return imported, nil
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		return 0, fmt.Errorf("import: %s", err)
+	}
+	defer file.Close()
+	archive := bufio.NewReader(file)
+	if magic, _ := archive.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(archive)
+		if err != nil {
+			return 0, fmt.Errorf("import: %s", err)
+		}
+		defer gz.Close()
+		archive = bufio.NewReader(gz)
+	}
+	tr := tar.NewReader(archive)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return imported, nil
+		}
+		if err != nil {
+			return imported, fmt.Errorf("import: %s", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = importFile(strings.TrimPrefix(path.Clean(header.Name), "./"), header.Size, tr); err != nil {
+			return imported, fmt.Errorf("import: %s", err)
+		}
+	}
+}
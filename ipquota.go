@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded is returned for upload over quota of its client.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// quotaEntry is paste created or edited by client.
+type quotaEntry struct {
+	created time.Time
+	size    int64
+}
+
+// IPQuota limits number and total size of pastes created or edited by
+// client within rolling window, so that one can't fill storage while obeying
+// the cooldown. Clients are grouped like by rate limiter. Pastes already
+// stored are counted on startup by address recorded in their meta, edits
+// made before are not. API key holders have limits of their own and are not
+// counted.
+type IPQuota struct {
+	window     time.Duration
+	maxPastes  int
+	maxBytes   int64
+	ipv6Prefix int
+	mu         sync.Mutex
+	// Entries of every client, oldest first
+	clients map[string][]quotaEntry
+}
+
+func NewIPQuota(config *Config, storage Storage) (*IPQuota, error) {
+	quota := &IPQuota{
+		window:     config.IPQuotaWindow,
+		maxPastes:  config.IPQuotaPastes,
+		maxBytes:   config.IPQuotaBytes,
+		ipv6Prefix: config.IPv6Prefix,
+		clients:    map[string][]quotaEntry{},
+	}
+	if !quota.Enabled() {
+		return quota, nil
+	}
+	names, err := storage.List()
+	if err != nil {
+		return nil, fmt.Errorf("ip quota: %s", err)
+	}
+	since := time.Now().Add(-quota.window)
+	for _, name := range names {
+		meta, err := storage.LoadMeta(name)
+		if err != nil {
+			if errors.Is(err, ErrPasteNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("ip quota: %s", err)
+		}
+		if meta.IP != "" && meta.Created.After(since) {
+			key := ClientKey(meta.IP, quota.ipv6Prefix)
+			quota.clients[key] = append(quota.clients[key], quotaEntry{meta.Created, meta.Size})
+		}
+	}
+	for _, entries := range quota.clients {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].created.Before(entries[j].created)
+		})
+	}
+	return quota, nil
+}
+
+func (q *IPQuota) Enabled() bool {
+	return q.maxPastes > 0 || q.maxBytes > 0
+}
+
+// clientKey returns key request's client is counted by, empty if it's not
+// counted.
+func (q *IPQuota) clientKey(r *http.Request) string {
+	addr := RemoteIP(r)
+	if !q.Enabled() || addr == "" || GetRequestInfo(r).APIKey != nil {
+		return ""
+	}
+	return ClientKey(addr, q.ipv6Prefix)
+}
+
+// prune drops entries which left the window, caller must hold lock.
+func (q *IPQuota) prune(key string, now time.Time) []quotaEntry {
+	entries := q.clients[key]
+	for len(entries) > 0 && !entries[0].created.After(now.Add(-q.window)) {
+		entries = entries[1:]
+	}
+	if len(entries) == 0 {
+		delete(q.clients, key)
+		return nil
+	}
+	q.clients[key] = entries
+	return entries
+}
+
+// Check returns error wrapping ErrQuotaExceeded, which tells when client can
+// try again, if uploads don't fit in quota of request's client.
+func (q *IPQuota) Check(r *http.Request, uploads []*Upload) error {
+	key := q.clientKey(r)
+	if key == "" {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	entries := q.prune(key, now)
+
+	var size, used int64
+	for _, upload := range uploads {
+		size += upload.Size
+	}
+	for _, entry := range entries {
+		used += entry.size
+	}
+	if q.maxPastes > 0 && len(entries)+len(uploads) > q.maxPastes {
+		// Enough entries have to leave the window to make room
+		if len(uploads) > q.maxPastes {
+			return fmt.Errorf("%w: limit is %d pastes", ErrQuotaExceeded, q.maxPastes)
+		}
+		wait := entries[len(entries)+len(uploads)-q.maxPastes-1].created.Add(q.window).Sub(now)
+		return fmt.Errorf("%w: limit is %d pastes, try again in %s", ErrQuotaExceeded, q.maxPastes, wait.Round(time.Second))
+	}
+	if q.maxBytes > 0 && used+size > q.maxBytes {
+		if size > q.maxBytes {
+			return fmt.Errorf("%w: limit is %s", ErrQuotaExceeded, FormatSize(q.maxBytes))
+		}
+		var wait time.Duration
+		for _, entry := range entries {
+			used -= entry.size
+			if used+size <= q.maxBytes {
+				wait = entry.created.Add(q.window).Sub(now)
+				break
+			}
+		}
+		return fmt.Errorf("%w: limit is %s, try again in %s", ErrQuotaExceeded, FormatSize(q.maxBytes), wait.Round(time.Second))
+	}
+	return nil
+}
+
+// Record counts paste created or edited by request's client.
+func (q *IPQuota) Record(r *http.Request, size int64, created time.Time) {
+	key := q.clientKey(r)
+	if key == "" {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.clients[key] = append(q.clients[key], quotaEntry{created, size})
+}
+
+// Run periodically forgets clients with nothing left in the window until
+// ctx is done.
+func (q *IPQuota) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.mu.Lock()
+			for key := range q.clients {
+				q.prune(key, now)
+			}
+			q.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type PasteInfo struct {
+	ID          string     `json:"id"`
+	URL         string     `json:"url"`
+	RawURL      string     `json:"raw_url"`
+	DeleteURL   string     `json:"delete_url,omitempty"`
+	DeleteToken string     `json:"delete_token,omitempty"`
+	EditToken   string     `json:"edit_token,omitempty"`
+	Bytes       int        `json:"bytes"`
+	Created     time.Time  `json:"created"`
+	Updated     *time.Time `json:"updated,omitempty"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	Burn        bool       `json:"burn,omitempty"`
+	Private     bool       `json:"private,omitempty"`
+	// Either public or unlisted
+	Visibility string   `json:"visibility"`
+	Tags       []string `json:"tags,omitempty"`
+	// Detected language and its usual file extension
+	Language  string `json:"language,omitempty"`
+	Extension string `json:"extension,omitempty"`
+	// Set when existing paste with the same content was returned
+	Duplicate bool `json:"duplicate,omitempty"`
+	// Name of uploaded file paste was created from
+	Filename string `json:"filename,omitempty"`
+	// Set when content is encrypted by client and unreadable by server
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Set when password is required to read content
+	Protected bool `json:"protected,omitempty"`
+	// ID of paste this one was forked from
+	Parent string `json:"parent,omitempty"`
+	// URL paste redirects to
+	Redirect string `json:"redirect,omitempty"`
+	// Set when specific revision was requested
+	Revision  int            `json:"revision,omitempty"`
+	Revisions []RevisionInfo `json:"revisions,omitempty"`
+	Content   *string        `json:"content,omitempty"`
+	// Set to "base64" when content is not valid UTF-8
+	Encoding string `json:"encoding,omitempty"`
+	// Set for new paste which seems to contain secrets
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type RevisionInfo struct {
+	Revision int       `json:"revision"`
+	URL      string    `json:"url"`
+	Bytes    int64     `json:"bytes"`
+	Created  time.Time `json:"created"`
+}
+
+func NewPasteInfo(r *http.Request, hash string, meta *PasteMeta, size int) *PasteInfo {
+	url := BaseURL(r) + "/" + hash
+	var revisions []RevisionInfo
+	for i, revision := range meta.Revisions {
+		revisions = append(revisions, RevisionInfo{
+			Revision: i + 1,
+			URL:      fmt.Sprintf("%s/v/%d", url, i+1),
+			Bytes:    revision.Size,
+			Created:  revision.Created,
+		})
+	}
+	info := &PasteInfo{
+		Revisions:  revisions,
+		ID:         hash,
+		URL:        url,
+		RawURL:     url + "/raw",
+		Bytes:      size,
+		Created:    meta.Created,
+		Updated:    meta.Updated,
+		Expires:    meta.Expires,
+		Burn:       meta.Burn,
+		Private:    meta.Private,
+		Filename:   meta.Filename,
+		Visibility: VisibilityUnlisted,
+		Tags:       meta.Tags,
+		Language:   meta.Language,
+		Extension:  LanguageExtension(meta.Language),
+		Encrypted:  meta.Encrypted,
+		Protected:  meta.Password != nil,
+		Parent:     meta.Parent,
+		Redirect:   meta.Redirect,
+	}
+	if meta.Visibility != "" {
+		info.Visibility = meta.Visibility
+	}
+	return info
+}
+
+// PasteMetaInfo is returned by metadata endpoint.
+type PasteMetaInfo struct {
+	*PasteInfo
+	Lines       int    `json:"lines"`
+	ContentType string `json:"content_type"`
+	SHA256      string `json:"sha256"`
+}
+
+func (pi *PasteInfo) SetContent(content []byte) {
+	var value string
+	if utf8.Valid(content) {
+		value = string(content)
+	} else {
+		value = base64.StdEncoding.EncodeToString(content)
+		pi.Encoding = "base64"
+	}
+	pi.Content = &value
+}
+
+// WantsJSON checks for ?format=json or Accept header asking for JSON only.
+// Accept lists with a wildcard are ignored: httpie sends
+// "application/json, */*" with any piped body and expects plain text back.
+func WantsJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accept))
+		if mediaType != "application/json" {
+			return false
+		}
+	}
+	return r.Header.Get("Accept") != ""
+}
+
+func WriteJSON(rw http.ResponseWriter, code int, value interface{}) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	rw.Write(append(content, '\n'))
+}
+
+// WriteError responds with error message as plain text or JSON.
+func WriteError(rw http.ResponseWriter, r *http.Request, code int, msg string) {
+	if WantsJSON(r) {
+		WriteJSON(rw, code, map[string]string{"error": msg})
+		return
+	}
+	rw.WriteHeader(code)
+	rw.Write([]byte("error: " + msg + "\n"))
+}
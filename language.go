@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/go-enry/go-enry/v2"
+)
+
+// LanguageCandidates are languages content is classified into. Classifier is
+// much less accurate if asked to pick from every language it knows.
+var LanguageCandidates = []string{
+	"C", "C#", "C++", "CSS", "Diff", "Dockerfile", "Elixir", "Go", "HTML",
+	"Haskell", "INI", "Java", "JavaScript", "Kotlin", "Lua", "Makefile",
+	"Markdown", "Nginx", "PHP", "Perl", "Python", "Ruby", "Rust", "SQL",
+	"Scala", "Shell", "Swift", "TOML", "TypeScript", "XML", "YAML",
+}
+
+// Classifier rarely recognizes Go, package clause is a sure sign of it.
+var goPackageClause = regexp.MustCompile(`(?m)^package [A-Za-z_][A-Za-z0-9_]*\s*$`)
+
+// DetectLanguage guesses language of text paste from its name and beginning
+// of content, empty result means plain text. Shebang, modeline and file
+// extension are trusted, classifier only runs on content which looks like
+// code, prose would be classified as something anyway.
+func DetectLanguage(filename string, head []byte, complete bool) string {
+	if language, safe := enry.GetLanguageByShebang(head); safe {
+		return language
+	}
+	if language, safe := enry.GetLanguageByModeline(head); safe {
+		return language
+	}
+	if filename != "" {
+		if language := enry.GetLanguage(filename, head); language != "" {
+			return language
+		}
+	}
+	if goPackageClause.Match(head) {
+		return "Go"
+	}
+	if complete && json.Valid(head) && strings.ContainsAny(string(head), "{[") {
+		return "JSON"
+	}
+	if !LooksLikeCode(string(head)) {
+		return ""
+	}
+	language, _ := enry.GetLanguageByClassifier(head, LanguageCandidates)
+	return language
+}
+
+// LooksLikeCode tells code from prose by share of punctuation typical for
+// code, and requires a few lines to judge by.
+func LooksLikeCode(content string) bool {
+	if strings.Count(strings.TrimSpace(content), "\n") < 2 {
+		return false
+	}
+	symbols := 0
+	for _, char := range content {
+		if strings.ContainsRune("{}()[];=<>:#$&|", char) {
+			symbols++
+		}
+	}
+	return symbols*100 >= len(content)*2
+}
+
+// Language detects language of text upload.
+func (u *Upload) Language() string {
+	if u.Binary() {
+		return ""
+	}
+	return DetectLanguage(u.Filename, u.Head(), u.Size <= HeadLen)
+}
+
+// LanguageExtension returns the usual file extension of language, e.g. ".py"
+// for Python.
+func LanguageExtension(language string) string {
+	if extensions := enry.GetLanguageExtensions(language); len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ""
+}
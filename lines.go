@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+var ErrInvalidLines = errors.New("invalid lines, expected e.g. 120-180, 120- or 120")
+
+// LineRange selects lines of paste, numbered from 1. End is inclusive, zero
+// means the last line.
+type LineRange struct {
+	Start int
+	End   int
+}
+
+var lineRangePattern = regexp.MustCompile(`^L?([0-9]+)(-(L?([0-9]+))?)?$`)
+
+// ParseLineRange parses lines parameter: 120-180, 120- or 120. Anchors of
+// HTML view like L120-L180 are accepted too. Empty value selects nothing.
+func ParseLineRange(value string) (*LineRange, error) {
+	if value == "" {
+		return nil, nil
+	}
+	match := lineRangePattern.FindStringSubmatch(value)
+	if match == nil {
+		return nil, ErrInvalidLines
+	}
+	lines := &LineRange{}
+	lines.Start, _ = strconv.Atoi(match[1])
+	switch {
+	case match[4] != "":
+		lines.End, _ = strconv.Atoi(match[4])
+	case match[2] == "":
+		lines.End = lines.Start
+	}
+	if lines.Start < 1 || (lines.End != 0 && lines.End < lines.Start) {
+		return nil, ErrInvalidLines
+	}
+	return lines, nil
+}
+
+// PasteLines parses lines parameter of request.
+func PasteLines(r *http.Request) (*LineRange, error) {
+	return ParseLineRange(r.URL.Query().Get("lines"))
+}
+
+// Slice returns selected lines of content with their line breaks, lines
+// past the end are simply missing.
+func (lr *LineRange) Slice(content []byte) []byte {
+	start := 0
+	for line := 1; line < lr.Start; line++ {
+		next := bytes.IndexByte(content[start:], '\n')
+		if next < 0 {
+			return []byte{}
+		}
+		start += next + 1
+	}
+	if lr.End == 0 {
+		return content[start:]
+	}
+	end := start
+	for line := lr.Start; line <= lr.End; line++ {
+		next := bytes.IndexByte(content[end:], '\n')
+		if next < 0 {
+			return content[start:]
+		}
+		end += next + 1
+	}
+	return content[start:end]
+}
+
+func (lr *LineRange) String() string {
+	if lr.End == 0 {
+		return fmt.Sprintf("%d-", lr.Start)
+	}
+	return fmt.Sprintf("%d-%d", lr.Start, lr.End)
+}
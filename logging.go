@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+)
+
+type contextKey int
+
+const requestInfoKey contextKey = iota
+
+// RequestInfo is attached to every request context so handlers can log with
+// request attributes and report paste they worked with.
+type RequestInfo struct {
+	ID      string
+	Logger  *slog.Logger
+	PasteID string
+	APIKey  *APIKey
+}
+
+func NewLogger(config *Config) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.LogLevel)); err != nil {
+		return nil, fmt.Errorf("config: invalid log-level: %s", config.LogLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch config.LogFormat {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("config: invalid log-format: %s", config.LogFormat)
+}
+
+// RequestID reuses X-Request-Id set by reverse proxy if it looks sane,
+// otherwise generates a new one.
+func RequestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" && len(id) <= 64 {
+		valid := true
+		for _, char := range id {
+			if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || char == '-') {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return id
+		}
+	}
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func RemoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// GetRequestInfo returns info attached by LoggingMiddleware, or a stub with
+// default logger for requests which did not pass through it.
+func GetRequestInfo(r *http.Request) *RequestInfo {
+	if info, ok := r.Context().Value(requestInfoKey).(*RequestInfo); ok {
+		return info
+	}
+	return &RequestInfo{Logger: slog.Default()}
+}
+
+func RequestLogger(r *http.Request) *slog.Logger {
+	info := GetRequestInfo(r)
+	if info.PasteID != "" {
+		return info.Logger.With("paste_id", info.PasteID)
+	}
+	return info.Logger
+}
+
+// SetPasteID records paste ID for request log, e.g. of a newly created paste.
+func SetPasteID(r *http.Request, hash string) {
+	GetRequestInfo(r).PasteID = hash
+}
+
+// ListenerRequest stands in for HTTP request when paste is created through
+// another listener, so that it gets URL under public-url, and client address
+// is logged and rate limited the same way.
+func ListenerRequest(publicURL string, remoteAddr string, listener string) (*http.Request, error) {
+	r, err := http.NewRequest("POST", strings.TrimSuffix(publicURL, "/")+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	r.RemoteAddr = remoteAddr
+	id := RequestID(r)
+	info := &RequestInfo{
+		ID:     id,
+		Logger: slog.Default().With("request_id", id, "listener", listener, "remote_ip", RemoteIP(r)),
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey, info)), nil
+}
+
+// LoggingMiddleware assigns request ID and logs every request with its status
+// and latency. Successful requests are logged at debug level, server errors
+// at error level.
+func LoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id := RequestID(r)
+		rw.Header().Set("X-Request-Id", id)
+		info := &RequestInfo{
+			ID: id,
+			Logger: slog.Default().With(
+				"request_id", id,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"remote_ip", RemoteIP(r),
+			),
+			PasteID: mux.Vars(r)["hash"],
+		}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey, info))
+		m := httpsnoop.CaptureMetrics(next, rw, r)
+		level := slog.LevelDebug
+		if m.Code >= 500 {
+			level = slog.LevelError
+		}
+		RequestLogger(r).Log(r.Context(), level, "request",
+			"status", m.Code,
+			"latency", m.Duration,
+		)
+	})
+}
+
+// RecoverError turns panic in handler into 500 response, it must be deferred
+// directly by the handler. Actual error is only logged since it may contain
+// file paths or other internals, client gets request ID to refer to.
+func RecoverError(rw http.ResponseWriter, r *http.Request) {
+	rec := recover()
+	if rec == nil {
+		return
+	}
+	// Response is past saving, connection is to be dropped
+	if rec == http.ErrAbortHandler {
+		panic(rec)
+	}
+	var msg string
+	if err, ok := rec.(error); ok {
+		msg = err.Error()
+	} else {
+		msg = fmt.Sprint(rec)
+	}
+	// Disk filling up is no bug, client may retry later
+	if err, ok := rec.(error); ok && errors.Is(err, ErrStorageFull) {
+		RequestLogger(r).Error("storage is full", "error", msg)
+		WriteError(rw, r, 507, ErrStorageFull.Error())
+		return
+	}
+	RequestLogger(r).Error("internal error", "error", strings.TrimSpace(msg))
+	WriteError(rw, r, 500, fmt.Sprintf("internal error, ref=%s", GetRequestInfo(r).ID))
+}
+
+// WriteJSONAccessLog is handlers.LogFormatter writing one JSON object per line.
+func WriteJSONAccessLog(writer io.Writer, params handlers.LogFormatterParams) {
+	r := params.Request
+	line, _ := json.Marshal(map[string]interface{}{
+		"time":       params.TimeStamp.Format("2006-01-02T15:04:05.000Z07:00"),
+		"request_id": GetRequestInfo(r).ID,
+		"remote_ip":  RemoteIP(r),
+		"method":     r.Method,
+		"uri":        params.URL.RequestURI(),
+		"proto":      r.Proto,
+		"status":     params.StatusCode,
+		"size":       params.Size,
+		"referer":    r.Referer(),
+		"user_agent": r.UserAgent(),
+	})
+	writer.Write(append(line, '\n'))
+}
+
+// NewAccessLogMiddleware returns middleware writing access log in configured
+// format, or nil if access log is disabled.
+func NewAccessLogMiddleware(config *Config) (mux.MiddlewareFunc, error) {
+	if config.AccessLog == "off" {
+		return nil, nil
+	}
+	var out io.Writer = os.Stdout
+	if config.AccessLogFile != "" {
+		file, err := os.OpenFile(config.AccessLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("access log: %s", err)
+		}
+		out = file
+	}
+	switch config.AccessLog {
+	case "common":
+		return func(next http.Handler) http.Handler {
+			return handlers.LoggingHandler(out, next)
+		}, nil
+	case "combined":
+		return func(next http.Handler) http.Handler {
+			return handlers.CombinedLoggingHandler(out, next)
+		}, nil
+	case "json":
+		return func(next http.Handler) http.Handler {
+			return handlers.CustomLoggingHandler(out, next, WriteJSONAccessLog)
+		}, nil
+	}
+	return nil, fmt.Errorf("config: invalid access-log: %s", config.AccessLog)
+}
@@ -1,30 +1,38 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
-	"math"
-	"mime/multipart"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"path"
+	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/speps/go-hashids/v2"
 )
 
-const MaxBodyLen = 1<<20
-const PasteCooldown = 5 * time.Second
-const Alphabet = "abcdefghijklmnopqrstuvwxyz1234567890"
-const DataDir = "/var/lib/paast"
+const ExtensionPattern = `(?:\.[A-Za-z0-9_+-]+)?`
 const ManpageText =
 `NAME
 	paast - create pastes with different methods
@@ -33,18 +41,368 @@ SYNOPSIS
 	cat code.txt | curl {HOST} --data-binary @-
 	cat code.txt | curl {HOST} -F 'foo=<-'
 	cat code.txt | curl {HOST} -F '=<-'
+	cat code.txt | curl {HOST} --data-urlencode 'sprunge@-'
 	cat code.txt | http {HOST}
+	cat code.txt | curl '{HOST}?expire=1h' --data-binary @-
+	cat secret.txt | curl '{HOST}?burn=1' --data-binary @-
+
+OPTIONS
+	expire (query) or X-Expire (header)
+		Delete paste after given time, e.g. 30m, 12h or 7d.
+		Expired pastes return 404.
+
+	burn (query) or X-Burn (header)
+		Delete paste after it has been read once.
+
+	encrypted (query) or X-Encrypted (header)
+		Paste is encrypted by client, see ENCRYPTED PASTES.
+
+	password (query) or X-Password (header)
+		Require password to read paste, see PASSWORD-PROTECTED PASTES.
+
+	private (query) or X-Private (header)
+		Paste is only reachable by signed URL returned on creation,
+		plain ID gives 404. Available if enabled by the operator.
+
+	visibility (query) or X-Visibility (header)
+		Either unlisted (default), only reachable by those who know
+		paste URL, or public, which also lists paste in feeds and
+		search. Private pastes cannot be public.
+
+	tags (query) or X-Tags (header)
+		Comma-separated tags, e.g. nginx,prod. Listings and search
+		can be narrowed down by tag.
+
+	type (query) or X-Type (header)
+		Paste which is a single http(s) URL redirects to it, see
+		SHORT LINKS. Set to text to prevent that, or to redirect to
+		fail unless paste is a URL.
+
+SHORT LINKS
+	Paste which is a single http or https URL works as a short link:
+	its URL responds with 302 redirect. Views of it are counted, and
+	raw paste or JSON still show the target:
+
+	echo https://example.com/very/long/url | curl {HOST} --data-binary @-
+	curl {HOST}/<id>/raw
+
+MULTIPLE FILES
+	Every file of multipart body becomes a separate paste, up to
+	{MAX_PARTS} at once. URLs are returned one per line (JSON array),
+	tokens are repeated in headers in the same order:
+
+	curl {HOST} -F 'a=@main.go' -F 'b=@go.mod'
+
+	Files sent as file field are handled the way 0x0.st does: they
+	expire after time scaled by size unless expires field (hours or
+	epoch milliseconds) asks for sooner, and secret field makes them
+	private:
+
+	curl {HOST} -F 'file=@image.png' -F 'expires=24' -F 'secret='
+
+RESUMABLE UPLOADS
+	Large pastes can be uploaded in chunks with tus protocol 1.0.0
+	(https://tus.io) at {HOST}/uploads, so interrupted upload resumes
+	where it stopped. Options go to Upload-Metadata as expire, burn,
+	private, visibility, tags, filename and filetype keys. Response to the
+	last chunk has paste URL in X-Paste-Url header along with the
+	tokens:
+
+	curl -i -X POST {HOST}/uploads -H 'Tus-Resumable: 1.0.0' \
+		-H 'Upload-Length: <size>'
+	curl -i -X PATCH <Location> -H 'Tus-Resumable: 1.0.0' \
+		-H 'Upload-Offset: 0' \
+		-H 'Content-Type: application/offset+octet-stream' \
+		--data-binary @big.log
+
+	Unfinished uploads are removed after 24 hours of inactivity.
+
+ENCRYPTED PASTES
+	Check "Encrypt in browser" in web form to encrypt paste before it
+	is sent. Decryption key is kept in URL fragment after #, which
+	browsers never send, so server cannot read such pastes. Anyone
+	with the full URL can, so share it as carefully as the content.
+	Browsers only allow this over HTTPS.
+
+	Other clients can create them too: content must be 12-byte IV
+	followed by AES-256-GCM ciphertext with tag, sent with encrypted
+	option. Key goes after # as unpadded base64url:
+
+	curl '{HOST}?encrypted=1' --data-binary @ciphertext.bin
+
+	Encrypted pastes are served as application/octet-stream with
+	X-Encrypted: true header, HTML view decrypts them in the browser.
+
+PASSWORD-PROTECTED PASTES
+	Paste created with password is stored encrypted with a key derived
+	from it, and can only be read with the same password. Browsers are
+	asked for it, other clients send it in X-Password header (or in
+	password query parameter, which is more likely to end up in logs):
+
+	cat secret.txt | curl {HOST} -H 'X-Password: <password>' --data-binary @-
+	curl {HOST}/<id> -H 'X-Password: <password>'
+
+	Editing protected paste requires the password along with edit token.
+	Lost password cannot be recovered.
+
+FETCHING URLS
+	If enabled by the operator, paste can be created from a remote URL,
+	same options apply:
+
+	curl '{HOST}/fetch?expire=7d' -d url=https://ci.example.com/job/42/log
+
+GISTS
+	If enabled by the operator, paste can be exported to GitHub gist
+	with token of your own, which is never stored, and every file of
+	gist can be imported as a paste:
+
+	curl -X POST '{HOST}/<id>/gist?public=1' -H 'X-GitHub-Token: <token>'
+	curl '{HOST}/gist?expire=7d' -d id=<gist id or URL>
+
+NETCAT
+	If enabled by the operator, anything sent to port {TCP_PORT} becomes
+	a paste, its URL is sent back. Paste ends when connection is closed
+	or nothing is sent for a couple of seconds:
+
+	echo hi | nc {HOSTNAME} {TCP_PORT}
+
+SSH
+	If enabled by the operator, pastes can be created and read over SSH
+	on port {SSH_PORT}, options go as name=value arguments. Pastes created
+	with SSH key can be deleted with the same key:
+
+	cat main.go | ssh -p {SSH_PORT} {HOSTNAME} expire=1d filename=main.go
+	ssh -p {SSH_PORT} {HOSTNAME} get <id>
+	ssh -p {SSH_PORT} {HOSTNAME} delete <id>
+
+GRPC
+	If enabled by the operator, pastes can be created, read and deleted
+	over gRPC on port {GRPC_PORT}, service is described by reflection:
+
+	grpcurl -plaintext {HOSTNAME}:{GRPC_PORT} describe paast.v1.Pastes
+
+GEMINI
+	If enabled by the operator, recent pastes are listed and pastes are
+	shown over Gemini on port {GEMINI_PORT}:
+
+	gemini://{HOSTNAME}:{GEMINI_PORT}/<id>
+	gemini://{HOSTNAME}:{GEMINI_PORT}/<id>/raw
+
+EMAIL
+	If enabled by the operator, emails sent to any address at
+	{SMTP_DOMAIN} become pastes and their URLs are mailed back: first
+	attachment is pasted, or text of email if there's none. Expiry goes
+	after plus in address:
+
+	mail -s backup paste+7d@{SMTP_DOMAIN} < backup.log
+
+HASTEBIN
+	Hastebin clients work with {HOST} as their server, POST /documents
+	creates paste and responds with its key, GET /documents/<id> and
+	/raw/<id> return it:
+
+	curl {HOST}/documents --data-binary @file.txt
+	curl {HOST}/raw/<id>
+
+	Tools made for pastebin.com API can use {HOST}/api/api_post.php
+	with api_option=paste, developer key is not checked:
+
+	curl {HOST}/api/api_post.php -d api_option=paste \
+		--data-urlencode api_paste_code@main.py -d api_paste_format=python
+
+WEB INTERFACE
+	Open {HOST} in a browser to create pastes from a simple form.
+
+RECENT PASTES
+	Latest public pastes (see visibility option) are listed with size
+	and first line at {HOST}/recent, also available as RSS and Atom
+	feeds:
+
+	curl {HOST}/recent
+	curl {HOST}/recent.atom?tag=nginx
+
+	If enabled by the operator, public text pastes can be searched,
+	query syntax allows phrases, +required and -excluded terms:
+
+	curl '{HOST}/search?q=%2Bnginx+%22proxy_pass%22&tag=prod'
+
+	Number and size of pastes, pastes created in last 24 hours and
+	uptime of the instance are shown at:
+
+	curl {HOST}/stats
+
+	If enabled by the operator, {HOST}/dav is read-only WebDAV share
+	listing the same pastes, any other paste opens there by ID:
+
+	rclone cat :webdav:<id> --webdav-url {HOST}/dav
+
+VIEWING PASTES
+	Browsers get syntax-highlighted HTML, other clients get plain text.
+	Either can be forced by adding /html or /raw to paste URL:
+
+	curl {HOST}/<id>/html
+	curl {HOST}/<id>/raw
+
+	Markdown pastes (detected, or named or viewed with .md extension) are
+	rendered to HTML in browsers, raw HTML in them is left out. Rendering
+	can be requested explicitly with /md:
+
+	curl {HOST}/<id>/md
+
+	Colored terminal output keeps its colors in browsers, unless file
+	extension is given. Colors can be requested explicitly with /ansi, or
+	stripped from raw paste with strip-ansi parameter:
+
+	make 2>&1 | curl {HOST} --data-binary @-
+	curl {HOST}/<id>/ansi
+	curl '{HOST}/<id>/raw?strip-ansi=1'
+
+	Raw paste is served with Content-Type it was created with (or type
+	of the multipart part), plain text if none was given. Type of binary
+	pastes is detected if not given, HTML view shows their hexdump:
+
+	curl {HOST} -H 'Content-Type: application/json' -d @data.json
+	curl {HOST} -F 'file=@image.png'
+
+	Language of text pastes is detected on creation, it picks
+	highlighting of HTML view and Content-Type of raw paste, and is
+	returned in JSON along with suggested file extension. Optional file
+	extension overrides both:
+
+	curl {HOST}/<id>.json
+	curl {HOST}/<id>.go/html
+
+	Raw pastes can be fetched partially with Range header, e.g. to
+	resume interrupted download. Burn pastes are always served whole:
+
+	curl -r 1024- {HOST}/<id>/raw
+
+	Only some lines of text paste can be fetched with lines parameter,
+	e.g. 120-180, 120- or 120. HTML view numbers lines, #L120-L180 in its
+	URL highlights them (shift-click on line numbers selects range):
+
+	curl '{HOST}/<id>?lines=120-180'
+
+	Two text pastes can be compared, browsers get them side by side,
+	other clients get unified diff:
+
+	curl {HOST}/diff/<id>/<other id>
+
+	Size, line count, content type, checksum, expiry and revisions
+	are available in JSON without fetching the content itself:
+
+	curl {HOST}/<id>/meta
+
+	HEAD requests return headers only and don't count as views or
+	burn pastes, e.g. to check that paste still exists:
+
+	curl -I {HOST}/<id>
+
+	Number of views and time of last view are available at:
+
+	curl {HOST}/<id>/stats
+
+	QR code of paste URL is served as PNG, SVG or text for terminal,
+	which is also printed after URL on creation with qr=1:
+
+	curl {HOST}/<id>/qr.svg
+	cat code.txt | curl '{HOST}?qr=1' --data-binary @-
+
+JSON API
+	Add ?format=json or send Accept: application/json to get JSON
+	responses when creating, viewing or deleting pastes:
+
+	cat code.txt | curl '{HOST}?format=json' --data-binary @-
+	curl -H 'Accept: application/json' {HOST}/<id>
+
+	{"id": ..., "url": ..., "raw_url": ..., "bytes": ..., ...}
+
+	Errors are returned as {"error": ...}. Content of pastes which are
+	not valid UTF-8 is base64-encoded with "encoding": "base64".
+
+GRAPHQL
+	Pastes can be read, created and deleted with GraphQL queries POSTed
+	to {HOST}/graphql, GET returns the schema:
+
+	curl {HOST}/graphql -H 'Content-Type: application/json' \
+		-d '{"query": "{ paste(id: \"<id>\") { created content { data } } }"}'
+
+DELETING PASTES
+	Every created paste comes with a secret deletion URL which is
+	returned in X-Delete-Url response header (use curl -i to see it):
+
+	curl {HOST}/delete/<id>/<token>
+
+	Token alone is also returned in X-Delete-Token header, it can be
+	used to delete paste with DELETE request, which responds with 204:
+
+	curl -X DELETE {HOST}/<id> -H 'X-Delete-Token: <token>'
+
+	Servers may return existing paste with identical content instead of
+	creating a new one, marked with X-Duplicate: true header. Such
+	responses carry no tokens.
+
+	Pastes which seem to contain secrets like private keys or access
+	tokens come with X-Warning header, delete them if that's a mistake.
+
+EDITING PASTES
+	Paste content can be replaced while keeping its URL with edit token
+	returned in X-Edit-Token header on creation:
+
+	cat code.txt | curl -X PUT {HOST}/<id> -H 'X-Edit-Token: <token>' --data-binary @-
+
+	Every edit keeps previous content as a revision, revisions are
+	numbered from 1 and listed in JSON view:
+
+	curl {HOST}/<id>/v/1
+
+	Anyone can fork a paste instead: fork is a new paste with its own
+	tokens, created from the original or from request body if it's not
+	empty. ID of the original is returned as parent in JSON view:
+
+	curl -X POST {HOST}/<id>/fork
+	cat code.txt | curl {HOST}/<id>/fork --data-binary @-
+
+REPORTING ABUSE
+	Illegal or abusive pastes can be reported to the operator:
+
+	curl {HOST}/<id>/report -d reason='phishing page'
+
+	Pastes taken down by the operator return 451 with the reason.
 
 LIMITS
-	Maximum allowed request body size is 1 MB.
-	Creating pastes has a 5-second cooldown.
+	Maximum allowed request body size is {MAX_BODY_LEN}.
+	Up to {BURST} pastes can be created at once, after that one more
+	paste becomes available every {COOLDOWN}.
+
+	Operator may issue API keys with custom limits, pass them with
+	Authorization header:
+
+	cat big.log | curl {HOST} -H 'Authorization: Bearer KEY' --data-binary @-
 
 STATUS CODES
 	200 - paste created, URL returned in response
-	400 - bad request or empty paste input
-	413 - paste input too large
-	429 - attempt to create too many pastes, please wait 5 seconds
-	500 - internal server error
+	204 - paste deleted with DELETE request
+	400 - bad request, invalid option or empty paste input
+	401 - invalid API key or password required
+	403 - invalid deletion or edit token or password, banned address
+	      or paste rejected by content filter
+	404 - paste not found or expired
+	409 - resumable upload is at another offset, see Upload-Offset
+	413 - paste input too large, limit is stated in error message
+	423 - resumable upload is busy with another request
+	429 - attempt to create too many pastes, see Retry-After header,
+	      or too many invalid passwords for protected paste
+	451 - paste was taken down or its content is blocked by the operator
+	500 - internal server error, response contains reference ID
+	      to report to the operator
+	502 - remote URL could not be fetched
+	503 - paste could not be scanned for viruses, try again later
+	504 - remote URL took too long to fetch
+	507 - server is out of storage, try again later
+
+API
+	OpenAPI specification is available at {HOST}/openapi.json
 
 AUTHOR
 	Created by Andrew Dunai.
@@ -54,245 +412,1852 @@ WWW
 	https://dun.ai
 `
 
-var idSalt = os.Getenv("ID_SALT")
-var addrTimeMap = map[string]time.Time{}
 
-func ReadCounter(file *os.File) (int64, error) {
-	content, err := ioutil.ReadAll(file)
-	if err != nil {
-		return 0, fmt.Errorf("read counter: %s", err)
+// NewToken generates a random secret and its SHA-256 digest for storing.
+func NewToken() (string, string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("new token: %s", err)
 	}
-	value, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
-	if err != nil {
-		return 0, nil
+	token := hex.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+// CheckToken compares token against stored digest in constant time.
+func CheckToken(token string, tokenHash string) bool {
+	if token == "" || tokenHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(tokenHash)) == 1
+}
+
+// PasteOption returns creation option from query string, falling back to header.
+func PasteOption(r *http.Request, name string, header string) string {
+	if value := r.URL.Query().Get(name); value != "" {
+		return value
 	}
-	return value, nil
+	return r.Header.Get(header)
 }
 
-func WriteCounter(file *os.File, value int64) error {
-	if _, err := file.Seek(0, 0); err != nil {
-		return fmt.Errorf("write counter: %s", err)
+// ParseExpiry accepts anything time.ParseDuration does, plus a "d" suffix for days.
+func ParseExpiry(value string) (time.Duration, error) {
+	var ttl time.Duration
+	var err error
+	if strings.HasSuffix(value, "d") {
+		var days int64
+		if days, err = strconv.ParseInt(strings.TrimSuffix(value, "d"), 10, 64); err != nil {
+			return 0, fmt.Errorf("invalid expiry: %s", value)
+		}
+		ttl = time.Duration(days) * 24 * time.Hour
+	} else if ttl, err = time.ParseDuration(value); err != nil {
+		return 0, fmt.Errorf("invalid expiry: %s", value)
 	}
-	if _, err := file.Write([]byte(fmt.Sprint(value))); err != nil {
-		return fmt.Errorf("write counter: %s", err)
+	if ttl <= 0 {
+		return 0, fmt.Errorf("invalid expiry: %s", value)
 	}
-	return nil
+	return ttl, nil
+}
+
+// Limits of tags given on creation.
+const (
+	MaxTags   = 10
+	MaxTagLen = 32
+	TagChars  = "abcdefghijklmnopqrstuvwxyz0123456789-_.+"
+)
+
+// ParseTags splits comma-separated tags. Tags are case-insensitive and may
+// contain letters, digits, and "-", "_", "." or "+" after the first character.
+func ParseTags(value string) ([]string, error) {
+	tags := []string{}
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || slices.Contains(tags, tag) {
+			continue
+		}
+		if len(tag) > MaxTagLen || strings.Trim(tag, TagChars) != "" || strings.ContainsRune("-_.+", rune(tag[0])) {
+			return nil, fmt.Errorf("invalid tag: %s", tag)
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) > MaxTags {
+		return nil, fmt.Errorf("too many tags, limit is %d", MaxTags)
+	}
+	return tags, nil
+}
+
+// RequestOptions looks up creation options in query string, falling back to
+// X- headers, e.g. expire and X-Expire.
+func RequestOptions(r *http.Request) func(string) string {
+	return func(name string) string {
+		return PasteOption(r, name, "X-"+strings.ToUpper(name[:1])+name[1:])
+	}
+}
+
+// PasteOptions validates expiry, burn, private, visibility, tags and type of
+// new paste, looked up by name, and returns them as a template of its metadata.
+func (hr *HttpRoutes) PasteOptions(option func(string) string) (*PasteMeta, error) {
+	options := &PasteMeta{Created: time.Now()}
+	if expire := option("expire"); expire != "" {
+		ttl, err := ParseExpiry(expire)
+		if err != nil {
+			return nil, err
+		}
+		expires := options.Created.Add(ttl)
+		options.Expires = &expires
+	}
+	if burn := option("burn"); burn != "" {
+		var err error
+		if options.Burn, err = strconv.ParseBool(burn); err != nil {
+			return nil, fmt.Errorf("invalid burn flag: %s", burn)
+		}
+	}
+	if private := option("private"); private != "" {
+		var err error
+		if options.Private, err = strconv.ParseBool(private); err != nil {
+			return nil, fmt.Errorf("invalid private flag: %s", private)
+		}
+		if options.Private && hr.config.URLSecret == "" {
+			return nil, errors.New("private pastes are not enabled on this server")
+		}
+	}
+	switch visibility := option("visibility"); visibility {
+	case "", VisibilityUnlisted:
+	case VisibilityPublic:
+		if options.Private {
+			return nil, errors.New("private paste cannot be public")
+		}
+		options.Visibility = visibility
+	default:
+		return nil, fmt.Errorf("invalid visibility: %s, must be public or unlisted", visibility)
+	}
+	if tags := option("tags"); tags != "" {
+		var err error
+		if options.Tags, err = ParseTags(tags); err != nil {
+			return nil, err
+		}
+	}
+	switch pasteType := option("type"); pasteType {
+	case "", TypeText, TypeRedirect:
+		options.Type = pasteType
+	default:
+		return nil, fmt.Errorf("invalid type: %s, must be text or redirect", pasteType)
+	}
+	return options, nil
 }
 
 type HttpRoutes struct {
 	hashidMaker *hashids.HashID
-	lock sync.Mutex
+	storage Storage
+	uploads *TusStore
+	fetchClient *http.Client
+	search *SearchIndex
+	gistClient *http.Client
+	bans *BanList
+	auditLog *AuditLog
+	blocklist *Blocklist
+	filter ContentFilter
+	scanner Scanner
+	usage *StorageUsage
+	ipQuota *IPQuota
+	passwords *PasswordGuard
+	peers *Peers
+	instanceStats instanceStats
+	notifications *Notifications
+	config *Config
 }
 
-func NewHttpRoutes() *HttpRoutes {
-	hr := &HttpRoutes{}
+func NewHttpRoutes(config *Config, storage Storage) (*HttpRoutes, error) {
+	hr := &HttpRoutes{config: config, storage: storage, uploads: NewTusStore(config), passwords: NewPasswordGuard(config), peers: NewPeers(config), notifications: NewNotifications(config)}
+	hr.instanceStats.started = time.Now()
+	if config.Fetch {
+		hr.fetchClient = NewFetchClient(config.FetchTimeout, config.FetchPrivate)
+	}
+	if config.Gist {
+		hr.gistClient = &http.Client{Timeout: config.FetchTimeout}
+	}
+	if config.Search {
+		search, err := NewSearchIndex(config)
+		if err != nil {
+			return nil, err
+		}
+		hr.search = search
+	}
+	bans, err := NewBanList(config)
+	if err != nil {
+		return nil, err
+	}
+	hr.bans = bans
+	if hr.auditLog, err = NewAuditLog(config); err != nil {
+		return nil, err
+	}
+	if hr.blocklist, err = NewBlocklist(config); err != nil {
+		return nil, err
+	}
+	if config.FilterRules != "" {
+		if hr.filter, err = LoadContentFilter(config.FilterRules); err != nil {
+			return nil, err
+		}
+	}
+	if config.Scanner != "" {
+		if hr.scanner, err = NewScanner(config.Scanner, config.ScannerTimeout); err != nil {
+			return nil, err
+		}
+	}
+	if hr.usage, err = NewStorageUsage(config, storage); err != nil {
+		return nil, err
+	}
+	if hr.ipQuota, err = NewIPQuota(config, storage); err != nil {
+		return nil, err
+	}
 	hashidData := hashids.NewData()
-	hashidData.Salt = idSalt
-	hashidData.Alphabet = Alphabet
+	hashidData.Salt = config.IDSalt
+	hashidData.Alphabet = config.Alphabet
 	hashidData.MinLength = 3
 	hashidMaker, err := hashids.NewWithData(hashidData)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("hashids: %s", err)
 	}
 	hr.hashidMaker = hashidMaker
-	return hr
+	return hr, nil
 }
 
-func (*HttpRoutes) Manpage(rw http.ResponseWriter, r *http.Request) {
-	rw.WriteHeader(200)
-	rw.Write([]byte(strings.ReplaceAll(ManpageText, "{HOST}", r.Host)))
+// HashName resolves paste hash into storage name. Hashes which cannot be
+// decoded resolve to a name which never exists.
+func (hr *HttpRoutes) HashName(hash string) string {
+	// Signature of private paste is checked separately
+	hash, _, _ = strings.Cut(hash, "-")
+	counters, _ := hr.hashidMaker.DecodeInt64WithError(hash)
+	if len(counters) == 0 {
+		counters = append(counters, 0)
+	}
+	return PasteName(counters[0], hash)
 }
 
-func PasteFromMultipart(r *http.Request) ([]byte, error) {
-	var err error
-	var mr *multipart.Reader
-	if mr, err = r.MultipartReader(); err != nil {
-		return nil, err
+func BaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
 	}
-	var part *multipart.Part
-	part, err = mr.NextPart()
-	if err != nil {
-		if errors.Is(err, io.EOF) {
-			return nil, errors.New("no parts in multipart body")
+	if r.URL.Scheme != "" {
+		scheme = r.URL.Scheme
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+// FormatSize renders byte count in largest unit it divides evenly into.
+func FormatSize(size int64) string {
+	switch {
+	case size%(1<<20) == 0:
+		return fmt.Sprintf("%d MB", size>>20)
+	case size%(1<<10) == 0:
+		return fmt.Sprintf("%d KB", size>>10)
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+func (hr *HttpRoutes) Manpage(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	if WantsHTML(r) {
+		if err := RenderIndex(rw, nil); err != nil {
+			panic(err)
 		}
-		return nil, err
+		return
 	}
-	return ioutil.ReadAll(part)
+	hostname := r.Host
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		hostname = host
+	}
+	_, tcpPort, _ := net.SplitHostPort(hr.config.TCPListen)
+	_, sshPort, _ := net.SplitHostPort(hr.config.SSHListen)
+	_, grpcPort, _ := net.SplitHostPort(hr.config.GRPCListen)
+	_, geminiPort, _ := net.SplitHostPort(hr.config.GeminiListen)
+	rw.WriteHeader(200)
+	rw.Write([]byte(strings.NewReplacer(
+		"{HOST}", r.Host,
+		"{HOSTNAME}", hostname,
+		"{TCP_PORT}", tcpPort,
+		"{SSH_PORT}", sshPort,
+		"{GRPC_PORT}", grpcPort,
+		"{GEMINI_PORT}", geminiPort,
+		"{SMTP_DOMAIN}", hr.config.SMTPDomain,
+		"{MAX_BODY_LEN}", FormatSize(hr.config.MaxBodyLen),
+		"{COOLDOWN}", hr.config.PasteCooldown.String(),
+		"{BURST}", fmt.Sprint(hr.config.PasteBurst),
+		"{MAX_PARTS}", fmt.Sprint(hr.config.MaxParts),
+	).Replace(ManpageText)))
 }
 
-func PasteFromBody(r *http.Request) ([]byte, error) {
-	return ioutil.ReadAll(r.Body)
+// Upload is paste spooled from request body along with type and file name
+// client sent it with.
+type Upload struct {
+	*Spool
+	ContentType string
+	Filename    string
+	// Set for files sent 0x0.st style
+	Form *FileForm
+	// Set by content filter
+	Quarantine string
+	MaxTTL time.Duration
+	// Shown to creator, e.g. about secrets found in content
+	Warnings []string
 }
 
-func (hr *HttpRoutes) CreatePaste(rw http.ResponseWriter, r *http.Request) {
-	defer func() {
-		if r := recover(); r != nil {
-			var msg string
-			if err, ok := r.(error); ok {
-				msg = err.Error()
-			} else {
-				msg = fmt.Sprint(r)
+func CloseUploads(uploads []*Upload) {
+	for _, upload := range uploads {
+		upload.Close()
+	}
+}
+
+// ErrTooManyParts is returned for multipart body with more files than allowed.
+var ErrTooManyParts = errors.New("too many parts in multipart body")
+
+// PastesFromMultipart spools every non-empty part, e.g. each of several
+// files passed with curl -F. Secret and expires fields of 0x0.st style
+// upload go to form of files instead.
+func PastesFromMultipart(r *http.Request, maxParts int) ([]*Upload, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	var uploads []*Upload
+	form := &FileForm{}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return uploads, nil
+		}
+		if err == nil && part.FileName() == "" && (part.FormName() == FileFieldSecret || part.FormName() == FileFieldExpires) {
+			var value []byte
+			if value, err = io.ReadAll(io.LimitReader(part, 64)); err == nil {
+				if part.FormName() == FileFieldSecret {
+					form.Secret = true
+				} else {
+					form.Expires = string(value)
+				}
+				continue
 			}
-			rw.WriteHeader(500)
-			rw.Write([]byte(msg))
 		}
-	}()
+		var spool *Spool
+		if err == nil {
+			spool, err = SpoolPaste(part)
+		}
+		if err == nil && spool.Size > 0 && len(uploads) == maxParts {
+			spool.Close()
+			err = ErrTooManyParts
+		}
+		if err != nil {
+			CloseUploads(uploads)
+			return nil, err
+		}
+		// Forms send empty parts for file inputs left blank
+		if spool.Size == 0 {
+			spool.Close()
+			continue
+		}
+		upload := &Upload{
+			Spool:       spool,
+			ContentType: part.Header.Get("Content-Type"),
+			Filename:    part.FileName(),
+		}
+		if part.FormName() == FileField {
+			upload.Form = form
+		}
+		uploads = append(uploads, upload)
+	}
+}
+
+func PasteFromBody(r *http.Request) ([]*Upload, error) {
+	spool, err := SpoolPaste(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if spool.Size == 0 {
+		spool.Close()
+		return nil, nil
+	}
+	return []*Upload{{Spool: spool, ContentType: r.Header.Get("Content-Type")}}, nil
+}
 
-	hr.lock.Lock()
-	defer hr.lock.Unlock()
+// OriginalContentType normalizes Content-Type sent along with paste. Form
+// types are dropped: curl sends them with any --data-binary.
+func OriginalContentType(contentType string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/x-www-form-urlencoded" || strings.HasPrefix(mediaType, "multipart/") {
+		return ""
+	}
+	return mime.FormatMediaType(mediaType, params)
+}
 
+// readPastes spools pastes from request body to temporary files, one per
+// part of multipart body. On failure it responds with error and returns nil,
+// otherwise caller must close uploads.
+func (hr *HttpRoutes) readPastes(rw http.ResponseWriter, r *http.Request) []*Upload {
 	var err error
 
-	// Limit maximum request body size
-	r.Body = http.MaxBytesReader(rw, r.Body, MaxBodyLen)
+	// Limit maximum request body size, declared size is checked before
+	// anything is read
+	limit := MaxBodyLen(r, hr.config)
+	tooLarge := fmt.Sprintf("request body too large, limit is %s", FormatSize(limit))
+	if r.ContentLength > limit {
+		WriteError(rw, r, 413, tooLarge)
+		return nil
+	}
+	r.Body = http.MaxBytesReader(rw, r.Body, limit)
 
 	// Parse request
-	var pasteContent []byte
+	var uploads []*Upload
 	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
-		pasteContent, err = PasteFromMultipart(r)
-	// } else if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		uploads, err = PastesFromMultipart(r, hr.config.MaxParts)
 	} else {
-		pasteContent, err = PasteFromBody(r)
+		uploads, err = PasteFromBody(r)
+		// sprunge and ix.io clients send pastes as form fields
+		if err == nil && len(uploads) == 1 && IsPasteForm(uploads[0]) {
+			form := uploads[0]
+			uploads, err = PastesFromForm(form, hr.config.MaxParts)
+			form.Close()
+		}
 	}
 	if err != nil {
 		// https://github.com/golang/go/issues/30715
 		if strings.HasSuffix(err.Error(), "http: request body too large") {
-			rw.WriteHeader(413)
-			rw.Write([]byte("error: request body too large\n"))
-			return
+			WriteError(rw, r, 413, tooLarge)
+			return nil
+		}
+		if errors.Is(err, ErrTooManyParts) {
+			WriteError(rw, r, 400, fmt.Sprintf("too many files, limit is %d", hr.config.MaxParts))
+			return nil
+		}
+		if errors.Is(err, ErrInvalidForm) {
+			WriteError(rw, r, 400, err.Error())
+			return nil
 		}
 		panic(err)
 	}
 
-	if len(pasteContent) == 0 {
-		rw.WriteHeader(400)
-		rw.Write([]byte("error: your paste is empty!\n"))
-		return
-	}
-
-	// Read counter
-	var counterFile *os.File
-	var counter int64
-	var counterHash string
-	if counterFile, err = os.OpenFile(path.Join(DataDir, "counter.dat"), os.O_CREATE | os.O_RDWR, 0644); err != nil {
-		panic(err)
-	}
-	defer counterFile.Close()
-	if counter, err = ReadCounter(counterFile); err != nil {
-		panic(err)
+	if len(uploads) == 0 {
+		WriteError(rw, r, 400, "your paste is empty!")
+		return nil
 	}
-	counter++
-	if err = WriteCounter(counterFile, counter); err != nil {
-		panic(err)
+	for _, upload := range uploads {
+		upload.DetectContentType()
 	}
-
-	// Generate hash
-	if counterHash, err = hr.hashidMaker.EncodeInt64([]int64{counter}); err != nil {
-		panic(err)
+	if err = hr.CheckContent(r, uploads...); err != nil {
+		CloseUploads(uploads)
+		WriteError(rw, r, ContentStatus(err), err.Error())
+		return nil
 	}
+	return uploads
+}
 
-	// Save paste
-	var pasteFile *os.File
-	if pasteFile, err = os.OpenFile(
-		path.Join(DataDir, fmt.Sprintf("pastes/%09d_%s", counter, counterHash)),
-		os.O_CREATE | os.O_WRONLY, 0644,
-	); err != nil {
-		panic(err)
+// DetectContentType normalizes type upload was sent with, falling back to
+// file name and content.
+func (u *Upload) DetectContentType() {
+	u.ContentType = OriginalContentType(u.ContentType)
+	// curl labels files it doesn't know as octet-stream, name tells more
+	if u.Filename != "" && (u.ContentType == "" || u.ContentType == "application/octet-stream") {
+		u.ContentType = mime.TypeByExtension(path.Ext(u.Filename))
 	}
-	defer pasteFile.Close()
-	if _, err = pasteFile.Write(pasteContent); err != nil {
-		panic(err)
+	if u.ContentType == "" && u.Binary() {
+		u.ContentType = http.DetectContentType(u.Head())
 	}
+}
 
-	// Return URL
-	scheme := "http"
-	if r.URL.Scheme != "" {
-		scheme = r.URL.Scheme
+// IsBinary uses the same heuristic as git: text has no NUL bytes. Content
+// which is not valid UTF-8 is treated as binary too.
+func IsBinary(content []byte) bool {
+	head := content
+	if len(head) > 8000 {
+		head = head[:8000]
 	}
-	rw.WriteHeader(200)
-	rw.Write([]byte(fmt.Sprintf("%s://%s/%s\n", scheme, r.Host, counterHash)))
+	return bytes.IndexByte(head, 0) != -1 || !utf8.Valid(content)
 }
 
-func (hr *HttpRoutes) RetrievePaste(rw http.ResponseWriter, r *http.Request) {
-	defer func() {
-		if e, ok := recover().(error); ok {
-			rw.WriteHeader(500)
-			rw.Write([]byte(e.Error()))
-		}
-	}()
+// CiphertextOverhead is the size of IV and authentication tag which every
+// client-encrypted paste has besides ciphertext itself.
+const CiphertextOverhead = 12 + 16
 
-	var err error
+// ValidCiphertext checks that encrypted pastes are long enough to be IV
+// followed by AES-GCM ciphertext, server can't tell more.
+func ValidCiphertext(uploads []*Upload) bool {
+	for _, upload := range uploads {
+		if upload.Size <= CiphertextOverhead {
+			return false
+		}
+	}
+	return true
+}
 
-	// Get hash from URL
-	vars := mux.Vars(r)
-	hash, _ := vars["hash"]
+func (hr *HttpRoutes) CreatePaste(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
 
-	// Read paste from file
-	var pasteFile *os.File
-	var content []byte
-	counters, _ := hr.hashidMaker.DecodeInt64WithError(hash)
-	if len(counters) == 0 {
-		counters = append(counters, 0)
+	// Parse expiry and flags
+	options, err := hr.PasteOptions(RequestOptions(r))
+	if err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
 	}
-	if pasteFile, err = os.OpenFile(
-		path.Join(DataDir, fmt.Sprintf("pastes/%09d_%s", counters[0], hash)),
-		os.O_RDONLY, 0644,
-	); err != nil {
-		if os.IsNotExist(err) {
-			rw.WriteHeader(404)
-			rw.Write([]byte(fmt.Sprintf("paste with id \"%s\" was not found\n", hash)))
+	if encrypted := PasteOption(r, "encrypted", "X-Encrypted"); encrypted != "" {
+		if options.Encrypted, err = strconv.ParseBool(encrypted); err != nil {
+			WriteError(rw, r, 400, fmt.Sprintf("invalid encrypted flag: %s", encrypted))
 			return
 		}
-		panic(err)
-	}
-	defer pasteFile.Close()
-	if content, err = ioutil.ReadAll(pasteFile); err != nil {
-		panic(err)
 	}
 
-	// Return content
-	rw.WriteHeader(200)
-	rw.Write(content)
-}
-
-func RateLimit(fn http.HandlerFunc) http.HandlerFunc {
-	return func(rw http.ResponseWriter, r *http.Request) {
-		addrParts := strings.Split(r.RemoteAddr, ":")
-		if len(addrParts) > 1 {
-			lastTime := addrTimeMap[addrParts[0]]
-			nextTry := lastTime.Add(PasteCooldown)
-			retryAfter := int64(math.Ceil(time.Until(nextTry).Seconds()))
-			if retryAfter > 0 {
-				rw.Header().Add("Retry-After", fmt.Sprint(retryAfter))
-				rw.WriteHeader(429)
-				rw.Write([]byte(fmt.Sprintf(
-					"error: please wait %d seconds before creating new paste\n",
-					retryAfter,
-				)))
-				return
-			}
-			addrTimeMap[addrParts[0]] = time.Now()
-		}
-		fn(rw, r)
+	uploads := hr.readPastes(rw, r)
+	if uploads == nil {
+		return
 	}
-}
+	defer CloseUploads(uploads)
+	if options.Encrypted && !ValidCiphertext(uploads) {
+		WriteError(rw, r, 400, "encrypted paste must be 12-byte IV followed by AES-GCM ciphertext")
+		return
+	}
+	password := PasteOption(r, "password", "X-Password")
+	if err = CheckRedirect(options, password != "", uploads); err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
+	}
+	if password != "" {
+		if options.Password, err = NewPasswordKDF(); err != nil {
+			panic(err)
+		}
+		key := hr.passwords.Key(options.Password, password)
+		for _, upload := range uploads {
+			if err = upload.Encrypt(key); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	// Every file of multipart body becomes a paste of its own, ones sent
+	// 0x0.st style get options of their own
+	metas := make([]*PasteMeta, len(uploads))
+	for i, upload := range uploads {
+		metas[i] = options
+		if upload.Form != nil {
+			if metas[i], err = hr.FileOptions(r, options, upload); err != nil {
+				WriteError(rw, r, 400, err.Error())
+				return
+			}
+		}
+	}
+	var pastes []*PasteInfo
+	for i, upload := range uploads {
+		pastes = append(pastes, hr.createPaste(r, metas[i], upload))
+	}
+	WriteCreated(rw, r, pastes)
+}
+
+// WriteCreated returns URLs of created pastes. Tokens are repeated in headers
+// in the order of pastes, duplicates get empty ones unless there's nothing
+// else.
+func WriteCreated(rw http.ResponseWriter, r *http.Request, pastes []*PasteInfo) {
+	duplicates := false
+	for _, info := range pastes {
+		duplicates = duplicates || info.Duplicate
+	}
+	for _, info := range pastes {
+		if len(pastes) > 1 || !info.Duplicate {
+			rw.Header().Add("X-Delete-Url", info.DeleteURL)
+			rw.Header().Add("X-Delete-Token", info.DeleteToken)
+			rw.Header().Add("X-Edit-Token", info.EditToken)
+		}
+		if duplicates {
+			rw.Header().Add("X-Duplicate", strconv.FormatBool(info.Duplicate))
+		}
+		WriteWarnings(rw, info.Warnings)
+	}
+	if WantsJSON(r) {
+		if len(pastes) == 1 {
+			WriteJSON(rw, 200, pastes[0])
+		} else {
+			WriteJSON(rw, 200, pastes)
+		}
+		return
+	}
+	if WantsHTML(r) {
+		if err := RenderIndex(rw, pastes); err != nil {
+			panic(err)
+		}
+		return
+	}
+	// QR code lets paste be opened on phone right from terminal
+	qr, _ := strconv.ParseBool(r.URL.Query().Get("qr"))
+	rw.WriteHeader(200)
+	for _, info := range pastes {
+		rw.Write([]byte(info.URL + "\n"))
+		if qr {
+			text, err := QRText(info.URL)
+			if err != nil {
+				panic(err)
+			}
+			rw.Write([]byte(text))
+		}
+	}
+}
+
+// createPaste stores upload as a new paste with expiry and burn flag taken
+// from options, unless identical paste exists. Returned info includes
+// secret tokens of new paste.
+func (hr *HttpRoutes) createPaste(r *http.Request, options *PasteMeta, upload *Upload) *PasteInfo {
+	var err error
+
+	meta := *options
+	meta.ContentType = upload.ContentType
+	meta.Filename = upload.Filename
+	meta.ApplyFilter(upload, meta.Created)
+	if meta.Encrypted {
+		meta.ContentType = "application/octet-stream"
+	}
+	meta.SetSummary(upload.ContentSummer)
+	if meta.Password != nil {
+		// Line count of ciphertext means nothing
+		meta.Lines = 0
+	} else if !meta.Encrypted {
+		meta.Language = upload.Language()
+		if meta.Type != TypeText {
+			meta.Redirect = RedirectTarget(upload)
+		}
+	}
+
+	// Return existing paste with the same content, forks are always new
+	if hr.config.Dedup && !meta.Burn && !meta.Private && meta.Parent == "" {
+		if hash, existing := hr.findDuplicate(r, &meta); existing != nil {
+			SetPasteID(r, hash)
+			RequestLogger(r).Info("duplicate paste", "bytes", meta.Size)
+			info := NewPasteInfo(r, hash, existing, int(meta.Size))
+			info.Duplicate = true
+			info.Warnings = upload.Warnings
+			return info
+		}
+	}
+
+	// Get next counter
+	var counter int64
+	var counterHash string
+	if counter, err = hr.storage.NextCounter(); err != nil {
+		panic(err)
+	}
+
+	// Generate hash
+	if counterHash, err = hr.hashidMaker.EncodeInt64([]int64{counter}); err != nil {
+		panic(err)
+	}
+
+	// Generate deletion and edit tokens
+	var deleteToken, editToken string
+	if deleteToken, meta.DeleteHash, err = NewToken(); err != nil {
+		panic(err)
+	}
+	if editToken, meta.EditHash, err = NewToken(); err != nil {
+		panic(err)
+	}
+
+	// Save paste
+	meta.IP = RemoteIP(r)
+	if key := GetRequestInfo(r).APIKey; key != nil {
+		meta.APIKey = key.Name
+	}
+	if err = hr.storage.Save(PasteName(counter, counterHash), &meta, upload); err != nil {
+		panic(err)
+	}
+	hr.usage.Add(meta.Size)
+	hr.ipQuota.Record(r, meta.Size, meta.Created)
+	hr.indexPaste(r, PasteName(counter, counterHash), &meta)
+	metricPastesCreated.Inc()
+	metricBytesStored.Add(float64(meta.Size))
+	SetPasteID(r, counterHash)
+	RequestLogger(r).Info("paste created", "bytes", meta.Size)
+	if meta.Quarantine != "" {
+		RequestLogger(r).Warn("paste quarantined", "reason", meta.Quarantine)
+	}
+	if hr.notifications != nil && meta.Listed() {
+		hr.notifications.Queue(hr.recentPaste(r, PasteName(counter, counterHash), counterHash, &meta))
+	}
+
+	id := hr.PasteID(counterHash, &meta)
+	info := NewPasteInfo(r, id, &meta, int(meta.Size))
+	info.DeleteURL = fmt.Sprintf("%s/delete/%s/%s", BaseURL(r), id, deleteToken)
+	info.DeleteToken = deleteToken
+	info.EditToken = editToken
+	info.Warnings = upload.Warnings
+	return info
+}
+
+// findDuplicate looks up paste with the same content which lives at least as
+// long as the new one would. Burn-after-read pastes are never reused.
+func (hr *HttpRoutes) findDuplicate(r *http.Request, meta *PasteMeta) (string, *PasteMeta) {
+	name, err := hr.storage.FindByHash(meta.SHA256)
+	if err != nil {
+		if !errors.Is(err, ErrPasteNotFound) {
+			RequestLogger(r).Warn("failed to look up duplicate", "error", err)
+		}
+		return "", nil
+	}
+	existing, err := hr.storage.LoadMeta(name)
+	if err != nil || existing.SHA256 != meta.SHA256 || existing.ContentType != meta.ContentType || existing.Encrypted != meta.Encrypted ||
+		existing.Visibility != meta.Visibility || !slices.Equal(existing.Tags, meta.Tags) || existing.Redirect != meta.Redirect || existing.Burn || existing.Private || existing.Expired() || existing.Takedown != nil || existing.Quarantine != "" {
+		return "", nil
+	}
+	if existing.Expires != nil && (meta.Expires == nil || existing.Expires.Before(*meta.Expires)) {
+		return "", nil
+	}
+	_, hash, err := ParsePasteName(name)
+	if err != nil {
+		return "", nil
+	}
+	return hash, existing
+}
+
+// ContentTypeByExtension maps extension to MIME type.
+func ContentTypeByExtension(ext string) string {
+	return SafeContentType(mime.TypeByExtension(ext))
+}
+
+// PasteContentType picks type to serve raw paste with: extension from URL
+// wins over type paste was created with, then over detected language.
+// Encrypted pastes are opaque.
+func PasteContentType(meta *PasteMeta, ext string) string {
+	if meta.Encrypted {
+		return "application/octet-stream"
+	}
+	if ext == "" && meta.ContentType != "" {
+		return SafeContentType(meta.ContentType)
+	}
+	if ext == "" {
+		ext = LanguageExtension(meta.Language)
+	}
+	return ContentTypeByExtension(ext)
+}
+
+// SafeContentType replaces anything a browser could execute with plain text
+// since pastes share origin with the service.
+func SafeContentType(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "", mediaType == "text/html", mediaType == "application/xhtml+xml", mediaType == "image/svg+xml":
+		return "text/plain; charset=utf-8"
+	case strings.HasPrefix(mediaType, "text/") && !strings.Contains(contentType, "charset"):
+		return contentType + "; charset=utf-8"
+	}
+	return contentType
+}
+
+func PasteNotFound(rw http.ResponseWriter, r *http.Request, hash string) {
+	if WantsJSON(r) {
+		WriteJSON(rw, 404, map[string]string{"error": fmt.Sprintf("paste with id \"%s\" was not found", hash)})
+		return
+	}
+	rw.WriteHeader(404)
+	rw.Write([]byte(fmt.Sprintf("paste with id \"%s\" was not found\n", hash)))
+}
+
+// ErrPasswordRequired is returned for protected paste read without password.
+var ErrPasswordRequired = errors.New("paste is protected")
+
+// readPaste loads paste to be shown outside of regular views: expired and
+// inaccessible pastes are not found, protected ones are decrypted with
+// password. Caller must call viewPaste once content is going to be shown.
+func (hr *HttpRoutes) readPaste(r *http.Request, hash string, password string) (*PasteMeta, []byte, error) {
+	meta, content, err := hr.storage.Load(hr.HashName(hash))
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta.Expired() || !hr.CanAccess(hash, meta) {
+		return nil, nil, ErrPasteNotFound
+	}
+	if meta.Password != nil {
+		if password == "" {
+			return nil, nil, ErrPasswordRequired
+		}
+		if content, _, err = hr.passwords.Unlock(r, hash, meta.Password, password, content); err != nil {
+			return nil, nil, err
+		}
+	}
+	return meta, content, nil
+}
+
+// viewPaste records view of paste loaded by readPaste and burns it if
+// needed. Only one of concurrent readers of burn paste gets nil.
+func (hr *HttpRoutes) viewPaste(r *http.Request, hash string, meta *PasteMeta) error {
+	name := hr.HashName(hash)
+	if meta.Burn {
+		if err := hr.storage.Delete(name); err != nil {
+			return err
+		}
+		hr.unindexPaste(r, name)
+		hr.usage.Release(meta)
+		RequestLogger(r).Info("paste burned")
+	}
+	if err := hr.storage.RecordView(name, time.Now()); err != nil && !errors.Is(err, ErrPasteNotFound) {
+		RequestLogger(r).Warn("failed to record view", "error", err)
+	}
+	return nil
+}
+
+func (hr *HttpRoutes) RetrievePaste(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	var err error
+
+	// Get hash from URL
+	vars := mux.Vars(r)
+	hash, _ := vars["hash"]
+
+	// Read paste from storage, raw content of current version is streamed
+	var content []byte
+	var stream io.ReadSeekCloser
+	var meta *PasteMeta
+	name := hr.HashName(hash)
+	view := PasteView(r, vars["view"])
+	if view == "raw" && StripANSIRequested(r) {
+		view = "text"
+	}
+	var lines *LineRange
+	if lines, err = PasteLines(r); err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
+	}
+	_, isRevision := vars["revision"]
+	if view == "raw" && !isRevision && lines == nil {
+		meta, stream, err = hr.storage.Open(name)
+	} else {
+		meta, content, err = hr.storage.Load(name)
+	}
+	if err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			if hr.peerRedirect(rw, r, hash) {
+				return
+			}
+			PasteNotFound(rw, r, hash)
+			return
+		}
+		panic(err)
+	}
+	if stream != nil {
+		defer stream.Close()
+		// Burn pastes must be read before they are deleted, protected ones
+		// decrypted, and pastes created before checksums were recorded
+		// have to be summed up
+		if meta.Burn || meta.Password != nil || meta.SHA256 == "" {
+			if content, err = io.ReadAll(stream); err != nil {
+				panic(err)
+			}
+			stream = nil
+		}
+	}
+	if meta.Expired() {
+		// Expired pastes are removed lazily on first access
+		if err = hr.storage.Delete(name); err != nil && !errors.Is(err, ErrPasteNotFound) {
+			panic(err)
+		} else if err == nil {
+			hr.usage.Release(meta)
+		}
+		hr.unindexPaste(r, name)
+		RequestLogger(r).Debug("expired paste removed")
+		PasteNotFound(rw, r, hash)
+		return
+	}
+	if hr.Withheld(rw, r, hash, meta) {
+		return
+	}
+	if !hr.CanAccess(hash, meta) {
+		PasteNotFound(rw, r, hash)
+		return
+	}
+	// Last revision number refers to current content
+	revision := 0
+	checksum := meta.SHA256
+	if value, ok := vars["revision"]; ok {
+		revision, _ = strconv.Atoi(value)
+		// Revisions would let burn pastes be read more than once
+		if meta.Burn || revision < 1 || revision > len(meta.Revisions)+1 {
+			PasteNotFound(rw, r, hash)
+			return
+		}
+		if revision <= len(meta.Revisions) {
+			if content, err = hr.storage.LoadRevision(name, revision); err != nil {
+				if errors.Is(err, ErrPasteNotFound) {
+					PasteNotFound(rw, r, hash)
+					return
+				}
+				panic(err)
+			}
+			checksum = meta.Revisions[revision-1].SHA256
+		}
+	}
+	if checksum == "" {
+		digest := sha256.Sum256(content)
+		checksum = hex.EncodeToString(digest[:])
+	}
+	// Wrong password must not burn the paste
+	if meta.Password != nil {
+		password := PastePassword(r)
+		if password == "" {
+			PasswordRequired(rw, r, hash, false)
+			return
+		}
+		if content, _, err = hr.passwords.Unlock(r, hash, meta.Password, password, content); err != nil {
+			if errors.Is(err, ErrInvalidPassword) {
+				RequestLogger(r).Info("invalid paste password")
+				PasswordRequired(rw, r, hash, true)
+				return
+			}
+			if errors.Is(err, ErrPasswordAttempts) {
+				WriteError(rw, r, 429, err.Error())
+				return
+			}
+			panic(err)
+		}
+		rw.Header().Set("Cache-Control", "no-store")
+	}
+	if lines != nil {
+		// Burn paste is left in place, asking for lines of binary
+		// is clearly a mistake
+		if meta.Encrypted || IsBinary(content) {
+			WriteError(rw, r, 400, "lines can only be selected from text pastes")
+			return
+		}
+		content = lines.Slice(content)
+	}
+	if meta.Burn {
+		// Paste is read only once, answering conditional request with 304
+		// or 412 would burn it without anyone seeing content
+		for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since"} {
+			r.Header.Del(name)
+		}
+		// Nor is it read in parts, whole content is served
+		r.Header.Del("Range")
+		// Only one of concurrent readers will succeed in deleting the paste,
+		// the rest will respond with 404.
+		if err = hr.storage.Delete(name); err != nil {
+			if errors.Is(err, ErrPasteNotFound) {
+				PasteNotFound(rw, r, hash)
+				return
+			}
+			panic(err)
+		}
+		hr.unindexPaste(r, name)
+		hr.usage.Release(meta)
+		RequestLogger(r).Info("paste burned")
+	}
+
+	if err = hr.storage.RecordView(name, time.Now()); err != nil && !errors.Is(err, ErrPasteNotFound) {
+		RequestLogger(r).Warn("failed to record view", "error", err)
+	}
+	// Redirect pastes redirect unless specific representation is asked for
+	if meta.Redirect != "" && vars["view"] == "" && view != "json" && !isRevision && lines == nil {
+		http.Redirect(rw, r, meta.Redirect, 302)
+		return
+	}
+
+	// Return content, browsers get highlighted HTML unless raw is requested
+	ext, _ := vars["ext"]
+	if vars["view"] == "" && view == "html" && IsMarkdown(meta, ext) {
+		view = "md"
+	}
+	etag := PasteETag(meta, checksum, view, lines)
+	if meta.Burn {
+		rw.Header().Set("Cache-Control", "no-store")
+	} else {
+		SetCacheHeaders(rw, meta, etag)
+	}
+	if meta.Encrypted {
+		rw.Header().Set("X-Encrypted", "true")
+	}
+	if !meta.Burn && NotModified(r, meta, etag) {
+		rw.WriteHeader(304)
+		return
+	}
+	if view == "json" {
+		info := NewPasteInfo(r, hash, meta, len(content))
+		info.Revision = revision
+		info.SetContent(content)
+		WriteJSON(rw, 200, info)
+		return
+	}
+	// Neither encrypted nor binary pastes can be rendered, their usual page
+	// is shown instead.
+	if view == "md" && !meta.Encrypted && !IsBinary(content) {
+		if err = RenderMarkdown(rw, hash, ext, content); err != nil {
+			panic(err)
+		}
+		return
+	}
+	// Terminal output keeps its colors unless highlighting was asked for
+	// with extension
+	if !meta.Encrypted && !IsBinary(content) && (view == "ansi" || view == "html" && ext == "" && HasANSI(content)) {
+		if err = RenderANSI(rw, hash, ext, content); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if view == "html" || view == "md" || view == "ansi" {
+		if meta.Encrypted {
+			err = RenderEncryptedPaste(rw, hash, ext, content)
+		} else {
+			err = RenderPaste(rw, hash, ext, meta.Language, PasteContentType(meta, ext), content, lines)
+		}
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+	// Never let content be sniffed, it could turn out to be HTML. XML may
+	// contain scripts too, so sandbox whatever is served.
+	rw.Header().Set("Content-Type", PasteContentType(meta, ext))
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.Header().Set("Content-Security-Policy", "sandbox")
+	if view == "text" {
+		content = StripANSI(content)
+	}
+	// Handles Range requests, so big pastes can be fetched in pieces
+	if stream == nil {
+		stream = BytesStream(content)
+	}
+	http.ServeContent(rw, r, "", meta.Modified(), stream)
+}
+
+// PasteView tells which representation of paste to respond with: raw, html,
+// md, ansi or json. View from URL wins, otherwise it's negotiated by headers.
+// Browsers are switched to md for Markdown pastes once metadata is loaded.
+func PasteView(r *http.Request, view string) string {
+	switch {
+	case view != "":
+		return view
+	case WantsJSON(r):
+		return "json"
+	case WantsHTML(r):
+		return "html"
+	}
+	return "raw"
+}
+
+// PasteETag derives entity tag from content checksum. Representations
+// differ, so each gets its own tag, JSON one also changes with metadata.
+// Selected lines are parts of paste on their own.
+func PasteETag(meta *PasteMeta, checksum string, view string, lines *LineRange) string {
+	if lines != nil && checksum != "" {
+		checksum += "-L" + lines.String()
+	}
+	switch {
+	case checksum == "":
+		return ""
+	case view == "json":
+		return fmt.Sprintf(`"%s-json-%x"`, checksum, meta.Modified().UnixNano())
+	case view != "raw":
+		return fmt.Sprintf(`"%s-%s"`, checksum, view)
+	}
+	return fmt.Sprintf(`"%s"`, checksum)
+}
+
+// StripANSIRequested tells whether raw paste should be served as plain text,
+// without terminal escape sequences.
+func StripANSIRequested(r *http.Request) bool {
+	strip, _ := strconv.ParseBool(r.URL.Query().Get("strip-ansi"))
+	return strip
+}
+
+// SetCacheHeaders sets headers letting clients revalidate cached paste.
+func SetCacheHeaders(rw http.ResponseWriter, meta *PasteMeta, etag string) {
+	rw.Header().Set("Last-Modified", meta.Modified().UTC().Format(http.TimeFormat))
+	if etag != "" {
+		rw.Header().Set("ETag", etag)
+	}
+}
+
+// NotModified checks If-None-Match, or If-Modified-Since if the former is
+// absent, against paste validators.
+func NotModified(r *http.Request, meta *PasteMeta, etag string) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || etag != "" && candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !meta.Modified().Truncate(time.Second).After(since)
+}
+
+// HeadPaste answers HEAD with headers GET would send. Content is not read,
+// so it neither burns paste nor counts as a view.
+func (hr *HttpRoutes) HeadPaste(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	var err error
+
+	vars := mux.Vars(r)
+	hash, _ := vars["hash"]
+	view := PasteView(r, vars["view"])
+	if view == "raw" && StripANSIRequested(r) {
+		view = "text"
+	}
+	ext, _ := vars["ext"]
+	lines, err := PasteLines(r)
+	if err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
+	}
+
+	var meta *PasteMeta
+	name := hr.HashName(hash)
+	if meta, err = hr.storage.LoadMeta(name); err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			if hr.peerRedirect(rw, r, hash) {
+				return
+			}
+			PasteNotFound(rw, r, hash)
+			return
+		}
+		panic(err)
+	}
+	if hr.Withheld(rw, r, hash, meta) {
+		return
+	}
+	if meta.Expired() || !hr.CanAccess(hash, meta) {
+		PasteNotFound(rw, r, hash)
+		return
+	}
+	if meta.SHA256 == "" {
+		// Pastes created before size was recorded
+		var content []byte
+		if _, content, err = hr.storage.Load(name); err != nil {
+			if errors.Is(err, ErrPasteNotFound) {
+				PasteNotFound(rw, r, hash)
+				return
+			}
+			panic(err)
+		}
+		meta.SetContent(content)
+	}
+	size, checksum := meta.Size, meta.SHA256
+	if value, ok := vars["revision"]; ok {
+		revision, _ := strconv.Atoi(value)
+		if meta.Burn || revision < 1 || revision > len(meta.Revisions)+1 {
+			PasteNotFound(rw, r, hash)
+			return
+		}
+		if revision <= len(meta.Revisions) {
+			size, checksum = meta.Revisions[revision-1].Size, meta.Revisions[revision-1].SHA256
+		}
+	}
+	if meta.Password != nil {
+		size -= PasswordOverhead
+	}
+	if _, isRevision := vars["revision"]; meta.Redirect != "" && vars["view"] == "" && view != "json" && !isRevision && lines == nil {
+		rw.Header().Set("Location", meta.Redirect)
+		rw.WriteHeader(302)
+		return
+	}
+	if vars["view"] == "" && view == "html" && IsMarkdown(meta, ext) {
+		view = "md"
+	}
+
+	// Burn pastes are single-use, there's nothing to revalidate
+	etag := PasteETag(meta, checksum, view, lines)
+	if meta.Burn {
+		rw.Header().Set("Cache-Control", "no-store")
+	} else {
+		SetCacheHeaders(rw, meta, etag)
+	}
+	if meta.Encrypted {
+		rw.Header().Set("X-Encrypted", "true")
+	}
+	if !meta.Burn && NotModified(r, meta, etag) {
+		rw.WriteHeader(304)
+		return
+	}
+	switch view {
+	case "json":
+		rw.Header().Set("Content-Type", "application/json")
+	case "html":
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	case "md":
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Header().Set("Content-Security-Policy", MarkdownCSP)
+	case "ansi":
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	default:
+		rw.Header().Set("Content-Type", PasteContentType(meta, ext))
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		rw.Header().Set("Content-Security-Policy", "sandbox")
+		// Length of stripped content or selected lines is only known once
+		// content is loaded
+		if view == "raw" && lines == nil {
+			rw.Header().Set("Content-Length", fmt.Sprint(size))
+			rw.Header().Set("Accept-Ranges", "bytes")
+		}
+	}
+	rw.WriteHeader(200)
+}
+
+func (hr *HttpRoutes) PasteMetadata(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	var err error
+
+	vars := mux.Vars(r)
+	hash, _ := vars["hash"]
+	ext, _ := vars["ext"]
+
+	var meta *PasteMeta
+	name := hr.HashName(hash)
+	if meta, err = hr.storage.LoadMeta(name); err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			PasteNotFound(rw, r, hash)
+			return
+		}
+		panic(err)
+	}
+	if hr.Withheld(rw, r, hash, meta) {
+		return
+	}
+	if meta.Expired() || !hr.CanAccess(hash, meta) {
+		PasteNotFound(rw, r, hash)
+		return
+	}
+	if meta.SHA256 == "" {
+		// Pastes created before checksums were recorded
+		var content []byte
+		if _, content, err = hr.storage.Load(name); err != nil {
+			if errors.Is(err, ErrPasteNotFound) {
+				PasteNotFound(rw, r, hash)
+				return
+			}
+			panic(err)
+		}
+		meta.SetContent(content)
+	}
+
+	size := meta.Size
+	if meta.Password != nil {
+		size -= PasswordOverhead
+	}
+	WriteJSON(rw, 200, &PasteMetaInfo{
+		PasteInfo:   NewPasteInfo(r, hash, meta, int(size)),
+		Lines:       meta.Lines,
+		ContentType: PasteContentType(meta, ext),
+		SHA256:      meta.SHA256,
+	})
+}
+
+func (hr *HttpRoutes) PasteStats(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	var err error
+
+	hash, _ := mux.Vars(r)["hash"]
+
+	var meta *PasteMeta
+	name := hr.HashName(hash)
+	if meta, err = hr.storage.LoadMeta(name); err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			PasteNotFound(rw, r, hash)
+			return
+		}
+		panic(err)
+	}
+	if hr.Withheld(rw, r, hash, meta) {
+		return
+	}
+	if meta.Expired() || !hr.CanAccess(hash, meta) {
+		PasteNotFound(rw, r, hash)
+		return
+	}
+	var stats *PasteStats
+	if stats, err = hr.storage.LoadStats(name); err != nil {
+		panic(err)
+	}
+
+	if WantsJSON(r) {
+		WriteJSON(rw, 200, stats)
+		return
+	}
+	lastViewed := "never"
+	if stats.LastViewed != nil {
+		lastViewed = stats.LastViewed.UTC().Format(time.RFC3339)
+	}
+	rw.WriteHeader(200)
+	rw.Write([]byte(fmt.Sprintf("views: %d\nlast viewed: %s\n", stats.Views, lastViewed)))
+}
+
+// ErrInvalidToken is returned for deletion token which doesn't match paste.
+var ErrInvalidToken = errors.New("invalid deletion token")
+
+// deletePaste deletes paste if token is its deletion token.
+func (hr *HttpRoutes) deletePaste(r *http.Request, hash string, token string) error {
+	name := hr.HashName(hash)
+	meta, err := hr.storage.LoadMeta(name)
+	if err != nil {
+		return err
+	}
+	if !CheckToken(token, meta.DeleteHash) {
+		return ErrInvalidToken
+	}
+	if err = hr.storage.Delete(name); err != nil {
+		return err
+	}
+	hr.unindexPaste(r, name)
+	hr.usage.Release(meta)
+	RequestLogger(r).Info("paste deleted")
+	hr.auditLog.Record(&AuditEvent{Action: AuditDelete, Actor: "owner", IP: RemoteIP(r), Target: hash})
+	return nil
+}
+
+func (hr *HttpRoutes) DeletePaste(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	vars := mux.Vars(r)
+	hash, _ := vars["hash"]
+	token, ok := vars["token"]
+	if !ok {
+		token = PasteOption(r, "token", "X-Delete-Token")
+	}
+
+	if err := hr.deletePaste(r, hash, token); err != nil {
+		switch {
+		case errors.Is(err, ErrPasteNotFound):
+			PasteNotFound(rw, r, hash)
+		case errors.Is(err, ErrInvalidToken):
+			WriteError(rw, r, 403, err.Error())
+		default:
+			panic(err)
+		}
+		return
+	}
+	if r.Method == "DELETE" {
+		rw.WriteHeader(204)
+		return
+	}
+	if WantsJSON(r) {
+		WriteJSON(rw, 200, map[string]string{"id": hash, "status": "deleted"})
+		return
+	}
+	rw.WriteHeader(200)
+	rw.Write([]byte(fmt.Sprintf("paste with id \"%s\" was deleted\n", hash)))
+}
+
+func (hr *HttpRoutes) EditPaste(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	var err error
+
+	hash, _ := mux.Vars(r)["hash"]
+
+	var meta *PasteMeta
+	var oldContent []byte
+	name := hr.HashName(hash)
+	// Concurrent edits would save the same revision
+	unlock, err := hr.lockPaste(name)
+	if err != nil {
+		panic(err)
+	}
+	defer unlock()
+	if meta, oldContent, err = hr.storage.Load(name); err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			PasteNotFound(rw, r, hash)
+			return
+		}
+		panic(err)
+	}
+	if meta.Expired() {
+		PasteNotFound(rw, r, hash)
+		return
+	}
+	if !CheckToken(PasteOption(r, "token", "X-Edit-Token"), meta.EditHash) {
+		WriteError(rw, r, 403, "invalid edit token")
+		return
+	}
+	if meta.Takedown != nil {
+		PasteTakenDown(rw, r, hash, meta.Takedown)
+		return
+	}
+	// Protected paste stays protected with the same password
+	var key []byte
+	if meta.Password != nil {
+		if _, key, err = hr.passwords.Unlock(r, hash, meta.Password, PastePassword(r), oldContent); err != nil {
+			if errors.Is(err, ErrInvalidPassword) {
+				WriteError(rw, r, 403, "invalid password")
+				return
+			}
+			if errors.Is(err, ErrPasswordAttempts) {
+				WriteError(rw, r, 429, err.Error())
+				return
+			}
+			panic(err)
+		}
+	}
+
+	uploads := hr.readPastes(rw, r)
+	if uploads == nil {
+		return
+	}
+	defer CloseUploads(uploads)
+	if len(uploads) > 1 {
+		WriteError(rw, r, 400, "paste can only be replaced with a single file")
+		return
+	}
+	if meta.Encrypted && !ValidCiphertext(uploads) {
+		WriteError(rw, r, 400, "encrypted paste must be 12-byte IV followed by AES-GCM ciphertext")
+		return
+	}
+	upload := uploads[0]
+	redirect := ""
+	if meta.Type != TypeText && meta.Password == nil && !meta.Encrypted {
+		redirect = RedirectTarget(upload)
+	}
+	if meta.Type == TypeRedirect && redirect == "" {
+		WriteError(rw, r, 400, ErrInvalidRedirect.Error())
+		return
+	}
+	if key != nil {
+		if err = upload.Encrypt(key); err != nil {
+			panic(err)
+		}
+	}
+
+	// Current content becomes the latest revision
+	if err = hr.storage.SaveRevision(name, len(meta.Revisions)+1, oldContent); err != nil {
+		panic(err)
+	}
+	meta.Revisions = append(meta.Revisions, Revision{
+		Created: meta.Modified(),
+		Size:    int64(len(oldContent)),
+		SHA256:  meta.SHA256,
+	})
+
+	updated := time.Now()
+	meta.Updated = &updated
+	meta.ApplyFilter(upload, updated)
+	meta.SetSummary(upload.ContentSummer)
+	if meta.Password != nil {
+		meta.Lines = 0
+	}
+	if upload.ContentType != "" && !meta.Encrypted {
+		meta.ContentType = upload.ContentType
+	}
+	if upload.Filename != "" {
+		meta.Filename = upload.Filename
+	}
+	// Name may be kept from before, content is new
+	meta.Redirect = redirect
+	meta.Language = ""
+	if meta.Password == nil && !meta.Encrypted && !upload.Binary() {
+		meta.Language = DetectLanguage(meta.Filename, upload.Head(), upload.Size <= HeadLen)
+	}
+	if err = hr.storage.Save(name, meta, upload); err != nil {
+		panic(err)
+	}
+	// Old content is kept as revision
+	hr.usage.Add(meta.Size)
+	hr.ipQuota.Record(r, meta.Size, updated)
+	hr.indexPaste(r, name, meta)
+	metricBytesStored.Add(float64(meta.Size))
+	RequestLogger(r).Info("paste edited", "bytes", meta.Size)
+
+	id := hr.PasteID(hash, meta)
+	pasteURL := fmt.Sprintf("%s/%s", BaseURL(r), id)
+	WriteWarnings(rw, upload.Warnings)
+	if WantsJSON(r) {
+		info := NewPasteInfo(r, id, meta, int(meta.Size))
+		info.Warnings = upload.Warnings
+		WriteJSON(rw, 200, info)
+		return
+	}
+	rw.WriteHeader(200)
+	rw.Write([]byte(pasteURL + "\n"))
+}
+
+// Fatal logs error and exits, to be used only during startup.
+func Fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
 
 func main() {
-	httpRoutes := NewHttpRoutes()
+	config, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		Fatal("failed to load config", err)
+	}
+	logger, err := NewLogger(config)
+	if err != nil {
+		Fatal("failed to set up logging", err)
+	}
+	slog.SetDefault(logger)
+	if err = InheritSockets(); err != nil {
+		Fatal("failed to inherit sockets", err)
+	}
+	storage, err := NewStorage(config)
+	if err != nil {
+		Fatal("failed to set up storage", err)
+	}
+	if config.Migrate {
+		migrator, ok := storage.(Migrator)
+		if !ok {
+			Fatal("failed to migrate storage", fmt.Errorf("%s storage needs no migration", config.Storage))
+		}
+		migrated, err := migrator.Migrate()
+		if err != nil {
+			Fatal("failed to migrate storage", err)
+		}
+		slog.Info("storage migrated", "files", migrated)
+		storage.Close()
+		return
+	}
+	if config.Import != "" {
+		// Imported pastes are unlisted, search index which may be held
+		// by running server is not needed
+		config.Search = false
+		httpRoutes, err := NewHttpRoutes(config, storage)
+		if err != nil {
+			Fatal("failed to set up routes", err)
+		}
+		imported, err := httpRoutes.ImportPastes(config.Import, config.PublicURL, os.Stdout)
+		if err != nil {
+			Fatal("failed to import pastes", err)
+		}
+		slog.Info("pastes imported", "pastes", imported)
+		storage.Close()
+		return
+	}
+	if config.Backup != "" {
+		auditLog, err := NewAuditLog(config)
+		if err != nil {
+			Fatal("failed to open audit log", err)
+		}
+		pastes, err := WriteBackupFile(storage, config.Backup)
+		if err != nil {
+			Fatal("failed to create backup", err)
+		}
+		auditLog.Record(&AuditEvent{Action: AuditBackup, Actor: "cli", Target: config.Backup})
+		slog.Info("backup created", "pastes", pastes)
+		storage.Close()
+		return
+	}
+	if config.Restore != "" {
+		// Restored pastes are indexed, so search index must not be held
+		// by running server
+		httpRoutes, err := NewHttpRoutes(config, storage)
+		if err != nil {
+			Fatal("failed to set up routes", err)
+		}
+		result, err := httpRoutes.RestoreBackup(config.Restore)
+		if err != nil {
+			Fatal("failed to restore backup", err)
+		}
+		httpRoutes.auditLog.Record(&AuditEvent{Action: AuditRestore, Actor: "cli", Target: config.Restore})
+		slog.Info("backup restored", "pastes", result.Restored, "skipped", result.Skipped)
+		storage.Close()
+		return
+	}
+	if config.GC {
+		// Search index may be held by running server, it skips pastes
+		// which are gone anyway
+		config.Search = false
+		httpRoutes, err := NewHttpRoutes(config, storage)
+		if err != nil {
+			Fatal("failed to set up routes", err)
+		}
+		result, err := httpRoutes.CollectGarbage()
+		if err != nil {
+			Fatal("failed to collect garbage", err)
+		}
+		httpRoutes.auditLog.Record(&AuditEvent{Action: AuditGC, Actor: "cli"})
+		slog.Info("garbage collected", "pastes", result.Deleted, "bytes", result.Bytes)
+		storage.Close()
+		return
+	}
+	var replicator *Replicator
+	if config.Replicate != "" {
+		if replicator, err = NewReplicator(config, storage); err != nil {
+			Fatal("failed to set up replication", err)
+		}
+		storage = &ReplicatedStorage{storage, replicator}
+	}
+	httpRoutes, err := NewHttpRoutes(config, storage)
+	if err != nil {
+		Fatal("failed to set up routes", err)
+	}
+	// Private pastes have signature after hash
+	idPattern := fmt.Sprintf("[%s]+(?:-[0-9a-f]{%d})?", config.Alphabet, SignatureLen)
+	rateLimiter, err := NewRateLimiter(config)
+	if err != nil {
+		Fatal("failed to set up rate limiter", err)
+	}
 
-	router := mux.NewRouter()
-	router.Use(handlers.ProxyHeaders) // Required for X-Forwarded-Proto
-	router.HandleFunc("/", httpRoutes.Manpage).Methods("GET")
-	router.HandleFunc("/", RateLimit(httpRoutes.CreatePaste)).Methods("POST")
-	router.HandleFunc(fmt.Sprintf("/{hash:[%s]+}", Alphabet), httpRoutes.RetrievePaste).Methods("GET")
+	compressor := NewCompressor(config)
+
+	accessLog, err := NewAccessLogMiddleware(config)
+	if err != nil {
+		Fatal("failed to set up access log", err)
+	}
 
-	server := &http.Server{
-		Addr:    "0.0.0.0:8080",
-		Handler: nil,
+	// Validated along with config
+	trustedProxies, _ := ParseTrustedProxies(config.TrustedProxies)
+	router := mux.NewRouter()
+	router.Use(trustedProxies.Middleware) // Required for X-Forwarded-Proto
+	router.Use(LoggingMiddleware)
+	if accessLog != nil {
+		router.Use(accessLog)
+	}
+	var apiKeys APIKeys
+	if config.APIKeysFile != "" {
+		if apiKeys, err = LoadAPIKeys(config.APIKeysFile, config); err != nil {
+			Fatal("failed to load API keys", err)
+		}
+		router.Use(apiKeys.Middleware)
+	}
+	adminRouter := mux.NewRouter()
+	adminRouter.Use(LoggingMiddleware)
+	if config.Metrics {
+		router.Use(MetricsMiddleware)
+		metricsRouter := router
+		if config.AdminListen != "" {
+			metricsRouter = adminRouter
+		}
+		metricsRouter.Handle("/metrics", promhttp.Handler()).Methods("GET").Name("metrics")
+	}
+	if config.Pprof {
+		adminRouter.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		adminRouter.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		adminRouter.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		adminRouter.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		adminRouter.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+		adminRouter.Handle("/debug/vars", expvar.Handler())
+	}
+	if config.AdminToken != "" {
+		adminAPI := adminRouter.PathPrefix("/api/").Subrouter()
+		adminAPI.Use(AdminAuth(config.AdminToken))
+		adminAPI.HandleFunc("/pastes", httpRoutes.AdminListPastes).Methods("GET").Name("admin_list")
+		adminAPI.HandleFunc("/pastes/{counter:[0-9]+}", httpRoutes.AdminGetPaste).Methods("GET").Name("admin_get")
+		adminAPI.HandleFunc("/pastes/{counter:[0-9]+}", httpRoutes.AdminDeletePaste).Methods("DELETE").Name("admin_delete")
+		adminAPI.HandleFunc("/pastes/{counter:[0-9]+}/takedown", httpRoutes.AdminTakedown).Methods("POST").Name("admin_takedown")
+		adminAPI.HandleFunc("/pastes/{counter:[0-9]+}/reports", httpRoutes.AdminDismissReports).Methods("DELETE").Name("admin_dismiss")
+		adminAPI.HandleFunc("/reports", httpRoutes.AdminListReports).Methods("GET").Name("admin_reports")
+		adminAPI.HandleFunc("/bans", httpRoutes.AdminListBans).Methods("GET").Name("admin_bans")
+		adminAPI.HandleFunc("/bans", httpRoutes.AdminBan).Methods("POST").Name("admin_ban")
+		adminAPI.HandleFunc("/bans", httpRoutes.AdminUnban).Methods("DELETE").Name("admin_unban")
+		adminAPI.HandleFunc("/blocklist", httpRoutes.AdminListBlocklist).Methods("GET").Name("admin_blocklist")
+		adminAPI.HandleFunc("/blocklist", httpRoutes.AdminBlock).Methods("POST").Name("admin_block")
+		adminAPI.HandleFunc("/blocklist", httpRoutes.AdminUnblock).Methods("DELETE").Name("admin_unblock")
+		adminAPI.HandleFunc("/purge", httpRoutes.AdminPurge).Methods("POST").Name("admin_purge")
+		adminAPI.HandleFunc("/export", httpRoutes.AdminExport).Methods("GET").Name("admin_export")
+		adminAPI.HandleFunc("/audit", httpRoutes.AdminAuditLog).Methods("GET").Name("admin_audit")
+		adminAPI.HandleFunc("/gc", httpRoutes.AdminCollectGarbage).Methods("POST").Name("admin_gc")
+		adminAPI.HandleFunc("/backup", httpRoutes.AdminBackup).Methods("GET").Name("admin_backup")
+		adminAPI.HandleFunc("/search", httpRoutes.AdminSearch).Methods("GET").Name("admin_search")
+		adminAPI.HandleFunc("/replica", httpRoutes.AdminListReplica).Methods("GET").Name("admin_replica_list")
+		adminAPI.HandleFunc("/replica", httpRoutes.AdminSaveReplica).Methods("PUT").Name("admin_replica_save")
+		adminAPI.HandleFunc("/replica/{name:[0-9]+_[^/.]+}", httpRoutes.AdminDeleteReplica).Methods("DELETE").Name("admin_replica_delete")
+		dashboard := adminRouter.PathPrefix("/dashboard").Subrouter()
+		dashboard.Use(DashboardAuth(config.AdminToken))
+		dashboard.HandleFunc("", httpRoutes.Dashboard).Methods("GET").Name("dashboard")
+		dashboard.HandleFunc("/pastes/{counter:[0-9]+}/{action:delete|takedown|dismiss|ban}", httpRoutes.DashboardAction).Methods("POST").Name("dashboard_action")
+	}
+	router.HandleFunc("/", httpRoutes.Manpage).Methods("GET").Name("index")
+	router.HandleFunc("/openapi.json", httpRoutes.OpenAPI).Methods("GET").Name("openapi")
+	router.HandleFunc("/", httpRoutes.bans.Middleware(rateLimiter.Middleware(httpRoutes.CreatePaste))).Methods("POST").Name("create")
+	if config.Fetch {
+		router.HandleFunc("/fetch", httpRoutes.bans.Middleware(rateLimiter.Middleware(httpRoutes.FetchPaste))).Methods("POST").Name("fetch")
+	}
+	router.HandleFunc("/recent", compressor.Middleware(httpRoutes.RecentPastes)).Methods("GET").Name("recent")
+	router.HandleFunc("/stats", httpRoutes.Stats).Methods("GET").Name("instance_stats")
+	router.HandleFunc("/recent.{feed:rss|atom}", compressor.Middleware(httpRoutes.RecentPastes)).Methods("GET").Name("recent_feed")
+	router.HandleFunc(fmt.Sprintf("/diff/{a:%s}/{b:%s}", idPattern, idPattern), compressor.Middleware(httpRoutes.DiffPastes)).Methods("GET").Name("diff")
+	router.HandleFunc(fmt.Sprintf("/diff/{a:%s}/{b:%s}/{view:html|raw}", idPattern, idPattern), compressor.Middleware(httpRoutes.DiffPastes)).Methods("GET").Name("diff")
+	if config.Gist {
+		router.HandleFunc("/gist", httpRoutes.bans.Middleware(rateLimiter.Middleware(httpRoutes.ImportGist))).Methods("POST").Name("gist_import")
+		router.HandleFunc(fmt.Sprintf("/{hash:%s}/gist", idPattern), httpRoutes.ExportGist).Methods("POST").Name("gist_export")
+	}
+	if config.WebDAV {
+		webDAV := httpRoutes.WebDAV()
+		router.HandleFunc(DAVPrefix, webDAV).Methods("GET", "HEAD", "OPTIONS", "PROPFIND").Name("webdav")
+		router.PathPrefix(DAVPrefix+"/").HandlerFunc(webDAV).Methods("GET", "HEAD", "OPTIONS", "PROPFIND").Name("webdav")
 	}
-	http.Handle("/", router)
+	if config.Search {
+		router.HandleFunc("/search", compressor.Middleware(httpRoutes.SearchPastes)).Methods("GET").Name("search")
+	}
+	router.HandleFunc("/documents", httpRoutes.bans.Middleware(rateLimiter.Middleware(httpRoutes.HastebinCreate))).Methods("POST").Name("hastebin_create")
+	router.HandleFunc(fmt.Sprintf("/documents/{hash:%s}{ext:%s}", idPattern, ExtensionPattern), httpRoutes.HastebinDocument).Methods("GET").Name("hastebin_document")
+	router.HandleFunc(fmt.Sprintf("/raw/{hash:%s}{ext:%s}", idPattern, ExtensionPattern), compressor.Middleware(httpRoutes.HastebinRaw)).Methods("GET").Name("hastebin_raw")
+	router.HandleFunc("/api/api_post.php", httpRoutes.bans.Middleware(rateLimiter.Middleware(httpRoutes.PastebinPost))).Methods("POST").Name("pastebin_post")
+	graphQL, err := NewGraphQL(httpRoutes, rateLimiter)
+	if err != nil {
+		Fatal("failed to set up graphql", err)
+	}
+	router.HandleFunc("/graphql", compressor.Middleware(graphQL.Serve)).Methods("GET", "POST").Name("graphql")
+	router.HandleFunc("/uploads", httpRoutes.TusOptions).Methods("OPTIONS").Name("upload_options")
+	router.HandleFunc("/uploads", httpRoutes.bans.Middleware(rateLimiter.Middleware(httpRoutes.CreateUpload))).Methods("POST").Name("upload_create")
+	router.HandleFunc("/uploads/{id:[0-9a-f]{32}}", httpRoutes.TusOptions).Methods("OPTIONS").Name("upload_options")
+	router.HandleFunc("/uploads/{id:[0-9a-f]{32}}", httpRoutes.HeadUpload).Methods("HEAD").Name("upload_head")
+	router.HandleFunc("/uploads/{id:[0-9a-f]{32}}", httpRoutes.PatchUpload).Methods("PATCH").Name("upload_patch")
+	router.HandleFunc("/uploads/{id:[0-9a-f]{32}}", httpRoutes.DeleteUpload).Methods("DELETE").Name("upload_delete")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}{ext:%s}", idPattern, ExtensionPattern), compressor.Middleware(httpRoutes.RetrievePaste)).Methods("GET", "POST").Name("retrieve")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}{ext:%s}/{view:html|raw|md|ansi}", idPattern, ExtensionPattern), compressor.Middleware(httpRoutes.RetrievePaste)).Methods("GET", "POST").Name("retrieve")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}{ext:%s}/v/{revision:[0-9]+}", idPattern, ExtensionPattern), compressor.Middleware(httpRoutes.RetrievePaste)).Methods("GET", "POST").Name("retrieve_revision")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}{ext:%s}/v/{revision:[0-9]+}/{view:html|raw|md|ansi}", idPattern, ExtensionPattern), compressor.Middleware(httpRoutes.RetrievePaste)).Methods("GET", "POST").Name("retrieve_revision")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}{ext:%s}", idPattern, ExtensionPattern), httpRoutes.HeadPaste).Methods("HEAD").Name("head")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}{ext:%s}/{view:html|raw|md|ansi}", idPattern, ExtensionPattern), httpRoutes.HeadPaste).Methods("HEAD").Name("head")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}{ext:%s}/v/{revision:[0-9]+}", idPattern, ExtensionPattern), httpRoutes.HeadPaste).Methods("HEAD").Name("head")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}{ext:%s}/v/{revision:[0-9]+}/{view:html|raw|md|ansi}", idPattern, ExtensionPattern), httpRoutes.HeadPaste).Methods("HEAD").Name("head")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}{ext:%s}/meta", idPattern, ExtensionPattern), httpRoutes.PasteMetadata).Methods("GET").Name("meta")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}{ext:%s}/stats", idPattern, ExtensionPattern), httpRoutes.PasteStats).Methods("GET").Name("stats")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}/qr", idPattern), httpRoutes.PasteQR).Methods("GET").Name("qr")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}/qr.{format:png|svg|txt}", idPattern), httpRoutes.PasteQR).Methods("GET").Name("qr")
+	router.HandleFunc(fmt.Sprintf("/delete/{hash:%s}/{token:[0-9a-f]+}", idPattern), httpRoutes.DeletePaste).Methods("GET").Name("delete")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}{ext:%s}", idPattern, ExtensionPattern), httpRoutes.DeletePaste).Methods("DELETE").Name("delete")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}{ext:%s}", idPattern, ExtensionPattern), httpRoutes.bans.Middleware(rateLimiter.Middleware(httpRoutes.EditPaste))).Methods("PUT").Name("edit")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}/fork", idPattern), httpRoutes.bans.Middleware(rateLimiter.Middleware(httpRoutes.ForkPaste))).Methods("POST").Name("fork")
+	router.HandleFunc(fmt.Sprintf("/{hash:%s}/report", idPattern), httpRoutes.bans.Middleware(rateLimiter.Middleware(httpRoutes.ReportPaste))).Methods("POST").Name("report")
 
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Printf("server loop: %s\n", err)
+	var handler http.Handler = router
+	if cors := NewCORS(config); cors != nil {
+		handler = cors.Middleware(handler)
+	}
+	var adminHandler http.Handler = adminRouter
+	if security := NewSecurityHeaders(config); security != nil {
+		handler = security.Middleware(handler)
+		adminHandler = security.Middleware(adminRouter)
+	}
+	var onion *OnionService
+	if config.TorControl != "" {
+		if onion, err = NewOnionService(config, handler); err != nil {
+			Fatal("failed to set up onion service", err)
+		}
+		slog.Info("onion service published", "address", onion.Address)
+		handler = OnionLocation(onion.Address, handler)
+	}
+	servers, err := NewServers(config, handler, adminHandler)
+	if err != nil {
+		Fatal("failed to set up servers", err)
+	}
+	if onion != nil {
+		servers = append(servers, onion)
+	}
+	var tcpServer *TCPServer
+	if config.TCP {
+		if tcpServer, err = NewTCPServer(config, httpRoutes, rateLimiter); err != nil {
+			Fatal("failed to set up tcp listener", err)
+		}
+		go func() {
+			if err := tcpServer.Serve(); err != nil {
+				slog.Error("tcp listener", "error", err)
+			}
+		}()
+	}
+	var sshServer *SSHServer
+	if config.SSH {
+		if sshServer, err = NewSSHServer(config, httpRoutes, rateLimiter); err != nil {
+			Fatal("failed to set up ssh server", err)
+		}
+		go func() {
+			if err := sshServer.Serve(); err != nil {
+				slog.Error("ssh server", "error", err)
+			}
+		}()
+	}
+	var smtpServer *SMTPServer
+	if config.SMTP {
+		if smtpServer, err = NewSMTPServer(config, httpRoutes, rateLimiter); err != nil {
+			Fatal("failed to set up smtp listener", err)
+		}
+		go func() {
+			if err := smtpServer.Serve(); err != nil {
+				slog.Error("smtp listener", "error", err)
+			}
+		}()
+	}
+	var grpcServer *GRPCServer
+	if config.GRPC {
+		if grpcServer, err = NewGRPCServer(config, httpRoutes, rateLimiter, apiKeys); err != nil {
+			Fatal("failed to set up grpc server", err)
+		}
+		go func() {
+			if err := grpcServer.Serve(); err != nil {
+				slog.Error("grpc server", "error", err)
+			}
+		}()
+	}
+	var geminiServer *GeminiServer
+	if config.Gemini {
+		if geminiServer, err = NewGeminiServer(config, httpRoutes, idPattern); err != nil {
+			Fatal("failed to set up gemini server", err)
+		}
+		go func() {
+			if err := geminiServer.Serve(); err != nil {
+				slog.Error("gemini server", "error", err)
+			}
+		}()
+	}
+	slog.Info("starting", "listen", config.Listen, "storage", config.Storage)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go rateLimiter.Run(ctx)
+	go httpRoutes.uploads.Run(ctx)
+	if replicator != nil {
+		go replicator.Run(ctx)
+	}
+	if httpRoutes.notifications != nil {
+		go httpRoutes.notifications.Run(ctx)
+	}
+	if httpRoutes.peers != nil {
+		go httpRoutes.peers.Run(ctx)
+	}
+	if config.GCInterval > 0 {
+		go httpRoutes.RunGC(ctx)
+	}
+	if httpRoutes.ipQuota.Enabled() {
+		go httpRoutes.ipQuota.Run(ctx)
+	}
+	go httpRoutes.passwords.Run(ctx)
+	if httpRoutes.search != nil && httpRoutes.search.Empty() {
+		go httpRoutes.Reindex()
+	}
+	// Config is only read on startup, so this is when it changes
+	httpRoutes.auditLog.Record(&AuditEvent{Action: AuditStart, Actor: "system"})
+	if err := Serve(ctx, servers, config.ShutdownTimeout); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server loop", "error", err)
+	}
+	if tcpServer != nil {
+		tcpServer.Close()
+	}
+	if sshServer != nil {
+		if err := sshServer.Close(config.ShutdownTimeout); err != nil {
+			slog.Error("failed to stop ssh server", "error", err)
+		}
+	}
+	if smtpServer != nil {
+		if err := smtpServer.Close(config.ShutdownTimeout); err != nil {
+			slog.Error("failed to stop smtp listener", "error", err)
+		}
+	}
+	if grpcServer != nil {
+		grpcServer.Close(config.ShutdownTimeout)
+	}
+	if geminiServer != nil {
+		geminiServer.Close()
+	}
+	if err := storage.Close(); err != nil {
+		slog.Error("failed to close storage", "error", err)
+	}
+	if httpRoutes.search != nil {
+		if err := httpRoutes.search.Close(); err != nil {
+			slog.Error("failed to close search index", "error", err)
+		}
+	}
+	if err := rateLimiter.Close(); err != nil {
+		slog.Error("failed to close rate limiter", "error", err)
 	}
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Raw HTML is left out of rendered Markdown and dangerous links are dropped
+// unless goldmark is told otherwise, which it never is here.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// MarkdownCSP keeps rendered pastes from loading anything but images.
+const MarkdownCSP = "default-src 'none'; style-src 'unsafe-inline'; img-src * data:"
+
+const MarkdownHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Hash }} - paast</title>
+<style>
+body { margin: 0; font-family: sans-serif; font-size: 16px; line-height: 1.5; }
+header { padding: 8px 12px; border-bottom: 1px solid #ddd; background: #f6f8fa; font-family: monospace; font-size: 14px; }
+header a { color: #0366d6; margin-left: 12px; }
+article { margin: 0 auto; padding: 12px; max-width: 860px; word-wrap: break-word; }
+article a { color: #0366d6; }
+article img { max-width: 100%; }
+article pre { padding: 12px; overflow: auto; background: #f6f8fa; }
+article code { font-size: 14px; }
+article blockquote { margin: 0; padding: 0 12px; border-left: 4px solid #ddd; color: #586069; }
+article table { border-collapse: collapse; }
+article th, article td { padding: 4px 12px; border: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<header>{{ .Hash }}{{ .Ext }} (Markdown)<a href="/{{ .Hash }}{{ .Ext }}/html">source</a><a href="/{{ .Hash }}{{ .Ext }}/raw">raw</a></header>
+<article>
+{{ .Content }}
+</article>
+</body>
+</html>
+`
+
+var markdownTemplate = template.Must(template.New("markdown").Parse(MarkdownHTML))
+
+// IsMarkdown tells whether browsers should get rendered Markdown by default,
+// extension from URL wins over detected language.
+func IsMarkdown(meta *PasteMeta, ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".md", ".markdown":
+		return true
+	case "":
+		return meta.Language == "Markdown"
+	}
+	return false
+}
+
+// RenderMarkdown shows paste rendered from Markdown.
+func RenderMarkdown(rw http.ResponseWriter, hash string, ext string, content []byte) error {
+	var rendered bytes.Buffer
+	if err := markdown.Convert(content, &rendered); err != nil {
+		return fmt.Errorf("render markdown: %s", err)
+	}
+	var page bytes.Buffer
+	if err := markdownTemplate.Execute(&page, map[string]interface{}{
+		"Hash":    hash,
+		"Ext":     ext,
+		"Content": template.HTML(rendered.String()),
+	}); err != nil {
+		return fmt.Errorf("render markdown: %s", err)
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Content-Security-Policy", MarkdownCSP)
+	rw.WriteHeader(200)
+	rw.Write(page.Bytes())
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricPastesCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "paast_pastes_created_total",
+		Help: "Number of pastes created.",
+	})
+	metricBytesStored = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "paast_stored_bytes_total",
+		Help: "Total size of created pastes in bytes.",
+	})
+	metricStorageBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "paast_storage_bytes",
+		Help: "Size of stored pastes and their revisions in bytes, known with max-storage set or after garbage collection.",
+	})
+	metricGCDeleted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "paast_gc_deleted_pastes_total",
+		Help: "Number of expired pastes deleted by garbage collector.",
+	})
+	metricGCReclaimed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "paast_gc_reclaimed_bytes_total",
+		Help: "Total size of content deleted by garbage collector in bytes.",
+	})
+	metricReplicationPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "paast_replication_pending_pastes",
+		Help: "Number of pastes waiting to be sent to standby.",
+	})
+	metricRateLimited = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "paast_rate_limited_total",
+		Help: "Number of requests rejected by rate limiter.",
+	})
+	metricRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "paast_http_requests_total",
+		Help: "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	metricRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "paast_http_request_duration_seconds",
+		Help:    "HTTP request latency by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+)
+
+// RouteName returns name of matched route to be used as metric label.
+// Raw paths are never used since every paste ID would become a label value.
+func RouteName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil && route.GetName() != "" {
+		return route.GetName()
+	}
+	return "unknown"
+}
+
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		m := httpsnoop.CaptureMetrics(next, rw, r)
+		route := RouteName(r)
+		metricRequests.WithLabelValues(route, r.Method, fmt.Sprint(m.Code)).Inc()
+		metricRequestDuration.WithLabelValues(route).Observe(m.Duration.Seconds())
+	})
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// NotifyTimeout limits sending of one notification, including connecting to
+// IRC server.
+const NotifyTimeout = 10 * time.Second
+
+// NotifyQueueLen is how many notifications may wait to be sent, more are
+// dropped rather than delaying creation of pastes.
+const NotifyQueueLen = 100
+
+// Notifier announces new paste to chat.
+type Notifier interface {
+	Notify(text string) error
+}
+
+// Notifications announce new public pastes to chats in background, so that
+// teams using instance as a shared drop box get a feed of it.
+type Notifications struct {
+	notifiers map[string]Notifier
+	queue     chan string
+}
+
+// NewNotifications returns nil if no chat is configured.
+func NewNotifications(config *Config) *Notifications {
+	notifiers := map[string]Notifier{}
+	if config.NotifySlack != "" {
+		notifiers["slack"] = &SlackNotifier{url: config.NotifySlack, client: &http.Client{Timeout: NotifyTimeout}}
+	}
+	if config.NotifyMatrix != "" {
+		notifiers["matrix"] = &MatrixNotifier{
+			url:    strings.TrimSuffix(config.NotifyMatrix, "/") + "/_matrix/client/v3/rooms/" + url.PathEscape(config.NotifyMatrixRoom) + "/send/m.room.message/",
+			token:  config.NotifyMatrixToken,
+			client: &http.Client{Timeout: NotifyTimeout},
+		}
+	}
+	if config.NotifyIRC != "" {
+		// Validated along with config
+		parsed, _ := url.Parse(config.NotifyIRC)
+		notifiers["irc"] = &IRCNotifier{
+			addr:    parsed.Host,
+			tls:     parsed.Scheme == "ircs",
+			channel: "#" + strings.TrimPrefix(parsed.Path, "/"),
+			nick:    config.NotifyIRCNick,
+		}
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+	return &Notifications{notifiers: notifiers, queue: make(chan string, NotifyQueueLen)}
+}
+
+// Queue announces paste unless too many announcements are waiting.
+func (n *Notifications) Queue(paste *RecentPaste) {
+	text := "New paste " + paste.URL
+	if paste.Preview != "" || paste.Filename != "" {
+		text += ": " + paste.Title()
+	}
+	// Line breaks and formatting codes of IRC
+	text = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text))
+	select {
+	case n.queue <- text:
+	default:
+		slog.Warn("too many notifications pending, dropping", "paste", paste.ID)
+	}
+}
+
+// Run sends queued notifications until ctx is done. Failures are logged,
+// notifications aren't retried.
+func (n *Notifications) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case text := <-n.queue:
+			for name, notifier := range n.notifiers {
+				if err := notifier.Notify(text); err != nil {
+					slog.Warn("failed to send notification", "chat", name, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// SlackNotifier posts to incoming webhook of Slack, or of anything
+// compatible such as Mattermost.
+type SlackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func (sn *SlackNotifier) Notify(text string) error {
+	body, err := json.Marshal(map[string]string{"text": slackEscaper.Replace(text)})
+	if err != nil {
+		return err
+	}
+	res, err := sn.client.Post(sn.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("slack responded with %s", res.Status)
+	}
+	return nil
+}
+
+// MatrixNotifier sends notice to Matrix room as user whose access token it
+// has, the user must have joined the room.
+type MatrixNotifier struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (mn *MatrixNotifier) Notify(text string) error {
+	body, err := json.Marshal(map[string]string{"msgtype": "m.notice", "body": text})
+	if err != nil {
+		return err
+	}
+	// Transaction ID makes retries of the same message idempotent
+	req, err := http.NewRequest("PUT", mn.url+strconv.FormatInt(time.Now().UnixNano(), 10), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+mn.token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := mn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("matrix responded with %s", res.Status)
+	}
+	return nil
+}
+
+// IRCNotifier stays connected to IRC server once first notification is
+// sent, joined to channel, and connects again after connection is lost.
+type IRCNotifier struct {
+	addr    string
+	tls     bool
+	channel string
+	nick    string
+	mu      sync.Mutex
+	// Nil while disconnected
+	conn net.Conn
+}
+
+func (in *IRCNotifier) Notify(text string) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.conn == nil {
+		if err := in.connect(); err != nil {
+			return fmt.Errorf("connect to %s: %s", in.addr, err)
+		}
+	}
+	if err := in.send("PRIVMSG %s :%s", in.channel, text); err != nil {
+		in.conn.Close()
+		in.conn = nil
+		return err
+	}
+	return nil
+}
+
+// send writes command, caller holds the lock.
+func (in *IRCNotifier) send(format string, args ...any) error {
+	in.conn.SetWriteDeadline(time.Now().Add(NotifyTimeout))
+	_, err := fmt.Fprintf(in.conn, format+"\r\n", args...)
+	return err
+}
+
+// connect registers with server and joins channel, caller holds the lock.
+func (in *IRCNotifier) connect() error {
+	dialer := &net.Dialer{Timeout: NotifyTimeout}
+	var conn net.Conn
+	var err error
+	if in.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", in.addr, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", in.addr)
+	}
+	if err != nil {
+		return err
+	}
+	in.conn = conn
+	nick := in.nick
+	err = in.send("NICK %s", nick)
+	if err == nil {
+		err = in.send("USER %s 0 * :paast", in.nick)
+	}
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(NotifyTimeout))
+	for err == nil {
+		var line string
+		if line, err = reader.ReadString('\n'); err != nil {
+			break
+		}
+		command, params := parseIRCLine(line)
+		if command == "001" {
+			break
+		}
+		switch command {
+		case "PING":
+			err = in.send("PONG :%s", params)
+		case "433":
+			// Nick is taken, e.g. by connection not yet timed out
+			nick += "_"
+			err = in.send("NICK %s", nick)
+		case "ERROR":
+			err = fmt.Errorf("server closed connection: %s", params)
+		}
+	}
+	if err == nil {
+		err = in.send("JOIN %s", in.channel)
+	}
+	if err != nil {
+		conn.Close()
+		in.conn = nil
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+	go in.read(conn, reader)
+	return nil
+}
+
+// read answers pings of server until connection is lost.
+func (in *IRCNotifier) read(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		if command, params := parseIRCLine(line); command == "PING" {
+			in.mu.Lock()
+			if in.conn == conn {
+				err = in.send("PONG :%s", params)
+			}
+			in.mu.Unlock()
+			if err != nil {
+				break
+			}
+		}
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	conn.Close()
+	if in.conn == conn {
+		in.conn = nil
+	}
+}
+
+// parseIRCLine returns command of line and its last parameter, prefix is
+// dropped.
+func parseIRCLine(line string) (string, string) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	command, params, _ := strings.Cut(line, " ")
+	if _, trailing, ok := strings.Cut(params, ":"); ok {
+		params = trailing
+	}
+	return command, params
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Form fields which come along with file in uploads of 0x0.st (The Null
+// Pointer) style, curl -F file=@name -F secret= -F expires=24.
+const (
+	FileField        = "file"
+	FileFieldSecret  = "secret"
+	FileFieldExpires = "expires"
+)
+
+// FileExpiresEpoch separates expires given in hours from ones given as
+// milliseconds since epoch, the same way 0x0.st does.
+const FileExpiresEpoch = 1650460320000
+
+// FileForm holds fields sent along with file field of multipart body, it's
+// shared by every file of the body.
+type FileForm struct {
+	Secret  bool
+	Expires string
+}
+
+// FileRetention scales time file is kept by its size like 0x0.st does:
+// tiny files are kept for max age, ones at size limit for min age, with
+// cubic curve in between.
+func FileRetention(size int64, limit int64, minAge time.Duration, maxAge time.Duration) time.Duration {
+	ratio := min(float64(size)/float64(limit), 1)
+	return minAge + time.Duration(float64(minAge-maxAge)*math.Pow(ratio-1, 3))
+}
+
+// ParseFileExpires parses expires field, which is either number of hours or
+// milliseconds since epoch. Usual expiry like 7d is accepted too.
+func ParseFileExpires(value string, now time.Time) (time.Time, error) {
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		ttl, err := ParseExpiry(value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(ttl), nil
+	}
+	if number <= 0 {
+		return time.Time{}, fmt.Errorf("invalid expiry: %s", value)
+	}
+	if number < FileExpiresEpoch {
+		return now.Add(time.Duration(number) * time.Hour), nil
+	}
+	return time.UnixMilli(number), nil
+}
+
+// FileOptions applies fields of 0x0.st style upload to options of its
+// paste. Secret file becomes private paste, and file expires with time
+// scaled by its size unless asked to expire sooner.
+func (hr *HttpRoutes) FileOptions(r *http.Request, options *PasteMeta, upload *Upload) (*PasteMeta, error) {
+	meta := *options
+	if upload.Form.Secret {
+		if hr.config.URLSecret == "" {
+			return nil, errors.New("private pastes are not enabled on this server")
+		}
+		if meta.Visibility == VisibilityPublic {
+			return nil, errors.New("private paste cannot be public")
+		}
+		meta.Private = true
+	}
+	if expires := strings.TrimSpace(upload.Form.Expires); expires != "" {
+		expiry, err := ParseFileExpires(expires, meta.Created)
+		if err != nil {
+			return nil, err
+		}
+		if !expiry.After(meta.Created) {
+			return nil, fmt.Errorf("invalid expiry: %s", expires)
+		}
+		meta.Expires = &expiry
+	}
+	if hr.config.FileMaxAge > 0 {
+		retention := meta.Created.Add(FileRetention(upload.Size, MaxBodyLen(r, hr.config), hr.config.FileMinAge, hr.config.FileMaxAge))
+		if meta.Expires == nil || meta.Expires.After(retention) {
+			meta.Expires = &retention
+		}
+	}
+	return &meta, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// OnionRemoteAddr is remote address of requests coming through onion
+// service. Tor hides client addresses, so all of them share one rate limit
+// bucket with its own budget.
+const OnionRemoteAddr = "tor"
+
+// TorControlTimeout limits setting up onion service through control port.
+const TorControlTimeout = 30 * time.Second
+
+// OnionService publishes handler as onion service of local Tor through its
+// control port. Tor connects to private listener on loopback, and removes
+// the service once control connection is closed.
+type OnionService struct {
+	// Address is host name of the service, like <id>.onion
+	Address  string
+	server   *http.Server
+	listener net.Listener
+	control  *textproto.Conn
+}
+
+// torCommand sends command to control port and returns lines of its reply.
+func torCommand(control *textproto.Conn, format string, args ...any) ([]string, error) {
+	if err := control.PrintfLine(format, args...); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for {
+		line, err := control.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 4 {
+			return nil, fmt.Errorf("malformed reply: %s", line)
+		}
+		if !strings.HasPrefix(line, "250") {
+			return nil, errors.New(line)
+		}
+		lines = append(lines, line[4:])
+		if line[3] == ' ' {
+			return lines, nil
+		}
+	}
+}
+
+// torAuthenticate authenticates with password if it's given, otherwise with
+// cookie file or without credentials, whichever Tor allows.
+func torAuthenticate(control *textproto.Conn, password string) error {
+	if password != "" {
+		_, err := torCommand(control, "AUTHENTICATE \"%s\"", strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(password))
+		return err
+	}
+	lines, err := torCommand(control, "PROTOCOLINFO 1")
+	if err != nil {
+		return err
+	}
+	var methods, cookieFile string
+	for _, line := range lines {
+		if rest, ok := strings.CutPrefix(line, "AUTH METHODS="); ok {
+			methods, rest, _ = strings.Cut(rest, " ")
+			if _, file, ok := strings.Cut(rest, "COOKIEFILE="); ok {
+				cookieFile = strings.Trim(file, `"`)
+			}
+		}
+	}
+	for _, method := range strings.Split(methods, ",") {
+		switch method {
+		case "NULL":
+			_, err = torCommand(control, "AUTHENTICATE")
+			return err
+		case "COOKIE":
+			cookie, err := os.ReadFile(cookieFile)
+			if err != nil {
+				return err
+			}
+			_, err = torCommand(control, "AUTHENTICATE %s", hex.EncodeToString(cookie))
+			return err
+		}
+	}
+	return fmt.Errorf("no supported authentication method in %s, set tor-control-password", methods)
+}
+
+// NewOnionService adds onion service forwarding to handler. Its key is kept
+// in tor-key, so the address stays the same across restarts.
+func NewOnionService(config *Config, handler http.Handler) (*OnionService, error) {
+	keyPath := config.TorKey
+	if keyPath == "" {
+		keyPath = path.Join(config.DataDir, "tor_onion_key")
+	}
+	key := "NEW:ED25519-V3"
+	if data, err := os.ReadFile(keyPath); err == nil {
+		key = strings.TrimSpace(string(data))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("tor key: %s", err)
+	}
+
+	conn, err := net.DialTimeout("tcp", config.TorControl, TorControlTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("tor: %s", err)
+	}
+	conn.SetDeadline(time.Now().Add(TorControlTimeout))
+	control := textproto.NewConn(conn)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		control.Close()
+		return nil, fmt.Errorf("tor: %s", err)
+	}
+	svc := &OnionService{listener: listener, control: control}
+	if err = svc.add(config.TorControlPassword, key, keyPath); err != nil {
+		listener.Close()
+		control.Close()
+		return nil, fmt.Errorf("tor: %s", err)
+	}
+	conn.SetDeadline(time.Time{})
+	svc.server = &http.Server{Handler: OnionHandler(handler)}
+	return svc, nil
+}
+
+func (svc *OnionService) add(password string, key string, keyPath string) error {
+	if err := torAuthenticate(svc.control, password); err != nil {
+		return fmt.Errorf("authentication failed: %s", err)
+	}
+	lines, err := torCommand(svc.control, "ADD_ONION %s Port=80,%s", key, svc.listener.Addr())
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if id, ok := strings.CutPrefix(line, "ServiceID="); ok {
+			svc.Address = id + ".onion"
+		}
+		// Only returned for new key
+		if privateKey, ok := strings.CutPrefix(line, "PrivateKey="); ok {
+			if err = os.MkdirAll(path.Dir(keyPath), 0755); err == nil {
+				err = os.WriteFile(keyPath, []byte(privateKey+"\n"), 0600)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to save key: %s", err)
+			}
+			slog.Info("onion service key generated", "path", keyPath)
+		}
+	}
+	if svc.Address == "" {
+		return errors.New("no service ID in reply")
+	}
+	return nil
+}
+
+// OnionHandler marks requests as coming from Tor. Headers of proxies are
+// dropped, otherwise clients could pose as anyone outside of Tor.
+func OnionHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Forwarded-Scheme", "X-Real-Ip"} {
+			r.Header.Del(name)
+		}
+		r.RemoteAddr = OnionRemoteAddr
+		handler.ServeHTTP(rw, r)
+	})
+}
+
+// OnionLocation advertises onion service to Tor Browser visiting the site
+// over HTTPS.
+func OnionLocation(address string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Onion-Location", "http://"+address+r.URL.RequestURI())
+		handler.ServeHTTP(rw, r)
+	})
+}
+
+func (svc *OnionService) ListenAndServe() error {
+	return svc.server.Serve(svc.listener)
+}
+
+// Shutdown removes onion service and waits for in-flight requests until
+// ctx is done.
+func (svc *OnionService) Shutdown(ctx context.Context) error {
+	svc.control.Close()
+	return svc.server.Shutdown(ctx)
+}
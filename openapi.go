@@ -0,0 +1,1025 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const OpenAPIText = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "paast",
+    "description": "Create pastes with different methods",
+    "version": "1.0.0"
+  },
+  "servers": [{"url": "{BASE_URL}"}],
+  "paths": {
+    "/": {
+      "post": {
+        "summary": "Create paste",
+        "operationId": "createPaste",
+        "security": [{}, {"apiKey": []}],
+        "parameters": [
+          {"$ref": "#/components/parameters/format"},
+          {"name": "expire", "in": "query", "description": "Delete paste after given time, e.g. 30m, 12h or 7d", "schema": {"type": "string"}},
+          {"name": "X-Expire", "in": "header", "description": "Same as expire", "schema": {"type": "string"}},
+          {"name": "burn", "in": "query", "description": "Delete paste after it has been read once", "schema": {"type": "boolean"}},
+          {"name": "X-Burn", "in": "header", "description": "Same as burn", "schema": {"type": "boolean"}},
+          {"name": "encrypted", "in": "query", "description": "Content is encrypted by client: 12-byte IV followed by AES-256-GCM ciphertext with tag. Key is kept in URL fragment and never sent to server", "schema": {"type": "boolean"}},
+          {"name": "X-Encrypted", "in": "header", "description": "Same as encrypted", "schema": {"type": "boolean"}},
+          {"$ref": "#/components/parameters/password"},
+          {"name": "password", "in": "query", "description": "Same as X-Password", "schema": {"type": "string"}},
+          {"name": "private", "in": "query", "description": "Paste is only reachable by ID with signature returned on creation. Fails with 400 unless enabled on server", "schema": {"type": "boolean"}},
+          {"name": "X-Private", "in": "header", "description": "Same as private", "schema": {"type": "boolean"}},
+          {"name": "visibility", "in": "query", "description": "Public pastes show up in listings, feeds and search, unlisted ones are only reachable by URL", "schema": {"type": "string", "enum": ["public", "unlisted"], "default": "unlisted"}},
+          {"name": "X-Visibility", "in": "header", "description": "Same as visibility", "schema": {"type": "string", "enum": ["public", "unlisted"]}},
+          {"name": "tags", "in": "query", "description": "Comma-separated tags, up to 10", "schema": {"type": "string"}},
+          {"name": "X-Tags", "in": "header", "description": "Same as tags", "schema": {"type": "string"}},
+          {"name": "type", "in": "query", "description": "Paste which is a single http(s) URL redirects to it by default. Text prevents that, redirect fails with 400 unless paste is a URL", "schema": {"type": "string", "enum": ["text", "redirect"]}},
+          {"name": "X-Type", "in": "header", "description": "Same as type", "schema": {"type": "string", "enum": ["text", "redirect"]}},
+          {"name": "qr", "in": "query", "description": "Follow each URL in plain text response with its QR code drawn for terminal", "schema": {"type": "boolean"}}
+        ],
+        "requestBody": {
+          "required": true,
+          "description": "Paste content, up to {MAX_BODY_LEN} bytes unless API key allows more. Every non-empty part of multipart body becomes a separate paste, up to {MAX_PARTS} parts.",
+          "content": {
+            "application/octet-stream": {"schema": {"type": "string", "format": "binary"}},
+            "multipart/form-data": {"schema": {"type": "object", "description": "Files sent as file field expire 0x0.st style, after time scaled by size, which secret and expires fields apply to", "properties": {"file": {"type": "string", "format": "binary"}, "secret": {"type": "string", "description": "Make files private, value is ignored"}, "expires": {"type": "string", "description": "Expire files after this many hours, or at milliseconds since epoch, if sooner"}}, "additionalProperties": {"type": "string", "format": "binary"}}},
+            "application/x-www-form-urlencoded": {"schema": {"type": "object", "description": "sprunge and ix.io style form, every sprunge and f:N field becomes a paste. Other form bodies are stored as is", "properties": {"sprunge": {"type": "string"}, "f:1": {"type": "string"}}}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "Paste created",
+            "headers": {
+              "X-Delete-Url": {"description": "Secret URL which deletes the paste, repeated for every paste of multipart body", "schema": {"type": "string"}},
+              "X-Delete-Token": {"description": "Secret token which deletes the paste, repeated for every paste of multipart body", "schema": {"type": "string"}},
+              "X-Edit-Token": {"description": "Secret token which allows replacing paste content, repeated for every paste of multipart body", "schema": {"type": "string"}},
+              "X-Duplicate": {"description": "Set to true if existing paste with identical content was returned instead, no tokens are sent then. With several pastes it is repeated for each and their tokens are empty", "schema": {"type": "boolean"}}
+            },
+            "content": {
+              "text/plain": {"schema": {"type": "string", "description": "Paste URL, one per line for multipart body"}},
+              "application/json": {"schema": {"oneOf": [
+                {"$ref": "#/components/schemas/PasteInfo"},
+                {"type": "array", "description": "Multipart body with several files", "items": {"$ref": "#/components/schemas/PasteInfo"}}
+              ]}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "451": {"description": "Content is blocked by operator, reason is stated in error message"},
+          "503": {"description": "Content could not be scanned for viruses, retry later"},
+          "507": {"description": "Server is out of storage, retry later"},
+          "429": {
+            "description": "Too many pastes, wait before retrying",
+            "headers": {
+              "Retry-After": {"description": "Seconds to wait", "schema": {"type": "integer"}}
+            },
+            "content": {
+              "text/plain": {"schema": {"type": "string"}},
+              "application/json": {"schema": {"$ref": "#/components/schemas/Error"}}
+            }
+          },
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/fetch": {
+      "post": {
+        "summary": "Create paste from remote URL",
+        "description": "Only available if enabled by the operator. Private addresses are not fetched.",
+        "operationId": "fetchPaste",
+        "security": [{}, {"apiKey": []}],
+        "parameters": [
+          {"$ref": "#/components/parameters/format"},
+          {"name": "url", "in": "query", "description": "URL to fetch, may be sent as form field instead", "schema": {"type": "string"}},
+          {"name": "expire", "in": "query", "description": "Delete paste after given time, e.g. 30m, 12h or 7d", "schema": {"type": "string"}},
+          {"name": "burn", "in": "query", "description": "Delete paste after it has been read once", "schema": {"type": "boolean"}},
+          {"name": "private", "in": "query", "description": "Paste is only reachable by ID with signature returned on creation", "schema": {"type": "boolean"}},
+          {"name": "visibility", "in": "query", "description": "Public pastes show up in listings, feeds and search", "schema": {"type": "string", "enum": ["public", "unlisted"], "default": "unlisted"}},
+          {"name": "tags", "in": "query", "description": "Comma-separated tags, up to 10", "schema": {"type": "string"}}
+        ],
+        "requestBody": {
+          "content": {
+            "application/x-www-form-urlencoded": {"schema": {"type": "object", "properties": {"url": {"type": "string"}}}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "Paste created, remote content is limited to {MAX_BODY_LEN} bytes unless API key allows more",
+            "headers": {
+              "X-Delete-Url": {"schema": {"type": "string"}},
+              "X-Delete-Token": {"schema": {"type": "string"}},
+              "X-Edit-Token": {"schema": {"type": "string"}}
+            },
+            "content": {
+              "text/plain": {"schema": {"type": "string", "description": "Paste URL"}},
+              "application/json": {"schema": {"$ref": "#/components/schemas/PasteInfo"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"},
+          "504": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/gist": {
+      "post": {
+        "summary": "Import GitHub gist",
+        "description": "Only available if enabled by the operator. Every file of gist becomes a paste.",
+        "operationId": "importGist",
+        "security": [{}, {"apiKey": []}],
+        "parameters": [
+          {"$ref": "#/components/parameters/format"},
+          {"name": "id", "in": "query", "description": "Gist ID or URL, may be sent as form field instead", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/githubToken"},
+          {"name": "expire", "in": "query", "description": "Delete paste after given time, e.g. 30m, 12h or 7d", "schema": {"type": "string"}},
+          {"name": "burn", "in": "query", "description": "Delete paste after it has been read once", "schema": {"type": "boolean"}},
+          {"name": "private", "in": "query", "description": "Paste is only reachable by ID with signature returned on creation", "schema": {"type": "boolean"}},
+          {"name": "visibility", "in": "query", "description": "Public pastes show up in listings, feeds and search", "schema": {"type": "string", "enum": ["public", "unlisted"], "default": "unlisted"}},
+          {"name": "tags", "in": "query", "description": "Comma-separated tags, up to 10", "schema": {"type": "string"}}
+        ],
+        "requestBody": {
+          "content": {
+            "application/x-www-form-urlencoded": {"schema": {"type": "object", "properties": {"id": {"type": "string"}}}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "Pastes created",
+            "content": {
+              "text/plain": {"schema": {"type": "string", "description": "Paste URL, one per line"}},
+              "application/json": {"schema": {"oneOf": [
+                {"$ref": "#/components/schemas/PasteInfo"},
+                {"type": "array", "items": {"$ref": "#/components/schemas/PasteInfo"}}
+              ]}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/documents": {
+      "post": {
+        "summary": "Create paste, hastebin-compatible",
+        "description": "Same as POST /documents of haste-server, for existing hastebin clients. Query options of regular upload are accepted too.",
+        "operationId": "hastebinCreate",
+        "security": [{}, {"apiKey": []}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "text/plain": {"schema": {"type": "string"}},
+            "application/octet-stream": {"schema": {"type": "string", "format": "binary"}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "Paste created",
+            "content": {
+              "application/json": {"schema": {"type": "object", "properties": {"key": {"type": "string", "description": "Paste ID"}}}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/HastebinError"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/documents/{id}": {
+      "get": {
+        "summary": "Get paste, hastebin-compatible",
+        "description": "Same as GET /documents/{id} of haste-server. Encrypted and binary pastes can only be read raw.",
+        "operationId": "hastebinDocument",
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"$ref": "#/components/parameters/password"}
+        ],
+        "responses": {
+          "200": {
+            "description": "Paste content",
+            "content": {
+              "application/json": {"schema": {"type": "object", "properties": {"key": {"type": "string"}, "data": {"type": "string"}}}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/HastebinError"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/HastebinError"}
+        }
+      }
+    },
+    "/api/api_post.php": {
+      "post": {
+        "summary": "Create paste, pastebin.com-compatible",
+        "description": "Takes the form of pastebin.com API, only api_option=paste is supported and api_dev_key is ignored.",
+        "operationId": "pastebinPost",
+        "security": [{}, {"apiKey": []}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/x-www-form-urlencoded": {"schema": {"type": "object", "required": ["api_option", "api_paste_code"], "properties": {
+              "api_option": {"type": "string", "enum": ["paste"]},
+              "api_dev_key": {"type": "string", "description": "Ignored"},
+              "api_paste_code": {"type": "string", "description": "Paste content"},
+              "api_paste_name": {"type": "string", "description": "Paste file name"},
+              "api_paste_format": {"type": "string", "description": "Language, e.g. python or bash"},
+              "api_paste_expire_date": {"type": "string", "enum": ["N", "10M", "1H", "1D", "1W", "2W", "1M", "6M", "1Y"]},
+              "api_paste_private": {"type": "string", "description": "0 is public, 1 unlisted, 2 private", "enum": ["0", "1", "2"]}
+            }}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "Paste created",
+            "headers": {
+              "X-Delete-Url": {"schema": {"type": "string"}},
+              "X-Delete-Token": {"schema": {"type": "string"}},
+              "X-Edit-Token": {"schema": {"type": "string"}}
+            },
+            "content": {"text/plain": {"schema": {"type": "string", "description": "Paste URL"}}}
+          },
+          "400": {"$ref": "#/components/responses/PastebinError"},
+          "413": {"$ref": "#/components/responses/PastebinError"},
+          "429": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/graphql": {
+      "get": {
+        "summary": "Get GraphQL schema",
+        "operationId": "graphqlSchema",
+        "responses": {
+          "200": {"description": "Schema in GraphQL SDL", "content": {"text/plain": {"schema": {"type": "string"}}}}
+        }
+      },
+      "post": {
+        "summary": "Run GraphQL query or mutation",
+        "description": "Queries read paste metadata and content, mutations create and delete pastes with the same options and limits as the rest of API. Content of burn paste is deleted once read. Errors of the query are returned in errors of response with status 200.",
+        "operationId": "graphql",
+        "security": [{}, {"apiKey": []}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {"schema": {"type": "object", "required": ["query"], "properties": {
+              "query": {"type": "string"},
+              "operationName": {"type": "string"},
+              "variables": {"type": "object"}
+            }}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "Result of query",
+            "content": {"application/json": {"schema": {"type": "object", "properties": {
+              "data": {"type": "object"},
+              "errors": {"type": "array", "items": {"type": "object", "properties": {"message": {"type": "string"}}}}
+            }}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "415": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/raw/{id}": {
+      "get": {
+        "summary": "Get raw paste, hastebin-compatible",
+        "description": "Same as /{id}/raw, extension may follow ID.",
+        "operationId": "hastebinRaw",
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"$ref": "#/components/parameters/password"},
+          {"$ref": "#/components/parameters/lines"}
+        ],
+        "responses": {
+          "200": {
+            "description": "Paste content",
+            "content": {"*/*": {"schema": {"type": "string", "format": "binary"}}}
+          },
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/recent": {
+      "get": {
+        "summary": "Recent public pastes",
+        "description": "Latest public pastes, newest first. Browsers get HTML page, other clients get tab-separated URL, size and preview per line.",
+        "operationId": "recentPastes",
+        "parameters": [
+          {"$ref": "#/components/parameters/format"},
+          {"name": "tag", "in": "query", "description": "Only list pastes with this tag, may be repeated", "schema": {"type": "array", "items": {"type": "string"}}, "explode": true}
+        ],
+        "responses": {
+          "200": {
+            "description": "Up to 50 pastes",
+            "content": {
+              "text/plain": {"schema": {"type": "string"}},
+              "text/html": {"schema": {"type": "string"}},
+              "application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/RecentPaste"}}}
+            }
+          }
+        }
+      }
+    },
+    "/recent.{feed}": {
+      "get": {
+        "summary": "Feed of recent public pastes",
+        "operationId": "recentFeed",
+        "parameters": [
+          {"name": "feed", "in": "path", "required": true, "schema": {"type": "string", "enum": ["rss", "atom"]}},
+          {"name": "tag", "in": "query", "description": "Only list pastes with this tag, may be repeated", "schema": {"type": "array", "items": {"type": "string"}}, "explode": true}
+        ],
+        "responses": {
+          "200": {
+            "description": "RSS 2.0 or Atom feed",
+            "content": {
+              "application/rss+xml": {"schema": {"type": "string"}},
+              "application/atom+xml": {"schema": {"type": "string"}}
+            }
+          }
+        }
+      }
+    },
+    "/stats": {
+      "get": {
+        "summary": "Instance statistics",
+        "description": "Total number and size of pastes which haven't expired, pastes created within the last 24 hours and uptime. Counted at most once a minute.",
+        "operationId": "instanceStats",
+        "parameters": [
+          {"$ref": "#/components/parameters/format"}
+        ],
+        "responses": {
+          "200": {
+            "description": "Instance statistics",
+            "content": {
+              "text/plain": {"schema": {"type": "string"}},
+              "application/json": {"schema": {"$ref": "#/components/schemas/InstanceStats"}}
+            }
+          },
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/diff/{a}/{b}": {
+      "get": {
+        "summary": "Compare two pastes",
+        "description": "Browsers get side-by-side HTML, other clients get unified diff. Also available with /raw and /html suffixes. Only text pastes up to 1 MiB which are not burn, encrypted or protected can be compared.",
+        "operationId": "diffPastes",
+        "parameters": [
+          {"name": "a", "in": "path", "required": true, "description": "ID of original paste", "schema": {"type": "string"}},
+          {"name": "b", "in": "path", "required": true, "description": "ID of changed paste", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Changes between pastes",
+            "content": {
+              "text/x-diff": {"schema": {"type": "string"}},
+              "text/html": {"schema": {"type": "string"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/search": {
+      "get": {
+        "summary": "Search public pastes",
+        "description": "Only available if enabled by the operator. Results are listed like recent pastes, best match first.",
+        "operationId": "searchPastes",
+        "parameters": [
+          {"$ref": "#/components/parameters/format"},
+          {"name": "q", "in": "query", "required": true, "description": "Query in bleve query string syntax: words, \"phrases\", +required and -excluded terms", "schema": {"type": "string"}},
+          {"name": "tag", "in": "query", "description": "Only list pastes with this tag, may be repeated", "schema": {"type": "array", "items": {"type": "string"}}, "explode": true}
+        ],
+        "responses": {
+          "200": {
+            "description": "Up to 50 pastes",
+            "content": {
+              "text/plain": {"schema": {"type": "string"}},
+              "text/html": {"schema": {"type": "string"}},
+              "application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/RecentPaste"}}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/uploads": {
+      "options": {
+        "summary": "Resumable upload capabilities",
+        "description": "Resumable uploads follow tus protocol 1.0.0, see https://tus.io/protocols/resumable-upload",
+        "operationId": "uploadOptions",
+        "responses": {
+          "204": {
+            "description": "Supported protocol version and extensions",
+            "headers": {
+              "Tus-Version": {"schema": {"type": "string"}},
+              "Tus-Extension": {"schema": {"type": "string"}},
+              "Tus-Max-Size": {"description": "Maximum upload size in bytes", "schema": {"type": "integer"}}
+            }
+          }
+        }
+      },
+      "post": {
+        "summary": "Start resumable upload",
+        "operationId": "createUpload",
+        "security": [{}, {"apiKey": []}],
+        "parameters": [
+          {"$ref": "#/components/parameters/tusResumable"},
+          {"name": "Upload-Length", "in": "header", "required": true, "description": "Paste size, up to {MAX_BODY_LEN} bytes unless API key allows more", "schema": {"type": "integer"}},
+          {"name": "Upload-Metadata", "in": "header", "description": "Comma-separated keys with base64-encoded values: expire, burn, private, visibility, tags, filename, filetype", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "201": {
+            "description": "Upload created",
+            "headers": {
+              "Location": {"description": "Upload URL", "schema": {"type": "string"}},
+              "Upload-Expires": {"schema": {"type": "string"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "412": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/uploads/{upload}": {
+      "parameters": [
+        {"name": "upload", "in": "path", "required": true, "schema": {"type": "string"}},
+        {"$ref": "#/components/parameters/tusResumable"}
+      ],
+      "head": {
+        "summary": "Resumable upload offset",
+        "operationId": "uploadOffset",
+        "responses": {
+          "200": {
+            "description": "Upload state",
+            "headers": {
+              "Upload-Offset": {"schema": {"type": "integer"}},
+              "Upload-Length": {"schema": {"type": "integer"}},
+              "X-Paste-Url": {"description": "Set once upload is complete", "schema": {"type": "string"}}
+            }
+          },
+          "404": {"description": "Upload not found or expired"}
+        }
+      },
+      "patch": {
+        "summary": "Upload chunk",
+        "operationId": "uploadChunk",
+        "parameters": [
+          {"name": "Upload-Offset", "in": "header", "required": true, "description": "Must match offset upload is at", "schema": {"type": "integer"}}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/offset+octet-stream": {"schema": {"type": "string", "format": "binary"}}}
+        },
+        "responses": {
+          "204": {
+            "description": "Chunk stored. Paste is created with the last chunk",
+            "headers": {
+              "Upload-Offset": {"schema": {"type": "integer"}},
+              "X-Paste-Url": {"description": "Set once upload is complete", "schema": {"type": "string"}},
+              "X-Delete-Url": {"schema": {"type": "string"}},
+              "X-Delete-Token": {"schema": {"type": "string"}},
+              "X-Edit-Token": {"schema": {"type": "string"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "415": {"$ref": "#/components/responses/Error"},
+          "423": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Cancel resumable upload",
+        "operationId": "deleteUpload",
+        "responses": {
+          "204": {"description": "Upload removed"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "423": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}": {
+      "get": {
+        "summary": "Retrieve paste",
+        "description": "ID may be followed by file extension, e.g. /abc.go, which sets Content-Type of raw paste and highlighting language of HTML view. Browsers get HTML view, or rendered Markdown for Markdown pastes. Responses carry ETag and Last-Modified, If-None-Match and If-Modified-Since are honored.",
+        "operationId": "retrievePaste",
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"$ref": "#/components/parameters/format"},
+          {"$ref": "#/components/parameters/password"},
+          {"$ref": "#/components/parameters/lines"}
+        ],
+        "responses": {
+          "302": {"description": "Paste is a short link, Location header holds its URL. Views with suffix, revisions, lines and JSON are not redirected"},
+          "304": {"description": "Cached copy is still valid"},
+          "200": {
+            "description": "Paste content",
+            "headers": {
+              "X-Encrypted": {"description": "Set to true if paste is encrypted by client, server cannot read it", "schema": {"type": "boolean"}}
+            },
+            "content": {
+              "text/plain": {"schema": {"type": "string"}},
+              "text/html": {"schema": {"type": "string"}},
+              "application/json": {"schema": {"$ref": "#/components/schemas/PasteInfo"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"description": "Paste is protected, password is required"},
+          "403": {"description": "Invalid password"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "429": {"description": "Too many invalid passwords, try again later"},
+          "451": {"description": "Paste was taken down by operator, reason is stated in error message"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "summary": "Replace paste content",
+        "operationId": "editPaste",
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"$ref": "#/components/parameters/format"},
+          {"name": "X-Edit-Token", "in": "header", "description": "Token returned on creation", "schema": {"type": "string"}},
+          {"name": "token", "in": "query", "description": "Same as X-Edit-Token", "schema": {"type": "string"}}
+        ],
+        "requestBody": {
+          "required": true,
+          "description": "New paste content, same as on creation",
+          "content": {
+            "application/octet-stream": {"schema": {"type": "string", "format": "binary"}},
+            "multipart/form-data": {"schema": {"type": "object", "additionalProperties": {"type": "string", "format": "binary"}}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "Paste updated",
+            "content": {
+              "text/plain": {"schema": {"type": "string", "description": "Paste URL"}},
+              "application/json": {"schema": {"$ref": "#/components/schemas/PasteInfo"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "451": {"description": "Paste was taken down or content is blocked by operator"},
+          "503": {"description": "Content could not be scanned for viruses, retry later"},
+          "507": {"description": "Server is out of storage, retry later"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "head": {
+        "summary": "Check paste",
+        "description": "Same headers as GET, including Content-Length of raw paste. Doesn't burn paste or count as a view.",
+        "operationId": "headPaste",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "responses": {
+          "200": {"description": "Paste exists"},
+          "404": {"description": "Paste not found"}
+        }
+      },
+      "delete": {
+        "summary": "Delete paste with token",
+        "operationId": "deletePasteWithToken",
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"name": "X-Delete-Token", "in": "header", "description": "Token returned on creation", "schema": {"type": "string"}},
+          {"name": "token", "in": "query", "description": "Same as X-Delete-Token", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "204": {"description": "Paste deleted"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}/raw": {
+      "get": {
+        "summary": "Retrieve raw paste",
+        "description": "Supports Range requests.",
+        "operationId": "retrieveRawPaste",
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"$ref": "#/components/parameters/lines"},
+          {"name": "strip-ansi", "in": "query", "description": "Remove terminal escape sequences, e.g. colors, from content", "schema": {"type": "boolean"}}
+        ],
+        "responses": {
+          "200": {"description": "Paste content", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "206": {"description": "Requested range of paste content", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "416": {"description": "Requested range is not satisfiable"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}/html": {
+      "get": {
+        "summary": "Retrieve syntax-highlighted paste",
+        "description": "Lines are numbered and linkable, #L120-L180 fragment highlights range of them.",
+        "operationId": "retrieveHTMLPaste",
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"$ref": "#/components/parameters/lines"}
+        ],
+        "responses": {
+          "200": {"description": "Paste content", "content": {"text/html": {"schema": {"type": "string"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}/ansi": {
+      "get": {
+        "summary": "Retrieve terminal output with its colors",
+        "description": "ANSI color sequences are converted to styled HTML, others are dropped. Browsers get this view by default for pastes containing color sequences unless file extension is given.",
+        "operationId": "retrieveANSIPaste",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "responses": {
+          "200": {"description": "Rendered paste", "content": {"text/html": {"schema": {"type": "string"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}/md": {
+      "get": {
+        "summary": "Retrieve paste rendered from Markdown",
+        "description": "Raw HTML is left out of rendered page. Browsers get this view by default for Markdown pastes. Encrypted and binary pastes get HTML view instead.",
+        "operationId": "retrieveMarkdownPaste",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "responses": {
+          "200": {"description": "Rendered paste", "content": {"text/html": {"schema": {"type": "string"}}}},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}/v/{revision}": {
+      "get": {
+        "summary": "Retrieve paste revision",
+        "description": "Revisions are numbered from 1, the last one is current content. Also available with /raw, /html, /md and /ansi suffixes.",
+        "operationId": "retrieveRevision",
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"name": "revision", "in": "path", "required": true, "schema": {"type": "integer", "minimum": 1}},
+          {"$ref": "#/components/parameters/format"}
+        ],
+        "responses": {
+          "200": {
+            "description": "Revision content",
+            "content": {
+              "text/plain": {"schema": {"type": "string"}},
+              "text/html": {"schema": {"type": "string"}},
+              "application/json": {"schema": {"$ref": "#/components/schemas/PasteInfo"}}
+            }
+          },
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}/meta": {
+      "get": {
+        "summary": "Paste metadata",
+        "description": "Everything about the paste but its content. File extension after ID affects content_type.",
+        "operationId": "pasteMetadata",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "responses": {
+          "200": {
+            "description": "Paste metadata",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/PasteMetaInfo"}}
+            }
+          },
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}/stats": {
+      "get": {
+        "summary": "Paste view statistics",
+        "operationId": "pasteStats",
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"$ref": "#/components/parameters/format"}
+        ],
+        "responses": {
+          "200": {
+            "description": "View statistics",
+            "content": {
+              "text/plain": {"schema": {"type": "string"}},
+              "application/json": {"schema": {"$ref": "#/components/schemas/PasteStats"}}
+            }
+          },
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}/qr": {
+      "get": {
+        "summary": "QR code of paste URL",
+        "description": "PNG by default, also available as /qr.png, /qr.svg and /qr.txt, the latter drawn with Unicode blocks for terminal.",
+        "operationId": "pasteQR",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "responses": {
+          "200": {
+            "description": "QR code",
+            "content": {
+              "image/png": {"schema": {"type": "string", "format": "binary"}},
+              "image/svg+xml": {"schema": {"type": "string"}},
+              "text/plain": {"schema": {"type": "string"}}
+            }
+          },
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}/gist": {
+      "post": {
+        "summary": "Export paste to GitHub gist",
+        "description": "Only available if enabled by the operator. Burn and encrypted pastes can't be exported.",
+        "operationId": "exportGist",
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"$ref": "#/components/parameters/format"},
+          {"$ref": "#/components/parameters/password"},
+          {"$ref": "#/components/parameters/githubToken"},
+          {"name": "public", "in": "query", "description": "Create public gist instead of secret one", "schema": {"type": "boolean"}},
+          {"name": "description", "in": "query", "description": "Gist description, paste URL by default", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Gist created",
+            "content": {
+              "text/plain": {"schema": {"type": "string", "description": "Gist URL"}},
+              "application/json": {"schema": {"type": "object", "properties": {"id": {"type": "string"}, "url": {"type": "string"}}}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}/fork": {
+      "post": {
+        "summary": "Fork paste",
+        "description": "Creates a new paste from existing one, or from request body if it's not empty, and records ID of the original as parent. File name, content type and tags are inherited unless given. Burn pastes can't be forked, forks of encrypted pastes are encrypted too. Password opens protected paste and protects the fork.",
+        "operationId": "forkPaste",
+        "security": [{}, {"apiKey": []}],
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"$ref": "#/components/parameters/format"},
+          {"$ref": "#/components/parameters/password"},
+          {"name": "expire", "in": "query", "description": "Delete paste after given time, e.g. 30m, 12h or 7d", "schema": {"type": "string"}},
+          {"name": "burn", "in": "query", "description": "Delete paste after it has been read once", "schema": {"type": "boolean"}},
+          {"name": "private", "in": "query", "description": "Paste is only reachable by ID with signature returned on creation", "schema": {"type": "boolean"}},
+          {"name": "visibility", "in": "query", "description": "Public pastes show up in listings, feeds and search", "schema": {"type": "string", "enum": ["public", "unlisted"], "default": "unlisted"}},
+          {"name": "tags", "in": "query", "description": "Comma-separated tags, up to 10", "schema": {"type": "string"}}
+        ],
+        "requestBody": {
+          "description": "Replacement content, optional",
+          "content": {
+            "application/octet-stream": {"schema": {"type": "string", "format": "binary"}},
+            "multipart/form-data": {"schema": {"type": "object", "additionalProperties": {"type": "string", "format": "binary"}}}
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "Paste created",
+            "headers": {
+              "X-Delete-Url": {"schema": {"type": "string"}},
+              "X-Delete-Token": {"schema": {"type": "string"}},
+              "X-Edit-Token": {"schema": {"type": "string"}}
+            },
+            "content": {
+              "text/plain": {"schema": {"type": "string", "description": "Paste URL"}},
+              "application/json": {"schema": {"$ref": "#/components/schemas/PasteInfo"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"description": "Paste is protected, password is required"},
+          "403": {"description": "Invalid password"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/{id}/report": {
+      "post": {
+        "summary": "Report paste",
+        "description": "Flags paste as illegal or abusive for operator to review. One report per address is kept, repeated reports are accepted but ignored.",
+        "operationId": "reportPaste",
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"$ref": "#/components/parameters/format"},
+          {"name": "reason", "in": "query", "description": "What's wrong with the paste, up to 1000 characters", "schema": {"type": "string"}}
+        ],
+        "requestBody": {
+          "description": "Reason, unless given in query",
+          "content": {
+            "application/x-www-form-urlencoded": {"schema": {"type": "object", "properties": {"reason": {"type": "string"}}}},
+            "application/json": {"schema": {"type": "object", "properties": {"reason": {"type": "string"}}}}
+          }
+        },
+        "responses": {
+          "202": {"description": "Paste reported"},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/delete/{id}/{token}": {
+      "get": {
+        "summary": "Delete paste",
+        "description": "This is the URL returned in X-Delete-Url header on creation.",
+        "operationId": "deletePaste",
+        "parameters": [
+          {"$ref": "#/components/parameters/id"},
+          {"name": "token", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/format"}
+        ],
+        "responses": {
+          "200": {"description": "Paste deleted", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "apiKey": {"type": "http", "scheme": "bearer", "description": "Optional API key issued by operator, grants custom limits"}
+    },
+    "parameters": {
+      "id": {"name": "id", "in": "path", "required": true, "description": "Paste ID, followed by dash and signature for private pastes", "schema": {"type": "string"}},
+      "githubToken": {"name": "X-GitHub-Token", "in": "header", "description": "GitHub token, only passed to GitHub and never stored", "schema": {"type": "string"}},
+      "lines": {"name": "lines", "in": "query", "description": "Only return these lines of text paste, e.g. 120-180, 120- or 120. Numbering starts from 1", "schema": {"type": "string"}},
+      "format": {"name": "format", "in": "query", "description": "Set to json for JSON response, same as Accept: application/json", "schema": {"type": "string", "enum": ["json"]}},
+      "password": {"name": "X-Password", "in": "header", "description": "Password of protected paste, set on creation and required to read or edit it", "schema": {"type": "string"}},
+      "tusResumable": {"name": "Tus-Resumable", "in": "header", "required": true, "schema": {"type": "string", "enum": ["1.0.0"]}}
+    },
+    "responses": {
+      "Error": {
+        "description": "Error",
+        "content": {
+          "text/plain": {"schema": {"type": "string"}},
+          "application/json": {"schema": {"$ref": "#/components/schemas/Error"}}
+        }
+      },
+      "HastebinError": {
+        "description": "Error in haste-server format",
+        "content": {
+          "application/json": {"schema": {"type": "object", "properties": {"message": {"type": "string"}}}}
+        }
+      },
+      "PastebinError": {
+        "description": "Error in pastebin.com format, starting with \"Bad API request\"",
+        "content": {
+          "text/plain": {"schema": {"type": "string"}}
+        }
+      }
+    },
+    "schemas": {
+      "PasteInfo": {
+        "type": "object",
+        "required": ["id", "url", "raw_url", "bytes", "created"],
+        "properties": {
+          "id": {"type": "string"},
+          "url": {"type": "string"},
+          "raw_url": {"type": "string"},
+          "delete_url": {"type": "string", "description": "Only returned on creation"},
+          "delete_token": {"type": "string", "description": "Only returned on creation"},
+          "edit_token": {"type": "string", "description": "Only returned on creation"},
+          "bytes": {"type": "integer"},
+          "created": {"type": "string", "format": "date-time"},
+          "updated": {"type": "string", "format": "date-time", "description": "Set if paste has been edited"},
+          "expires": {"type": "string", "format": "date-time"},
+          "burn": {"type": "boolean"},
+          "duplicate": {"type": "boolean", "description": "Set on creation if existing paste with identical content was returned"},
+          "warnings": {"type": "array", "items": {"type": "string"}, "description": "Set on creation or edit if content seems to contain secrets, also sent in X-Warning headers"},
+          "filename": {"type": "string", "description": "Name of uploaded file paste was created from"},
+          "encrypted": {"type": "boolean", "description": "Set if content is encrypted by client, server cannot read it"},
+          "protected": {"type": "boolean", "description": "Set if password is required to read content"},
+          "private": {"type": "boolean", "description": "Set if paste is only reachable by signed ID"},
+          "visibility": {"type": "string", "enum": ["public", "unlisted"]},
+          "tags": {"type": "array", "items": {"type": "string"}},
+          "language": {"type": "string", "description": "Language detected on creation, e.g. Go"},
+          "extension": {"type": "string", "description": "Usual file extension of detected language, e.g. .go"},
+          "parent": {"type": "string", "description": "ID of paste this one was forked from"},
+          "redirect": {"type": "string", "description": "URL paste redirects to"},
+          "revision": {"type": "integer", "description": "Set when specific revision was requested"},
+          "revisions": {
+            "type": "array",
+            "description": "Prior versions of edited paste, oldest first",
+            "items": {
+              "type": "object",
+              "properties": {
+                "revision": {"type": "integer"},
+                "url": {"type": "string"},
+                "bytes": {"type": "integer"},
+                "created": {"type": "string", "format": "date-time"}
+              }
+            }
+          },
+          "content": {"type": "string", "description": "Only returned on retrieval"},
+          "encoding": {"type": "string", "enum": ["base64"], "description": "Set if content is not valid UTF-8"}
+        }
+      },
+      "PasteMetaInfo": {
+        "allOf": [
+          {"$ref": "#/components/schemas/PasteInfo"},
+          {
+            "type": "object",
+            "required": ["lines", "content_type", "sha256"],
+            "properties": {
+              "lines": {"type": "integer"},
+              "content_type": {"type": "string"},
+              "sha256": {"type": "string", "description": "Hex-encoded SHA-256 of content"}
+            }
+          }
+        ]
+      },
+      "RecentPaste": {
+        "allOf": [
+          {"$ref": "#/components/schemas/PasteInfo"},
+          {
+            "type": "object",
+            "properties": {
+              "preview": {"type": "string", "description": "First non-blank line of text paste, missing for binary, encrypted and protected pastes"}
+            }
+          }
+        ]
+      },
+      "PasteStats": {
+        "type": "object",
+        "required": ["views"],
+        "properties": {
+          "views": {"type": "integer"},
+          "last_viewed": {"type": "string", "format": "date-time"}
+        }
+      },
+      "InstanceStats": {
+        "type": "object",
+        "required": ["pastes", "bytes", "pastes_24h", "started", "uptime_seconds", "counted"],
+        "properties": {
+          "pastes": {"type": "integer"},
+          "bytes": {"type": "integer", "description": "Size of pastes including their revisions"},
+          "pastes_24h": {"type": "integer"},
+          "started": {"type": "string", "format": "date-time"},
+          "uptime_seconds": {"type": "integer"},
+          "counted": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["error"],
+        "properties": {
+          "error": {"type": "string"}
+        }
+      }
+    }
+  }
+}
+`
+
+func (hr *HttpRoutes) OpenAPI(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(200)
+	rw.Write([]byte(strings.NewReplacer(
+		"{BASE_URL}", BaseURL(r),
+		"{MAX_BODY_LEN}", fmt.Sprint(hr.config.MaxBodyLen),
+		"{MAX_PARTS}", fmt.Sprint(hr.config.MaxParts),
+	).Replace(OpenAPIText)))
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// PasswordOverhead is the size of nonce and authentication tag stored along
+// with content of password-protected paste.
+const PasswordOverhead = 12 + 16
+
+// Argon2id parameters for new pastes, as recommended by OWASP.
+const (
+	PasswordTime   = 2
+	PasswordMemory = 19 * 1024
+)
+
+var ErrInvalidPassword = errors.New("invalid password")
+
+// ErrPasswordAttempts is returned once client has run out of failed password
+// attempts on paste.
+var ErrPasswordAttempts = errors.New("too many invalid passwords")
+
+// PasswordClientsMax limits number of client and paste pairs failed attempts
+// are counted for.
+const PasswordClientsMax = 100000
+
+// PasswordKDF describes how content key of password-protected paste is
+// derived from password. Parameters are stored, so they can be raised
+// without breaking existing pastes.
+type PasswordKDF struct {
+	Salt   string `json:"salt"`
+	Time   uint32 `json:"time"`
+	Memory uint32 `json:"memory"`
+}
+
+func NewPasswordKDF() (*PasswordKDF, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("new password: %s", err)
+	}
+	return &PasswordKDF{Salt: hex.EncodeToString(salt), Time: PasswordTime, Memory: PasswordMemory}, nil
+}
+
+// Key derives AES-256 key from password with Argon2id.
+func (kdf *PasswordKDF) Key(password string) []byte {
+	salt, _ := hex.DecodeString(kdf.Salt)
+	return argon2.IDKey([]byte(password), salt, kdf.Time, kdf.Memory, 1, 32)
+}
+
+func contentCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptContent returns random nonce followed by AES-GCM ciphertext.
+func EncryptContent(key []byte, content []byte) ([]byte, error) {
+	aead, err := contentCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt content: %s", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("encrypt content: %s", err)
+	}
+	return aead.Seal(nonce, nonce, content, nil), nil
+}
+
+// DecryptContent reverses EncryptContent, wrong key gives ErrInvalidPassword.
+func DecryptContent(key []byte, content []byte) ([]byte, error) {
+	aead, err := contentCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt content: %s", err)
+	}
+	if len(content) < aead.NonceSize() {
+		return nil, ErrInvalidPassword
+	}
+	plain, err := aead.Open(nil, content[:aead.NonceSize()], content[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrInvalidPassword
+	}
+	return plain, nil
+}
+
+// Encrypt replaces spooled content with its ciphertext.
+func (u *Upload) Encrypt(key []byte) error {
+	content, err := io.ReadAll(u.Spool)
+	if err != nil {
+		return fmt.Errorf("encrypt upload: %s", err)
+	}
+	if content, err = EncryptContent(key, content); err != nil {
+		return err
+	}
+	spool, err := SpoolPaste(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("encrypt upload: %s", err)
+	}
+	u.Spool.Close()
+	u.Spool = spool
+	return nil
+}
+
+// passwordFailures counts failed attempts since the first of them.
+type passwordFailures struct {
+	count int
+	since time.Time
+}
+
+// PasswordGuard keeps protected pastes from being guessed: client gets
+// password-tries failed attempts per paste within password-lockout, and
+// at most password-workers keys are derived at once, as each derivation
+// takes PasswordMemory KiB.
+type PasswordGuard struct {
+	tries      int
+	lockout    time.Duration
+	ipv6Prefix int
+	workers    chan struct{}
+	mu         sync.Mutex
+	failures   map[string]*passwordFailures
+}
+
+func NewPasswordGuard(config *Config) *PasswordGuard {
+	return &PasswordGuard{
+		tries:      config.PasswordTries,
+		lockout:    config.PasswordLockout,
+		ipv6Prefix: config.IPv6Prefix,
+		workers:    make(chan struct{}, config.PasswordWorkers),
+		failures:   map[string]*passwordFailures{},
+	}
+}
+
+// Key derives key of kdf from password, waiting for a free worker.
+func (pg *PasswordGuard) Key(kdf *PasswordKDF, password string) []byte {
+	pg.workers <- struct{}{}
+	defer func() { <-pg.workers }()
+	return kdf.Key(password)
+}
+
+// Unlock decrypts content of protected paste with password sent by request's
+// client, and returns key along with it. Error wraps ErrPasswordAttempts and
+// tells when to try again if client has failed too many times.
+func (pg *PasswordGuard) Unlock(r *http.Request, hash string, kdf *PasswordKDF, password string, content []byte) ([]byte, []byte, error) {
+	client := ClientKey(RemoteIP(r), pg.ipv6Prefix) + " " + hash
+	if wait := pg.locked(client, time.Now()); wait > 0 {
+		return nil, nil, fmt.Errorf("%w, try again in %s", ErrPasswordAttempts, wait.Round(time.Second))
+	}
+	key := pg.Key(kdf, password)
+	plain, err := DecryptContent(key, content)
+	if err != nil {
+		if errors.Is(err, ErrInvalidPassword) {
+			pg.fail(client, time.Now())
+		}
+		return nil, nil, err
+	}
+	return plain, key, nil
+}
+
+// locked returns time until client may try again, zero if it may now.
+func (pg *PasswordGuard) locked(client string, now time.Time) time.Duration {
+	if pg.tries <= 0 {
+		return 0
+	}
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	failures, ok := pg.failures[client]
+	if !ok || failures.count < pg.tries {
+		return 0
+	}
+	return max(failures.since.Add(pg.lockout).Sub(now), 0)
+}
+
+func (pg *PasswordGuard) fail(client string, now time.Time) {
+	if pg.tries <= 0 {
+		return
+	}
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	failures, ok := pg.failures[client]
+	if ok && !now.Before(failures.since.Add(pg.lockout)) {
+		ok = false
+	}
+	if !ok {
+		if len(pg.failures) >= PasswordClientsMax {
+			pg.prune(now)
+			// Arbitrary clients make room if none is due
+			for cached := range pg.failures {
+				if len(pg.failures) < PasswordClientsMax {
+					break
+				}
+				delete(pg.failures, cached)
+			}
+		}
+		failures = &passwordFailures{since: now}
+		pg.failures[client] = failures
+	}
+	failures.count++
+}
+
+// prune forgets clients whose window has passed, caller must hold lock.
+func (pg *PasswordGuard) prune(now time.Time) {
+	for client, failures := range pg.failures {
+		if !now.Before(failures.since.Add(pg.lockout)) {
+			delete(pg.failures, client)
+		}
+	}
+}
+
+// Run periodically forgets clients whose window has passed until ctx is
+// done.
+func (pg *PasswordGuard) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			pg.mu.Lock()
+			pg.prune(now)
+			pg.mu.Unlock()
+		}
+	}
+}
+
+// PastePassword returns password sent to open protected paste: header is
+// preferred since query string ends up in logs, form field is sent by the
+// HTML prompt.
+func PastePassword(r *http.Request) string {
+	if password := r.Header.Get("X-Password"); password != "" {
+		return password
+	}
+	if password := r.URL.Query().Get("password"); password != "" {
+		return password
+	}
+	if r.Method == "POST" {
+		return r.PostFormValue("password")
+	}
+	return ""
+}
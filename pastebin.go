@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/go-enry/go-enry/v2"
+)
+
+// PastebinExpiry maps api_paste_expire_date of pastebin.com to expiry.
+var PastebinExpiry = map[string]string{
+	"N":   "",
+	"10M": "10m",
+	"1H":  "1h",
+	"1D":  "1d",
+	"1W":  "7d",
+	"2W":  "14d",
+	"1M":  "30d",
+	"6M":  "182d",
+	"1Y":  "365d",
+}
+
+// PastebinError responds the way pastebin.com does, with message in plain
+// text starting with "Bad API request".
+func PastebinError(rw http.ResponseWriter, code int, msg string) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.WriteHeader(code)
+	rw.Write([]byte("Bad API request, " + msg))
+}
+
+// PastebinFilename names paste after api_paste_name, with extension of
+// language given in api_paste_format so that it's highlighted as such.
+func PastebinFilename(name string, format string) string {
+	language, ok := enry.GetLanguageByAlias(format)
+	if !ok || path.Ext(name) != "" {
+		return name
+	}
+	if name == "" {
+		name = "paste"
+	}
+	return name + LanguageExtension(language)
+}
+
+// PastebinPost creates paste from form of api_post.php of pastebin.com, so
+// that tools made for it can be pointed at paast. Developer key is not
+// checked, api_paste_private of 0 makes paste public, 1 unlisted and 2
+// private.
+func (hr *HttpRoutes) PastebinPost(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	// Form encoding takes up to three bytes per byte of paste
+	limit := MaxBodyLen(r, hr.config)
+	r.Body = http.MaxBytesReader(rw, r.Body, limit*3+64<<10)
+	if err := r.ParseMultipartForm(limit); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		if strings.HasSuffix(err.Error(), "http: request body too large") {
+			PastebinError(rw, 413, "maximum paste file size exceeded")
+			return
+		}
+		PastebinError(rw, 400, "invalid form")
+		return
+	}
+	if option := r.PostFormValue("api_option"); option != "paste" {
+		PastebinError(rw, 400, "invalid api_option")
+		return
+	}
+	code := r.PostFormValue("api_paste_code")
+	if code == "" {
+		PastebinError(rw, 400, "api_paste_code was empty")
+		return
+	}
+	if int64(len(code)) > limit {
+		PastebinError(rw, 413, "maximum paste file size exceeded")
+		return
+	}
+	expire, ok := PastebinExpiry[r.PostFormValue("api_paste_expire_date")]
+	if !ok && r.PostFormValue("api_paste_expire_date") != "" {
+		PastebinError(rw, 400, "invalid api_expire_date")
+		return
+	}
+	var visibility, private string
+	switch r.PostFormValue("api_paste_private") {
+	case "0":
+		visibility = VisibilityPublic
+	case "", "1":
+	case "2":
+		private = "true"
+	default:
+		PastebinError(rw, 400, "invalid api_paste_private")
+		return
+	}
+
+	options, err := hr.PasteOptions(func(name string) string {
+		switch name {
+		case "expire":
+			return expire
+		case "visibility":
+			return visibility
+		case "private":
+			return private
+		}
+		return RequestOptions(r)(name)
+	})
+	if err != nil {
+		PastebinError(rw, 400, err.Error())
+		return
+	}
+	spool, err := SpoolPaste(strings.NewReader(code))
+	if err != nil {
+		panic(err)
+	}
+	upload := &Upload{Spool: spool, Filename: PastebinFilename(r.PostFormValue("api_paste_name"), r.PostFormValue("api_paste_format"))}
+	defer upload.Close()
+	upload.DetectContentType()
+	if err = hr.CheckContent(r, upload); err != nil {
+		PastebinError(rw, ContentStatus(err), err.Error())
+		return
+	}
+	if err = CheckRedirect(options, false, []*Upload{upload}); err != nil {
+		PastebinError(rw, 400, err.Error())
+		return
+	}
+
+	info := hr.createPaste(r, options, upload)
+	if !info.Duplicate {
+		rw.Header().Set("X-Delete-Url", info.DeleteURL)
+		rw.Header().Set("X-Delete-Token", info.DeleteToken)
+		rw.Header().Set("X-Edit-Token", info.EditToken)
+	}
+	// pastebin.com responds with bare URL
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.WriteHeader(200)
+	rw.Write([]byte(info.URL))
+}
@@ -0,0 +1,4 @@
+// Package pastepb holds gRPC service of paast generated from paast.proto.
+package pastepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative paast.proto
@@ -0,0 +1,795 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v29.3.0
+// source: paast.proto
+
+package pastepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// PasteOptions are the same as options of HTTP API.
+type PasteOptions struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Expiry like 1h or 7d, paste never expires if empty
+	Expire  string `protobuf:"bytes,1,opt,name=expire,proto3" json:"expire,omitempty"`
+	Burn    bool   `protobuf:"varint,2,opt,name=burn,proto3" json:"burn,omitempty"`
+	Private bool   `protobuf:"varint,3,opt,name=private,proto3" json:"private,omitempty"`
+	// Either public or unlisted, unlisted by default
+	Visibility string   `protobuf:"bytes,4,opt,name=visibility,proto3" json:"visibility,omitempty"`
+	Tags       []string `protobuf:"bytes,5,rep,name=tags,proto3" json:"tags,omitempty"`
+	// Either text or redirect, detected from content if empty
+	Type        string `protobuf:"bytes,6,opt,name=type,proto3" json:"type,omitempty"`
+	Filename    string `protobuf:"bytes,7,opt,name=filename,proto3" json:"filename,omitempty"`
+	ContentType string `protobuf:"bytes,8,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	// Content is encrypted by client and unreadable by server
+	Encrypted bool `protobuf:"varint,9,opt,name=encrypted,proto3" json:"encrypted,omitempty"`
+	// Password required to read paste
+	Password      string `protobuf:"bytes,10,opt,name=password,proto3" json:"password,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PasteOptions) Reset() {
+	*x = PasteOptions{}
+	mi := &file_paast_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PasteOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PasteOptions) ProtoMessage() {}
+
+func (x *PasteOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_paast_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PasteOptions.ProtoReflect.Descriptor instead.
+func (*PasteOptions) Descriptor() ([]byte, []int) {
+	return file_paast_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PasteOptions) GetExpire() string {
+	if x != nil {
+		return x.Expire
+	}
+	return ""
+}
+
+func (x *PasteOptions) GetBurn() bool {
+	if x != nil {
+		return x.Burn
+	}
+	return false
+}
+
+func (x *PasteOptions) GetPrivate() bool {
+	if x != nil {
+		return x.Private
+	}
+	return false
+}
+
+func (x *PasteOptions) GetVisibility() string {
+	if x != nil {
+		return x.Visibility
+	}
+	return ""
+}
+
+func (x *PasteOptions) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *PasteOptions) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *PasteOptions) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *PasteOptions) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *PasteOptions) GetEncrypted() bool {
+	if x != nil {
+		return x.Encrypted
+	}
+	return false
+}
+
+func (x *PasteOptions) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+type CreatePasteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Options       *PasteOptions          `protobuf:"bytes,1,opt,name=options,proto3" json:"options,omitempty"`
+	Content       []byte                 `protobuf:"bytes,2,opt,name=content,proto3" json:"content,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreatePasteRequest) Reset() {
+	*x = CreatePasteRequest{}
+	mi := &file_paast_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreatePasteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreatePasteRequest) ProtoMessage() {}
+
+func (x *CreatePasteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_paast_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreatePasteRequest.ProtoReflect.Descriptor instead.
+func (*CreatePasteRequest) Descriptor() ([]byte, []int) {
+	return file_paast_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CreatePasteRequest) GetOptions() *PasteOptions {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+func (x *CreatePasteRequest) GetContent() []byte {
+	if x != nil {
+		return x.Content
+	}
+	return nil
+}
+
+type UploadPasteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Options       *PasteOptions          `protobuf:"bytes,1,opt,name=options,proto3" json:"options,omitempty"`
+	Chunk         []byte                 `protobuf:"bytes,2,opt,name=chunk,proto3" json:"chunk,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadPasteRequest) Reset() {
+	*x = UploadPasteRequest{}
+	mi := &file_paast_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadPasteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadPasteRequest) ProtoMessage() {}
+
+func (x *UploadPasteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_paast_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadPasteRequest.ProtoReflect.Descriptor instead.
+func (*UploadPasteRequest) Descriptor() ([]byte, []int) {
+	return file_paast_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *UploadPasteRequest) GetOptions() *PasteOptions {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+func (x *UploadPasteRequest) GetChunk() []byte {
+	if x != nil {
+		return x.Chunk
+	}
+	return nil
+}
+
+type GetPasteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Password      string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPasteRequest) Reset() {
+	*x = GetPasteRequest{}
+	mi := &file_paast_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPasteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPasteRequest) ProtoMessage() {}
+
+func (x *GetPasteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_paast_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPasteRequest.ProtoReflect.Descriptor instead.
+func (*GetPasteRequest) Descriptor() ([]byte, []int) {
+	return file_paast_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetPasteRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *GetPasteRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+type GetPasteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Paste         *Paste                 `protobuf:"bytes,1,opt,name=paste,proto3" json:"paste,omitempty"`
+	Content       []byte                 `protobuf:"bytes,2,opt,name=content,proto3" json:"content,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPasteResponse) Reset() {
+	*x = GetPasteResponse{}
+	mi := &file_paast_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPasteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPasteResponse) ProtoMessage() {}
+
+func (x *GetPasteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_paast_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPasteResponse.ProtoReflect.Descriptor instead.
+func (*GetPasteResponse) Descriptor() ([]byte, []int) {
+	return file_paast_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetPasteResponse) GetPaste() *Paste {
+	if x != nil {
+		return x.Paste
+	}
+	return nil
+}
+
+func (x *GetPasteResponse) GetContent() []byte {
+	if x != nil {
+		return x.Content
+	}
+	return nil
+}
+
+type DeletePasteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Token         string                 `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeletePasteRequest) Reset() {
+	*x = DeletePasteRequest{}
+	mi := &file_paast_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeletePasteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeletePasteRequest) ProtoMessage() {}
+
+func (x *DeletePasteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_paast_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeletePasteRequest.ProtoReflect.Descriptor instead.
+func (*DeletePasteRequest) Descriptor() ([]byte, []int) {
+	return file_paast_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeletePasteRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DeletePasteRequest) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+type DeletePasteResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeletePasteResponse) Reset() {
+	*x = DeletePasteResponse{}
+	mi := &file_paast_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeletePasteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeletePasteResponse) ProtoMessage() {}
+
+func (x *DeletePasteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_paast_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeletePasteResponse.ProtoReflect.Descriptor instead.
+func (*DeletePasteResponse) Descriptor() ([]byte, []int) {
+	return file_paast_proto_rawDescGZIP(), []int{6}
+}
+
+// Paste mirrors paste info of JSON API. Tokens are only set for pastes
+// which were just created.
+type Paste struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Id          string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Url         string                 `protobuf:"bytes,2,opt,name=url,proto3" json:"url,omitempty"`
+	RawUrl      string                 `protobuf:"bytes,3,opt,name=raw_url,json=rawUrl,proto3" json:"raw_url,omitempty"`
+	DeleteUrl   string                 `protobuf:"bytes,4,opt,name=delete_url,json=deleteUrl,proto3" json:"delete_url,omitempty"`
+	DeleteToken string                 `protobuf:"bytes,5,opt,name=delete_token,json=deleteToken,proto3" json:"delete_token,omitempty"`
+	EditToken   string                 `protobuf:"bytes,6,opt,name=edit_token,json=editToken,proto3" json:"edit_token,omitempty"`
+	Bytes       int64                  `protobuf:"varint,7,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	Created     *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=created,proto3" json:"created,omitempty"`
+	Updated     *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=updated,proto3" json:"updated,omitempty"`
+	Expires     *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=expires,proto3" json:"expires,omitempty"`
+	Burn        bool                   `protobuf:"varint,11,opt,name=burn,proto3" json:"burn,omitempty"`
+	Private     bool                   `protobuf:"varint,12,opt,name=private,proto3" json:"private,omitempty"`
+	Visibility  string                 `protobuf:"bytes,13,opt,name=visibility,proto3" json:"visibility,omitempty"`
+	Tags        []string               `protobuf:"bytes,14,rep,name=tags,proto3" json:"tags,omitempty"`
+	Language    string                 `protobuf:"bytes,15,opt,name=language,proto3" json:"language,omitempty"`
+	Extension   string                 `protobuf:"bytes,16,opt,name=extension,proto3" json:"extension,omitempty"`
+	// Existing paste with the same content was returned
+	Duplicate     bool   `protobuf:"varint,17,opt,name=duplicate,proto3" json:"duplicate,omitempty"`
+	Filename      string `protobuf:"bytes,18,opt,name=filename,proto3" json:"filename,omitempty"`
+	Encrypted     bool   `protobuf:"varint,19,opt,name=encrypted,proto3" json:"encrypted,omitempty"`
+	Protected     bool   `protobuf:"varint,20,opt,name=protected,proto3" json:"protected,omitempty"`
+	Parent        string `protobuf:"bytes,21,opt,name=parent,proto3" json:"parent,omitempty"`
+	Redirect      string `protobuf:"bytes,22,opt,name=redirect,proto3" json:"redirect,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Paste) Reset() {
+	*x = Paste{}
+	mi := &file_paast_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Paste) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Paste) ProtoMessage() {}
+
+func (x *Paste) ProtoReflect() protoreflect.Message {
+	mi := &file_paast_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Paste.ProtoReflect.Descriptor instead.
+func (*Paste) Descriptor() ([]byte, []int) {
+	return file_paast_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Paste) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Paste) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Paste) GetRawUrl() string {
+	if x != nil {
+		return x.RawUrl
+	}
+	return ""
+}
+
+func (x *Paste) GetDeleteUrl() string {
+	if x != nil {
+		return x.DeleteUrl
+	}
+	return ""
+}
+
+func (x *Paste) GetDeleteToken() string {
+	if x != nil {
+		return x.DeleteToken
+	}
+	return ""
+}
+
+func (x *Paste) GetEditToken() string {
+	if x != nil {
+		return x.EditToken
+	}
+	return ""
+}
+
+func (x *Paste) GetBytes() int64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+func (x *Paste) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *Paste) GetUpdated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Updated
+	}
+	return nil
+}
+
+func (x *Paste) GetExpires() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Expires
+	}
+	return nil
+}
+
+func (x *Paste) GetBurn() bool {
+	if x != nil {
+		return x.Burn
+	}
+	return false
+}
+
+func (x *Paste) GetPrivate() bool {
+	if x != nil {
+		return x.Private
+	}
+	return false
+}
+
+func (x *Paste) GetVisibility() string {
+	if x != nil {
+		return x.Visibility
+	}
+	return ""
+}
+
+func (x *Paste) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Paste) GetLanguage() string {
+	if x != nil {
+		return x.Language
+	}
+	return ""
+}
+
+func (x *Paste) GetExtension() string {
+	if x != nil {
+		return x.Extension
+	}
+	return ""
+}
+
+func (x *Paste) GetDuplicate() bool {
+	if x != nil {
+		return x.Duplicate
+	}
+	return false
+}
+
+func (x *Paste) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *Paste) GetEncrypted() bool {
+	if x != nil {
+		return x.Encrypted
+	}
+	return false
+}
+
+func (x *Paste) GetProtected() bool {
+	if x != nil {
+		return x.Protected
+	}
+	return false
+}
+
+func (x *Paste) GetParent() string {
+	if x != nil {
+		return x.Parent
+	}
+	return ""
+}
+
+func (x *Paste) GetRedirect() string {
+	if x != nil {
+		return x.Redirect
+	}
+	return ""
+}
+
+var File_paast_proto protoreflect.FileDescriptor
+
+const file_paast_proto_rawDesc = "" +
+	"\n" +
+	"\vpaast.proto\x12\bpaast.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x95\x02\n" +
+	"\fPasteOptions\x12\x16\n" +
+	"\x06expire\x18\x01 \x01(\tR\x06expire\x12\x12\n" +
+	"\x04burn\x18\x02 \x01(\bR\x04burn\x12\x18\n" +
+	"\aprivate\x18\x03 \x01(\bR\aprivate\x12\x1e\n" +
+	"\n" +
+	"visibility\x18\x04 \x01(\tR\n" +
+	"visibility\x12\x12\n" +
+	"\x04tags\x18\x05 \x03(\tR\x04tags\x12\x12\n" +
+	"\x04type\x18\x06 \x01(\tR\x04type\x12\x1a\n" +
+	"\bfilename\x18\a \x01(\tR\bfilename\x12!\n" +
+	"\fcontent_type\x18\b \x01(\tR\vcontentType\x12\x1c\n" +
+	"\tencrypted\x18\t \x01(\bR\tencrypted\x12\x1a\n" +
+	"\bpassword\x18\n" +
+	" \x01(\tR\bpassword\"`\n" +
+	"\x12CreatePasteRequest\x120\n" +
+	"\aoptions\x18\x01 \x01(\v2\x16.paast.v1.PasteOptionsR\aoptions\x12\x18\n" +
+	"\acontent\x18\x02 \x01(\fR\acontent\"\\\n" +
+	"\x12UploadPasteRequest\x120\n" +
+	"\aoptions\x18\x01 \x01(\v2\x16.paast.v1.PasteOptionsR\aoptions\x12\x14\n" +
+	"\x05chunk\x18\x02 \x01(\fR\x05chunk\"=\n" +
+	"\x0fGetPasteRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1a\n" +
+	"\bpassword\x18\x02 \x01(\tR\bpassword\"S\n" +
+	"\x10GetPasteResponse\x12%\n" +
+	"\x05paste\x18\x01 \x01(\v2\x0f.paast.v1.PasteR\x05paste\x12\x18\n" +
+	"\acontent\x18\x02 \x01(\fR\acontent\":\n" +
+	"\x12DeletePasteRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05token\x18\x02 \x01(\tR\x05token\"\x15\n" +
+	"\x13DeletePasteResponse\"\xa1\x05\n" +
+	"\x05Paste\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x10\n" +
+	"\x03url\x18\x02 \x01(\tR\x03url\x12\x17\n" +
+	"\araw_url\x18\x03 \x01(\tR\x06rawUrl\x12\x1d\n" +
+	"\n" +
+	"delete_url\x18\x04 \x01(\tR\tdeleteUrl\x12!\n" +
+	"\fdelete_token\x18\x05 \x01(\tR\vdeleteToken\x12\x1d\n" +
+	"\n" +
+	"edit_token\x18\x06 \x01(\tR\teditToken\x12\x14\n" +
+	"\x05bytes\x18\a \x01(\x03R\x05bytes\x124\n" +
+	"\acreated\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\acreated\x124\n" +
+	"\aupdated\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\aupdated\x124\n" +
+	"\aexpires\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\aexpires\x12\x12\n" +
+	"\x04burn\x18\v \x01(\bR\x04burn\x12\x18\n" +
+	"\aprivate\x18\f \x01(\bR\aprivate\x12\x1e\n" +
+	"\n" +
+	"visibility\x18\r \x01(\tR\n" +
+	"visibility\x12\x12\n" +
+	"\x04tags\x18\x0e \x03(\tR\x04tags\x12\x1a\n" +
+	"\blanguage\x18\x0f \x01(\tR\blanguage\x12\x1c\n" +
+	"\textension\x18\x10 \x01(\tR\textension\x12\x1c\n" +
+	"\tduplicate\x18\x11 \x01(\bR\tduplicate\x12\x1a\n" +
+	"\bfilename\x18\x12 \x01(\tR\bfilename\x12\x1c\n" +
+	"\tencrypted\x18\x13 \x01(\bR\tencrypted\x12\x1c\n" +
+	"\tprotected\x18\x14 \x01(\bR\tprotected\x12\x16\n" +
+	"\x06parent\x18\x15 \x01(\tR\x06parent\x12\x1a\n" +
+	"\bredirect\x18\x16 \x01(\tR\bredirect2\x95\x02\n" +
+	"\x06Pastes\x12<\n" +
+	"\vCreatePaste\x12\x1c.paast.v1.CreatePasteRequest\x1a\x0f.paast.v1.Paste\x12>\n" +
+	"\vUploadPaste\x12\x1c.paast.v1.UploadPasteRequest\x1a\x0f.paast.v1.Paste(\x01\x12A\n" +
+	"\bGetPaste\x12\x19.paast.v1.GetPasteRequest\x1a\x1a.paast.v1.GetPasteResponse\x12J\n" +
+	"\vDeletePaste\x12\x1c.paast.v1.DeletePasteRequest\x1a\x1d.paast.v1.DeletePasteResponseB#Z!github.com/and3rson/paast/pastepbb\x06proto3"
+
+var (
+	file_paast_proto_rawDescOnce sync.Once
+	file_paast_proto_rawDescData []byte
+)
+
+func file_paast_proto_rawDescGZIP() []byte {
+	file_paast_proto_rawDescOnce.Do(func() {
+		file_paast_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_paast_proto_rawDesc), len(file_paast_proto_rawDesc)))
+	})
+	return file_paast_proto_rawDescData
+}
+
+var file_paast_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_paast_proto_goTypes = []any{
+	(*PasteOptions)(nil),          // 0: paast.v1.PasteOptions
+	(*CreatePasteRequest)(nil),    // 1: paast.v1.CreatePasteRequest
+	(*UploadPasteRequest)(nil),    // 2: paast.v1.UploadPasteRequest
+	(*GetPasteRequest)(nil),       // 3: paast.v1.GetPasteRequest
+	(*GetPasteResponse)(nil),      // 4: paast.v1.GetPasteResponse
+	(*DeletePasteRequest)(nil),    // 5: paast.v1.DeletePasteRequest
+	(*DeletePasteResponse)(nil),   // 6: paast.v1.DeletePasteResponse
+	(*Paste)(nil),                 // 7: paast.v1.Paste
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+}
+var file_paast_proto_depIdxs = []int32{
+	0,  // 0: paast.v1.CreatePasteRequest.options:type_name -> paast.v1.PasteOptions
+	0,  // 1: paast.v1.UploadPasteRequest.options:type_name -> paast.v1.PasteOptions
+	7,  // 2: paast.v1.GetPasteResponse.paste:type_name -> paast.v1.Paste
+	8,  // 3: paast.v1.Paste.created:type_name -> google.protobuf.Timestamp
+	8,  // 4: paast.v1.Paste.updated:type_name -> google.protobuf.Timestamp
+	8,  // 5: paast.v1.Paste.expires:type_name -> google.protobuf.Timestamp
+	1,  // 6: paast.v1.Pastes.CreatePaste:input_type -> paast.v1.CreatePasteRequest
+	2,  // 7: paast.v1.Pastes.UploadPaste:input_type -> paast.v1.UploadPasteRequest
+	3,  // 8: paast.v1.Pastes.GetPaste:input_type -> paast.v1.GetPasteRequest
+	5,  // 9: paast.v1.Pastes.DeletePaste:input_type -> paast.v1.DeletePasteRequest
+	7,  // 10: paast.v1.Pastes.CreatePaste:output_type -> paast.v1.Paste
+	7,  // 11: paast.v1.Pastes.UploadPaste:output_type -> paast.v1.Paste
+	4,  // 12: paast.v1.Pastes.GetPaste:output_type -> paast.v1.GetPasteResponse
+	6,  // 13: paast.v1.Pastes.DeletePaste:output_type -> paast.v1.DeletePasteResponse
+	10, // [10:14] is the sub-list for method output_type
+	6,  // [6:10] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_paast_proto_init() }
+func file_paast_proto_init() {
+	if File_paast_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_paast_proto_rawDesc), len(file_paast_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_paast_proto_goTypes,
+		DependencyIndexes: file_paast_proto_depIdxs,
+		MessageInfos:      file_paast_proto_msgTypes,
+	}.Build()
+	File_paast_proto = out.File
+	file_paast_proto_goTypes = nil
+	file_paast_proto_depIdxs = nil
+}
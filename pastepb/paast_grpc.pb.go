@@ -0,0 +1,245 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             v29.3.0
+// source: paast.proto
+
+package pastepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Pastes_CreatePaste_FullMethodName = "/paast.v1.Pastes/CreatePaste"
+	Pastes_UploadPaste_FullMethodName = "/paast.v1.Pastes/UploadPaste"
+	Pastes_GetPaste_FullMethodName    = "/paast.v1.Pastes/GetPaste"
+	Pastes_DeletePaste_FullMethodName = "/paast.v1.Pastes/DeletePaste"
+)
+
+// PastesClient is the client API for Pastes service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Pastes is gRPC counterpart of HTTP API. API key goes in "authorization"
+// metadata as "Bearer <key>", the same way as in HTTP.
+type PastesClient interface {
+	// CreatePaste creates paste from content sent at once.
+	CreatePaste(ctx context.Context, in *CreatePasteRequest, opts ...grpc.CallOption) (*Paste, error)
+	// UploadPaste creates paste from content streamed in chunks, options are
+	// taken from the first message.
+	UploadPaste(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadPasteRequest, Paste], error)
+	// GetPaste returns paste along with its content. Burn paste is deleted.
+	GetPaste(ctx context.Context, in *GetPasteRequest, opts ...grpc.CallOption) (*GetPasteResponse, error)
+	// DeletePaste deletes paste with deletion token returned on creation.
+	DeletePaste(ctx context.Context, in *DeletePasteRequest, opts ...grpc.CallOption) (*DeletePasteResponse, error)
+}
+
+type pastesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPastesClient(cc grpc.ClientConnInterface) PastesClient {
+	return &pastesClient{cc}
+}
+
+func (c *pastesClient) CreatePaste(ctx context.Context, in *CreatePasteRequest, opts ...grpc.CallOption) (*Paste, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Paste)
+	err := c.cc.Invoke(ctx, Pastes_CreatePaste_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pastesClient) UploadPaste(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadPasteRequest, Paste], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Pastes_ServiceDesc.Streams[0], Pastes_UploadPaste_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UploadPasteRequest, Paste]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Pastes_UploadPasteClient = grpc.ClientStreamingClient[UploadPasteRequest, Paste]
+
+func (c *pastesClient) GetPaste(ctx context.Context, in *GetPasteRequest, opts ...grpc.CallOption) (*GetPasteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetPasteResponse)
+	err := c.cc.Invoke(ctx, Pastes_GetPaste_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pastesClient) DeletePaste(ctx context.Context, in *DeletePasteRequest, opts ...grpc.CallOption) (*DeletePasteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeletePasteResponse)
+	err := c.cc.Invoke(ctx, Pastes_DeletePaste_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PastesServer is the server API for Pastes service.
+// All implementations must embed UnimplementedPastesServer
+// for forward compatibility.
+//
+// Pastes is gRPC counterpart of HTTP API. API key goes in "authorization"
+// metadata as "Bearer <key>", the same way as in HTTP.
+type PastesServer interface {
+	// CreatePaste creates paste from content sent at once.
+	CreatePaste(context.Context, *CreatePasteRequest) (*Paste, error)
+	// UploadPaste creates paste from content streamed in chunks, options are
+	// taken from the first message.
+	UploadPaste(grpc.ClientStreamingServer[UploadPasteRequest, Paste]) error
+	// GetPaste returns paste along with its content. Burn paste is deleted.
+	GetPaste(context.Context, *GetPasteRequest) (*GetPasteResponse, error)
+	// DeletePaste deletes paste with deletion token returned on creation.
+	DeletePaste(context.Context, *DeletePasteRequest) (*DeletePasteResponse, error)
+	mustEmbedUnimplementedPastesServer()
+}
+
+// UnimplementedPastesServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPastesServer struct{}
+
+func (UnimplementedPastesServer) CreatePaste(context.Context, *CreatePasteRequest) (*Paste, error) {
+	return nil, status.Error(codes.Unimplemented, "method CreatePaste not implemented")
+}
+func (UnimplementedPastesServer) UploadPaste(grpc.ClientStreamingServer[UploadPasteRequest, Paste]) error {
+	return status.Error(codes.Unimplemented, "method UploadPaste not implemented")
+}
+func (UnimplementedPastesServer) GetPaste(context.Context, *GetPasteRequest) (*GetPasteResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetPaste not implemented")
+}
+func (UnimplementedPastesServer) DeletePaste(context.Context, *DeletePasteRequest) (*DeletePasteResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method DeletePaste not implemented")
+}
+func (UnimplementedPastesServer) mustEmbedUnimplementedPastesServer() {}
+func (UnimplementedPastesServer) testEmbeddedByValue()                {}
+
+// UnsafePastesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PastesServer will
+// result in compilation errors.
+type UnsafePastesServer interface {
+	mustEmbedUnimplementedPastesServer()
+}
+
+func RegisterPastesServer(s grpc.ServiceRegistrar, srv PastesServer) {
+	// If the following call panics, it indicates UnimplementedPastesServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Pastes_ServiceDesc, srv)
+}
+
+func _Pastes_CreatePaste_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreatePasteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PastesServer).CreatePaste(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Pastes_CreatePaste_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PastesServer).CreatePaste(ctx, req.(*CreatePasteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Pastes_UploadPaste_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PastesServer).UploadPaste(&grpc.GenericServerStream[UploadPasteRequest, Paste]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Pastes_UploadPasteServer = grpc.ClientStreamingServer[UploadPasteRequest, Paste]
+
+func _Pastes_GetPaste_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPasteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PastesServer).GetPaste(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Pastes_GetPaste_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PastesServer).GetPaste(ctx, req.(*GetPasteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Pastes_DeletePaste_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeletePasteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PastesServer).DeletePaste(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Pastes_DeletePaste_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PastesServer).DeletePaste(ctx, req.(*DeletePasteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Pastes_ServiceDesc is the grpc.ServiceDesc for Pastes service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Pastes_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "paast.v1.Pastes",
+	HandlerType: (*PastesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreatePaste",
+			Handler:    _Pastes_CreatePaste_Handler,
+		},
+		{
+			MethodName: "GetPaste",
+			Handler:    _Pastes_GetPaste_Handler,
+		},
+		{
+			MethodName: "DeletePaste",
+			Handler:    _Pastes_DeletePaste_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UploadPaste",
+			Handler:       _Pastes_UploadPaste_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "paast.proto",
+}
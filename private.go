@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// SignatureLen is the number of hex characters of HMAC appended to IDs of
+// private pastes, 128 bits are enough to make them unguessable.
+const SignatureLen = 32
+
+// SignHash returns signature of paste hash keyed by url-secret.
+func (hr *HttpRoutes) SignHash(hash string) string {
+	mac := hmac.New(sha256.New, []byte(hr.config.URLSecret))
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))[:SignatureLen]
+}
+
+// PasteID returns ID paste is published under: hash followed by signature
+// for private pastes, plain hash for the rest.
+func (hr *HttpRoutes) PasteID(id string, meta *PasteMeta) string {
+	hash, _, _ := strings.Cut(id, "-")
+	if meta.Private {
+		return hash + "-" + hr.SignHash(hash)
+	}
+	return hash
+}
+
+// CanAccess tells whether ID from URL grants access to paste. Private pastes
+// need valid signature, it's accepted for others too. Pastes taken down or
+// held for review are not accessible at all.
+func (hr *HttpRoutes) CanAccess(id string, meta *PasteMeta) bool {
+	return meta.Takedown == nil && meta.Quarantine == "" && hr.ValidID(id, meta)
+}
+
+// ValidID is CanAccess regardless of takedown and quarantine.
+func (hr *HttpRoutes) ValidID(id string, meta *PasteMeta) bool {
+	hash, signature, signed := strings.Cut(id, "-")
+	if !signed {
+		return !meta.Private
+	}
+	return hr.config.URLSecret != "" && hmac.Equal([]byte(signature), []byte(hr.SignHash(hash)))
+}
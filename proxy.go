@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are networks of reverse proxies whose forwarded headers are
+// honoured. Anyone else could claim another address with them to get around
+// bans, rate limits and quotas.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses comma-separated addresses and CIDRs.
+func ParseTrustedProxies(value string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, item := range splitList(value) {
+		network, err := ParseNetwork(item)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// Trusted reports whether addr belongs to trusted proxy.
+func (tp TrustedProxies) Trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range tp {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// client walks addresses proxies appended, nearest last, and returns the
+// first one which isn't a trusted proxy. Addresses before it may be made up
+// by client.
+func (tp TrustedProxies) client(addrs []string, remote string) string {
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(addrs[i])
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		addr = strings.Trim(addr, "[]")
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		remote = addr
+		if !tp.Trusted(addr) {
+			break
+		}
+	}
+	return remote
+}
+
+// forwardedFor returns for parameters of Forwarded header, nearest last.
+func forwardedFor(header []string) []string {
+	var addrs []string
+	for _, element := range strings.Split(strings.Join(header, ","), ",") {
+		for _, pair := range strings.Split(element, ";") {
+			if name, value, ok := strings.Cut(strings.TrimSpace(pair), "="); ok && strings.EqualFold(name, "for") {
+				addrs = append(addrs, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return addrs
+}
+
+// Middleware takes client address from X-Forwarded-For, X-Real-IP or
+// Forwarded header, and scheme and host from X-Forwarded-Proto and
+// X-Forwarded-Host, of requests coming from trusted proxies.
+func (tp TrustedProxies) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		remote := RemoteIP(r)
+		if !tp.Trusted(remote) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			r.RemoteAddr = tp.client(strings.Split(strings.Join(forwarded, ","), ","), remote)
+		} else if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			r.RemoteAddr = tp.client([]string{realIP}, remote)
+		} else if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+			r.RemoteAddr = tp.client(forwardedFor(forwarded), remote)
+		}
+		scheme := r.Header.Get("X-Forwarded-Proto")
+		if scheme == "" {
+			scheme = r.Header.Get("X-Forwarded-Scheme")
+		}
+		if scheme = strings.ToLower(scheme); scheme == "http" || scheme == "https" {
+			r.URL.Scheme = scheme
+		}
+		if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+			r.Host = host
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/skip2/go-qrcode"
+)
+
+// QRSize is the width of PNG QR code in pixels.
+const QRSize = 256
+
+// QRText draws QR code of URL with Unicode half blocks, two rows of modules
+// per line, to be shown in terminal.
+func QRText(url string) (string, error) {
+	code, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return "", fmt.Errorf("qr code: %s", err)
+	}
+	return code.ToSmallString(false), nil
+}
+
+// QRSVG draws QR code of URL as SVG, one unit per module.
+func QRSVG(url string) (string, error) {
+	code, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return "", fmt.Errorf("qr code: %s", err)
+	}
+	bitmap := code.Bitmap()
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %[1]d %[1]d" width="%[2]d" height="%[2]d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%[3]s"/></svg>`+"\n", len(bitmap), QRSize, path.String()), nil
+}
+
+// PasteQR returns QR code of paste URL, as PNG unless SVG or text is
+// requested by extension.
+func (hr *HttpRoutes) PasteQR(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	vars := mux.Vars(r)
+	hash, _ := vars["hash"]
+	meta, err := hr.storage.LoadMeta(hr.HashName(hash))
+	if err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			PasteNotFound(rw, r, hash)
+			return
+		}
+		panic(err)
+	}
+	if meta.Expired() || !hr.CanAccess(hash, meta) {
+		PasteNotFound(rw, r, hash)
+		return
+	}
+
+	url := BaseURL(r) + "/" + hash
+	var content []byte
+	switch vars["format"] {
+	case "svg":
+		svg, err := QRSVG(url)
+		if err != nil {
+			panic(err)
+		}
+		rw.Header().Set("Content-Type", "image/svg+xml")
+		content = []byte(svg)
+	case "txt":
+		text, err := QRText(url)
+		if err != nil {
+			panic(err)
+		}
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		content = []byte(text)
+	default:
+		if content, err = qrcode.Encode(url, qrcode.Medium, QRSize); err != nil {
+			panic(fmt.Errorf("qr code: %s", err))
+		}
+		rw.Header().Set("Content-Type", "image/png")
+	}
+	rw.WriteHeader(200)
+	rw.Write(content)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrStorageFull is returned for paste which doesn't fit in max-storage, or
+// on disk.
+var ErrStorageFull = errors.New("storage is full")
+
+// StorageUsage tracks size of pastes and their revisions, before
+// compression, to enforce max-storage. It's counted on startup and
+// recounted by garbage collector, which also makes up for pastes deleted by
+// other instances sharing storage.
+type StorageUsage struct {
+	max   int64
+	bytes atomic.Int64
+}
+
+// NewStorageUsage counts usage of storage if max-storage is set, otherwise
+// it's only known after garbage collection.
+func NewStorageUsage(config *Config, storage Storage) (*StorageUsage, error) {
+	usage := &StorageUsage{max: config.MaxStorage}
+	if usage.max == 0 {
+		return usage, nil
+	}
+	names, err := storage.List()
+	if err != nil {
+		return nil, fmt.Errorf("storage usage: %s", err)
+	}
+	var total int64
+	for _, name := range names {
+		meta, err := storage.LoadMeta(name)
+		if err != nil {
+			if errors.Is(err, ErrPasteNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("storage usage: %s", err)
+		}
+		total += meta.StoredSize()
+	}
+	usage.Set(total)
+	return usage, nil
+}
+
+// StoredSize is the size of paste content along with its revisions.
+func (m *PasteMeta) StoredSize() int64 {
+	size := m.Size
+	for _, revision := range m.Revisions {
+		size += revision.Size
+	}
+	return size
+}
+
+func (u *StorageUsage) Bytes() int64 {
+	return u.bytes.Load()
+}
+
+func (u *StorageUsage) Set(bytes int64) {
+	u.bytes.Store(bytes)
+	metricStorageBytes.Set(float64(bytes))
+}
+
+// Add accounts for saved content, or deleted one if delta is negative.
+func (u *StorageUsage) Add(delta int64) {
+	metricStorageBytes.Set(float64(u.bytes.Add(delta)))
+}
+
+// Release accounts for deleted paste.
+func (u *StorageUsage) Release(meta *PasteMeta) {
+	u.Add(-meta.StoredSize())
+}
+
+// CheckUploads returns ErrStorageFull if uploads don't fit in max-storage.
+// Concurrent uploads may overshoot it a bit, they're checked before being
+// saved.
+func (u *StorageUsage) CheckUploads(uploads []*Upload) error {
+	if u.max == 0 {
+		return nil
+	}
+	size := u.Bytes()
+	for _, upload := range uploads {
+		size += upload.Size
+	}
+	if size > u.max {
+		return ErrStorageFull
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimitStore keeps token buckets of clients. Take removes one token from
+// key's bucket of burst tokens which refills with one token per interval. If
+// bucket is empty, it returns false and the time until next token becomes
+// available.
+type RateLimitStore interface {
+	Take(key string, interval time.Duration, burst int) (bool, time.Duration)
+	Close() error
+}
+
+type RateLimiter struct {
+	store      RateLimitStore
+	interval   time.Duration
+	burst      int
+	ipv6Prefix int
+	// Budget shared by clients of onion service
+	torInterval time.Duration
+	torBurst    int
+}
+
+func NewRateLimiter(config *Config) (*RateLimiter, error) {
+	var store RateLimitStore
+	switch config.RateLimitStore {
+	case "memory":
+		store = NewMemoryRateLimitStore(config.RateLimitMax)
+	case "redis":
+		var err error
+		store, err = NewRedisRateLimitStore(config.RedisURL, config.RedisPrefix)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown rate limit store: %s", config.RateLimitStore)
+	}
+	return &RateLimiter{
+		store:       store,
+		interval:    config.PasteCooldown,
+		burst:       config.PasteBurst,
+		ipv6Prefix:  config.IPv6Prefix,
+		torInterval: config.TorCooldown,
+		torBurst:    config.TorBurst,
+	}, nil
+}
+
+// ClientKey identifies client for rate limiting. IPv6 clients usually get a
+// whole /64 (or more) from their provider, so addresses are aggregated by
+// prefix, otherwise one could rotate through them to bypass the limit.
+func ClientKey(addr string, ipv6Prefix int) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	return (&net.IPNet{
+		IP:   ip.Mask(net.CIDRMask(ipv6Prefix, 128)),
+		Mask: net.CIDRMask(ipv6Prefix, 128),
+	}).String()
+}
+
+// Run performs periodic housekeeping of the store until ctx is done.
+func (rl *RateLimiter) Run(ctx context.Context) {
+	if store, ok := rl.store.(*MemoryRateLimitStore); ok {
+		store.Run(ctx)
+	}
+}
+
+func (rl *RateLimiter) Close() error {
+	return rl.store.Close()
+}
+
+// Take takes one paste from budget of client connecting from addr, for
+// pastes created outside of HTTP.
+func (rl *RateLimiter) Take(addr string) (bool, time.Duration) {
+	if rl.interval <= 0 {
+		return true, 0
+	}
+	return rl.store.Take(ClientKey(addr, rl.ipv6Prefix), rl.interval, rl.burst)
+}
+
+// TakeRequest takes one paste from budget of request's client. API key
+// holders share budget no matter where they come from, and so do clients of
+// onion service.
+func (rl *RateLimiter) TakeRequest(r *http.Request) (bool, time.Duration) {
+	key, interval, burst := ClientKey(RemoteIP(r), rl.ipv6Prefix), rl.interval, rl.burst
+	if r.RemoteAddr == OnionRemoteAddr {
+		interval, burst = rl.torInterval, rl.torBurst
+	}
+	if apiKey := GetRequestInfo(r).APIKey; apiKey != nil {
+		key, interval, burst = "key:"+apiKey.Name, apiKey.PasteCooldown, apiKey.PasteBurst
+	}
+	if interval <= 0 {
+		return true, 0
+	}
+	return rl.store.Take(key, interval, burst)
+}
+
+func (rl *RateLimiter) Middleware(fn http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if ok, wait := rl.TakeRequest(r); !ok {
+			retryAfter := int64(math.Ceil(wait.Seconds()))
+			metricRateLimited.Inc()
+			rw.Header().Add("Retry-After", fmt.Sprint(retryAfter))
+			WriteError(rw, r, 429, fmt.Sprintf(
+				"please wait %d seconds before creating new paste",
+				retryAfter,
+			))
+			return
+		}
+		fn(rw, r)
+	}
+}
+
+type TokenBucket struct {
+	Tokens   float64
+	Burst    float64
+	Interval time.Duration
+	Updated  time.Time
+}
+
+// Full reports whether bucket would have refilled completely by now.
+func (tb *TokenBucket) Full(now time.Time) bool {
+	return tb.Tokens+float64(now.Sub(tb.Updated))/float64(tb.Interval) >= tb.Burst
+}
+
+// MemoryRateLimitStore keeps buckets in process memory. Buckets which have
+// refilled completely carry no state and are evicted, number of buckets is
+// capped at maxClients.
+type MemoryRateLimitStore struct {
+	maxClients int
+	buckets    map[string]*TokenBucket
+	lock       sync.Mutex
+}
+
+func NewMemoryRateLimitStore(maxClients int) *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		maxClients: maxClients,
+		buckets:    map[string]*TokenBucket{},
+	}
+}
+
+func (ms *MemoryRateLimitStore) Take(key string, interval time.Duration, burst int) (bool, time.Duration) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	now := time.Now()
+	bucket, ok := ms.buckets[key]
+	if !ok {
+		if ms.maxClients > 0 && len(ms.buckets) >= ms.maxClients {
+			ms.evict(now)
+		}
+		// Still full: forget random client rather than grow unbounded.
+		for k := range ms.buckets {
+			if ms.maxClients <= 0 || len(ms.buckets) < ms.maxClients {
+				break
+			}
+			delete(ms.buckets, k)
+		}
+		bucket = &TokenBucket{Tokens: float64(burst), Updated: now}
+		ms.buckets[key] = bucket
+	}
+	bucket.Burst, bucket.Interval = float64(burst), interval
+	bucket.Tokens = math.Min(bucket.Burst, bucket.Tokens+float64(now.Sub(bucket.Updated))/float64(interval))
+	bucket.Updated = now
+	if bucket.Tokens < 1 {
+		return false, time.Duration((1 - bucket.Tokens) * float64(interval))
+	}
+	bucket.Tokens--
+	return true, 0
+}
+
+// evict removes buckets which would be full by now. Caller must hold the lock.
+func (ms *MemoryRateLimitStore) evict(now time.Time) {
+	for key, bucket := range ms.buckets {
+		if bucket.Full(now) {
+			delete(ms.buckets, key)
+		}
+	}
+}
+
+// Run periodically evicts stale buckets until ctx is done.
+func (ms *MemoryRateLimitStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			ms.lock.Lock()
+			ms.evict(now)
+			ms.lock.Unlock()
+		}
+	}
+}
+
+func (ms *MemoryRateLimitStore) Close() error {
+	return nil
+}
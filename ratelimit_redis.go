@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript refills and takes from a token bucket stored in a hash, returning
+// milliseconds to wait or 0 if token was taken. Buckets expire once they would
+// be full again, so idle clients don't occupy memory.
+var takeScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1]) or burst
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) / interval)
+local wait = 0
+if tokens < 1 then
+	wait = math.ceil((1 - tokens) * interval)
+else
+	tokens = tokens - 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.max(1, math.ceil((burst - tokens) * interval)))
+return wait
+`)
+
+// RedisRateLimitStore shares token buckets between replicas.
+type RedisRateLimitStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisRateLimitStore(url string, prefix string) (*RedisRateLimitStore, error) {
+	if url == "" {
+		return nil, errors.New("redis rate limit: url is required")
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("redis rate limit: %s", err)
+	}
+	client := redis.NewClient(opts)
+	if err = client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("redis rate limit: %s", err)
+	}
+	return &RedisRateLimitStore{client: client, prefix: prefix}, nil
+}
+
+func (rs *RedisRateLimitStore) Take(key string, interval time.Duration, burst int) (bool, time.Duration) {
+	wait, err := takeScript.Run(
+		context.Background(),
+		rs.client,
+		[]string{rs.prefix + "ratelimit:" + key},
+		interval.Milliseconds(),
+		burst,
+		time.Now().UnixMilli(),
+	).Int64()
+	if err != nil {
+		// Don't take the service down with Redis, let client through.
+		slog.Error("rate limit", "error", err)
+		return true, 0
+	}
+	if wait > 0 {
+		return false, time.Duration(wait) * time.Millisecond
+	}
+	return true, 0
+}
+
+func (rs *RedisRateLimitStore) Close() error {
+	return rs.client.Close()
+}
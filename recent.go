@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// RecentLimit is how many pastes are shown in listing and feeds.
+const RecentLimit = 50
+
+// PreviewLen is the maximum number of characters of first line shown.
+const PreviewLen = 100
+
+// RecentPaste is PasteInfo with first line of content, if it's readable.
+type RecentPaste struct {
+	*PasteInfo
+	Preview string `json:"preview,omitempty"`
+}
+
+// Title is what paste is called in listing and feeds.
+func (rp *RecentPaste) Title() string {
+	switch {
+	case rp.Filename != "":
+		return rp.Filename
+	case rp.Preview != "":
+		return rp.Preview
+	}
+	return rp.ID
+}
+
+// recentPastes returns up to limit public pastes with all of given tags,
+// newest first. Pastes are walked from the highest counter, so only the
+// newest ones are loaded.
+func (hr *HttpRoutes) recentPastes(r *http.Request, limit int, tags []string) ([]*RecentPaste, error) {
+	names, err := hr.storage.List()
+	if err != nil {
+		return nil, err
+	}
+	counters := map[string]int64{}
+	for _, name := range names {
+		if counter, _, err := ParsePasteName(name); err == nil {
+			counters[name] = counter
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return counters[names[i]] > counters[names[j]]
+	})
+
+	pastes := []*RecentPaste{}
+	for _, name := range names {
+		if len(pastes) == limit {
+			break
+		}
+		_, hash, err := ParsePasteName(name)
+		if err != nil {
+			continue
+		}
+		meta, err := hr.storage.LoadMeta(name)
+		if err != nil {
+			// Deleted while listing
+			if errors.Is(err, ErrPasteNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		if !meta.Listed() || !meta.HasTags(tags) {
+			continue
+		}
+		pastes = append(pastes, hr.recentPaste(r, name, hash, meta))
+	}
+	return pastes, nil
+}
+
+func (hr *HttpRoutes) recentPaste(r *http.Request, name string, hash string, meta *PasteMeta) *RecentPaste {
+	size := meta.Size
+	if meta.Password != nil {
+		size -= PasswordOverhead
+	}
+	paste := &RecentPaste{PasteInfo: NewPasteInfo(r, hash, meta, int(size))}
+	if meta.Password == nil && !meta.Encrypted {
+		var err error
+		if paste.Preview, err = hr.pastePreview(name); err != nil {
+			RequestLogger(r).Warn("failed to load preview", "paste", hash, "error", err)
+		}
+	}
+	return paste
+}
+
+// pastePreview returns first non-blank line of text paste, shortened to
+// PreviewLen characters.
+func (hr *HttpRoutes) pastePreview(name string) (string, error) {
+	_, content, err := hr.storage.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+	head := make([]byte, 4096)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	head = head[:n]
+	// Last rune may be cut in half
+	for i := 0; i < utf8.UTFMax && len(head) > 0 && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+	if IsBinary(head) {
+		return "", nil
+	}
+	for _, line := range strings.Split(string(head), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			if utf8.RuneCountInString(line) > PreviewLen {
+				line = string([]rune(line)[:PreviewLen]) + "…"
+			}
+			return line, nil
+		}
+	}
+	return "", nil
+}
+
+// RecentPastes lists latest public pastes as HTML, JSON or plain text, or
+// as RSS/Atom feed if requested by extension. Listing can be narrowed down
+// with tag parameter, which may be repeated.
+func (hr *HttpRoutes) RecentPastes(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	pastes, err := hr.recentPastes(r, RecentLimit, r.URL.Query()["tag"])
+	if err != nil {
+		panic(err)
+	}
+	switch feed := mux.Vars(r)["feed"]; {
+	case feed == "rss":
+		WriteXML(rw, "application/rss+xml", NewRSSFeed(r, pastes))
+	case feed == "atom":
+		WriteXML(rw, "application/atom+xml", NewAtomFeed(r, pastes))
+	case WantsJSON(r):
+		WriteJSON(rw, 200, pastes)
+	case WantsHTML(r):
+		if err = RenderRecent(rw, r, "", pastes, hr.search != nil); err != nil {
+			panic(err)
+		}
+	default:
+		WritePasteList(rw, pastes)
+	}
+}
+
+// WritePasteList writes tab-separated URL, size and preview of each paste.
+func WritePasteList(rw http.ResponseWriter, pastes []*RecentPaste) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, paste := range pastes {
+		fmt.Fprintf(rw, "%s\t%d\t%s\n", paste.URL, paste.Bytes, paste.Preview)
+	}
+}
+
+const RecentHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ if .Query }}{{ .Query }} - search{{ else }}Recent pastes{{ end }} - paast</title>
+<link rel="alternate" type="application/rss+xml" title="Recent pastes" href="{{ .Base }}/recent.rss">
+<link rel="alternate" type="application/atom+xml" title="Recent pastes" href="{{ .Base }}/recent.atom">
+<style>
+body { margin: 0 auto; padding: 12px; max-width: 960px; font-family: monospace; font-size: 14px; }
+a { color: #0366d6; }
+table { border-collapse: collapse; width: 100%; }
+td { padding: 4px 8px; border-bottom: 1px solid #ddd; white-space: nowrap; }
+td.preview { white-space: normal; word-break: break-all; color: #586069; }
+input { font-family: monospace; }
+</style>
+</head>
+<body>
+<h3>{{ if .Query }}Search results <small><a href="/recent">recent</a></small>{{ else }}Recent pastes <small><a href="/recent.rss">RSS</a> <a href="/recent.atom">Atom</a></small>{{ end }}</h3>
+{{ if .Search }}<form action="/search"><input name="q" value="{{ .Query }}" required> <input type="submit" value="Search"></form>
+{{ end -}}
+{{ if .Pastes }}<table>
+{{ range .Pastes }}<tr><td><a href="{{ .URL }}">{{ .ID }}</a></td><td>{{ .Bytes }} bytes</td><td>{{ .Created.Format "2006-01-02 15:04" }}</td><td class="preview">{{ if .Filename }}{{ .Filename }}: {{ end }}{{ .Preview }}</td><td>{{ range .Tags }}<a href="/recent?tag={{ . }}">#{{ . }}</a> {{ end }}</td></tr>
+{{ end }}</table>
+{{ else if .Query }}<p>Nothing found.</p>
+{{ else }}<p>No public pastes yet.</p>
+{{ end }}</body>
+</html>
+`
+
+var recentTemplate = template.Must(template.New("recent").Parse(RecentHTML))
+
+// RenderRecent shows list of pastes, either recent ones or search results
+// if query is given. Search form is shown if search is enabled.
+func RenderRecent(rw http.ResponseWriter, r *http.Request, query string, pastes []*RecentPaste, search bool) error {
+	var page bytes.Buffer
+	if err := recentTemplate.Execute(&page, map[string]interface{}{
+		"Base":   BaseURL(r),
+		"Query":  query,
+		"Pastes": pastes,
+		"Search": search,
+	}); err != nil {
+		return fmt.Errorf("render recent: %s", err)
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(200)
+	rw.Write(page.Bytes())
+	return nil
+}
+
+type RSSFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel RSSChannel `xml:"channel"`
+}
+
+type RSSChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []RSSItem `xml:"item"`
+}
+
+type RSSItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description,omitempty"`
+	Categories  []string `xml:"category"`
+}
+
+func NewRSSFeed(r *http.Request, pastes []*RecentPaste) *RSSFeed {
+	feed := &RSSFeed{Version: "2.0", Channel: RSSChannel{
+		Title:       "Recent pastes",
+		Link:        BaseURL(r) + "/recent",
+		Description: "Latest public pastes on " + r.Host,
+	}}
+	for _, paste := range pastes {
+		feed.Channel.Items = append(feed.Channel.Items, RSSItem{
+			Title:       paste.Title(),
+			Link:        paste.URL,
+			GUID:        paste.URL,
+			PubDate:     paste.Created.UTC().Format(time.RFC1123Z),
+			Description: paste.Preview,
+			Categories:  paste.Tags,
+		})
+	}
+	return feed
+}
+
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []AtomLink  `xml:"link"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type AtomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Link       AtomLink       `xml:"link"`
+	Summary    string         `xml:"summary,omitempty"`
+	Categories []AtomCategory `xml:"category"`
+}
+
+type AtomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+func NewAtomFeed(r *http.Request, pastes []*RecentPaste) *AtomFeed {
+	base := BaseURL(r)
+	feed := &AtomFeed{
+		ID:    base + "/recent",
+		Title: "Recent pastes",
+		Links: []AtomLink{{Href: base + "/recent"}, {Href: base + "/recent.atom", Rel: "self"}},
+	}
+	var latest time.Time
+	for _, paste := range pastes {
+		updated := paste.Created
+		if paste.Updated != nil {
+			updated = *paste.Updated
+		}
+		if updated.After(latest) {
+			latest = updated
+		}
+		var categories []AtomCategory
+		for _, tag := range paste.Tags {
+			categories = append(categories, AtomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, AtomEntry{
+			ID:         paste.URL,
+			Title:      paste.Title(),
+			Updated:    updated.UTC().Format(time.RFC3339),
+			Link:       AtomLink{Href: paste.URL},
+			Summary:    paste.Preview,
+			Categories: categories,
+		})
+	}
+	feed.Updated = latest.UTC().Format(time.RFC3339)
+	return feed
+}
+
+func WriteXML(rw http.ResponseWriter, contentType string, value interface{}) {
+	content, err := xml.MarshalIndent(value, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	rw.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	rw.WriteHeader(200)
+	rw.Write([]byte(xml.Header))
+	rw.Write(append(content, '\n'))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Types of paste which can be asked for on creation. Paste which is a single
+// URL becomes redirect unless text is asked for.
+const (
+	TypeText     = "text"
+	TypeRedirect = "redirect"
+)
+
+// MaxRedirectLen is the longest URL paste redirects to.
+const MaxRedirectLen = 2048
+
+var ErrInvalidRedirect = errors.New("redirect paste must be a single http or https URL")
+
+// RedirectTarget returns URL upload consists of, empty if it's anything
+// else.
+func RedirectTarget(upload *Upload) string {
+	if upload.Size > MaxRedirectLen || upload.Binary() {
+		return ""
+	}
+	target := strings.TrimSpace(string(upload.Head()))
+	if target == "" || strings.ContainsAny(target, " \t\r\n") {
+		return ""
+	}
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ""
+	}
+	return target
+}
+
+// CheckRedirect makes sure that pastes asked to be redirects can be ones:
+// server has to read URL, and that's all it should find.
+func CheckRedirect(options *PasteMeta, protected bool, uploads []*Upload) error {
+	if options.Type != TypeRedirect {
+		return nil
+	}
+	if options.Encrypted || protected {
+		return errors.New("redirect paste can't be encrypted or protected")
+	}
+	for _, upload := range uploads {
+		if RedirectTarget(upload) == "" {
+			return ErrInvalidRedirect
+		}
+	}
+	return nil
+}
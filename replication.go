@@ -0,0 +1,345 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ReplicationTimeout limits sending of a single paste to standby.
+const ReplicationTimeout = 5 * time.Minute
+
+// ReplicationMinDelay and ReplicationMaxDelay bound the delay between
+// attempts while standby is failing. Pending pastes are also flushed every
+// ReplicationMaxDelay, in case wakeup was missed.
+const (
+	ReplicationMinDelay = 5 * time.Second
+	ReplicationMaxDelay = 5 * time.Minute
+)
+
+// Replicator sends pastes changed on this instance to admin API of standby,
+// whose admin token is used to authenticate. Current state of every changed
+// paste is sent rather than changes themselves, so that replication is
+// idempotent and pastes changed many times in a row are sent once: standby
+// replaces paste with the one sent, or deletes it if it's gone. Pastes
+// pending replication are kept in memory, on startup they're found by
+// comparing pastes with standby.
+type Replicator struct {
+	url     string
+	token   string
+	client  *http.Client
+	storage Storage
+	mu      sync.Mutex
+	pending map[string]bool
+	wake    chan struct{}
+}
+
+func NewReplicator(config *Config, storage Storage) (*Replicator, error) {
+	tlsConfig, err := NewReplicateTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &Replicator{
+		url:     strings.TrimSuffix(config.Replicate, "/") + "/api/replica",
+		token:   config.ReplicateToken,
+		client:  &http.Client{Timeout: ReplicationTimeout, Transport: transport},
+		storage: storage,
+		pending: map[string]bool{},
+		wake:    make(chan struct{}, 1),
+	}, nil
+}
+
+// Queue marks paste for replication.
+func (rp *Replicator) Queue(name string) {
+	rp.mu.Lock()
+	rp.pending[name] = true
+	metricReplicationPending.Set(float64(len(rp.pending)))
+	rp.mu.Unlock()
+	select {
+	case rp.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run replicates pending pastes until ctx is done, retrying with growing
+// delay while standby is failing.
+func (rp *Replicator) Run(ctx context.Context) {
+	reconciled := false
+	delay := ReplicationMinDelay
+	for {
+		var err error
+		if !reconciled {
+			err = rp.reconcile()
+			reconciled = err == nil
+		}
+		if err == nil {
+			err = rp.flush(ctx)
+		}
+		wait, wake := ReplicationMaxDelay, rp.wake
+		if err != nil {
+			slog.Warn("replication failed", "error", err, "retry", delay)
+			// Wakeups would defeat the delay
+			wait, wake = delay, nil
+			delay = min(delay*2, ReplicationMaxDelay)
+		} else {
+			delay = ReplicationMinDelay
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// flush sends pending pastes, those which failed stay pending.
+func (rp *Replicator) flush(ctx context.Context) error {
+	rp.mu.Lock()
+	names := make([]string, 0, len(rp.pending))
+	for name := range rp.pending {
+		names = append(names, name)
+	}
+	rp.mu.Unlock()
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return nil
+		}
+		// Paste changed while being sent is queued again
+		rp.mu.Lock()
+		delete(rp.pending, name)
+		metricReplicationPending.Set(float64(len(rp.pending)))
+		rp.mu.Unlock()
+		if err := rp.send(name); err != nil {
+			rp.Queue(name)
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func (rp *Replicator) request(method, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+rp.token)
+	res, err := rp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		res.Body.Close()
+		return nil, fmt.Errorf("standby responded with %s", res.Status)
+	}
+	return res, nil
+}
+
+// send replaces paste on standby, or deletes it if it's gone.
+func (rp *Replicator) send(name string) error {
+	if _, err := rp.storage.LoadMeta(name); errors.Is(err, ErrPasteNotFound) {
+		res, err := rp.request("DELETE", rp.url+"/"+name, nil)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// Paste is streamed in backup layout, deleted meanwhile fails the
+	// request and is deleted on retry
+	pr, pw := io.Pipe()
+	go func() {
+		tw := &backupWriter{tar.NewWriter(pw)}
+		err := tw.writePaste(rp.storage, name)
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	res, err := rp.request("PUT", rp.url, pr)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// reconcile queues pastes whose meta differs from standby, and pastes
+// standby has but this instance doesn't.
+func (rp *Replicator) reconcile() error {
+	res, err := rp.request("GET", rp.url, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	remote := map[string]string{}
+	if err = json.NewDecoder(res.Body).Decode(&remote); err != nil {
+		return fmt.Errorf("list standby pastes: %s", err)
+	}
+	names, err := rp.storage.List()
+	if err != nil {
+		return err
+	}
+	queued := 0
+	for _, name := range names {
+		meta, err := rp.storage.LoadMeta(name)
+		if errors.Is(err, ErrPasteNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if remote[name] != MetaDigest(meta) {
+			rp.Queue(name)
+			queued++
+		}
+		delete(remote, name)
+	}
+	for name := range remote {
+		rp.Queue(name)
+		queued++
+	}
+	slog.Info("replication started", "pending", queued)
+	return nil
+}
+
+// MetaDigest identifies state of paste, pastes are changed along with their
+// meta.
+func MetaDigest(meta *PasteMeta) string {
+	content, _ := json.Marshal(meta)
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// ReplicatedStorage queues pastes changed through it for replication.
+type ReplicatedStorage struct {
+	Storage
+	replicator *Replicator
+}
+
+func (rs *ReplicatedStorage) Save(name string, meta *PasteMeta, content io.Reader) error {
+	if err := rs.Storage.Save(name, meta, content); err != nil {
+		return err
+	}
+	rs.replicator.Queue(name)
+	return nil
+}
+
+func (rs *ReplicatedStorage) SaveRevision(name string, revision int, content []byte) error {
+	if err := rs.Storage.SaveRevision(name, revision, content); err != nil {
+		return err
+	}
+	rs.replicator.Queue(name)
+	return nil
+}
+
+func (rs *ReplicatedStorage) SaveStats(name string, stats *PasteStats) error {
+	if err := rs.Storage.SaveStats(name, stats); err != nil {
+		return err
+	}
+	rs.replicator.Queue(name)
+	return nil
+}
+
+func (rs *ReplicatedStorage) Delete(name string) error {
+	if err := rs.Storage.Delete(name); err != nil {
+		return err
+	}
+	rs.replicator.Queue(name)
+	return nil
+}
+
+// CollectOrphans is passed through, garbage collector looks for it.
+func (rs *ReplicatedStorage) CollectOrphans(minAge time.Duration) (int64, error) {
+	if collector, ok := rs.Storage.(OrphanCollector); ok {
+		return collector.CollectOrphans(minAge)
+	}
+	return 0, nil
+}
+
+func (rs *ReplicatedStorage) LockPaste(name string) (func(), error) {
+	if locker, ok := rs.Storage.(PasteLocker); ok {
+		return locker.LockPaste(name)
+	}
+	return func() {}, nil
+}
+
+// AdminListReplica returns digests of all pastes by name, for primary to
+// find which ones standby lacks.
+func (hr *HttpRoutes) AdminListReplica(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	names, err := hr.storage.List()
+	if err != nil {
+		panic(err)
+	}
+	digests := map[string]string{}
+	for _, name := range names {
+		meta, err := hr.storage.LoadMeta(name)
+		if err != nil {
+			if errors.Is(err, ErrPasteNotFound) {
+				continue
+			}
+			panic(err)
+		}
+		digests[name] = MetaDigest(meta)
+	}
+	WriteJSON(rw, 200, digests)
+}
+
+// AdminSaveReplica replaces pastes with ones sent by primary in backup
+// layout. Request is validated as a whole before anything is written.
+func (hr *HttpRoutes) AdminSaveReplica(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	spool, err := SpoolPaste(r.Body)
+	if err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	defer spool.Close()
+	if _, _, err = hr.readBackupPastes(tar.NewReader(spool), 0, false, true); err != nil {
+		WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+		return
+	}
+	if _, err = spool.Seek(0, io.SeekStart); err != nil {
+		panic(err)
+	}
+	result, counter, err := hr.readBackupPastes(tar.NewReader(spool), 0, true, true)
+	if err != nil {
+		panic(err)
+	}
+	// Standby promoted to primary must not hand out IDs of replicated pastes
+	if err = hr.storage.AdvanceCounter(counter); err != nil {
+		panic(err)
+	}
+	WriteJSON(rw, 200, result)
+}
+
+// AdminDeleteReplica deletes paste deleted on primary.
+func (hr *HttpRoutes) AdminDeleteReplica(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	name := mux.Vars(r)["name"]
+	if err := hr.storage.Delete(name); err != nil && !errors.Is(err, ErrPasteNotFound) {
+		panic(err)
+	}
+	hr.unindexPaste(r, name)
+	rw.WriteHeader(204)
+}
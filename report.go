@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// MaxReportReason limits length of reason given with report, in characters.
+const MaxReportReason = 1000
+
+// MaxReports limits reports kept per paste, further ones are accepted but
+// dropped, operators only need to notice the paste.
+const MaxReports = 100
+
+// ReportReason reads reason from form or query parameter, or JSON body
+// like {"reason": "..."}.
+func ReportReason(rw http.ResponseWriter, r *http.Request) (string, error) {
+	r.Body = http.MaxBytesReader(rw, r.Body, 64<<10)
+	reason := ""
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return "", fmt.Errorf("invalid JSON: %s", err)
+		}
+		reason = body.Reason
+	} else {
+		reason = r.FormValue("reason")
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return "", errors.New("reason is required")
+	}
+	if utf8.RuneCountInString(reason) > MaxReportReason {
+		return "", fmt.Errorf("reason is longer than %d characters", MaxReportReason)
+	}
+	return reason, nil
+}
+
+// ReportPaste flags paste for operators to review. Reports are kept in paste
+// meta, one per address, repeated reports are accepted but ignored.
+func (hr *HttpRoutes) ReportPaste(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	hash, _ := mux.Vars(r)["hash"]
+	reason, err := ReportReason(rw, r)
+	if err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
+	}
+
+	// Content is streamed back as is, the paste is saved with new meta only
+	name := hr.HashName(hash)
+	unlock, err := hr.lockPaste(name)
+	if err != nil {
+		panic(err)
+	}
+	defer unlock()
+	meta, content, err := hr.storage.Open(name)
+	if err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			PasteNotFound(rw, r, hash)
+			return
+		}
+		panic(err)
+	}
+	defer content.Close()
+	if meta.Expired() || !hr.CanAccess(hash, meta) {
+		PasteNotFound(rw, r, hash)
+		return
+	}
+	SetPasteID(r, hash)
+	ip := RemoteIP(r)
+	reported := slices.ContainsFunc(meta.Reports, func(report Report) bool {
+		return report.IP == ip
+	})
+	if !reported && len(meta.Reports) < MaxReports {
+		meta.Reports = append(meta.Reports, Report{Created: time.Now(), Reason: reason, IP: ip})
+		if err = hr.storage.Save(name, meta, content); err != nil {
+			panic(err)
+		}
+		RequestLogger(r).Info("paste reported", "reason", reason)
+	}
+
+	if WantsJSON(r) {
+		WriteJSON(rw, 202, map[string]string{"id": hash, "status": "reported"})
+		return
+	}
+	rw.WriteHeader(202)
+	rw.Write([]byte(fmt.Sprintf("paste with id \"%s\" was reported\n", hash)))
+}
+
+// PasteTakenDown responds to request of paste taken down by operator.
+func PasteTakenDown(rw http.ResponseWriter, r *http.Request, hash string, takedown *Takedown) {
+	msg := fmt.Sprintf("paste with id \"%s\" was taken down: %s", hash, takedown.Reason)
+	if WantsJSON(r) {
+		WriteJSON(rw, 451, map[string]string{"error": msg})
+		return
+	}
+	rw.WriteHeader(451)
+	rw.Write([]byte(msg + "\n"))
+}
+
+// Withheld responds with PasteTakenDown if paste was taken down, or with 403
+// if it's held for review. Private pastes are only revealed to those who
+// have their signed ID, the rest get not found as before.
+func (hr *HttpRoutes) Withheld(rw http.ResponseWriter, r *http.Request, hash string, meta *PasteMeta) bool {
+	if (meta.Takedown == nil && meta.Quarantine == "") || meta.Expired() || !hr.ValidID(hash, meta) {
+		return false
+	}
+	if meta.Takedown != nil {
+		PasteTakenDown(rw, r, hash, meta.Takedown)
+	} else {
+		WriteError(rw, r, 403, fmt.Sprintf("paste with id \"%s\" is held for review", hash))
+	}
+	return true
+}
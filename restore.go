@@ -0,0 +1,247 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// RestoreResult is the outcome of restoring backup.
+type RestoreResult struct {
+	Restored int `json:"restored"`
+	// Pastes which already exist in storage, they're left as they are
+	Skipped int `json:"skipped"`
+}
+
+// RestoreBackup restores pastes from archive made by WriteBackup. Archive is
+// validated as a whole before anything is written, so that corrupt or
+// truncated backup leaves storage untouched. Restored pastes are indexed and
+// counter is advanced past them and the counter of backup, so that new
+// pastes can't collide with restored ones.
+func (hr *HttpRoutes) RestoreBackup(filename string) (*RestoreResult, error) {
+	if _, err := hr.readBackup(filename, false); err != nil {
+		return nil, fmt.Errorf("restore: %s", err)
+	}
+	result, err := hr.readBackup(filename, true)
+	if err != nil {
+		return result, fmt.Errorf("restore: %s", err)
+	}
+	return result, nil
+}
+
+// restoredPaste is paste being read from archive.
+type restoredPaste struct {
+	name      string
+	counter   int64
+	meta      *PasteMeta
+	content   bool
+	revisions int
+	skip      bool
+}
+
+// complete checks that every entry of paste was found.
+func (p *restoredPaste) complete() error {
+	if p == nil {
+		return nil
+	}
+	if !p.content {
+		return fmt.Errorf("%s: content is missing", p.name)
+	}
+	if p.revisions != len(p.meta.Revisions) {
+		return fmt.Errorf("%s: revision %d is missing", p.name, p.revisions+1)
+	}
+	return nil
+}
+
+// readBackup checks every entry of archive, and writes pastes to storage if
+// write is set.
+func (hr *HttpRoutes) readBackup(filename string, write bool) (*RestoreResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %s", err)
+	}
+	if header.Name != BackupManifestName {
+		return nil, fmt.Errorf("%s is missing, not a backup archive?", BackupManifestName)
+	}
+	manifest := &BackupManifest{}
+	if err = json.NewDecoder(tr).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("read manifest: %s", err)
+	}
+	if manifest.Version != BackupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+	result, counter, err := hr.readBackupPastes(tr, manifest.Counter, write, false)
+	if err != nil {
+		return result, err
+	}
+	// Gzip checksum is only verified at the end of stream
+	if _, err = io.Copy(io.Discard, gz); err != nil {
+		return result, err
+	}
+	if write {
+		if err = hr.storage.AdvanceCounter(counter); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// readBackupPastes checks entries of pastes laid out as in backup, and writes
+// pastes to storage if write is set. Existing pastes are skipped unless
+// replace is set. Returns the highest of paste counters and given counter.
+func (hr *HttpRoutes) readBackupPastes(tr *tar.Reader, counter int64, write, replace bool) (*RestoreResult, int64, error) {
+	result := &RestoreResult{}
+	var paste *restoredPaste
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, counter, err
+		}
+		entry, ok := strings.CutPrefix(header.Name, "pastes/")
+		if !ok || header.Typeflag != tar.TypeReg {
+			return result, counter, fmt.Errorf("unexpected entry %s", header.Name)
+		}
+		switch {
+		case strings.HasSuffix(entry, ".json"):
+			if err = paste.complete(); err != nil {
+				return result, counter, err
+			}
+			if paste, err = hr.readPasteMeta(tr, strings.TrimSuffix(entry, ".json"), write && !replace); err != nil {
+				return result, counter, err
+			}
+			counter = max(counter, paste.counter)
+			if paste.skip {
+				result.Skipped++
+			}
+		case paste == nil:
+			return result, counter, fmt.Errorf("unexpected entry %s", header.Name)
+		case entry == paste.name:
+			if err = hr.readPasteContent(tr, paste, header.Size, write); err != nil {
+				return result, counter, err
+			}
+			if !paste.skip {
+				result.Restored++
+			}
+		case entry == paste.name+".stats":
+			stats := &PasteStats{}
+			if err = json.NewDecoder(tr).Decode(stats); err != nil {
+				return result, counter, fmt.Errorf("%s: %s", header.Name, err)
+			}
+			if write && !paste.skip {
+				if err = hr.storage.SaveStats(paste.name, stats); err != nil {
+					return result, counter, err
+				}
+			}
+		case entry == fmt.Sprintf("%s.v%d", paste.name, paste.revisions+1):
+			if err = hr.readPasteRevision(tr, paste, write); err != nil {
+				return result, counter, err
+			}
+		default:
+			return result, counter, fmt.Errorf("unexpected entry %s", header.Name)
+		}
+	}
+	return result, counter, paste.complete()
+}
+
+// readPasteMeta starts paste, which is skipped if skipExisting is set and it
+// already exists in storage.
+func (hr *HttpRoutes) readPasteMeta(r io.Reader, name string, skipExisting bool) (*restoredPaste, error) {
+	// Names end up in file paths
+	counter, _, err := ParsePasteName(name)
+	if err != nil || strings.ContainsAny(name, "/.") {
+		return nil, fmt.Errorf("invalid paste name: %s", name)
+	}
+	paste := &restoredPaste{name: name, counter: counter, meta: &PasteMeta{}}
+	if err = json.NewDecoder(r).Decode(paste.meta); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	if skipExisting {
+		_, err = hr.storage.LoadMeta(name)
+		if err == nil {
+			slog.Warn("paste already exists, skipping", "paste", name)
+			paste.skip = true
+		} else if !errors.Is(err, ErrPasteNotFound) {
+			return nil, err
+		}
+	}
+	return paste, nil
+}
+
+func (hr *HttpRoutes) readPasteContent(r io.Reader, paste *restoredPaste, size int64, write bool) error {
+	if paste.content {
+		return fmt.Errorf("%s: duplicate content", paste.name)
+	}
+	if size != paste.meta.Size {
+		return fmt.Errorf("%s: size %d doesn't match meta", paste.name, size)
+	}
+	hash := sha256.New()
+	content := io.TeeReader(r, hash)
+	var err error
+	if write && !paste.skip {
+		err = hr.storage.Save(paste.name, paste.meta, content)
+	} else {
+		_, err = io.Copy(io.Discard, content)
+	}
+	if err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); paste.meta.SHA256 != "" && sum != paste.meta.SHA256 {
+		return fmt.Errorf("%s: checksum doesn't match meta", paste.name)
+	}
+	paste.content = true
+	if write && !paste.skip && hr.search != nil && !paste.meta.Expired() {
+		_, content, err := hr.storage.Open(paste.name)
+		if err == nil {
+			err = hr.search.Index(paste.name, paste.meta, content)
+			content.Close()
+		}
+		if err != nil {
+			slog.Warn("failed to index paste", "paste", paste.name, "error", err)
+		}
+	}
+	return nil
+}
+
+func (hr *HttpRoutes) readPasteRevision(r io.Reader, paste *restoredPaste, write bool) error {
+	if paste.revisions == len(paste.meta.Revisions) {
+		return fmt.Errorf("%s: unexpected revision %d", paste.name, paste.revisions+1)
+	}
+	paste.revisions++
+	revision := paste.meta.Revisions[paste.revisions-1]
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(content)) != revision.Size {
+		return fmt.Errorf("%s: size of revision %d doesn't match meta", paste.name, paste.revisions)
+	}
+	sum := sha256.Sum256(content)
+	if revision.SHA256 != "" && hex.EncodeToString(sum[:]) != revision.SHA256 {
+		return fmt.Errorf("%s: checksum of revision %d doesn't match meta", paste.name, paste.revisions)
+	}
+	if write && !paste.skip {
+		return hr.storage.SaveRevision(paste.name, paste.revisions, content)
+	}
+	return nil
+}
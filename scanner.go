@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrScanFailed is returned for upload which couldn't be scanned, it's
+// refused rather than stored unscanned.
+var ErrScanFailed = errors.New("virus scan failed, try again later")
+
+// ScanChunkSize is the size of chunks content is streamed to scanner in.
+const ScanChunkSize = 64 << 10
+
+// Scanner checks content for malware before paste is stored.
+type Scanner interface {
+	// Scan returns name of threat found in content, empty if it's clean.
+	Scan(content io.Reader, size int64) (string, error)
+}
+
+// NewScanner parses scanner URL: clamd://host:port or clamd:///path/to/socket
+// for ClamAV daemon, icap://host[:port]/service for ICAP service.
+// Connections are made for every scan.
+func NewScanner(rawURL string, timeout time.Duration) (Scanner, error) {
+	parsed, err := url.Parse(rawURL)
+	if err == nil {
+		switch {
+		case parsed.Scheme == "clamd" && parsed.Host != "":
+			return &ClamdScanner{network: "tcp", address: parsed.Host, timeout: timeout}, nil
+		case parsed.Scheme == "clamd" && parsed.Path != "":
+			return &ClamdScanner{network: "unix", address: parsed.Path, timeout: timeout}, nil
+		case parsed.Scheme == "icap" && parsed.Host != "":
+			address := parsed.Host
+			if parsed.Port() == "" {
+				address = net.JoinHostPort(parsed.Hostname(), "1344")
+			}
+			return &ICAPScanner{address: address, url: parsed, timeout: timeout}, nil
+		}
+	}
+	return nil, fmt.Errorf("scanner must be clamd:// or icap:// URL, got %s", rawURL)
+}
+
+// ClamdScanner streams content to clamd with INSTREAM command. Content over
+// StreamMaxLength of clamd is refused by it and fails the scan.
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+func (s *ClamdScanner) Scan(content io.Reader, size int64) (string, error) {
+	conn, err := net.DialTimeout(s.network, s.address, s.timeout)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	// Every chunk is prefixed with its length, empty one ends the stream
+	w := bufio.NewWriter(conn)
+	w.WriteString("zINSTREAM\x00")
+	chunk := make([]byte, 4+ScanChunkSize)
+	for {
+		n, err := io.ReadFull(content, chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			w.Write(chunk[:4+n])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	w.Write([]byte{0, 0, 0, 0})
+	if err = w.Flush(); err != nil {
+		return "", fmt.Errorf("clamd: %s", err)
+	}
+
+	// Reply is "stream: OK", "stream: <threat> FOUND" or "<message> ERROR"
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("clamd: %s", err)
+	}
+	reply = strings.TrimPrefix(strings.TrimRight(reply, "\x00\n"), "stream: ")
+	if reply == "OK" {
+		return "", nil
+	}
+	if threat, ok := strings.CutSuffix(reply, " FOUND"); ok {
+		return threat, nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+// ICAPScanner sends content to ICAP service as HTTP response with RESPMOD.
+// Service replies with 204 if content is clean, anything it modifies is
+// treated as threat.
+type ICAPScanner struct {
+	address string
+	url     *url.URL
+	timeout time.Duration
+}
+
+func (s *ICAPScanner) Scan(content io.Reader, size int64) (string, error) {
+	conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+	if err != nil {
+		return "", fmt.Errorf("icap: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	// Encapsulated HTTP request and response headers come first, with
+	// offsets given in Encapsulated header, then chunked body
+	reqHeader := "GET /paste HTTP/1.1\r\nHost: paast\r\n\r\n"
+	resHeader := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\n\r\n", size)
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\n", s.url)
+	fmt.Fprintf(w, "Host: %s\r\n", s.url.Host)
+	w.WriteString("Allow: 204\r\n")
+	fmt.Fprintf(w, "Encapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n", len(reqHeader), len(reqHeader)+len(resHeader))
+	w.WriteString(reqHeader)
+	w.WriteString(resHeader)
+	chunk := make([]byte, ScanChunkSize)
+	for {
+		n, err := io.ReadFull(content, chunk)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(chunk[:n])
+			w.WriteString("\r\n")
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	w.WriteString("0\r\n\r\n")
+	if err = w.Flush(); err != nil {
+		return "", fmt.Errorf("icap: %s", err)
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	line, err := reader.ReadLine()
+	if err != nil {
+		return "", fmt.Errorf("icap: %s", err)
+	}
+	_, status, _ := strings.Cut(line, " ")
+	code, _, _ := strings.Cut(status, " ")
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return "", fmt.Errorf("icap: %s", err)
+	}
+	switch code {
+	case "204":
+		return "", nil
+	case "200":
+		return ICAPThreat(header), nil
+	}
+	return "", fmt.Errorf("icap: %s", line)
+}
+
+// ICAPThreat takes threat name from headers services commonly report it
+// in, e.g. "X-Infection-Found: Type=0; Resolution=2; Threat=Eicar;".
+func ICAPThreat(header textproto.MIMEHeader) string {
+	for _, field := range strings.Split(header.Get("X-Infection-Found"), ";") {
+		if threat, ok := strings.CutPrefix(strings.TrimSpace(field), "Threat="); ok && threat != "" {
+			return threat
+		}
+	}
+	if threat := header.Get("X-Virus-ID"); threat != "" {
+		return threat
+	}
+	return "blocked by ICAP service"
+}
+
+// scanUpload quarantines infected upload. Errors are logged and reported to
+// client as ErrScanFailed only.
+func (hr *HttpRoutes) scanUpload(upload *Upload) error {
+	if hr.scanner == nil {
+		return nil
+	}
+	// Content is read from spooled file without moving its offset
+	threat, err := hr.scanner.Scan(io.NewSectionReader(upload.File, 0, upload.Size), upload.Size)
+	if err != nil {
+		slog.Error("virus scan failed", "error", err)
+		return ErrScanFailed
+	}
+	if threat != "" {
+		// Malware takes precedence over whatever content filter decided
+		upload.Quarantine = "infected: " + threat
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/mapping"
+	bolt "go.etcd.io/bbolt"
+)
+
+// SearchContentLen is how much of paste content is indexed.
+const SearchContentLen = 1 << 20
+
+// SearchOpenTimeout is how long index held by another instance is waited
+// for.
+const SearchOpenTimeout = 5 * time.Second
+
+// SearchLimit is the maximum number of search results returned.
+const SearchLimit = 50
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// SearchIndex is full-text index of text pastes, documents are keyed by
+// paste name. Index is local to the instance, it can always be rebuilt from
+// storage by removing its directory.
+type SearchIndex struct {
+	index bleve.Index
+}
+
+type searchDocument struct {
+	Content  string   `json:"content"`
+	Filename string   `json:"filename"`
+	Public   bool     `json:"public"`
+	Tags     []string `json:"tags"`
+}
+
+// searchMapping indexes tags as they are, content and filename are split
+// into words.
+func searchMapping() *mapping.IndexMappingImpl {
+	tags := bleve.NewTextFieldMapping()
+	tags.Analyzer = keyword.Name
+	document := bleve.NewDocumentMapping()
+	document.AddFieldMappingsAt("tags", tags)
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.DefaultMapping = document
+	return indexMapping
+}
+
+func NewSearchIndex(config *Config) (*SearchIndex, error) {
+	dir := config.SearchDir
+	if dir == "" {
+		dir = path.Join(config.DataDir, "search")
+	}
+	// Index can only be open by one instance, others would wait forever
+	index, err := bleve.OpenUsing(dir, map[string]interface{}{"bolt_timeout": SearchOpenTimeout.String()})
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		index, err = bleve.New(dir, searchMapping())
+	}
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("search index: %s is used by another instance, set search-dir of its own", dir)
+		}
+		return nil, fmt.Errorf("search index: %s", err)
+	}
+	return &SearchIndex{index: index}, nil
+}
+
+// Empty tells whether index has to be filled from storage.
+func (si *SearchIndex) Empty() bool {
+	count, err := si.index.DocCount()
+	return err == nil && count == 0
+}
+
+// Index adds or replaces paste, pastes which can't or shouldn't be searched
+// are removed instead.
+func (si *SearchIndex) Index(name string, meta *PasteMeta, content io.Reader) error {
+	if meta.Burn || meta.Encrypted || meta.Password != nil {
+		return si.Remove(name)
+	}
+	head, err := io.ReadAll(io.LimitReader(content, SearchContentLen))
+	if err != nil {
+		return fmt.Errorf("index paste: %s", err)
+	}
+	if IsBinary(head) {
+		return si.Remove(name)
+	}
+	if err = si.index.Index(name, searchDocument{
+		Content:  string(head),
+		Filename: meta.Filename,
+		Public:   meta.Listed(),
+		Tags:     meta.Tags,
+	}); err != nil {
+		return fmt.Errorf("index paste: %s", err)
+	}
+	return nil
+}
+
+func (si *SearchIndex) Remove(name string) error {
+	if err := si.index.Delete(name); err != nil {
+		return fmt.Errorf("unindex paste: %s", err)
+	}
+	return nil
+}
+
+// Search returns names of pastes matching query string and tagged with all
+// of tags, best first. Unless all is set, only public pastes are searched.
+func (si *SearchIndex) Search(query string, tags []string, all bool) ([]string, error) {
+	parsed, err := bleve.NewQueryStringQuery(query).Parse()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidQuery, err)
+	}
+	conjunction := bleve.NewConjunctionQuery(parsed)
+	if !all {
+		public := bleve.NewBoolFieldQuery(true)
+		public.SetField("public")
+		conjunction.AddQuery(public)
+	}
+	for _, tag := range tags {
+		term := bleve.NewTermQuery(strings.ToLower(tag))
+		term.SetField("tags")
+		conjunction.AddQuery(term)
+	}
+	request := bleve.NewSearchRequestOptions(conjunction, SearchLimit, 0, false)
+	result, err := si.index.Search(request)
+	if err != nil {
+		return nil, fmt.Errorf("search: %s", err)
+	}
+	names := []string{}
+	for _, hit := range result.Hits {
+		names = append(names, hit.ID)
+	}
+	return names, nil
+}
+
+func (si *SearchIndex) Close() error {
+	return si.index.Close()
+}
+
+// indexPaste updates search index after paste was saved. Failures are only
+// logged: paste is there, it just can't be found.
+func (hr *HttpRoutes) indexPaste(r *http.Request, name string, meta *PasteMeta) {
+	if hr.search == nil {
+		return
+	}
+	var err error
+	if meta.Burn || meta.Encrypted || meta.Password != nil {
+		err = hr.search.Remove(name)
+	} else {
+		var content io.ReadSeekCloser
+		if _, content, err = hr.storage.Open(name); err == nil {
+			err = hr.search.Index(name, meta, content)
+			content.Close()
+		}
+	}
+	if err != nil {
+		RequestLogger(r).Warn("failed to index paste", "error", err)
+	}
+}
+
+func (hr *HttpRoutes) unindexPaste(r *http.Request, name string) {
+	if hr.search == nil {
+		return
+	}
+	if err := hr.search.Remove(name); err != nil {
+		RequestLogger(r).Warn("failed to unindex paste", "error", err)
+	}
+}
+
+// Reindex fills search index with all stored pastes.
+func (hr *HttpRoutes) Reindex() {
+	names, err := hr.storage.List()
+	if err != nil {
+		slog.Error("failed to reindex pastes", "error", err)
+		return
+	}
+	indexed := 0
+	for _, name := range names {
+		meta, content, err := hr.storage.Open(name)
+		if err != nil {
+			if !errors.Is(err, ErrPasteNotFound) {
+				slog.Warn("failed to reindex paste", "paste", name, "error", err)
+			}
+			continue
+		}
+		if !meta.Expired() {
+			err = hr.search.Index(name, meta, content)
+			indexed++
+		}
+		content.Close()
+		if err != nil {
+			slog.Warn("failed to reindex paste", "paste", name, "error", err)
+		}
+	}
+	slog.Info("search index rebuilt", "pastes", indexed)
+}
+
+// searchPastes runs query and loads metadata of found pastes, dropping
+// those deleted or expired since they were indexed.
+func (hr *HttpRoutes) searchPastes(query string, tags []string, all bool) (map[string]*PasteMeta, []string, error) {
+	names, err := hr.search.Search(query, tags, all)
+	if err != nil {
+		return nil, nil, err
+	}
+	metas := map[string]*PasteMeta{}
+	found := []string{}
+	for _, name := range names {
+		meta, err := hr.storage.LoadMeta(name)
+		if err != nil {
+			if errors.Is(err, ErrPasteNotFound) {
+				continue
+			}
+			return nil, nil, err
+		}
+		if meta.Expired() || (!all && !meta.Listed()) {
+			continue
+		}
+		metas[name] = meta
+		found = append(found, name)
+	}
+	return metas, found, nil
+}
+
+// SearchPastes looks up public pastes by query given in q parameter and
+// optional tags. Results look like those of RecentPastes.
+func (hr *HttpRoutes) SearchPastes(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		if WantsHTML(r) {
+			if err := RenderRecent(rw, r, "", nil, true); err != nil {
+				panic(err)
+			}
+			return
+		}
+		WriteError(rw, r, 400, "q parameter is required")
+		return
+	}
+	metas, names, err := hr.searchPastes(query, r.URL.Query()["tag"], false)
+	if err != nil {
+		if errors.Is(err, ErrInvalidQuery) {
+			WriteError(rw, r, 400, err.Error())
+			return
+		}
+		panic(err)
+	}
+	pastes := []*RecentPaste{}
+	for _, name := range names {
+		_, hash, err := ParsePasteName(name)
+		if err != nil {
+			continue
+		}
+		pastes = append(pastes, hr.recentPaste(r, name, hash, metas[name]))
+	}
+	switch {
+	case WantsJSON(r):
+		WriteJSON(rw, 200, pastes)
+	case WantsHTML(r):
+		if err = RenderRecent(rw, r, query, pastes, true); err != nil {
+			panic(err)
+		}
+	default:
+		WritePasteList(rw, pastes)
+	}
+}
+
+// AdminSearch searches all pastes, not only public ones.
+func (hr *HttpRoutes) AdminSearch(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	if hr.search == nil {
+		WriteJSON(rw, 404, map[string]string{"error": "search is not enabled"})
+		return
+	}
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		WriteJSON(rw, 400, map[string]string{"error": "q parameter is required"})
+		return
+	}
+	metas, names, err := hr.searchPastes(query, r.URL.Query()["tag"], true)
+	if err != nil {
+		if errors.Is(err, ErrInvalidQuery) {
+			WriteJSON(rw, 400, map[string]string{"error": err.Error()})
+			return
+		}
+		panic(err)
+	}
+	pastes := []*AdminPasteInfo{}
+	for _, name := range names {
+		counter, hash, err := ParsePasteName(name)
+		if err != nil {
+			continue
+		}
+		pastes = append(pastes, NewAdminPasteInfo(counter, hash, metas[name]))
+	}
+	WriteJSON(rw, 200, pastes)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Policies for pastes which seem to contain secrets.
+const (
+	SecretsOff    = "off"
+	SecretsWarn   = "warn"
+	SecretsExpire = "expire"
+	SecretsReject = "reject"
+)
+
+// SecretPattern recognizes well-known kind of credential. Patterns are
+// specific enough to rarely match anything else, generic ones like
+// "password=" would flag half of config files.
+type SecretPattern struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+var SecretPatterns = []SecretPattern{
+	{"private key", regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+	{"AWS access key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{60,})\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{"Stripe key", regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{20,}\b`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"bearer token", regexp.MustCompile(`(?i)\bauthorization:\s*bearer\s+[A-Za-z0-9._~+/-]{20,}=*`)},
+}
+
+// DetectSecrets returns names of kinds of secrets found in upload. Binary
+// uploads, client-encrypted ones included, are not scanned.
+func DetectSecrets(upload *Upload) []string {
+	if upload.Binary() {
+		return nil
+	}
+	var found []string
+	for _, secret := range SecretPatterns {
+		// Content is read from spooled file without moving its offset
+		if secret.Pattern.MatchReader(bufio.NewReader(io.NewSectionReader(upload.File, 0, upload.Size))) {
+			found = append(found, secret.Name)
+		}
+	}
+	return found
+}
+
+// SecretsWarning is shown to creator of paste which seems to contain
+// secrets.
+func SecretsWarning(secrets []string) string {
+	return fmt.Sprintf("paste seems to contain %s, delete it if it was pasted by accident and revoke the credentials", strings.Join(secrets, ", "))
+}
+
+// checkSecrets applies secrets policy to upload: rejects it, or records
+// warning and time to live for paste to be created with.
+func (hr *HttpRoutes) checkSecrets(upload *Upload) error {
+	if hr.config.Secrets == SecretsOff {
+		return nil
+	}
+	secrets := DetectSecrets(upload)
+	if len(secrets) == 0 {
+		return nil
+	}
+	switch hr.config.Secrets {
+	case SecretsReject:
+		return fmt.Errorf("%w: paste seems to contain %s", ErrContentRejected, strings.Join(secrets, ", "))
+	case SecretsExpire:
+		if upload.MaxTTL == 0 || hr.config.SecretsExpire < upload.MaxTTL {
+			upload.MaxTTL = hr.config.SecretsExpire
+		}
+	}
+	upload.Warnings = append(upload.Warnings, SecretsWarning(secrets))
+	return nil
+}
+
+// WriteWarnings sends warnings about new paste in X-Warning headers, which
+// are seen by clients not asking for JSON too.
+func WriteWarnings(rw http.ResponseWriter, warnings []string) {
+	for _, warning := range warnings {
+		rw.Header().Add("X-Warning", warning)
+	}
+}
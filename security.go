@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// DefaultCSP allows pages nothing but their own inline styles, built-in
+// scripts and requests to the same origin. {scripts} stands for hashes of
+// built-in scripts.
+const DefaultCSP = "default-src 'none'; script-src {scripts}; style-src 'unsafe-inline'; img-src 'self' data:; connect-src 'self'; form-action 'self'; base-uri 'none'"
+
+var scriptPattern = regexp.MustCompile(`(?s)<script>(.*?)</script>`)
+
+// ScriptHashes lists CSP hashes of inline scripts of pages, which are the
+// same for every paste. Pages are rendered to get scripts the way they're
+// served, as templates strip comments from them.
+func ScriptHashes() string {
+	var hashes []string
+	for _, page := range []struct {
+		template *template.Template
+		data     map[string]any
+	}{
+		{pasteTemplate, map[string]any{"Encrypted": true}},
+		{pasteTemplate, map[string]any{}},
+		{indexTemplate, map[string]any{}},
+	} {
+		var rendered bytes.Buffer
+		if err := page.template.Execute(&rendered, page.data); err != nil {
+			panic(err)
+		}
+		for _, match := range scriptPattern.FindAllStringSubmatch(rendered.String(), -1) {
+			sum := sha256.Sum256([]byte(match[1]))
+			hashes = append(hashes, "'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'")
+		}
+	}
+	return strings.Join(hashes, " ")
+}
+
+// SecurityHeaders sets headers which keep browsers from running or framing
+// user content. Handlers serving pastes override Content-Security-Policy
+// with stricter one.
+type SecurityHeaders struct {
+	headers map[string]string
+}
+
+func NewSecurityHeaders(config *Config) *SecurityHeaders {
+	if !config.SecurityHeaders {
+		return nil
+	}
+	headers := map[string]string{
+		"Content-Security-Policy": strings.ReplaceAll(config.CSP, "{scripts}", ScriptHashes()),
+		"X-Content-Type-Options":  "nosniff",
+		"Referrer-Policy":         config.ReferrerPolicy,
+		"X-Frame-Options":         config.FrameOptions,
+	}
+	for name, value := range headers {
+		if value == "" {
+			delete(headers, name)
+		}
+	}
+	return &SecurityHeaders{headers: headers}
+}
+
+func (sh *SecurityHeaders) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		for name, value := range sh.headers {
+			rw.Header().Set(name, value)
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
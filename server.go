@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSVersions are values of tls-min-version.
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSCipherSuites parses comma-separated names of cipher suites, like
+// TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384. Only suites Go considers secure
+// are accepted, nil is returned for empty list so that Go picks them. List
+// must include one of suites required by HTTP/2.
+func TLSCipherSuites(names string) ([]uint16, error) {
+	var suites []uint16
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		id := uint16(0)
+		for _, suite := range tls.CipherSuites() {
+			if suite.Name == name {
+				id = suite.ID
+			}
+		}
+		if id == 0 {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %s", name)
+		}
+		suites = append(suites, id)
+	}
+	if len(suites) > 0 && !slices.Contains(suites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) && !slices.Contains(suites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+		return nil, errors.New("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 is required by HTTP/2")
+	}
+	return suites, nil
+}
+
+// TLSEnabled reports whether HTTPS listener should be started.
+func (c *Config) TLSEnabled() bool {
+	return c.ACMEDomains != "" || c.TLSCert != ""
+}
+
+// NewTLSConfig loads static certificate or sets up ACME certificate manager.
+// Returned manager is nil unless ACME is used.
+func NewTLSConfig(config *Config) (*tls.Config, *autocert.Manager, error) {
+	if config.ACMEDomains != "" {
+		cacheDir := config.ACMECacheDir
+		if cacheDir == "" {
+			cacheDir = path.Join(config.DataDir, "acme")
+		}
+		var domains []string
+		for _, domain := range strings.Split(config.ACMEDomains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      config.ACMEEmail,
+		}
+		tlsConfig := manager.TLSConfig()
+		if err := ApplyTLSPolicy(tlsConfig, config); err != nil {
+			return nil, nil, err
+		}
+		return tlsConfig, manager, nil
+	}
+	if config.TLSCert == "" || config.TLSKey == "" {
+		return nil, nil, errors.New("tls: both tls-cert and tls-key are required")
+	}
+	cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tls: %s", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if err = ApplyTLSPolicy(tlsConfig, config); err != nil {
+		return nil, nil, err
+	}
+	return tlsConfig, nil, nil
+}
+
+// ApplyTLSPolicy sets minimum version and cipher suites of TLS config.
+// Cipher suites only apply to TLS 1.2 and older, those of TLS 1.3 aren't
+// configurable.
+func ApplyTLSPolicy(tlsConfig *tls.Config, config *Config) error {
+	version, ok := TLSVersions[config.TLSMinVersion]
+	if !ok {
+		return fmt.Errorf("tls: unknown version %s", config.TLSMinVersion)
+	}
+	suites, err := TLSCipherSuites(config.TLSCiphers)
+	if err != nil {
+		return fmt.Errorf("tls: %s", err)
+	}
+	tlsConfig.MinVersion = version
+	tlsConfig.CipherSuites = suites
+	return nil
+}
+
+// HSTSHandler tells browsers to only use HTTPS for hsts duration. It's only
+// sent over HTTPS, browsers ignore it otherwise.
+func HSTSHandler(config *Config, handler http.Handler) http.Handler {
+	if config.HSTS <= 0 {
+		return handler
+	}
+	value := fmt.Sprintf("max-age=%d", int64(config.HSTS.Seconds()))
+	if config.HSTSSubdomains {
+		value += "; includeSubDomains"
+	}
+	if config.HSTSPreload {
+		value += "; preload"
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Strict-Transport-Security", value)
+		handler.ServeHTTP(rw, r)
+	})
+}
+
+// NewAdminTLSConfig loads certificate of admin listener, and CA its clients
+// must present certificates signed by if admin-client-ca is set.
+func NewAdminTLSConfig(config *Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.AdminTLSCert, config.AdminTLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("admin tls: %s", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if err = ApplyTLSPolicy(tlsConfig, config); err != nil {
+		return nil, err
+	}
+	if config.AdminClientCA == "" {
+		return tlsConfig, nil
+	}
+	content, err := os.ReadFile(config.AdminClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("admin tls: %s", err)
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("admin tls: no certificates in %s", config.AdminClientCA)
+	}
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
+}
+
+// NewReplicateTLSConfig loads client certificate presented to standby, and
+// CA its certificate is verified with instead of system ones if set.
+func NewReplicateTLSConfig(config *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if config.ReplicateCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.ReplicateCert, config.ReplicateKey)
+		if err != nil {
+			return nil, fmt.Errorf("replicate tls: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if config.ReplicateCA != "" {
+		content, err := os.ReadFile(config.ReplicateCA)
+		if err != nil {
+			return nil, fmt.Errorf("replicate tls: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("replicate tls: no certificates in %s", config.ReplicateCA)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// RedirectHandler sends plain HTTP clients to the same URL on HTTPS listener.
+func RedirectHandler(tlsAddr string) http.Handler {
+	_, tlsPort, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if tlsPort != "" && tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+		code := http.StatusPermanentRedirect
+		if r.Method == "GET" || r.Method == "HEAD" {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(rw, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
+
+// Server is run by Serve: HTTP, HTTPS, HTTP/3 or FastCGI server.
+type Server interface {
+	ListenAndServe() error
+	Shutdown(ctx context.Context) error
+}
+
+// httpServer is HTTP server listening on socket of option name, HTTPS one
+// if it has TLSConfig.
+type httpServer struct {
+	*http.Server
+	name string
+}
+
+func (s httpServer) ListenAndServe() error {
+	listener, err := Listen(s.name, s.Addr)
+	if err != nil {
+		return err
+	}
+	if s.TLSConfig != nil {
+		return s.ServeTLS(listener, "", "")
+	}
+	return s.Serve(listener)
+}
+
+// http3Server is HTTP/3 server listening on UDP socket of tls-listen.
+type http3Server struct {
+	*http3.Server
+}
+
+func (s http3Server) ListenAndServe() error {
+	conn, err := ListenPacket("tls-listen", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// NewServers creates plain HTTP server and, if TLS is configured, HTTPS one.
+// With TLS enabled plain listener only answers ACME challenges and redirects
+// to HTTPS, unless tls-redirect is disabled. HTTP/3 server shares address
+// of HTTPS one over UDP if http3 is set, HTTPS responses advertise it with
+// Alt-Svc. FastCGI server takes place of all of them if fastcgi is set.
+// Admin server is added if admin-listen is set, over HTTPS if admin-tls-cert
+// is set.
+func NewServers(config *Config, handler http.Handler, adminHandler http.Handler) ([]Server, error) {
+	var servers []Server
+	if config.AdminListen != "" {
+		admin := &http.Server{Addr: config.AdminListen, Handler: adminHandler}
+		if config.AdminTLSCert != "" {
+			var err error
+			if admin.TLSConfig, err = NewAdminTLSConfig(config); err != nil {
+				return nil, err
+			}
+		}
+		servers = append(servers, httpServer{admin, "admin-listen"})
+	}
+	if config.FastCGI {
+		return append(servers, &FastCGIServer{Addr: config.Listen, Handler: handler}), nil
+	}
+	if !config.TLSEnabled() {
+		return append(servers, httpServer{&http.Server{Addr: config.Listen, Handler: handler}, "listen"}), nil
+	}
+	tlsConfig, manager, err := NewTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	var plainHandler http.Handler = handler
+	if config.TLSRedirect {
+		plainHandler = RedirectHandler(config.TLSListen)
+	}
+	if manager != nil {
+		// Challenges are answered regardless of redirect setting
+		plainHandler = manager.HTTPHandler(plainHandler)
+	}
+	servers = append(servers, httpServer{&http.Server{Addr: config.Listen, Handler: plainHandler}, "listen"})
+	handler = HSTSHandler(config, handler)
+	if !config.HTTP3 {
+		return append(servers, httpServer{&http.Server{Addr: config.TLSListen, Handler: handler, TLSConfig: tlsConfig}, "tls-listen"}), nil
+	}
+	h3 := &http3.Server{Addr: config.TLSListen, Handler: handler, TLSConfig: http3.ConfigureTLSConfig(tlsConfig)}
+	altSvc := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Fails only until HTTP/3 server is listening
+		h3.SetQUICHeaders(rw.Header())
+		handler.ServeHTTP(rw, r)
+	})
+	return append(servers, httpServer{&http.Server{Addr: config.TLSListen, Handler: altSvc, TLSConfig: tlsConfig}, "tls-listen"}, http3Server{h3}), nil
+}
+
+// Serve runs all servers until one of them fails or ctx is cancelled. In the
+// latter case servers stop accepting connections and in-flight requests are
+// given up to timeout to finish.
+func Serve(ctx context.Context, servers []Server, timeout time.Duration) error {
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server Server) {
+			errs <- server.ListenAndServe()
+		}(server)
+	}
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = fmt.Errorf("shutdown: %s", shutdownErr)
+		}
+	}
+	return err
+}
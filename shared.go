@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"syscall"
+)
+
+// LockFile takes advisory lock on file, creating it if needed, how is
+// syscall.LOCK_EX or syscall.LOCK_SH. Locks belong to the open file, so they
+// exclude other goroutines as well as other instances sharing the
+// directory. Closing the file releases the lock.
+func LockFile(filename string, how int) (*os.File, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// PasteLocker is implemented by storages which can be shared by instances
+// without coordinating changes of pastes otherwise.
+type PasteLocker interface {
+	// LockPaste serializes changes made by loading paste and saving it
+	// back, until returned function is called
+	LockPaste(name string) (func(), error)
+}
+
+// lockPaste is LockPaste of storage, if it has one.
+func (hr *HttpRoutes) lockPaste(name string) (func(), error) {
+	if locker, ok := hr.storage.(PasteLocker); ok {
+		return locker.LockPaste(name)
+	}
+	return func() {}, nil
+}
+
+// SharedFile is a file replaced as a whole, possibly by another instance
+// sharing it, which is read again only once it's replaced. Callers
+// synchronize access to it.
+type SharedFile struct {
+	filename string
+	// File as it was last read or written, nil if it didn't exist
+	info os.FileInfo
+}
+
+func NewSharedFile(filename string) *SharedFile {
+	return &SharedFile{filename: filename}
+}
+
+// Read returns content of file, nil if it doesn't exist.
+func (sf *SharedFile) Read() ([]byte, error) {
+	file, err := os.Open(sf.filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			sf.info = nil
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	sf.info = info
+	return content, nil
+}
+
+// Changed tells whether file was replaced or removed since it was last read
+// or written. Replacing file always gives it a new inode.
+func (sf *SharedFile) Changed() bool {
+	info, err := os.Stat(sf.filename)
+	if err != nil {
+		return sf.info != nil
+	}
+	return sf.info == nil || !os.SameFile(info, sf.info)
+}
+
+// Lock keeps other instances from replacing file until returned file is
+// closed, callers check whether it changed meanwhile before changing it.
+func (sf *SharedFile) Lock() (*os.File, error) {
+	if err := os.MkdirAll(path.Dir(sf.filename), 0755); err != nil {
+		return nil, err
+	}
+	return LockFile(sf.filename+".lock", syscall.LOCK_EX)
+}
+
+// Write replaces file, caller must hold the lock.
+func (sf *SharedFile) Write(content []byte) error {
+	if err := WriteFileAtomic(sf.filename, content); err != nil {
+		return err
+	}
+	info, err := os.Stat(sf.filename)
+	if err != nil {
+		return err
+	}
+	sf.info = info
+	return nil
+}
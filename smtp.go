@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+// Timeouts of SMTP connections, for every read and write.
+const (
+	SMTPReadTimeout  = time.Minute
+	SMTPWriteTimeout = time.Minute
+)
+
+// SMTPServer creates pastes from emails sent to any address of smtp-domain:
+// first attachment becomes paste, or text of email if there's none. URL is
+// mailed back to envelope sender through smtp-relay. Expiry can be given after plus
+// in recipient address, e.g. paste+1d@example.com.
+type SMTPServer struct {
+	routes      *HttpRoutes
+	rateLimiter *RateLimiter
+	listener    net.Listener
+	server      *smtp.Server
+	// Address replies are sent from
+	from string
+}
+
+func NewSMTPServer(config *Config, routes *HttpRoutes, rateLimiter *RateLimiter) (*SMTPServer, error) {
+	listener, err := Listen("smtp-listen", config.SMTPListen)
+	if err != nil {
+		return nil, fmt.Errorf("smtp: %s", err)
+	}
+	ss := &SMTPServer{routes: routes, rateLimiter: rateLimiter, listener: listener, from: config.SMTPFrom}
+	if ss.from == "" {
+		ss.from = "paste@" + config.SMTPDomain
+	}
+	ss.server = smtp.NewServer(ss)
+	ss.server.Domain = config.SMTPDomain
+	// Attachments are base64-encoded, which takes four bytes per three
+	ss.server.MaxMessageBytes = config.MaxBodyLen*4/3 + 64<<10
+	ss.server.MaxRecipients = 1
+	ss.server.ReadTimeout = SMTPReadTimeout
+	ss.server.WriteTimeout = SMTPWriteTimeout
+	ss.server.ErrorLog = slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn)
+	return ss, nil
+}
+
+// Serve accepts connections until server is closed.
+func (ss *SMTPServer) Serve() error {
+	if err := ss.server.Serve(ss.listener); err != nil && !errors.Is(err, smtp.ErrServerClosed) {
+		return fmt.Errorf("smtp: %s", err)
+	}
+	return nil
+}
+
+// Close stops accepting connections and waits up to timeout for pending
+// ones.
+func (ss *SMTPServer) Close(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := ss.server.Shutdown(ctx); err != nil {
+		ss.server.Close()
+		return fmt.Errorf("smtp: %s", err)
+	}
+	return nil
+}
+
+func (ss *SMTPServer) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return &smtpSession{server: ss, addr: c.Conn().RemoteAddr().String()}, nil
+}
+
+func smtpError(code int, enhancedCode smtp.EnhancedCode, format string, args ...interface{}) error {
+	return &smtp.SMTPError{Code: code, EnhancedCode: enhancedCode, Message: fmt.Sprintf(format, args...)}
+}
+
+// smtpSession receives one email at a time, each of them is a request of
+// its own.
+type smtpSession struct {
+	server  *SMTPServer
+	addr    string
+	r       *http.Request
+	from    string
+	options *PasteMeta
+}
+
+func (s *smtpSession) Reset() {
+	s.r = nil
+	s.from = ""
+	s.options = nil
+}
+
+func (s *smtpSession) Logout() error {
+	return nil
+}
+
+func (s *smtpSession) Mail(from string, opts *smtp.MailOptions) error {
+	// Bounces can't be replied to
+	if from == "" {
+		return smtpError(550, smtp.EnhancedCode{5, 7, 1}, "null sender is refused, URL of paste is mailed back to sender")
+	}
+	if _, err := mail.ParseAddress("<" + from + ">"); err != nil {
+		return smtpError(553, smtp.EnhancedCode{5, 1, 7}, "invalid sender: %s", err)
+	}
+	r, err := ListenerRequest(s.server.routes.config.PublicURL, s.addr, "smtp")
+	if err != nil {
+		return err
+	}
+	s.r = r
+	s.from = from
+	return nil
+}
+
+func (s *smtpSession) Rcpt(to string, opts *smtp.RcptOptions) error {
+	config := s.server.routes.config
+	at := strings.LastIndex(to, "@")
+	if at == -1 || !strings.EqualFold(to[at+1:], config.SMTPDomain) {
+		return smtpError(550, smtp.EnhancedCode{5, 7, 1}, "relaying is not allowed, send to @%s", config.SMTPDomain)
+	}
+	_, expire, _ := strings.Cut(to[:at], "+")
+	options, err := s.server.routes.PasteOptions(func(name string) string {
+		if name == "expire" {
+			return expire
+		}
+		return ""
+	})
+	if err != nil {
+		return smtpError(550, smtp.EnhancedCode{5, 1, 1}, "%s", err)
+	}
+	s.options = options
+	return nil
+}
+
+func (s *smtpSession) Data(body io.Reader) (err error) {
+	// Body must be consumed before responding
+	defer io.Copy(io.Discard, body)
+	defer func() {
+		if rec := recover(); rec != nil {
+			RequestLogger(s.r).Error("internal error", "error", strings.TrimSpace(fmt.Sprint(rec)))
+			err = smtpError(451, smtp.EnhancedCode{4, 3, 0}, "internal error, ref=%s", GetRequestInfo(s.r).ID)
+		}
+	}()
+	config := s.server.routes.config
+
+	msg, err := mail.ReadMessage(body)
+	if err != nil {
+		return smtpError(550, smtp.EnhancedCode{5, 6, 0}, "invalid message: %s", err)
+	}
+	// Headers are up to whoever sent the message, replying to them would
+	// mail URL and deletion link to anyone named there
+	replyTo := s.from
+	// Auto-replies would bounce between paast and responder forever
+	if strings.EqualFold(replyTo, s.server.from) || strings.HasPrefix(strings.ToLower(msg.Header.Get("Auto-Submitted")), "auto-replied") {
+		return smtpError(550, smtp.EnhancedCode{5, 7, 1}, "auto-replies are refused")
+	}
+	if ban := s.server.routes.bans.Banned(RemoteIP(s.r)); ban != nil {
+		return smtpError(550, smtp.EnhancedCode{5, 7, 1}, "%s", BannedError(ban))
+	}
+	// Temporary failure makes sending server retry later
+	if ok, wait := s.server.rateLimiter.Take(RemoteIP(s.r)); !ok {
+		metricRateLimited.Inc()
+		return smtpError(450, smtp.EnhancedCode{4, 7, 0}, "please wait %d seconds before creating new paste", int64(math.Ceil(wait.Seconds())))
+	}
+
+	limit := config.MaxBodyLen
+	part, err := ReadMailPart(textproto.MIMEHeader(msg.Header), msg.Body, limit)
+	if err != nil {
+		return smtpError(550, smtp.EnhancedCode{5, 6, 0}, "invalid message: %s", err)
+	}
+	if part == nil {
+		return smtpError(550, smtp.EnhancedCode{5, 6, 0}, "message has neither text nor attachment")
+	}
+	spool, err := SpoolPaste(io.LimitReader(part.Content, limit+1))
+	if err != nil {
+		return smtpError(550, smtp.EnhancedCode{5, 6, 0}, "invalid message: %s", err)
+	}
+	upload := &Upload{Spool: spool, Filename: part.Filename, ContentType: part.ContentType}
+	defer upload.Close()
+	if spool.Size > limit {
+		return smtpError(552, smtp.EnhancedCode{5, 3, 4}, "paste too large, limit is %s", FormatSize(limit))
+	}
+	if spool.Size == 0 {
+		return smtpError(550, smtp.EnhancedCode{5, 6, 0}, "your paste is empty!")
+	}
+	upload.DetectContentType()
+	if err = s.server.routes.CheckContent(s.r, upload); err != nil {
+		// Sender retries later if scanner is down
+		if errors.Is(err, ErrScanFailed) {
+			return smtpError(451, smtp.EnhancedCode{4, 3, 0}, "%s", err)
+		}
+		if errors.Is(err, ErrStorageFull) {
+			return smtpError(452, smtp.EnhancedCode{4, 3, 1}, "%s", err)
+		}
+		if errors.Is(err, ErrQuotaExceeded) {
+			return smtpError(452, smtp.EnhancedCode{4, 2, 2}, "%s", err)
+		}
+		return smtpError(550, smtp.EnhancedCode{5, 7, 1}, "%s", err)
+	}
+
+	info := s.server.routes.createPaste(s.r, s.options, upload)
+	// Paste is there already, sender retrying would only make a duplicate
+	if err = s.server.reply(replyTo, msg.Header, info); err != nil {
+		RequestLogger(s.r).Error("failed to reply", "to", replyTo, "error", err)
+	}
+	return nil
+}
+
+// MailPart is part of email which becomes paste.
+type MailPart struct {
+	Content     io.Reader
+	Filename    string
+	ContentType string
+}
+
+// ReadMailPart finds part of email which becomes paste: its first
+// attachment, or its first plain text part if there's none. Text is read
+// up to limit+1 bytes while the rest of email is searched for attachments.
+func ReadMailPart(header textproto.MIMEHeader, body io.Reader, limit int64) (*MailPart, error) {
+	var text *MailPart
+	var walk func(header textproto.MIMEHeader, body io.Reader) (*MailPart, error)
+	walk = func(header textproto.MIMEHeader, body io.Reader) (*MailPart, error) {
+		mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+		if err != nil {
+			mediaType = "text/plain"
+		}
+		if strings.HasPrefix(mediaType, "multipart/") {
+			parts := multipart.NewReader(body, params["boundary"])
+			for {
+				part, err := parts.NextPart()
+				if errors.Is(err, io.EOF) {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+				if attachment, err := walk(part.Header, part); attachment != nil || err != nil {
+					return attachment, err
+				}
+			}
+		}
+
+		// Parts of multipart body are decoded by multipart reader already
+		switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+		case "base64":
+			body = base64.NewDecoder(base64.StdEncoding, body)
+		case "quoted-printable":
+			body = quotedprintable.NewReader(body)
+		}
+		disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+		filename := dispositionParams["filename"]
+		if filename == "" {
+			filename = params["name"]
+		}
+		if disposition == "attachment" || filename != "" {
+			if decoded, err := new(mime.WordDecoder).DecodeHeader(filename); err == nil {
+				filename = decoded
+			}
+			return &MailPart{Content: body, Filename: path.Base(filename), ContentType: mediaType}, nil
+		}
+		if text == nil && mediaType == "text/plain" {
+			content, err := io.ReadAll(io.LimitReader(body, limit+1))
+			if err != nil {
+				return nil, err
+			}
+			// Lines of email always end with CRLF
+			content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+			text = &MailPart{Content: bytes.NewReader(content), ContentType: header.Get("Content-Type")}
+		}
+		return nil, nil
+	}
+	attachment, err := walk(header, body)
+	if attachment != nil || err != nil {
+		return attachment, err
+	}
+	return text, nil
+}
+
+// reply mails URL of paste back to envelope sender.
+func (ss *SMTPServer) reply(to string, header mail.Header, info *PasteInfo) error {
+	config := ss.routes.config
+	relay, err := url.Parse(config.SMTPRelay)
+	if err != nil {
+		return err
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil {
+		subject = header.Get("Subject")
+	}
+	subject = strings.Join(strings.Fields("Re: "+strings.TrimPrefix(subject, "Re: ")), " ")
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", ss.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	if messageID := strings.TrimSpace(header.Get("Message-Id")); messageID != "" && !strings.ContainsAny(messageID, "\r\n") {
+		fmt.Fprintf(&msg, "In-Reply-To: %s\r\nReferences: %s\r\n", messageID, messageID)
+	}
+	msg.WriteString("Auto-Submitted: auto-replied\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(info.URL + "\r\n")
+	if !info.Duplicate {
+		fmt.Fprintf(&msg, "\r\ndelete: %s\r\n", info.DeleteURL)
+	}
+
+	// Credentials are never sent in clear, while local relay without them
+	// is likely not to have certificate
+	var client *smtp.Client
+	switch {
+	case relay.Scheme == "smtps":
+		client, err = smtp.DialTLS(relay.Host, nil)
+	case relay.User != nil:
+		client, err = smtp.DialStartTLS(relay.Host, nil)
+	default:
+		client, err = smtp.Dial(relay.Host)
+	}
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if relay.User != nil {
+		password, _ := relay.User.Password()
+		if err = client.Auth(sasl.NewPlainClient("", relay.User.Username(), password)); err != nil {
+			return err
+		}
+	}
+	if err = client.SendMail(ss.from, []string{to}, &msg); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// HeadLen is how much of paste is kept in memory for binary detection.
+const HeadLen = 8000
+
+// ContentSummer computes size, line count, checksum and binary flag of
+// content written to it piece by piece.
+type ContentSummer struct {
+	Size    int64
+	Lines   int
+	head    []byte
+	digest  hash.Hash
+	last    byte
+	invalid bool
+	// Incomplete UTF-8 sequence at the end of previous write
+	pending []byte
+}
+
+func NewContentSummer() *ContentSummer {
+	return &ContentSummer{digest: sha256.New()}
+}
+
+func (cs *ContentSummer) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	cs.digest.Write(p)
+	cs.Size += int64(len(p))
+	cs.Lines += bytes.Count(p, []byte("\n"))
+	cs.last = p[len(p)-1]
+	if len(cs.head) < HeadLen {
+		cs.head = append(cs.head, p[:min(len(p), HeadLen-len(cs.head))]...)
+	}
+	if !cs.invalid {
+		data := p
+		if len(cs.pending) > 0 {
+			data = append(cs.pending, p...)
+		}
+		// Hold back rune split between writes
+		cut := len(data)
+		for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					cut = i
+				}
+				break
+			}
+		}
+		cs.invalid = !utf8.Valid(data[:cut])
+		cs.pending = append([]byte(nil), data[cut:]...)
+	}
+	return len(p), nil
+}
+
+// SHA256 returns hex checksum of content written so far.
+func (cs *ContentSummer) SHA256() string {
+	return hex.EncodeToString(cs.digest.Sum(nil))
+}
+
+// LineCount counts last line even if it has no trailing newline.
+func (cs *ContentSummer) LineCount() int {
+	if cs.Size > 0 && cs.last != '\n' {
+		return cs.Lines + 1
+	}
+	return cs.Lines
+}
+
+// Binary applies IsBinary heuristic to content written so far.
+func (cs *ContentSummer) Binary() bool {
+	return bytes.IndexByte(cs.head, 0) != -1 || cs.invalid || len(cs.pending) > 0
+}
+
+// Head returns beginning of content, enough for http.DetectContentType.
+func (cs *ContentSummer) Head() []byte {
+	return cs.head
+}
+
+// Spool is uploaded paste kept in a temporary file, so pastes never have
+// to fit in memory. Close removes the file.
+type Spool struct {
+	*os.File
+	*ContentSummer
+}
+
+// SpoolPaste copies src to a temporary file while summing it up. Returned
+// spool is positioned at the start of content.
+func SpoolPaste(src io.Reader) (*Spool, error) {
+	file, err := os.CreateTemp("", "paast-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	spool := &Spool{File: file, ContentSummer: NewContentSummer()}
+	if _, err = io.Copy(io.MultiWriter(file, spool.ContentSummer), src); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spool.Close()
+		return nil, err
+	}
+	return spool, nil
+}
+
+// Read and Write are ambiguous between embedded file and summer.
+func (s *Spool) Read(p []byte) (int, error) {
+	return s.File.Read(p)
+}
+
+func (s *Spool) Close() error {
+	err := s.File.Close()
+	os.Remove(s.File.Name())
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PasteFormPattern matches beginning of form body in sprunge (sprunge=...)
+// or ix.io (f:1=...) style. Anything else sent as form is taken literally,
+// curl labels every --data-binary that way.
+var PasteFormPattern = regexp.MustCompile(`^(sprunge|f(:|%3[aA])[0-9]+)=`)
+
+var ErrInvalidForm = errors.New("invalid form body")
+
+// IsPasteForm tells whether upload of form body is a sprunge or ix.io form
+// rather than paste itself.
+func IsPasteForm(upload *Upload) bool {
+	mediaType, _, _ := strings.Cut(upload.ContentType, ";")
+	return strings.TrimSpace(mediaType) == "application/x-www-form-urlencoded" && PasteFormPattern.Match(upload.Head())
+}
+
+// PastesFromForm spools every sprunge and f:N field of form body, in the
+// order of their numbers, so that old scripts doing curl -d sprunge=... or
+// curl -d f:1=... keep working.
+func PastesFromForm(upload *Upload, maxParts int) ([]*Upload, error) {
+	body, err := io.ReadAll(upload)
+	if err != nil {
+		return nil, err
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidForm, err)
+	}
+	type field struct {
+		number int
+		value  string
+	}
+	var fields []field
+	for key, list := range values {
+		number := 0
+		if key != "sprunge" {
+			value, found := strings.CutPrefix(key, "f:")
+			if !found {
+				continue
+			}
+			if number, err = strconv.Atoi(value); err != nil {
+				continue
+			}
+		}
+		for _, value := range list {
+			if value != "" {
+				fields = append(fields, field{number, value})
+			}
+		}
+	}
+	if len(fields) > maxParts {
+		return nil, ErrTooManyParts
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].number < fields[j].number
+	})
+
+	var uploads []*Upload
+	for _, field := range fields {
+		spool, err := SpoolPaste(strings.NewReader(field.value))
+		if err != nil {
+			CloseUploads(uploads)
+			return nil, err
+		}
+		uploads = append(uploads, &Upload{Spool: spool})
+	}
+	return uploads, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Timeouts of SSH connections: idle one closes connections which send
+// nothing, max one limits the whole connection.
+const (
+	SSHIdleTimeout = 30 * time.Second
+	SSHMaxTimeout  = 10 * time.Minute
+)
+
+// SSHOptions are options which can be given as name=value arguments to
+// create paste over SSH.
+var SSHOptions = []string{"expire", "burn", "private", "visibility", "tags", "type", "filename"}
+
+const SSHUsage = `usage:
+  cat file | ssh -p {PORT} {HOST} [name=value ...]   create paste
+  ssh -p {PORT} {HOST} get <id>                      print paste
+  ssh -p {PORT} {HOST} delete <id>                   delete paste created with your key
+
+options: expire=1h burn=1 private=1 visibility=public tags=a,b type=text filename=main.go
+`
+
+// SSHServer creates and prints pastes over SSH. Clients with keys are
+// identified by fingerprint, pastes they create can be deleted with the
+// same key, anyone else is let in anonymously.
+type SSHServer struct {
+	routes      *HttpRoutes
+	rateLimiter *RateLimiter
+	listener    net.Listener
+	server      *ssh.Server
+}
+
+// LoadHostKey reads SSH host key, generating ed25519 one if file doesn't
+// exist yet.
+func LoadHostKey(keyPath string) (gossh.Signer, error) {
+	content, err := os.ReadFile(keyPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("ssh host key: %s", err)
+		}
+		block, err := gossh.MarshalPrivateKey(key, "paast")
+		if err != nil {
+			return nil, fmt.Errorf("ssh host key: %s", err)
+		}
+		content = pem.EncodeToMemory(block)
+		if err = os.MkdirAll(path.Dir(keyPath), 0755); err == nil {
+			err = os.WriteFile(keyPath, content, 0600)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ssh host key: %s", err)
+		}
+		slog.Info("ssh host key generated", "path", keyPath)
+	} else if err != nil {
+		return nil, fmt.Errorf("ssh host key: %s", err)
+	}
+	signer, err := gossh.ParsePrivateKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("ssh host key: %s", err)
+	}
+	return signer, nil
+}
+
+func NewSSHServer(config *Config, routes *HttpRoutes, rateLimiter *RateLimiter) (*SSHServer, error) {
+	keyPath := config.SSHHostKey
+	if keyPath == "" {
+		keyPath = path.Join(config.DataDir, "ssh_host_ed25519_key")
+	}
+	signer, err := LoadHostKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := Listen("ssh-listen", config.SSHListen)
+	if err != nil {
+		return nil, fmt.Errorf("ssh: %s", err)
+	}
+	ss := &SSHServer{routes: routes, rateLimiter: rateLimiter, listener: listener}
+	ss.server = &ssh.Server{
+		Handler:     ss.handle,
+		Version:     "paast",
+		IdleTimeout: SSHIdleTimeout,
+		MaxTimeout:  SSHMaxTimeout,
+		// Fingerprint goes to permissions of the key client authenticated
+		// with, context of connection keeps every key client offered
+		ServerConfigCallback: func(ctx ssh.Context) *gossh.ServerConfig {
+			return &gossh.ServerConfig{
+				PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+					return &gossh.Permissions{Extensions: map[string]string{"fingerprint": gossh.FingerprintSHA256(key)}}, nil
+				},
+			}
+		},
+		// Clients without keys are let in without questions
+		KeyboardInteractiveHandler: func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
+			return true
+		},
+	}
+	ss.server.AddHostKey(signer)
+	return ss, nil
+}
+
+// Serve accepts connections until server is closed.
+func (ss *SSHServer) Serve() error {
+	if err := ss.server.Serve(ss.listener); err != nil && !errors.Is(err, ssh.ErrServerClosed) {
+		return fmt.Errorf("ssh: %s", err)
+	}
+	return nil
+}
+
+// Close stops accepting connections and waits up to timeout for pending
+// ones.
+func (ss *SSHServer) Close(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := ss.server.Shutdown(ctx); err != nil {
+		ss.server.Close()
+		return fmt.Errorf("ssh: %s", err)
+	}
+	return nil
+}
+
+// SSHFingerprint returns fingerprint of key client authenticated with, empty
+// for anonymous clients.
+func SSHFingerprint(sess ssh.Session) string {
+	if conn, ok := sess.Context().Value(ssh.ContextKeyConn).(*gossh.ServerConn); ok && conn.Permissions != nil {
+		return conn.Permissions.Extensions["fingerprint"]
+	}
+	return ""
+}
+
+func (ss *SSHServer) handle(sess ssh.Session) {
+	r, err := ListenerRequest(ss.routes.config.PublicURL, sess.RemoteAddr().String(), "ssh")
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			RequestLogger(r).Error("internal error", "error", strings.TrimSpace(fmt.Sprint(rec)))
+			fmt.Fprintf(sess.Stderr(), "error: internal error, ref=%s\n", GetRequestInfo(r).ID)
+			sess.Exit(1)
+		}
+	}()
+
+	owner := SSHFingerprint(sess)
+	args := sess.Command()
+	command := "create"
+	if len(args) > 0 && !strings.Contains(args[0], "=") {
+		command, args = args[0], args[1:]
+	}
+	var code int
+	switch {
+	case command == "create":
+		// Nothing is piped to interactive session
+		if _, _, isPty := sess.Pty(); isPty && len(sess.Command()) == 0 {
+			io.WriteString(sess, ss.usage())
+			break
+		}
+		code = ss.create(sess, r, owner, args)
+	case command == "get" && len(args) == 1:
+		code = ss.get(sess, r, args[0])
+	case command == "delete" && len(args) == 1:
+		code = ss.delete(sess, r, owner, args[0])
+	case command == "help":
+		io.WriteString(sess, ss.usage())
+	default:
+		io.WriteString(sess.Stderr(), ss.usage())
+		code = 2
+	}
+	sess.Exit(code)
+}
+
+func (ss *SSHServer) usage() string {
+	host := strings.TrimSuffix(ss.routes.config.PublicURL, "/")
+	host = host[strings.Index(host, "://")+3:]
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	_, port, _ := net.SplitHostPort(ss.routes.config.SSHListen)
+	return strings.NewReplacer("{HOST}", host, "{PORT}", port).Replace(SSHUsage)
+}
+
+func sshError(sess ssh.Session, format string, args ...interface{}) int {
+	fmt.Fprintf(sess.Stderr(), "error: "+format+"\n", args...)
+	return 1
+}
+
+func (ss *SSHServer) create(sess ssh.Session, r *http.Request, owner string, args []string) int {
+	config := ss.routes.config
+	values := map[string]string{}
+	for _, arg := range args {
+		name, value, _ := strings.Cut(arg, "=")
+		if !slices.Contains(SSHOptions, name) {
+			return sshError(sess, "unknown option: %s", name)
+		}
+		values[name] = value
+	}
+	options, err := ss.routes.PasteOptions(func(name string) string {
+		return values[name]
+	})
+	if err != nil {
+		return sshError(sess, "%s", err)
+	}
+	options.Owner = owner
+
+	if ban := ss.routes.bans.Banned(RemoteIP(r)); ban != nil {
+		return sshError(sess, "%s", BannedError(ban))
+	}
+	if ok, wait := ss.rateLimiter.Take(RemoteIP(r)); !ok {
+		metricRateLimited.Inc()
+		return sshError(sess, "please wait %d seconds before creating new paste", int64(math.Ceil(wait.Seconds())))
+	}
+	limit := config.MaxBodyLen
+	spool, err := SpoolPaste(io.LimitReader(sess, limit+1))
+	if err != nil {
+		panic(err)
+	}
+	upload := &Upload{Spool: spool, Filename: values["filename"]}
+	defer upload.Close()
+	if spool.Size > limit {
+		return sshError(sess, "paste too large, limit is %s", FormatSize(limit))
+	}
+	if spool.Size == 0 {
+		return sshError(sess, "your paste is empty!")
+	}
+	upload.DetectContentType()
+	if err = ss.routes.CheckContent(r, upload); err != nil {
+		return sshError(sess, "%s", err)
+	}
+	if err = CheckRedirect(options, false, []*Upload{upload}); err != nil {
+		return sshError(sess, "%s", err)
+	}
+
+	info := ss.routes.createPaste(r, options, upload)
+	io.WriteString(sess, info.URL+"\n")
+	if !info.Duplicate {
+		fmt.Fprintf(sess.Stderr(), "delete: %s\n", info.DeleteURL)
+	}
+	for _, warning := range info.Warnings {
+		fmt.Fprintf(sess.Stderr(), "warning: %s\n", warning)
+	}
+	return 0
+}
+
+func (ss *SSHServer) get(sess ssh.Session, r *http.Request, hash string) int {
+	meta, content, err := ss.routes.readPaste(r, hash, "")
+	if err == nil {
+		err = ss.routes.viewPaste(r, hash, meta)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrPasteNotFound):
+			return sshError(sess, "paste with id \"%s\" was not found", hash)
+		case errors.Is(err, ErrPasswordRequired):
+			return sshError(sess, "paste is protected, it can only be read over HTTP with password")
+		}
+		panic(err)
+	}
+	sess.Write(content)
+	return 0
+}
+
+func (ss *SSHServer) delete(sess ssh.Session, r *http.Request, owner string, hash string) int {
+	hr := ss.routes
+	name := hr.HashName(hash)
+	meta, err := hr.storage.LoadMeta(name)
+	if err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			return sshError(sess, "paste with id \"%s\" was not found", hash)
+		}
+		panic(err)
+	}
+	if owner == "" || meta.Owner != owner {
+		return sshError(sess, "paste was not created with your key")
+	}
+	if err = hr.storage.Delete(name); err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			return sshError(sess, "paste with id \"%s\" was not found", hash)
+		}
+		panic(err)
+	}
+	hr.unindexPaste(r, name)
+	hr.usage.Release(meta)
+	SetPasteID(r, hash)
+	RequestLogger(r).Info("paste deleted")
+	fmt.Fprintf(sess, "paste with id \"%s\" was deleted\n", hash)
+	return 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StatsCacheTTL is how long instance stats are reused, counting them loads
+// meta of every paste.
+const StatsCacheTTL = time.Minute
+
+// InstanceStats are aggregate numbers shown publicly at /stats.
+type InstanceStats struct {
+	// Pastes which haven't expired, including revisions in bytes
+	Pastes int64 `json:"pastes"`
+	Bytes  int64 `json:"bytes"`
+	// Pastes created within the last 24 hours
+	PastesDay int64     `json:"pastes_24h"`
+	Started   time.Time `json:"started"`
+	Uptime    int64     `json:"uptime_seconds"`
+	Counted   time.Time `json:"counted"`
+}
+
+// instanceStats counts pastes at most once per StatsCacheTTL.
+type instanceStats struct {
+	started time.Time
+	mu      sync.Mutex
+	stats   *InstanceStats
+}
+
+func (hr *HttpRoutes) countStats() (*InstanceStats, error) {
+	cache := &hr.instanceStats
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	now := time.Now()
+	if cache.stats == nil || now.Sub(cache.stats.Counted) >= StatsCacheTTL {
+		names, err := hr.storage.List()
+		if err != nil {
+			return nil, err
+		}
+		stats := &InstanceStats{Started: cache.started, Counted: now}
+		for _, name := range names {
+			meta, err := hr.storage.LoadMeta(name)
+			if err != nil {
+				// Deleted while counting
+				if errors.Is(err, ErrPasteNotFound) {
+					continue
+				}
+				return nil, err
+			}
+			if meta.Expired() {
+				continue
+			}
+			stats.Pastes++
+			stats.Bytes += meta.StoredSize()
+			if now.Sub(meta.Created) < 24*time.Hour {
+				stats.PastesDay++
+			}
+		}
+		cache.stats = stats
+	}
+	stats := *cache.stats
+	stats.Uptime = int64(now.Sub(stats.Started).Seconds())
+	return &stats, nil
+}
+
+// Stats shows total number and size of pastes, pastes created within a day
+// and uptime, as plain text or JSON.
+func (hr *HttpRoutes) Stats(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	stats, err := hr.countStats()
+	if err != nil {
+		panic(err)
+	}
+	if WantsJSON(r) {
+		WriteJSON(rw, 200, stats)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.WriteHeader(200)
+	fmt.Fprintf(rw, "pastes: %d\nbytes: %d\npastes in last 24h: %d\nuptime: %s\n",
+		stats.Pastes, stats.Bytes, stats.PastesDay, (time.Duration(stats.Uptime) * time.Second).String())
+}
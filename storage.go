@@ -0,0 +1,913 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var ErrPasteNotFound = errors.New("paste not found")
+
+type PasteMeta struct {
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Burn    bool       `json:"burn,omitempty"`
+	// SHA-256 of deletion token, token itself is only known to creator
+	DeleteHash string `json:"delete_hash,omitempty"`
+	// SHA-256 of edit token
+	EditHash string     `json:"edit_hash,omitempty"`
+	Updated  *time.Time `json:"updated,omitempty"`
+	Size     int64      `json:"size,omitempty"`
+	Lines    int        `json:"lines,omitempty"`
+	SHA256   string     `json:"sha256,omitempty"`
+	// Content-Type paste was created with, or detected type of binary paste
+	ContentType string `json:"content_type,omitempty"`
+	// Name of uploaded file, set for pastes created from multipart body
+	Filename string `json:"filename,omitempty"`
+	// Set for pastes encrypted by client, key is never sent to server
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Set for pastes stored encrypted with key derived from password
+	Password *PasswordKDF `json:"password,omitempty"`
+	// Private pastes are only reachable by ID with signature
+	Private bool `json:"private,omitempty"`
+	// VisibilityPublic or VisibilityUnlisted, empty means unlisted
+	Visibility string   `json:"visibility,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	// Language detected on creation, e.g. Go or Python
+	Language string `json:"language,omitempty"`
+	// Hash of paste this one was forked from
+	Parent string `json:"parent,omitempty"`
+	// TypeText or TypeRedirect if asked for on creation
+	Type string `json:"type,omitempty"`
+	// URL paste redirects to, content of redirect paste
+	Redirect string `json:"redirect,omitempty"`
+	// Prior versions of edited paste, oldest first
+	Revisions []Revision `json:"revisions,omitempty"`
+	// Address of creator, only exposed through admin API
+	IP string `json:"ip,omitempty"`
+	// Name of API key paste was created with, only exposed through admin API
+	APIKey string `json:"api_key,omitempty"`
+	// Fingerprint of SSH key paste was created with, the key can delete it
+	Owner string `json:"owner,omitempty"`
+	// Reports of abuse by visitors, at most one per address
+	Reports []Report `json:"reports,omitempty"`
+	// Set once operator takes paste down, its content is erased
+	Takedown *Takedown `json:"takedown,omitempty"`
+	// Reason content filter held paste for review for, until operator
+	// dismisses it
+	Quarantine string `json:"quarantine,omitempty"`
+}
+
+// Revision describes prior version of paste, its content is stored
+// separately. Revisions are numbered from 1.
+type Revision struct {
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+// Report flags paste as illegal or abusive for operators to review.
+type Report struct {
+	Created time.Time `json:"created"`
+	Reason  string    `json:"reason"`
+	IP      string    `json:"ip,omitempty"`
+}
+
+// Takedown is the tombstone of paste removed by operator, it's served with
+// 451 instead of the content.
+type Takedown struct {
+	Created time.Time `json:"created"`
+	Reason  string    `json:"reason"`
+}
+
+// PasteStats is kept apart from PasteMeta since it changes on every read.
+type PasteStats struct {
+	Views      int64      `json:"views"`
+	LastViewed *time.Time `json:"last_viewed,omitempty"`
+}
+
+// SetContent records size, line count and checksum of paste content.
+func (m *PasteMeta) SetContent(content []byte) {
+	summer := NewContentSummer()
+	summer.Write(content)
+	m.SetSummary(summer)
+}
+
+// SetSummary is SetContent for content which was streamed through summer.
+func (m *PasteMeta) SetSummary(summer *ContentSummer) {
+	m.Size = summer.Size
+	m.Lines = summer.LineCount()
+	m.SHA256 = summer.SHA256()
+}
+
+// Modified returns time of last edit or creation.
+func (m *PasteMeta) Modified() time.Time {
+	if m.Updated != nil {
+		return *m.Updated
+	}
+	return m.Created
+}
+
+func (m *PasteMeta) Expired() bool {
+	return m.Expires != nil && time.Now().After(*m.Expires)
+}
+
+// HasTags tells whether paste is tagged with all of given tags.
+func (m *PasteMeta) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(m.Tags, strings.ToLower(tag)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Listed tells whether paste may show up in listings, feeds and search.
+func (m *PasteMeta) Listed() bool {
+	return m.Visibility == VisibilityPublic && !m.Private && !m.Burn && !m.Expired() && m.Takedown == nil && m.Quarantine == ""
+}
+
+// Paste visibility, unlisted pastes are only reachable by those who know
+// their ID.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+)
+
+// Storage persists pastes along with the counter used to generate paste IDs.
+// Pastes are addressed by name as returned by PasteName.
+type Storage interface {
+	NextCounter() (int64, error)
+	// AdvanceCounter makes sure NextCounter returns values above counter,
+	// e.g. once pastes are restored from backup
+	AdvanceCounter(counter int64) error
+	// Save creates paste or replaces existing one, readers must never see
+	// partially written content. Content is streamed, meta.Size must match it
+	Save(name string, meta *PasteMeta, content io.Reader) error
+	LoadMeta(name string) (*PasteMeta, error)
+	Load(name string) (*PasteMeta, []byte, error)
+	// Open is Load which streams content if storage is able to
+	Open(name string) (*PasteMeta, io.ReadSeekCloser, error)
+	// SaveRevision keeps prior content of paste, revisions are deleted
+	// along with the paste
+	SaveRevision(name string, revision int, content []byte) error
+	LoadRevision(name string, revision int) ([]byte, error)
+	// RecordView atomically increments view counter of paste
+	RecordView(name string, at time.Time) error
+	// SaveStats replaces stats of paste, e.g. ones restored from backup
+	SaveStats(name string, stats *PasteStats) error
+	// LoadStats returns zero stats for pastes which were never viewed
+	LoadStats(name string) (*PasteStats, error)
+	// FindByHash returns name of paste saved with given SHA-256 of content,
+	// burn-after-read pastes are not indexed. Index may lag behind edits, so
+	// callers must check meta of the paste
+	FindByHash(sum string) (string, error)
+	// Delete must return ErrPasteNotFound to all but one of concurrent callers,
+	// burn-after-read pastes rely on this.
+	Delete(name string) error
+	// List returns names of all stored pastes in no particular order
+	List() ([]string, error)
+	// Close flushes pending writes and releases connections
+	Close() error
+}
+
+// Migrator is implemented by storages whose layout depends on options, so
+// existing pastes have to be rewritten when options change.
+type Migrator interface {
+	// Migrate returns number of rewritten files
+	Migrate() (int, error)
+}
+
+// OrphanCollector is implemented by storages which may leave files of
+// deleted pastes behind, e.g. stats of burn-after-read paste written by
+// reader racing the one who burned it, or meta of interrupted upload.
+type OrphanCollector interface {
+	// CollectOrphans removes such files, and temporary files of crashed
+	// writes, older than minAge: pastes being saved look like orphans
+	// meanwhile. Returns their total size
+	CollectOrphans(minAge time.Duration) (int64, error)
+}
+
+func PasteName(counter int64, hash string) string {
+	return fmt.Sprintf("%09d_%s", counter, hash)
+}
+
+// ParsePasteName is the reverse of PasteName.
+func ParsePasteName(name string) (int64, string, error) {
+	parts := strings.SplitN(name, "_", 2)
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("invalid paste name: %s", name)
+	}
+	counter, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid paste name: %s", name)
+	}
+	return counter, parts[1], nil
+}
+
+func NewStorage(config *Config) (Storage, error) {
+	switch config.Storage {
+	case "file":
+		return NewFileStorage(config.DataDir, config.StorageCompress, config.StorageSharded)
+	case "s3":
+		return NewS3Storage(
+			config.S3Endpoint,
+			config.S3Bucket,
+			config.S3Prefix,
+			config.S3Region,
+			config.S3AccessKey,
+			config.S3SecretKey,
+			config.S3Insecure,
+		)
+	case "redis":
+		return NewRedisStorage(config.RedisURL, config.RedisPrefix)
+	case "postgres":
+		return NewPostgresStorage(config.PostgresDSN)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", config.Storage)
+	}
+}
+
+// ReadCounter returns 0 for missing or empty counter file. Garbage is an
+// error: starting over would hand out IDs of existing pastes again.
+func ReadCounter(filename string) (int64, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("read counter: %s", err)
+	}
+	text := strings.TrimSpace(string(content))
+	if text == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("read counter: %s is corrupt, set it to the highest paste number to continue", filename)
+	}
+	return value, nil
+}
+
+// SyncDir flushes directory entries, making renames within it durable.
+func SyncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+func ReadMeta(filename string) (*PasteMeta, error) {
+	meta := &PasteMeta{}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		// Pastes created before metadata was introduced have no meta file
+		if os.IsNotExist(err) {
+			return meta, nil
+		}
+		return nil, fmt.Errorf("read meta: %s", err)
+	}
+	if err = json.Unmarshal(content, meta); err != nil {
+		return nil, fmt.Errorf("read meta: %s", err)
+	}
+	return meta, nil
+}
+
+func ReadStats(filename string) (*PasteStats, error) {
+	stats := &PasteStats{}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stats, nil
+		}
+		return nil, fmt.Errorf("read stats: %s", err)
+	}
+	if err = json.Unmarshal(content, stats); err != nil {
+		return nil, fmt.Errorf("read stats: %s", err)
+	}
+	return stats, nil
+}
+
+func WriteMeta(filename string, meta *PasteMeta) error {
+	content, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("write meta: %s", err)
+	}
+	if err = WriteFileAtomic(filename, content); err != nil {
+		return diskError("write meta", err)
+	}
+	return nil
+}
+
+// diskError adds context to error of writing file, disk running out of
+// space becomes ErrStorageFull.
+func diskError(context string, err error) error {
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		return fmt.Errorf("%w: %s: %s", ErrStorageFull, context, err)
+	}
+	return fmt.Errorf("%s: %s", context, err)
+}
+
+// WriteFileAtomic replaces file with a synced temporary one, so readers
+// see either old or new content.
+func WriteFileAtomic(filename string, content []byte) error {
+	return WriteFileAtomicFunc(filename, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
+
+// WriteFileAtomicFunc is WriteFileAtomic with content streamed by write.
+func WriteFileAtomicFunc(filename string, write func(w io.Writer) error) error {
+	file, err := os.CreateTemp(path.Dir(filename), path.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err = write(file); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// ZstdSuffix is appended to names of paste and revision files compressed
+// with zstd. Either variant is read no matter if compression is enabled.
+const ZstdSuffix = ".zst"
+
+// ZstdMinSize is the smallest content worth compressing.
+const ZstdMinSize = 1024
+
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// PasteLockBuckets is the number of lock files pastes are spread over.
+const PasteLockBuckets = 256
+
+// FileStorage keeps pastes in a local directory, one file per paste plus
+// a ".meta" file next to it. Directory may be shared by instances: changes
+// are coordinated with advisory locks on files under locks/.
+type FileStorage struct {
+	dir      string
+	compress bool
+	sharded  bool
+	lock     sync.Mutex
+}
+
+func NewFileStorage(dir string, compress bool, sharded bool) (*FileStorage, error) {
+	for _, subdir := range []string{"pastes", "hashes", "locks"} {
+		if err := os.MkdirAll(path.Join(dir, subdir), 0755); err != nil {
+			return nil, fmt.Errorf("file storage: %s", err)
+		}
+	}
+	return &FileStorage{dir: dir, compress: compress, sharded: sharded}, nil
+}
+
+// writeContent streams size bytes of content to file, compressed if enabled
+// and content is big enough, then removes the other variant of the file.
+func (fs *FileStorage) writeContent(filename string, content io.Reader, size int64) error {
+	target, stale := filename, filename+ZstdSuffix
+	write := func(w io.Writer) error {
+		_, err := io.Copy(w, content)
+		return err
+	}
+	if fs.compress && size >= ZstdMinSize {
+		target, stale = filename+ZstdSuffix, filename
+		write = func(w io.Writer) error {
+			encoder, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				return err
+			}
+			if _, err = io.Copy(encoder, content); err != nil {
+				encoder.Close()
+				return err
+			}
+			return encoder.Close()
+		}
+	}
+	if err := WriteFileAtomicFunc(target, write); err != nil {
+		return err
+	}
+	if err := os.Remove(stale); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readContent reads file written by writeContent. Compressed variant is
+// checked again if plain one is gone, as it could be replaced meanwhile.
+func readContent(filename string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		content, err := ioutil.ReadFile(filename + ZstdSuffix)
+		if err == nil {
+			return zstdDecoder.DecodeAll(content, nil)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		content, err = ioutil.ReadFile(filename)
+		if err == nil || !os.IsNotExist(err) || attempt > 0 {
+			return content, err
+		}
+	}
+}
+
+// openContent streams plain file. Compressed one is decompressed in memory,
+// as content has to be seekable.
+func openContent(filename string) (io.ReadSeekCloser, error) {
+	file, err := os.Open(filename)
+	if err == nil || !os.IsNotExist(err) {
+		return file, err
+	}
+	content, err := readContent(filename)
+	if err != nil {
+		return nil, err
+	}
+	return BytesStream(content), nil
+}
+
+// BytesStream wraps in-memory content for storages which can't stream.
+func BytesStream(content []byte) io.ReadSeekCloser {
+	return nopSeekCloser{bytes.NewReader(content)}
+}
+
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+// contentExists checks for either variant of content file.
+func contentExists(filename string) (bool, error) {
+	for _, candidate := range []string{filename + ZstdSuffix, filename} {
+		if _, err := os.Stat(candidate); err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// ShardDir spreads pastes over two levels of directories by counter, each
+// holding a thousand of entries, e.g. 001/234 for paste number 1234567.
+func ShardDir(name string) string {
+	counter, _, _ := ParsePasteName(name)
+	return fmt.Sprintf("%03d/%03d", counter/1000000, counter/1000%1000)
+}
+
+func (fs *FileStorage) pastePath(name string) string {
+	if fs.sharded {
+		return path.Join(fs.dir, "pastes", ShardDir(name), name)
+	}
+	return path.Join(fs.dir, "pastes", name)
+}
+
+// hashPath is a file with name of paste having content with given SHA-256.
+// Sharded layout groups them by first two characters.
+func (fs *FileStorage) hashPath(sum string) string {
+	if fs.sharded && len(sum) > 2 {
+		return path.Join(fs.dir, "hashes", sum[:2], sum)
+	}
+	return path.Join(fs.dir, "hashes", sum)
+}
+
+func (fs *FileStorage) NextCounter() (int64, error) {
+	return fs.updateCounter("next counter", func(counter int64) int64 {
+		return counter + 1
+	})
+}
+
+func (fs *FileStorage) AdvanceCounter(counter int64) error {
+	_, err := fs.updateCounter("advance counter", func(current int64) int64 {
+		return max(current, counter)
+	})
+	return err
+}
+
+// updateCounter replaces counter with the value returned by update, which
+// is left untouched if it's unchanged.
+func (fs *FileStorage) updateCounter(op string, update func(int64) int64) (int64, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	// Mutex only lets Close wait for update in progress, flock keeps other
+	// instances sharing data-dir out. Counter file is replaced on every
+	// update, so a separate file holds the lock
+	lockFile, err := LockFile(path.Join(fs.dir, "counter.lock"), syscall.LOCK_EX)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", op, err)
+	}
+	defer lockFile.Close()
+
+	counterPath := path.Join(fs.dir, "counter.dat")
+	current, err := ReadCounter(counterPath)
+	if err != nil {
+		return 0, err
+	}
+	counter := update(current)
+	if counter == current {
+		return counter, nil
+	}
+	// Counter must hit the disk before its value is handed out, otherwise
+	// a crash could make it reuse IDs. Rename leaves either old or new value
+	// after a crash, never a torn one
+	if err = WriteFileAtomic(counterPath, []byte(fmt.Sprint(counter))); err != nil {
+		return 0, diskError(op, err)
+	}
+	if err = SyncDir(fs.dir); err != nil {
+		return 0, fmt.Errorf("%s: %s", op, err)
+	}
+	return counter, nil
+}
+
+// lockPaste takes lock of kind on paste, which is "content" for its meta
+// and content files, "stats" or "edit". Pastes of a bucket share locks.
+func (fs *FileStorage) lockPaste(name, kind string, how int) (*os.File, error) {
+	bucket := crc32.ChecksumIEEE([]byte(name)) % PasteLockBuckets
+	return LockFile(path.Join(fs.dir, "locks", fmt.Sprintf("%02x.%s", bucket, kind)), how)
+}
+
+func (fs *FileStorage) LockPaste(name string) (func(), error) {
+	lockFile, err := fs.lockPaste(name, "edit", syscall.LOCK_EX)
+	if err != nil {
+		return nil, fmt.Errorf("lock paste: %s", err)
+	}
+	return func() { lockFile.Close() }, nil
+}
+
+func (fs *FileStorage) Save(name string, meta *PasteMeta, content io.Reader) error {
+	pastePath := fs.pastePath(name)
+	if fs.sharded {
+		for _, dir := range []string{path.Dir(pastePath), path.Dir(fs.hashPath(meta.SHA256))} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("save paste: %s", err)
+			}
+		}
+	}
+	// Readers see meta and content of the same version
+	lockFile, err := fs.lockPaste(name, "content", syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("save paste: %s", err)
+	}
+	defer lockFile.Close()
+	// Meta goes first: paste is only visible once its content file exists
+	if err := WriteMeta(pastePath+".meta", meta); err != nil {
+		return err
+	}
+	if err := fs.writeContent(pastePath, content, meta.Size); err != nil {
+		return diskError("save paste", err)
+	}
+	if meta.SHA256 != "" && !meta.Burn {
+		if err := WriteFileAtomic(fs.hashPath(meta.SHA256), []byte(name)); err != nil {
+			return diskError("save paste", err)
+		}
+	}
+	return nil
+}
+
+func (fs *FileStorage) LoadMeta(name string) (*PasteMeta, error) {
+	pastePath := fs.pastePath(name)
+	if exists, err := contentExists(pastePath); err != nil {
+		return nil, fmt.Errorf("load meta: %s", err)
+	} else if !exists {
+		return nil, ErrPasteNotFound
+	}
+	return ReadMeta(pastePath + ".meta")
+}
+
+func (fs *FileStorage) Load(name string) (*PasteMeta, []byte, error) {
+	pastePath := fs.pastePath(name)
+	lockFile, err := fs.lockPaste(name, "content", syscall.LOCK_SH)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load paste: %s", err)
+	}
+	defer lockFile.Close()
+	content, err := readContent(pastePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrPasteNotFound
+		}
+		return nil, nil, fmt.Errorf("load paste: %s", err)
+	}
+	meta, err := ReadMeta(pastePath + ".meta")
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, content, nil
+}
+
+func (fs *FileStorage) Open(name string) (*PasteMeta, io.ReadSeekCloser, error) {
+	pastePath := fs.pastePath(name)
+	// Content stays readable once it's open, even if it's replaced
+	lockFile, err := fs.lockPaste(name, "content", syscall.LOCK_SH)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open paste: %s", err)
+	}
+	defer lockFile.Close()
+	content, err := openContent(pastePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrPasteNotFound
+		}
+		return nil, nil, fmt.Errorf("open paste: %s", err)
+	}
+	meta, err := ReadMeta(pastePath + ".meta")
+	if err != nil {
+		content.Close()
+		return nil, nil, err
+	}
+	return meta, content, nil
+}
+
+func (fs *FileStorage) SaveRevision(name string, revision int, content []byte) error {
+	if err := fs.writeContent(fmt.Sprintf("%s.v%d", fs.pastePath(name), revision), bytes.NewReader(content), int64(len(content))); err != nil {
+		return diskError("save revision", err)
+	}
+	return nil
+}
+
+func (fs *FileStorage) LoadRevision(name string, revision int) ([]byte, error) {
+	content, err := readContent(fmt.Sprintf("%s.v%d", fs.pastePath(name), revision))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrPasteNotFound
+		}
+		return nil, fmt.Errorf("load revision: %s", err)
+	}
+	return content, nil
+}
+
+func (fs *FileStorage) RecordView(name string, at time.Time) error {
+	lockFile, err := fs.lockPaste(name, "stats", syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("record view: %s", err)
+	}
+	defer lockFile.Close()
+
+	pastePath := fs.pastePath(name)
+	if exists, err := contentExists(pastePath); err != nil {
+		return fmt.Errorf("record view: %s", err)
+	} else if !exists {
+		return ErrPasteNotFound
+	}
+	stats, err := ReadStats(pastePath + ".stats")
+	if err != nil {
+		return err
+	}
+	stats.Views++
+	stats.LastViewed = &at
+	content, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("record view: %s", err)
+	}
+	if err = WriteFileAtomic(pastePath+".stats", content); err != nil {
+		return fmt.Errorf("record view: %s", err)
+	}
+	return nil
+}
+
+func (fs *FileStorage) SaveStats(name string, stats *PasteStats) error {
+	lockFile, err := fs.lockPaste(name, "stats", syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("save stats: %s", err)
+	}
+	defer lockFile.Close()
+
+	content, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("save stats: %s", err)
+	}
+	if err = WriteFileAtomic(fs.pastePath(name)+".stats", content); err != nil {
+		return diskError("save stats", err)
+	}
+	return nil
+}
+
+func (fs *FileStorage) LoadStats(name string) (*PasteStats, error) {
+	return ReadStats(fs.pastePath(name) + ".stats")
+}
+
+func (fs *FileStorage) FindByHash(sum string) (string, error) {
+	// Checksum is hex, anything else could escape the directory
+	if _, err := hex.DecodeString(sum); err != nil || sum == "" {
+		return "", ErrPasteNotFound
+	}
+	name, err := ioutil.ReadFile(fs.hashPath(sum))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrPasteNotFound
+		}
+		return "", fmt.Errorf("find by hash: %s", err)
+	}
+	return string(name), nil
+}
+
+func (fs *FileStorage) Delete(name string) error {
+	pastePath := fs.pastePath(name)
+	lockFile, err := fs.lockPaste(name, "content", syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("delete paste: %s", err)
+	}
+	defer lockFile.Close()
+	// Unlink is atomic, so only one of concurrent callers succeeds
+	err = os.Remove(pastePath + ZstdSuffix)
+	if os.IsNotExist(err) {
+		err = os.Remove(pastePath)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrPasteNotFound
+		}
+		return fmt.Errorf("delete paste: %s", err)
+	}
+	if meta, err := ReadMeta(pastePath + ".meta"); err == nil && meta.SHA256 != "" {
+		if indexed, err := fs.FindByHash(meta.SHA256); err == nil && indexed == name {
+			os.Remove(fs.hashPath(meta.SHA256))
+		}
+	}
+	revisions, _ := filepath.Glob(pastePath + ".v*")
+	for _, filename := range append([]string{pastePath + ".meta", pastePath + ".stats"}, revisions...) {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("delete paste: %s", err)
+		}
+	}
+	return nil
+}
+
+func (fs *FileStorage) List() ([]string, error) {
+	names := []string{}
+	seen := map[string]bool{}
+	err := filepath.WalkDir(path.Join(fs.dir, "pastes"), func(filename string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		// Skip ".meta" files
+		name := strings.TrimSuffix(entry.Name(), ZstdSuffix)
+		if !strings.Contains(name, ".") && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list pastes: %s", err)
+	}
+	return names, nil
+}
+
+func (fs *FileStorage) CollectOrphans(minAge time.Duration) (int64, error) {
+	var reclaimed int64
+	err := filepath.WalkDir(path.Join(fs.dir, "pastes"), func(filename string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		// Meta, stats and revisions are named after content file
+		name, _, ok := strings.Cut(entry.Name(), ".")
+		if !ok || name == "" {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < minAge {
+			return nil
+		}
+		// Temporary files are only left by crashed writes
+		if !strings.HasSuffix(entry.Name(), ".tmp") {
+			if exists, err := contentExists(path.Join(path.Dir(filename), name)); err != nil || exists {
+				return err
+			}
+		}
+		if err = os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		reclaimed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return reclaimed, fmt.Errorf("collect orphans: %s", err)
+	}
+	return reclaimed, nil
+}
+
+// Migrate moves files according to storage-sharded, then compresses or
+// decompresses pastes and revisions according to storage-compress. Server
+// must not be running meanwhile.
+func (fs *FileStorage) Migrate() (int, error) {
+	migrated := 0
+	var dirs []string
+	for _, subdir := range []string{"pastes", "hashes"} {
+		// Collect files first, as moving them around confuses WalkDir
+		var files []string
+		root := path.Join(fs.dir, subdir)
+		err := filepath.WalkDir(root, func(filename string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if filename != root {
+					dirs = append(dirs, filename)
+				}
+			} else if !strings.HasSuffix(filename, ".tmp") {
+				files = append(files, filename)
+			}
+			return nil
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("migrate: %s", err)
+		}
+		for _, filename := range files {
+			changed, err := fs.migrateFile(subdir, filename)
+			if err != nil {
+				return migrated, fmt.Errorf("migrate %s: %s", filename, err)
+			}
+			if changed {
+				migrated++
+			}
+		}
+	}
+	// Drop shards left empty, removing non-empty ones fails
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+	return migrated, nil
+}
+
+// migrateFile moves file of pastes or hashes directory to its place and
+// compresses it if needed, reporting whether anything was changed.
+func (fs *FileStorage) migrateFile(subdir string, filename string) (bool, error) {
+	base := path.Base(filename)
+	target := fs.hashPath(base)
+	if subdir == "pastes" {
+		// Revisions, meta and stats files live next to paste
+		target = path.Join(path.Dir(fs.pastePath(base)), base)
+	}
+	changed := false
+	if target != filename {
+		if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+			return false, err
+		}
+		if err := os.Rename(filename, target); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	if subdir == "hashes" {
+		return changed, nil
+	}
+
+	// Only paste content and revisions are compressed
+	name := strings.TrimSuffix(base, ZstdSuffix)
+	if _, ext, found := strings.Cut(name, "."); found {
+		revision, ok := strings.CutPrefix(ext, "v")
+		if _, err := strconv.Atoi(revision); !ok || err != nil {
+			return changed, nil
+		}
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return changed, err
+	}
+	compressed := name != base
+	if compressed == (fs.compress && (compressed || info.Size() >= ZstdMinSize)) {
+		return changed, nil
+	}
+	contentPath := path.Join(path.Dir(target), name)
+	content, err := readContent(contentPath)
+	if err != nil {
+		return changed, err
+	}
+	return true, fs.writeContent(contentPath, bytes.NewReader(content), int64(len(content)))
+}
+
+func (fs *FileStorage) Close() error {
+	// Wait for counter update in progress, every write is synced already
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return nil
+}
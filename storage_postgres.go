@@ -0,0 +1,257 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+const PostgresSchema = `
+CREATE SEQUENCE IF NOT EXISTS paste_counter;
+CREATE TABLE IF NOT EXISTS pastes (
+	name    TEXT PRIMARY KEY,
+	meta    JSONB NOT NULL,
+	content BYTEA NOT NULL
+);
+CREATE TABLE IF NOT EXISTS paste_revisions (
+	name     TEXT NOT NULL REFERENCES pastes (name) ON DELETE CASCADE,
+	revision INTEGER NOT NULL,
+	content  BYTEA NOT NULL,
+	PRIMARY KEY (name, revision)
+);
+CREATE TABLE IF NOT EXISTS paste_stats (
+	name        TEXT PRIMARY KEY REFERENCES pastes (name) ON DELETE CASCADE,
+	views       BIGINT NOT NULL,
+	last_viewed TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS pastes_sha256 ON pastes ((meta->>'sha256'));
+`
+
+// PostgresStorage keeps pastes in a single table and uses a sequence for the
+// counter, so any number of instances can share one database.
+type PostgresStorage struct {
+	db *sql.DB
+}
+
+func NewPostgresStorage(dsn string) (*PostgresStorage, error) {
+	if dsn == "" {
+		return nil, errors.New("postgres storage: dsn is required")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("postgres storage: %s", err)
+	}
+	if _, err = db.Exec(PostgresSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("postgres storage: %s", err)
+	}
+	return &PostgresStorage{db: db}, nil
+}
+
+func (ps *PostgresStorage) NextCounter() (int64, error) {
+	var counter int64
+	if err := ps.db.QueryRow("SELECT nextval('paste_counter')").Scan(&counter); err != nil {
+		return 0, fmt.Errorf("next counter: %s", err)
+	}
+	return counter, nil
+}
+
+func (ps *PostgresStorage) AdvanceCounter(counter int64) error {
+	// Sequence which was never used hands out its last_value next
+	if _, err := ps.db.Exec(
+		"SELECT setval('paste_counter', $1) FROM paste_counter"+
+			" WHERE $1 > CASE WHEN is_called THEN last_value ELSE last_value - 1 END",
+		counter,
+	); err != nil {
+		return fmt.Errorf("advance counter: %s", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) Save(name string, meta *PasteMeta, stream io.Reader) error {
+	content, err := io.ReadAll(stream)
+	if err != nil {
+		return fmt.Errorf("save paste: %s", err)
+	}
+	metaContent, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("save paste: %s", err)
+	}
+	if _, err = ps.db.Exec(
+		"INSERT INTO pastes (name, meta, content) VALUES ($1, $2, $3)"+
+			" ON CONFLICT (name) DO UPDATE SET meta = EXCLUDED.meta, content = EXCLUDED.content",
+		name, metaContent, content,
+	); err != nil {
+		return fmt.Errorf("save paste: %s", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) LoadMeta(name string) (*PasteMeta, error) {
+	var metaContent []byte
+	if err := ps.db.QueryRow("SELECT meta FROM pastes WHERE name = $1", name).Scan(&metaContent); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPasteNotFound
+		}
+		return nil, fmt.Errorf("load meta: %s", err)
+	}
+	meta := &PasteMeta{}
+	if err := json.Unmarshal(metaContent, meta); err != nil {
+		return nil, fmt.Errorf("load meta: %s", err)
+	}
+	return meta, nil
+}
+
+func (ps *PostgresStorage) Load(name string) (*PasteMeta, []byte, error) {
+	var metaContent, content []byte
+	if err := ps.db.QueryRow(
+		"SELECT meta, content FROM pastes WHERE name = $1", name,
+	).Scan(&metaContent, &content); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrPasteNotFound
+		}
+		return nil, nil, fmt.Errorf("load paste: %s", err)
+	}
+	meta := &PasteMeta{}
+	if err := json.Unmarshal(metaContent, meta); err != nil {
+		return nil, nil, fmt.Errorf("load paste: %s", err)
+	}
+	return meta, content, nil
+}
+
+func (ps *PostgresStorage) Open(name string) (*PasteMeta, io.ReadSeekCloser, error) {
+	meta, content, err := ps.Load(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, BytesStream(content), nil
+}
+
+func (ps *PostgresStorage) SaveRevision(name string, revision int, content []byte) error {
+	if content == nil {
+		content = []byte{}
+	}
+	if _, err := ps.db.Exec(
+		"INSERT INTO paste_revisions (name, revision, content) VALUES ($1, $2, $3)"+
+			" ON CONFLICT (name, revision) DO UPDATE SET content = EXCLUDED.content",
+		name, revision, content,
+	); err != nil {
+		return fmt.Errorf("save revision: %s", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) LoadRevision(name string, revision int) ([]byte, error) {
+	var content []byte
+	if err := ps.db.QueryRow(
+		"SELECT content FROM paste_revisions WHERE name = $1 AND revision = $2", name, revision,
+	).Scan(&content); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPasteNotFound
+		}
+		return nil, fmt.Errorf("load revision: %s", err)
+	}
+	return content, nil
+}
+
+func (ps *PostgresStorage) RecordView(name string, at time.Time) error {
+	result, err := ps.db.Exec(
+		"INSERT INTO paste_stats (name, views, last_viewed) SELECT name, 1, $2 FROM pastes WHERE name = $1"+
+			" ON CONFLICT (name) DO UPDATE SET views = paste_stats.views + 1, last_viewed = EXCLUDED.last_viewed",
+		name, at,
+	)
+	if err != nil {
+		return fmt.Errorf("record view: %s", err)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return ErrPasteNotFound
+	}
+	return nil
+}
+
+// SaveStats leaves stats of pastes which were never viewed out.
+func (ps *PostgresStorage) SaveStats(name string, stats *PasteStats) error {
+	if stats.LastViewed == nil {
+		return nil
+	}
+	if _, err := ps.db.Exec(
+		"INSERT INTO paste_stats (name, views, last_viewed) VALUES ($1, $2, $3)"+
+			" ON CONFLICT (name) DO UPDATE SET views = EXCLUDED.views, last_viewed = EXCLUDED.last_viewed",
+		name, stats.Views, *stats.LastViewed,
+	); err != nil {
+		return fmt.Errorf("save stats: %s", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) LoadStats(name string) (*PasteStats, error) {
+	stats := &PasteStats{}
+	var lastViewed time.Time
+	if err := ps.db.QueryRow(
+		"SELECT views, last_viewed FROM paste_stats WHERE name = $1", name,
+	).Scan(&stats.Views, &lastViewed); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return stats, nil
+		}
+		return nil, fmt.Errorf("load stats: %s", err)
+	}
+	stats.LastViewed = &lastViewed
+	return stats, nil
+}
+
+func (ps *PostgresStorage) FindByHash(sum string) (string, error) {
+	var name string
+	if err := ps.db.QueryRow(
+		"SELECT name FROM pastes WHERE meta->>'sha256' = $1 AND meta->>'burn' IS NULL ORDER BY name LIMIT 1", sum,
+	).Scan(&name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrPasteNotFound
+		}
+		return "", fmt.Errorf("find by hash: %s", err)
+	}
+	return name, nil
+}
+
+func (ps *PostgresStorage) Delete(name string) error {
+	result, err := ps.db.Exec("DELETE FROM pastes WHERE name = $1", name)
+	if err != nil {
+		return fmt.Errorf("delete paste: %s", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete paste: %s", err)
+	}
+	if deleted == 0 {
+		return ErrPasteNotFound
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) List() ([]string, error) {
+	rows, err := ps.db.Query("SELECT name FROM pastes")
+	if err != nil {
+		return nil, fmt.Errorf("list pastes: %s", err)
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("list pastes: %s", err)
+		}
+		names = append(names, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("list pastes: %s", err)
+	}
+	return names, nil
+}
+
+func (ps *PostgresStorage) Close() error {
+	return ps.db.Close()
+}
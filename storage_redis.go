@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStorage keeps every paste in a single hash with "meta" and "content"
+// fields, revisions go to "v1", "v2", etc. fields of the same hash. Expiring pastes get native Redis TTL, so they disappear on their own.
+type RedisStorage struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStorage(url string, prefix string) (*RedisStorage, error) {
+	if url == "" {
+		return nil, errors.New("redis storage: url is required")
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("redis storage: %s", err)
+	}
+	client := redis.NewClient(opts)
+	if err = client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("redis storage: %s", err)
+	}
+	if prefix == "" {
+		prefix = "paast:"
+	}
+	return &RedisStorage{client: client, prefix: prefix}, nil
+}
+
+func (rs *RedisStorage) pasteKey(name string) string {
+	return rs.prefix + "paste:" + name
+}
+
+func (rs *RedisStorage) NextCounter() (int64, error) {
+	counter, err := rs.client.Incr(context.Background(), rs.prefix+"counter").Result()
+	if err != nil {
+		return 0, fmt.Errorf("next counter: %s", err)
+	}
+	return counter, nil
+}
+
+// advanceCounterScript only ever moves counter forward.
+var advanceCounterScript = redis.NewScript(`
+if tonumber(redis.call("GET", KEYS[1]) or "0") < tonumber(ARGV[1]) then
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+func (rs *RedisStorage) AdvanceCounter(counter int64) error {
+	if err := advanceCounterScript.Run(context.Background(), rs.client, []string{rs.prefix + "counter"}, counter).Err(); err != nil {
+		return fmt.Errorf("advance counter: %s", err)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) hashKey(sum string) string {
+	return rs.prefix + "sha256:" + sum
+}
+
+func (rs *RedisStorage) Save(name string, meta *PasteMeta, stream io.Reader) error {
+	ctx := context.Background()
+	content, err := io.ReadAll(stream)
+	if err != nil {
+		return fmt.Errorf("save paste: %s", err)
+	}
+	metaContent, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("save paste: %s", err)
+	}
+	key := rs.pasteKey(name)
+	_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "meta", metaContent, "content", content)
+		if meta.SHA256 != "" && !meta.Burn {
+			pipe.Set(ctx, rs.hashKey(meta.SHA256), name, 0)
+		}
+		if meta.Expires != nil {
+			pipe.ExpireAt(ctx, key, *meta.Expires)
+			if meta.SHA256 != "" && !meta.Burn {
+				pipe.ExpireAt(ctx, rs.hashKey(meta.SHA256), *meta.Expires)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("save paste: %s", err)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) LoadMeta(name string) (*PasteMeta, error) {
+	metaContent, err := rs.client.HGet(context.Background(), rs.pasteKey(name), "meta").Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrPasteNotFound
+		}
+		return nil, fmt.Errorf("load meta: %s", err)
+	}
+	meta := &PasteMeta{}
+	if err = json.Unmarshal(metaContent, meta); err != nil {
+		return nil, fmt.Errorf("load meta: %s", err)
+	}
+	return meta, nil
+}
+
+func (rs *RedisStorage) Load(name string) (*PasteMeta, []byte, error) {
+	values, err := rs.client.HMGet(context.Background(), rs.pasteKey(name), "meta", "content").Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("load paste: %s", err)
+	}
+	metaContent, ok1 := values[0].(string)
+	content, ok2 := values[1].(string)
+	if !ok1 || !ok2 {
+		return nil, nil, ErrPasteNotFound
+	}
+	meta := &PasteMeta{}
+	if err = json.Unmarshal([]byte(metaContent), meta); err != nil {
+		return nil, nil, fmt.Errorf("load paste: %s", err)
+	}
+	return meta, []byte(content), nil
+}
+
+func (rs *RedisStorage) Open(name string) (*PasteMeta, io.ReadSeekCloser, error) {
+	meta, content, err := rs.Load(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, BytesStream(content), nil
+}
+
+func (rs *RedisStorage) SaveRevision(name string, revision int, content []byte) error {
+	if err := rs.client.HSet(context.Background(), rs.pasteKey(name), fmt.Sprintf("v%d", revision), content).Err(); err != nil {
+		return fmt.Errorf("save revision: %s", err)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) LoadRevision(name string, revision int) ([]byte, error) {
+	content, err := rs.client.HGet(context.Background(), rs.pasteKey(name), fmt.Sprintf("v%d", revision)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrPasteNotFound
+		}
+		return nil, fmt.Errorf("load revision: %s", err)
+	}
+	return content, nil
+}
+
+// recordViewScript doesn't recreate paste hash if it's gone meanwhile.
+var recordViewScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HINCRBY", KEYS[1], "views", 1)
+redis.call("HSET", KEYS[1], "last_viewed", ARGV[1])
+return 1
+`)
+
+func (rs *RedisStorage) RecordView(name string, at time.Time) error {
+	found, err := recordViewScript.Run(
+		context.Background(), rs.client, []string{rs.pasteKey(name)}, at.Format(time.RFC3339Nano),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("record view: %s", err)
+	}
+	if found == 0 {
+		return ErrPasteNotFound
+	}
+	return nil
+}
+
+func (rs *RedisStorage) SaveStats(name string, stats *PasteStats) error {
+	values := []any{"views", stats.Views}
+	if stats.LastViewed != nil {
+		values = append(values, "last_viewed", stats.LastViewed.Format(time.RFC3339Nano))
+	}
+	if err := rs.client.HSet(context.Background(), rs.pasteKey(name), values...).Err(); err != nil {
+		return fmt.Errorf("save stats: %s", err)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) LoadStats(name string) (*PasteStats, error) {
+	values, err := rs.client.HMGet(context.Background(), rs.pasteKey(name), "views", "last_viewed").Result()
+	if err != nil {
+		return nil, fmt.Errorf("load stats: %s", err)
+	}
+	stats := &PasteStats{}
+	if views, ok := values[0].(string); ok {
+		stats.Views, _ = strconv.ParseInt(views, 10, 64)
+	}
+	if lastViewed, ok := values[1].(string); ok {
+		if at, err := time.Parse(time.RFC3339Nano, lastViewed); err == nil {
+			stats.LastViewed = &at
+		}
+	}
+	return stats, nil
+}
+
+func (rs *RedisStorage) FindByHash(sum string) (string, error) {
+	name, err := rs.client.Get(context.Background(), rs.hashKey(sum)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", ErrPasteNotFound
+		}
+		return "", fmt.Errorf("find by hash: %s", err)
+	}
+	return name, nil
+}
+
+// unindexScript removes hash index entry unless it points to another paste.
+var unindexScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (rs *RedisStorage) Delete(name string) error {
+	ctx := context.Background()
+	meta, _ := rs.LoadMeta(name)
+	deleted, err := rs.client.Del(ctx, rs.pasteKey(name)).Result()
+	if err != nil {
+		return fmt.Errorf("delete paste: %s", err)
+	}
+	if deleted == 0 {
+		return ErrPasteNotFound
+	}
+	if meta != nil && meta.SHA256 != "" {
+		unindexScript.Run(ctx, rs.client, []string{rs.hashKey(meta.SHA256)}, name)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) List() ([]string, error) {
+	ctx := context.Background()
+	names := []string{}
+	prefix := rs.pasteKey("")
+	iter := rs.client.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		names = append(names, strings.TrimPrefix(iter.Val(), prefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("list pastes: %s", err)
+	}
+	return names, nil
+}
+
+func (rs *RedisStorage) Close() error {
+	return rs.client.Close()
+}
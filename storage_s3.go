@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Storage keeps pastes in an S3-compatible bucket using the same layout as
+// FileStorage. Counter updates and deletes rely on conditional writes
+// (If-Match / If-None-Match) to stay consistent across several instances.
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+	lock   sync.Mutex
+}
+
+func NewS3Storage(endpoint, bucket, prefix, region, accessKey, secretKey string, insecure bool) (*S3Storage, error) {
+	if endpoint == "" || bucket == "" {
+		return nil, errors.New("s3 storage: endpoint and bucket are required")
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: !insecure,
+		Region: region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3 storage: %s", err)
+	}
+	exists, err := client.BucketExists(context.Background(), bucket)
+	if err != nil {
+		return nil, fmt.Errorf("s3 storage: %s", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("s3 storage: bucket %s does not exist", bucket)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3Storage{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *S3Storage) pasteKey(name string) string {
+	return s.prefix + "pastes/" + name
+}
+
+func isNoSuchKey(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
+func isPreconditionFailed(err error) bool {
+	return minio.ToErrorResponse(err).StatusCode == http.StatusPreconditionFailed
+}
+
+func (s *S3Storage) get(key string) ([]byte, string, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := ioutil.ReadAll(obj)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, info.ETag, nil
+}
+
+func (s *S3Storage) put(key string, content []byte, opts minio.PutObjectOptions) error {
+	_, err := s.client.PutObject(
+		context.Background(), s.bucket, key,
+		bytes.NewReader(content), int64(len(content)), opts,
+	)
+	return err
+}
+
+func (s *S3Storage) NextCounter() (int64, error) {
+	return s.updateCounter("next counter", func(counter int64) int64 {
+		return counter + 1
+	})
+}
+
+func (s *S3Storage) AdvanceCounter(counter int64) error {
+	_, err := s.updateCounter("advance counter", func(current int64) int64 {
+		return max(current, counter)
+	})
+	return err
+}
+
+// updateCounter replaces counter with the value returned by update, which
+// is left untouched if it's unchanged.
+func (s *S3Storage) updateCounter(op string, update func(int64) int64) (int64, error) {
+	// Lock only reduces contention between requests of this instance,
+	// conditional put below protects against other instances.
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := s.prefix + "counter.dat"
+	for attempt := 0; attempt < 10; attempt++ {
+		var current int64
+		content, etag, err := s.get(key)
+		if err != nil && !isNoSuchKey(err) {
+			return 0, fmt.Errorf("%s: %s", op, err)
+		}
+		if text := strings.TrimSpace(string(content)); err == nil && text != "" {
+			// Starting over would hand out IDs of existing pastes again
+			if current, err = strconv.ParseInt(text, 10, 64); err != nil {
+				return 0, fmt.Errorf("%s: %s is corrupt, set it to the highest paste number to continue", op, key)
+			}
+		}
+		counter := update(current)
+		if counter == current {
+			return counter, nil
+		}
+		opts := minio.PutObjectOptions{ContentType: "text/plain"}
+		if etag == "" {
+			opts.SetMatchETagExcept("*")
+		} else {
+			opts.SetMatchETag(etag)
+		}
+		if err = s.put(key, []byte(fmt.Sprint(counter)), opts); err != nil {
+			if isPreconditionFailed(err) {
+				continue
+			}
+			return 0, fmt.Errorf("%s: %s", op, err)
+		}
+		return counter, nil
+	}
+	return 0, fmt.Errorf("%s: too many concurrent updates", op)
+}
+
+func (s *S3Storage) hashKey(sum string) string {
+	return s.prefix + "sha256/" + sum
+}
+
+func (s *S3Storage) Save(name string, meta *PasteMeta, content io.Reader) error {
+	metaContent, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("save paste: %s", err)
+	}
+	key := s.pasteKey(name)
+	if err = s.put(key+".meta", metaContent, minio.PutObjectOptions{ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("save paste: %s", err)
+	}
+	if _, err = s.client.PutObject(context.Background(), s.bucket, key, content, meta.Size, minio.PutObjectOptions{}); err != nil {
+		return fmt.Errorf("save paste: %s", err)
+	}
+	if meta.SHA256 != "" && !meta.Burn {
+		if err = s.put(s.hashKey(meta.SHA256), []byte(name), minio.PutObjectOptions{ContentType: "text/plain"}); err != nil {
+			return fmt.Errorf("save paste: %s", err)
+		}
+	}
+	return nil
+}
+
+func (s *S3Storage) LoadMeta(name string) (*PasteMeta, error) {
+	key := s.pasteKey(name)
+	if _, err := s.client.StatObject(context.Background(), s.bucket, key, minio.StatObjectOptions{}); err != nil {
+		if isNoSuchKey(err) {
+			return nil, ErrPasteNotFound
+		}
+		return nil, fmt.Errorf("load meta: %s", err)
+	}
+	meta := &PasteMeta{}
+	metaContent, _, err := s.get(key + ".meta")
+	if err != nil {
+		if isNoSuchKey(err) {
+			return meta, nil
+		}
+		return nil, fmt.Errorf("load meta: %s", err)
+	}
+	if err = json.Unmarshal(metaContent, meta); err != nil {
+		return nil, fmt.Errorf("load meta: %s", err)
+	}
+	return meta, nil
+}
+
+func (s *S3Storage) Load(name string) (*PasteMeta, []byte, error) {
+	content, _, err := s.get(s.pasteKey(name))
+	if err != nil {
+		if isNoSuchKey(err) {
+			return nil, nil, ErrPasteNotFound
+		}
+		return nil, nil, fmt.Errorf("load paste: %s", err)
+	}
+	meta, err := s.LoadMeta(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, content, nil
+}
+
+func (s *S3Storage) Open(name string) (*PasteMeta, io.ReadSeekCloser, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.pasteKey(name), minio.GetObjectOptions{})
+	if err == nil {
+		_, err = obj.Stat()
+	}
+	if err != nil {
+		if obj != nil {
+			obj.Close()
+		}
+		if isNoSuchKey(err) {
+			return nil, nil, ErrPasteNotFound
+		}
+		return nil, nil, fmt.Errorf("open paste: %s", err)
+	}
+	meta, err := s.LoadMeta(name)
+	if err != nil {
+		obj.Close()
+		return nil, nil, err
+	}
+	return meta, obj, nil
+}
+
+func (s *S3Storage) SaveRevision(name string, revision int, content []byte) error {
+	if err := s.put(fmt.Sprintf("%s.v%d", s.pasteKey(name), revision), content, minio.PutObjectOptions{}); err != nil {
+		return fmt.Errorf("save revision: %s", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) LoadRevision(name string, revision int) ([]byte, error) {
+	content, _, err := s.get(fmt.Sprintf("%s.v%d", s.pasteKey(name), revision))
+	if err != nil {
+		if isNoSuchKey(err) {
+			return nil, ErrPasteNotFound
+		}
+		return nil, fmt.Errorf("load revision: %s", err)
+	}
+	return content, nil
+}
+
+// RecordView updates ".stats" object with conditional put, same as counter.
+func (s *S3Storage) RecordView(name string, at time.Time) error {
+	key := s.pasteKey(name) + ".stats"
+	for attempt := 0; attempt < 10; attempt++ {
+		stats := &PasteStats{}
+		content, etag, err := s.get(key)
+		if err != nil && !isNoSuchKey(err) {
+			return fmt.Errorf("record view: %s", err)
+		}
+		if err == nil {
+			if err = json.Unmarshal(content, stats); err != nil {
+				return fmt.Errorf("record view: %s", err)
+			}
+		}
+		stats.Views++
+		stats.LastViewed = &at
+		if content, err = json.Marshal(stats); err != nil {
+			return fmt.Errorf("record view: %s", err)
+		}
+		opts := minio.PutObjectOptions{ContentType: "application/json"}
+		if etag == "" {
+			opts.SetMatchETagExcept("*")
+		} else {
+			opts.SetMatchETag(etag)
+		}
+		if err = s.put(key, content, opts); err != nil {
+			if isPreconditionFailed(err) {
+				continue
+			}
+			return fmt.Errorf("record view: %s", err)
+		}
+		return nil
+	}
+	return errors.New("record view: too many concurrent updates")
+}
+
+func (s *S3Storage) SaveStats(name string, stats *PasteStats) error {
+	content, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("save stats: %s", err)
+	}
+	if err = s.put(s.pasteKey(name)+".stats", content, minio.PutObjectOptions{ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("save stats: %s", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) LoadStats(name string) (*PasteStats, error) {
+	stats := &PasteStats{}
+	content, _, err := s.get(s.pasteKey(name) + ".stats")
+	if err != nil {
+		if isNoSuchKey(err) {
+			return stats, nil
+		}
+		return nil, fmt.Errorf("load stats: %s", err)
+	}
+	if err = json.Unmarshal(content, stats); err != nil {
+		return nil, fmt.Errorf("load stats: %s", err)
+	}
+	return stats, nil
+}
+
+func (s *S3Storage) FindByHash(sum string) (string, error) {
+	name, _, err := s.get(s.hashKey(sum))
+	if err != nil {
+		if isNoSuchKey(err) {
+			return "", ErrPasteNotFound
+		}
+		return "", fmt.Errorf("find by hash: %s", err)
+	}
+	return string(name), nil
+}
+
+func (s *S3Storage) Delete(name string) error {
+	ctx := context.Background()
+	key := s.pasteKey(name)
+	// S3 deletes are idempotent, so a marker object created with
+	// If-None-Match decides which of concurrent callers wins.
+	opts := minio.PutObjectOptions{}
+	opts.SetMatchETagExcept("*")
+	if err := s.put(key+".deleted", nil, opts); err != nil {
+		if isPreconditionFailed(err) {
+			return ErrPasteNotFound
+		}
+		return fmt.Errorf("delete paste: %s", err)
+	}
+	defer s.client.RemoveObject(ctx, s.bucket, key+".deleted", minio.RemoveObjectOptions{})
+	if _, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{}); err != nil {
+		if isNoSuchKey(err) {
+			return ErrPasteNotFound
+		}
+		return fmt.Errorf("delete paste: %s", err)
+	}
+	keys := []string{key, key + ".meta", key + ".stats"}
+	if meta, err := s.LoadMeta(name); err == nil && meta.SHA256 != "" {
+		if indexed, err := s.FindByHash(meta.SHA256); err == nil && indexed == name {
+			keys = append(keys, s.hashKey(meta.SHA256))
+		}
+	}
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: key + ".v"}) {
+		if obj.Err != nil {
+			return fmt.Errorf("delete paste: %s", obj.Err)
+		}
+		keys = append(keys, obj.Key)
+	}
+	for _, k := range keys {
+		if err := s.client.RemoveObject(ctx, s.bucket, k, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("delete paste: %s", err)
+		}
+	}
+	return nil
+}
+
+func (s *S3Storage) List() ([]string, error) {
+	names := []string{}
+	prefix := s.pasteKey("")
+	for obj := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("list pastes: %s", obj.Err)
+		}
+		// Skip ".meta" and ".deleted" objects
+		if name := strings.TrimPrefix(obj.Key, prefix); !strings.Contains(name, ".") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (s *S3Storage) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SystemdFirstFD is the first descriptor systemd passes sockets in.
+const SystemdFirstFD = 3
+
+// Sockets passed by systemd, keyed by name of option whose address they
+// take place of. Servers take them from goroutines of their own, hence the
+// lock.
+var (
+	inheritedMu          sync.Mutex
+	inheritedListeners   = map[string]net.Listener{}
+	inheritedPacketConns = map[string]net.PacketConn{}
+)
+
+// InheritSockets takes sockets systemd passed with socket activation. Each
+// socket is named after listen option it's used for with FileDescriptorName,
+// e.g. tls-listen; socket without a name is used for listen. Datagram socket
+// named tls-listen is used for HTTP/3.
+func InheritSockets() error {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return fmt.Errorf("systemd: invalid LISTEN_FDS: %s", err)
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := range count {
+		fd := SystemdFirstFD + i
+		name := "listen"
+		// Unnamed sockets are named after socket unit
+		if i < len(names) && strings.HasSuffix(names[i], "listen") {
+			name = names[i]
+		}
+		if _, ok := inheritedListeners[name]; ok {
+			return fmt.Errorf("systemd: more than one socket for %s, name them with FileDescriptorName", name)
+		}
+		file := os.NewFile(uintptr(fd), name)
+		if listener, err := net.FileListener(file); err == nil {
+			inheritedListeners[name] = listener
+		} else if conn, err := net.FilePacketConn(file); err == nil {
+			inheritedPacketConns[name] = conn
+		} else {
+			return fmt.Errorf("systemd: socket %s: %s", name, err)
+		}
+		// Listener holds duplicate of descriptor, which isn't inherited
+		// by child processes
+		file.Close()
+	}
+	return nil
+}
+
+// inheritedListener returns listener passed by systemd for option name,
+// only once.
+func inheritedListener(name string) (net.Listener, bool) {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	listener, ok := inheritedListeners[name]
+	delete(inheritedListeners, name)
+	return listener, ok
+}
+
+// Listen returns listener passed by systemd for option name, or listens on
+// addr over TCP if there's none.
+func Listen(name string, addr string) (net.Listener, error) {
+	if listener, ok := inheritedListener(name); ok {
+		return listener, nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// inheritedPacketConn is inheritedListener for UDP.
+func inheritedPacketConn(name string) (net.PacketConn, bool) {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	conn, ok := inheritedPacketConns[name]
+	delete(inheritedPacketConns, name)
+	return conn, ok
+}
+
+// ListenPacket is Listen for UDP.
+func ListenPacket(name string, addr string) (net.PacketConn, error) {
+	if conn, ok := inheritedPacketConn(name); ok {
+		return conn, nil
+	}
+	return net.ListenPacket("udp", addr)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TCPDeadline limits the whole TCP connection, idle timeout alone would let
+// slow clients hold it forever.
+const TCPDeadline = time.Minute
+
+// TCPServer creates pastes from whatever is sent to plain TCP connection,
+// termbin-style: echo hi | nc host 9999. Paste ends when client closes its
+// side or stops sending for tcp-timeout, as plenty of nc builds never close
+// it.
+type TCPServer struct {
+	routes      *HttpRoutes
+	rateLimiter *RateLimiter
+	listener    net.Listener
+	wg          sync.WaitGroup
+}
+
+func NewTCPServer(config *Config, routes *HttpRoutes, rateLimiter *RateLimiter) (*TCPServer, error) {
+	listener, err := Listen("tcp-listen", config.TCPListen)
+	if err != nil {
+		return nil, fmt.Errorf("tcp: %s", err)
+	}
+	return &TCPServer{routes: routes, rateLimiter: rateLimiter, listener: listener}, nil
+}
+
+// Serve accepts connections until server is closed.
+func (ts *TCPServer) Serve() error {
+	for {
+		conn, err := ts.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return fmt.Errorf("tcp: %s", err)
+		}
+		ts.wg.Add(1)
+		go func() {
+			defer ts.wg.Done()
+			ts.handle(conn)
+		}()
+	}
+}
+
+// Close stops accepting connections and waits for pending ones, which are
+// bounded by TCPDeadline.
+func (ts *TCPServer) Close() error {
+	err := ts.listener.Close()
+	ts.wg.Wait()
+	return err
+}
+
+// idleReader ends content when nothing arrives for idle time.
+type idleReader struct {
+	conn     net.Conn
+	idle     time.Duration
+	deadline time.Time
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	deadline := time.Now().Add(ir.idle)
+	if deadline.After(ir.deadline) {
+		deadline = ir.deadline
+	}
+	ir.conn.SetReadDeadline(deadline)
+	n, err := ir.conn.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) && time.Now().Before(ir.deadline) {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func (ts *TCPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	config := ts.routes.config
+	conn.SetWriteDeadline(time.Now().Add(TCPDeadline))
+	r, err := ListenerRequest(config.PublicURL, conn.RemoteAddr().String(), "tcp")
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			RequestLogger(r).Error("internal error", "error", strings.TrimSpace(fmt.Sprint(rec)))
+			fmt.Fprintf(conn, "error: internal error, ref=%s\n", GetRequestInfo(r).ID)
+		}
+	}()
+
+	if ban := ts.routes.bans.Banned(RemoteIP(r)); ban != nil {
+		fmt.Fprintf(conn, "error: %s\n", BannedError(ban))
+		return
+	}
+	if ok, wait := ts.rateLimiter.Take(RemoteIP(r)); !ok {
+		metricRateLimited.Inc()
+		fmt.Fprintf(conn, "error: please wait %d seconds before creating new paste\n", int64(math.Ceil(wait.Seconds())))
+		return
+	}
+	spool, err := SpoolPaste(io.LimitReader(&idleReader{
+		conn:     conn,
+		idle:     config.TCPTimeout,
+		deadline: time.Now().Add(TCPDeadline),
+	}, config.TCPMaxLen+1))
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			RequestLogger(r).Info("tcp paste failed", "error", err)
+			conn.Write([]byte("error: paste was not received in time\n"))
+			return
+		}
+		panic(err)
+	}
+	upload := &Upload{Spool: spool}
+	defer upload.Close()
+	if spool.Size > config.TCPMaxLen {
+		fmt.Fprintf(conn, "error: paste too large, limit is %s\n", FormatSize(config.TCPMaxLen))
+		return
+	}
+	if spool.Size == 0 {
+		conn.Write([]byte("error: your paste is empty!\n"))
+		return
+	}
+	upload.DetectContentType()
+	if err = ts.routes.CheckContent(r, upload); err != nil {
+		fmt.Fprintf(conn, "error: %s\n", err)
+		return
+	}
+	info := ts.routes.createPaste(r, &PasteMeta{Created: time.Now()}, upload)
+	conn.Write([]byte(info.URL + "\n"))
+}
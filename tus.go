@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TusVersion is the only version of tus protocol spoken at /uploads.
+const TusVersion = "1.0.0"
+
+// TusExtensions lists supported extensions of tus protocol.
+const TusExtensions = "creation,termination,expiration"
+
+// UploadExpiry is how long unfinished uploads are kept after last chunk.
+const UploadExpiry = 24 * time.Hour
+
+var ErrUploadNotFound = errors.New("upload not found")
+
+// TusUpload is the state of resumable upload. Offset is not stored: it is
+// the size of data received so far, which survives crashes and restarts.
+type TusUpload struct {
+	Length      int64  `json:"length"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Expire      string `json:"expire,omitempty"`
+	Burn        bool   `json:"burn,omitempty"`
+	Private     bool   `json:"private,omitempty"`
+	Visibility  string `json:"visibility,omitempty"`
+	Tags        string `json:"tags,omitempty"`
+	Type        string `json:"type,omitempty"`
+	// ID of paste created once upload was complete
+	Paste string `json:"paste,omitempty"`
+}
+
+// TusStore keeps resumable uploads in a directory as <id>.json with state
+// and <id> with data. Each upload accepts one chunk at a time, even from
+// instances sharing the directory.
+type TusStore struct {
+	dir  string
+	lock sync.Mutex
+	// Data files of reserved uploads holding the lock, nil for uploads
+	// without one
+	busy map[string]*os.File
+}
+
+func NewTusStore(config *Config) *TusStore {
+	dir := config.UploadDir
+	if dir == "" {
+		dir = path.Join(config.DataDir, "uploads")
+	}
+	return &TusStore{dir: dir, busy: map[string]*os.File{}}
+}
+
+func (ts *TusStore) statePath(id string) string {
+	return path.Join(ts.dir, id+".json")
+}
+
+func (ts *TusStore) dataPath(id string) string {
+	return path.Join(ts.dir, id)
+}
+
+// Create stores state of new upload along with empty data file.
+func (ts *TusStore) Create(upload *TusUpload) (string, error) {
+	if err := os.MkdirAll(ts.dir, 0700); err != nil {
+		return "", fmt.Errorf("create upload: %s", err)
+	}
+	id, _, err := NewToken()
+	if err != nil {
+		return "", fmt.Errorf("create upload: %s", err)
+	}
+	if err = os.WriteFile(ts.dataPath(id), nil, 0600); err != nil {
+		return "", fmt.Errorf("create upload: %s", err)
+	}
+	if err = ts.Save(id, upload); err != nil {
+		os.Remove(ts.dataPath(id))
+		return "", err
+	}
+	return id, nil
+}
+
+func (ts *TusStore) Save(id string, upload *TusUpload) error {
+	content, err := json.Marshal(upload)
+	if err != nil {
+		return fmt.Errorf("save upload: %s", err)
+	}
+	if err = WriteFileAtomic(ts.statePath(id), content); err != nil {
+		return fmt.Errorf("save upload: %s", err)
+	}
+	return nil
+}
+
+// Load returns upload state and its offset. Offset of completed upload is
+// its length.
+func (ts *TusStore) Load(id string) (*TusUpload, int64, error) {
+	content, err := os.ReadFile(ts.statePath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, 0, ErrUploadNotFound
+		}
+		return nil, 0, fmt.Errorf("load upload: %s", err)
+	}
+	upload := &TusUpload{}
+	if err = json.Unmarshal(content, upload); err != nil {
+		return nil, 0, fmt.Errorf("load upload: %s", err)
+	}
+	if upload.Paste != "" {
+		return upload, upload.Length, nil
+	}
+	info, err := os.Stat(ts.dataPath(id))
+	if err != nil {
+		return nil, 0, fmt.Errorf("load upload: %s", err)
+	}
+	return upload, info.Size(), nil
+}
+
+// Acquire reserves upload for a single request, false means another one is
+// still writing to it. Reservation is dropped with Release.
+func (ts *TusStore) Acquire(id string) bool {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if _, ok := ts.busy[id]; ok {
+		return false
+	}
+	// Other instances are kept out by lock on data file, which is in place
+	// for the whole life of upload
+	file, err := os.Open(ts.dataPath(id))
+	if err == nil {
+		if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			file.Close()
+			return false
+		}
+	}
+	ts.busy[id] = file
+	return true
+}
+
+func (ts *TusStore) Release(id string) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if file := ts.busy[id]; file != nil {
+		file.Close()
+	}
+	delete(ts.busy, id)
+}
+
+// Append writes chunk to upload data. Whatever was received before an error
+// is kept, so client can resume from there.
+func (ts *TusStore) Append(id string, chunk io.Reader) (int64, error) {
+	file, err := os.OpenFile(ts.dataPath(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("append upload: %s", err)
+	}
+	written, err := io.Copy(file, chunk)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// Spool sums up data of complete upload. Closing returned spool removes the
+// data, state is kept until upload expires.
+func (ts *TusStore) Spool(id string) (*Spool, error) {
+	file, err := os.Open(ts.dataPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("spool upload: %s", err)
+	}
+	spool := &Spool{File: file, ContentSummer: NewContentSummer()}
+	if _, err = io.Copy(spool.ContentSummer, file); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("spool upload: %s", err)
+	}
+	return spool, nil
+}
+
+func (ts *TusStore) Delete(id string) error {
+	if err := os.Remove(ts.statePath(id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrUploadNotFound
+		}
+		return fmt.Errorf("delete upload: %s", err)
+	}
+	if err := os.Remove(ts.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete upload: %s", err)
+	}
+	return nil
+}
+
+// Expires returns time upload will be removed unless more data arrives.
+func (ts *TusStore) Expires(id string) time.Time {
+	updated := time.Now()
+	if info, err := os.Stat(ts.dataPath(id)); err == nil {
+		updated = info.ModTime()
+	} else if info, err = os.Stat(ts.statePath(id)); err == nil {
+		updated = info.ModTime()
+	}
+	return updated.Add(UploadExpiry)
+}
+
+// Run periodically removes expired uploads until ctx is done.
+func (ts *TusStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			ts.expire(now)
+		}
+	}
+}
+
+func (ts *TusStore) expire(now time.Time) {
+	entries, err := os.ReadDir(ts.dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Error("failed to expire uploads", "error", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || now.Before(ts.Expires(id)) || !ts.Acquire(id) {
+			continue
+		}
+		if err = ts.Delete(id); err != nil {
+			slog.Error("failed to expire upload", "upload", id, "error", err)
+		}
+		ts.Release(id)
+	}
+}
+
+// ParseUploadMetadata decodes Upload-Metadata header: comma-separated keys
+// with optional base64-encoded values.
+func ParseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload metadata: %s", key)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+// tusRequest checks protocol version of request and sets headers every
+// response carries. On mismatch it responds with 412 and returns false.
+func tusRequest(rw http.ResponseWriter, r *http.Request) bool {
+	rw.Header().Set("Tus-Resumable", TusVersion)
+	rw.Header().Set("Cache-Control", "no-store")
+	if r.Header.Get("Tus-Resumable") != TusVersion {
+		rw.Header().Set("Tus-Version", TusVersion)
+		WriteError(rw, r, 412, fmt.Sprintf("unsupported tus version, only %s is supported", TusVersion))
+		return false
+	}
+	return true
+}
+
+func (hr *HttpRoutes) TusOptions(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Tus-Resumable", TusVersion)
+	rw.Header().Set("Tus-Version", TusVersion)
+	rw.Header().Set("Tus-Extension", TusExtensions)
+	rw.Header().Set("Tus-Max-Size", fmt.Sprint(MaxBodyLen(r, hr.config)))
+	rw.WriteHeader(204)
+}
+
+// CreateUpload starts resumable upload. Paste options are taken from
+// Upload-Metadata or from query and headers, same as with regular upload.
+func (hr *HttpRoutes) CreateUpload(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	if !tusRequest(rw, r) {
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		WriteError(rw, r, 400, "Upload-Length header is required")
+		return
+	}
+	if length == 0 {
+		WriteError(rw, r, 400, "your paste is empty!")
+		return
+	}
+	if limit := MaxBodyLen(r, hr.config); length > limit {
+		WriteError(rw, r, 413, fmt.Sprintf("request body too large, limit is %s", FormatSize(limit)))
+		return
+	}
+	metadata, err := ParseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
+	}
+
+	// Options are validated now, but expiry counts from completion
+	option := func(name string) string {
+		if value := metadata[name]; value != "" {
+			return value
+		}
+		return RequestOptions(r)(name)
+	}
+	options, err := hr.PasteOptions(option)
+	if err != nil {
+		WriteError(rw, r, 400, err.Error())
+		return
+	}
+	upload := &TusUpload{
+		Length:      length,
+		Filename:    metadata["filename"],
+		ContentType: metadata["filetype"],
+		Expire:      option("expire"),
+		Burn:        options.Burn,
+		Private:     options.Private,
+		Visibility:  options.Visibility,
+		Tags:        strings.Join(options.Tags, ","),
+		Type:        options.Type,
+	}
+
+	id, err := hr.uploads.Create(upload)
+	if err != nil {
+		panic(err)
+	}
+	RequestLogger(r).Info("upload created", "upload", id, "bytes", length)
+	rw.Header().Set("Location", BaseURL(r)+"/uploads/"+id)
+	rw.Header().Set("Upload-Expires", hr.uploads.Expires(id).UTC().Format(http.TimeFormat))
+	rw.WriteHeader(201)
+}
+
+func (hr *HttpRoutes) HeadUpload(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	if !tusRequest(rw, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	upload, offset, err := hr.uploads.Load(id)
+	if err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			rw.WriteHeader(404)
+			return
+		}
+		panic(err)
+	}
+	rw.Header().Set("Upload-Offset", fmt.Sprint(offset))
+	rw.Header().Set("Upload-Length", fmt.Sprint(upload.Length))
+	if upload.Paste != "" {
+		rw.Header().Set("X-Paste-Url", BaseURL(r)+"/"+upload.Paste)
+	} else {
+		rw.Header().Set("Upload-Expires", hr.uploads.Expires(id).UTC().Format(http.TimeFormat))
+	}
+	rw.WriteHeader(200)
+}
+
+// PatchUpload appends chunk at offset upload is at. Once all data is there,
+// paste is created and its URL and tokens are returned in headers.
+func (hr *HttpRoutes) PatchUpload(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	if !tusRequest(rw, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		WriteError(rw, r, 415, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+	requested, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || requested < 0 {
+		WriteError(rw, r, 400, "Upload-Offset header is required")
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if !hr.uploads.Acquire(id) {
+		WriteError(rw, r, 423, "upload is busy with another request")
+		return
+	}
+	defer hr.uploads.Release(id)
+	upload, offset, err := hr.uploads.Load(id)
+	if err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			WriteError(rw, r, 404, "upload not found")
+			return
+		}
+		panic(err)
+	}
+	if requested != offset {
+		rw.Header().Set("Upload-Offset", fmt.Sprint(offset))
+		WriteError(rw, r, 409, fmt.Sprintf("upload is at offset %d", offset))
+		return
+	}
+	if upload.Paste != "" {
+		rw.Header().Set("Upload-Offset", fmt.Sprint(offset))
+		rw.Header().Set("X-Paste-Url", BaseURL(r)+"/"+upload.Paste)
+		rw.WriteHeader(204)
+		return
+	}
+
+	// Chunk must not go past declared length
+	remaining := upload.Length - offset
+	if r.ContentLength > remaining {
+		WriteError(rw, r, 413, fmt.Sprintf("chunk exceeds Upload-Length, %d bytes remaining", remaining))
+		return
+	}
+	written, err := hr.uploads.Append(id, http.MaxBytesReader(rw, r.Body, remaining))
+	offset += written
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "http: request body too large") {
+			WriteError(rw, r, 413, fmt.Sprintf("chunk exceeds Upload-Length, %d bytes remaining", remaining))
+			return
+		}
+		// Client went away, it will ask for offset when it comes back
+		RequestLogger(r).Info("upload interrupted", "upload", id, "offset", offset, "error", err)
+		rw.Header().Set("Upload-Offset", fmt.Sprint(offset))
+		WriteError(rw, r, 400, "upload interrupted")
+		return
+	}
+	rw.Header().Set("Upload-Offset", fmt.Sprint(offset))
+	if offset < upload.Length {
+		rw.Header().Set("Upload-Expires", hr.uploads.Expires(id).UTC().Format(http.TimeFormat))
+		rw.WriteHeader(204)
+		return
+	}
+
+	// Upload is complete, turn it into paste
+	options, err := hr.PasteOptions(func(name string) string {
+		return map[string]string{
+			"expire":     upload.Expire,
+			"burn":       strconv.FormatBool(upload.Burn),
+			"private":    strconv.FormatBool(upload.Private),
+			"visibility": upload.Visibility,
+			"tags":       upload.Tags,
+			"type":       upload.Type,
+		}[name]
+	})
+	if err != nil {
+		panic(err)
+	}
+	spool, err := hr.uploads.Spool(id)
+	if err != nil {
+		panic(err)
+	}
+	paste := &Upload{Spool: spool, ContentType: upload.ContentType, Filename: upload.Filename}
+	defer paste.Close()
+	paste.DetectContentType()
+	if err = hr.CheckContent(r, paste); err != nil {
+		// Data goes along with spool, state would be left without it
+		if err := hr.uploads.Delete(id); err != nil {
+			RequestLogger(r).Warn("failed to delete rejected upload", "upload", id, "error", err)
+		}
+		WriteError(rw, r, ContentStatus(err), err.Error())
+		return
+	}
+	info := hr.createPaste(r, options, paste)
+	upload.Paste = info.ID
+	if err = hr.uploads.Save(id, upload); err != nil {
+		panic(err)
+	}
+	rw.Header().Set("X-Paste-Url", info.URL)
+	if info.Duplicate {
+		rw.Header().Set("X-Duplicate", "true")
+	} else {
+		rw.Header().Set("X-Delete-Url", info.DeleteURL)
+		rw.Header().Set("X-Delete-Token", info.DeleteToken)
+		rw.Header().Set("X-Edit-Token", info.EditToken)
+	}
+	rw.WriteHeader(204)
+}
+
+func (hr *HttpRoutes) DeleteUpload(rw http.ResponseWriter, r *http.Request) {
+	defer RecoverError(rw, r)
+
+	if !tusRequest(rw, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if !hr.uploads.Acquire(id) {
+		WriteError(rw, r, 423, "upload is busy with another request")
+		return
+	}
+	defer hr.uploads.Release(id)
+	if err := hr.uploads.Delete(id); err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			WriteError(rw, r, 404, "upload not found")
+			return
+		}
+		panic(err)
+	}
+	rw.WriteHeader(204)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// DAVPrefix is where pastes are mounted over WebDAV.
+const DAVPrefix = "/dav"
+
+type davRequestKey struct{}
+
+// davFileSystem is read-only view of pastes: root lists recent public
+// pastes, and any other paste opens by its ID, with or without extension.
+// Burn and protected pastes are left out, file managers read files just to
+// show previews.
+type davFileSystem struct {
+	routes *HttpRoutes
+}
+
+// davFileInfo describes root directory if meta is nil, paste otherwise.
+type davFileInfo struct {
+	name string
+	meta *PasteMeta
+}
+
+func (fi *davFileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *davFileInfo) Size() int64 {
+	if fi.meta == nil {
+		return 0
+	}
+	return fi.meta.Size
+}
+
+func (fi *davFileInfo) Mode() fs.FileMode {
+	if fi.meta == nil {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (fi *davFileInfo) ModTime() time.Time {
+	if fi.meta == nil {
+		return time.Time{}
+	}
+	return fi.meta.Modified()
+}
+
+func (fi *davFileInfo) IsDir() bool {
+	return fi.meta == nil
+}
+
+func (fi *davFileInfo) Sys() any {
+	return nil
+}
+
+// ContentType saves listing from reading every paste to sniff its type.
+func (fi *davFileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.meta == nil {
+		return "", webdav.ErrNotImplemented
+	}
+	return PasteContentType(fi.meta, ""), nil
+}
+
+func (fi *davFileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.meta == nil || fi.meta.SHA256 == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return `"` + fi.meta.SHA256 + `"`, nil
+}
+
+// davFile is either paste content or listing of root.
+type davFile struct {
+	*bytes.Reader
+	info    *davFileInfo
+	entries []fs.FileInfo
+}
+
+func (f *davFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.info.IsDir() {
+		return nil, os.ErrInvalid
+	}
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	entries := f.entries[:min(count, len(f.entries))]
+	f.entries = f.entries[len(entries):]
+	return entries, nil
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *davFile) Close() error {
+	return nil
+}
+
+func (dfs *davFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (dfs *davFileSystem) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (dfs *davFileSystem) Rename(ctx context.Context, oldName string, newName string) error {
+	return os.ErrPermission
+}
+
+// DAVName is file name of paste in WebDAV listing, extension is taken from
+// file name or language so that editors highlight it.
+func DAVName(hash string, meta *PasteMeta) string {
+	if ext := path.Ext(meta.Filename); ext != "" {
+		return hash + ext
+	}
+	return hash + LanguageExtension(meta.Language)
+}
+
+func (dfs *davFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		return &davFileInfo{name: "/"}, nil
+	}
+	hash, _, _ := strings.Cut(name, ".")
+	if strings.Contains(name, "/") {
+		return nil, os.ErrNotExist
+	}
+	hr := dfs.routes
+	meta, err := hr.storage.LoadMeta(hr.HashName(hash))
+	if err != nil {
+		if errors.Is(err, ErrPasteNotFound) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	if meta.Expired() || !hr.CanAccess(hash, meta) || meta.Burn || meta.Password != nil {
+		return nil, os.ErrNotExist
+	}
+	return &davFileInfo{name: name, meta: meta}, nil
+}
+
+func (dfs *davFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	info, err := dfs.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	file := &davFile{Reader: bytes.NewReader(nil), info: info.(*davFileInfo)}
+	if !info.IsDir() {
+		hash, _, _ := strings.Cut(info.Name(), ".")
+		r := ctx.Value(davRequestKey{}).(*http.Request)
+		_, content, err := dfs.routes.readPaste(r, hash, "")
+		if err != nil {
+			if errors.Is(err, ErrPasteNotFound) {
+				return nil, os.ErrNotExist
+			}
+			return nil, err
+		}
+		file.Reader = bytes.NewReader(content)
+		return file, nil
+	}
+	r := ctx.Value(davRequestKey{}).(*http.Request)
+	pastes, err := dfs.routes.recentPastes(r, RecentLimit, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, paste := range pastes {
+		// Protected pastes are listed in recent ones
+		if entry, err := dfs.Stat(ctx, paste.ID); err == nil {
+			entry.(*davFileInfo).name = DAVName(paste.ID, entry.(*davFileInfo).meta)
+			file.entries = append(file.entries, entry)
+		}
+	}
+	return file, nil
+}
+
+// WebDAV serves pastes as read-only WebDAV share at DAVPrefix, so they can
+// be mounted in file manager or editor. Pastes are read with GET the same
+// way as raw ones, which counts views.
+func (hr *HttpRoutes) WebDAV() http.HandlerFunc {
+	handler := &webdav.Handler{
+		Prefix:     DAVPrefix,
+		FileSystem: &davFileSystem{hr},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				RequestLogger(r).Warn("webdav request failed", "error", err)
+			}
+		},
+	}
+	return func(rw http.ResponseWriter, r *http.Request) {
+		defer RecoverError(rw, r)
+
+		// Share is read-only and without locking, which file managers take
+		// as read-only mount
+		if r.Method == "OPTIONS" {
+			rw.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+			rw.Header().Set("DAV", "1")
+			rw.WriteHeader(200)
+			return
+		}
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, DAVPrefix), "/")
+		if (r.Method == "GET" || r.Method == "HEAD") && name != "" {
+			hr.davPaste(rw, r, name)
+			return
+		}
+		handler.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), davRequestKey{}, r)))
+	}
+}
+
+func (hr *HttpRoutes) davPaste(rw http.ResponseWriter, r *http.Request, name string) {
+	hash, _, _ := strings.Cut(name, ".")
+	meta, content, err := hr.readPaste(r, hash, "")
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrPasteNotFound):
+			PasteNotFound(rw, r, hash)
+		case errors.Is(err, ErrPasswordRequired):
+			WriteError(rw, r, 403, "protected pastes can't be read over WebDAV")
+		default:
+			panic(err)
+		}
+		return
+	}
+	if meta.Burn {
+		WriteError(rw, r, 403, "burn pastes can't be read over WebDAV")
+		return
+	}
+	if err = hr.viewPaste(r, hash, meta); err != nil {
+		panic(err)
+	}
+	// Served the same way as raw paste, content must never be sniffed
+	rw.Header().Set("Content-Type", PasteContentType(meta, ""))
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.Header().Set("Content-Security-Policy", "sandbox")
+	if meta.SHA256 != "" {
+		rw.Header().Set("ETag", `"`+meta.SHA256+`"`)
+	}
+	http.ServeContent(rw, r, "", meta.Modified(), bytes.NewReader(content))
+}